@@ -3,11 +3,17 @@ package client
 import (
 	"crypto/ed25519"
 	"fmt"
+	"net"
+	"net/http"
 	"net/url"
+	"time"
 
 	"github.com/threefoldtech/tfexplorer/models/generated/directory"
 	"github.com/threefoldtech/tfexplorer/models/generated/phonebook"
 	"github.com/threefoldtech/tfexplorer/models/generated/workloads"
+	escrowtypes "github.com/threefoldtech/tfexplorer/pkg/escrow/types"
+	pb "github.com/threefoldtech/tfexplorer/pkg/phonebook"
+	pbtypes "github.com/threefoldtech/tfexplorer/pkg/phonebook/types"
 	wrklds "github.com/threefoldtech/tfexplorer/pkg/workloads"
 	"github.com/threefoldtech/tfexplorer/schema"
 	"github.com/threefoldtech/zos/pkg/capacity"
@@ -27,12 +33,32 @@ type Directory interface {
 	FarmUpdate(farm directory.Farm) error
 	FarmList(tid schema.ID, name string, page *Pager) (farms []directory.Farm, err error)
 	FarmGet(id schema.ID) (farm directory.Farm, err error)
+	FarmAddAdmin(id schema.ID, tid int64, role directory.FarmRoleEnum) error
+	FarmRemoveAdmin(id schema.ID, tid int64) error
+	FarmTransfer(id schema.ID, tid int64) error
+	FarmAcceptTransfer(id schema.ID) error
+	FarmAddIP(id schema.ID, ip directory.PublicIP) error
+	FarmRemoveIP(id schema.ID, ip net.IP) error
+	FarmWalletChallenge(id schema.ID, address string) (challenge string, err error)
+	FarmWalletVerify(id schema.ID, address string, signature []byte) error
 
 	GatewayRegister(Gateway directory.Gateway) error
-	GatewayList(tid schema.ID, name string, page *Pager) (farms []directory.Gateway, err error)
+	GatewayList(filter GatewayFilter, page *Pager) (gateways []directory.Gateway, err error)
 	GatewayGet(id string) (farm directory.Gateway, err error)
 	GatewayUpdateUptime(id string, uptime uint64) error
 	GatewayUpdateReservedResources(id string, resources directory.ResourceAmount, workloads directory.WorkloadAmount) error
+	GatewayDecommission(id string) error
+	GatewayDomains(id string) (domains GatewayDomains, err error)
+	GatewaySetInterfaces(id string, ifaces []directory.Iface) error
+	GatewaySetPublic(id string, pub directory.PublicIface) error
+	GatewaySetFreeToUse(id string, free bool) error
+	GatewaySetCapacity(
+		id string,
+		resources directory.ResourceAmount,
+		dmiInfo dmi.DMI,
+		disksInfo capacity.Disks,
+		hypervisor []string,
+	) error
 
 	NodeRegister(node directory.Node) error
 	NodeList(filter NodeFilter) (nodes []directory.Node, err error)
@@ -42,6 +68,10 @@ type Directory interface {
 	NodeSetPorts(id string, ports []uint) error
 	NodeSetPublic(id string, pub directory.PublicIface) error
 	NodeSetFreeToUse(id string, free bool) error
+	NodeDecommission(id string) error
+	NodeAcknowledgeHardwareChange(id string) error
+	NodeApprove(id string, reason string) error
+	NodeRevoke(id string, reason string) error
 
 	//TODO: this method call uses types from zos that is not generated
 	//from the schema. Which is wrong imho.
@@ -62,7 +92,21 @@ type Phonebook interface {
 	Create(user phonebook.User) (schema.ID, error)
 	List(name, email string, page *Pager) (output []phonebook.User, err error)
 	Get(id schema.ID) (phonebook.User, error)
-	// Update() #TODO
+	Update(user phonebook.User, signature []byte) error
+	RotateKey(id schema.ID, pubkey, signer string, signature []byte) error
+	SetRecoveryKeys(id schema.ID, keys []string, signer string, signature []byte) error
+	SetBudget(id schema.ID, budget phonebook.Budget, version int64, signer string, signature []byte) error
+	TokenCreate(id schema.ID, name string, scopes []string, lifetime time.Duration) (pb.TokenCreateResponse, error)
+	TokenList(id schema.ID) ([]pbtypes.Token, error)
+	TokenRevoke(id schema.ID, tokenID schema.ID) error
+	RequestEmailVerification(id schema.ID) error
+	VerifyEmail(id schema.ID, token string) error
+	OrganizationCreate(name, description string) (phonebook.Organization, error)
+	OrganizationList(name string, member int64, page *Pager) (output []phonebook.Organization, err error)
+	OrganizationGet(id schema.ID) (phonebook.Organization, error)
+	OrganizationSetMember(id schema.ID, tid int64, role phonebook.OrganizationRoleEnum) error
+	OrganizationRemoveMember(id schema.ID, tid int64) error
+	OrganizationSetBudget(id schema.ID, budget phonebook.Budget, version int64, signer string, signature []byte) error
 	Validate(id schema.ID, message, signature string) (bool, error)
 }
 
@@ -70,6 +114,10 @@ type Phonebook interface {
 type Workloads interface {
 	Create(reservation workloads.Reservation) (resp wrklds.ReservationCreateResponse, err error)
 	List(nextAction *workloads.NextActionEnum, customerTid int64, page *Pager) (reservation []workloads.Reservation, err error)
+	ListOrganization(nextAction *workloads.NextActionEnum, customerOrg int64, page *Pager) (reservation []workloads.Reservation, err error)
+	Usage(id schema.ID) (usage wrklds.Usage, err error)
+	OrganizationUsage(id schema.ID) (usage wrklds.Usage, err error)
+	Escrow(id schema.ID) (info escrowtypes.CustomerEscrowInformation, err error)
 	Get(id schema.ID) (reservation workloads.Reservation, err error)
 
 	SignProvision(id schema.ID, user schema.ID, signature string) error
@@ -89,10 +137,24 @@ type Identity interface {
 	PrivateKey() ed25519.PrivateKey
 }
 
-// Pager for listing
+// Pager for listing. A pager created with Page always lists the same page,
+// while a pager created with Cursor moves to the next page after every list
+// call, so all items can be listed with
+//
+//	for page := client.Cursor(100); page.More(); {
+//		users, err := cl.Phonebook.List("", "", page)
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
 type Pager struct {
 	p int
 	s int
+
+	iterate bool
+	started bool
+	cursor  string
 }
 
 func (p *Pager) apply(v url.Values) {
@@ -100,16 +162,48 @@ func (p *Pager) apply(v url.Values) {
 		return
 	}
 
+	if p.s == 0 {
+		p.s = 10
+	}
+
+	v.Set("size", fmt.Sprint(p.s))
+
+	if p.iterate {
+		if len(p.cursor) != 0 {
+			v.Set("cursor", p.cursor)
+		}
+		return
+	}
+
 	if p.p < 1 {
 		p.p = 1
 	}
 
-	if p.s == 0 {
-		p.s = 10
+	v.Set("page", fmt.Sprint(p.p))
+}
+
+// update keeps the cursor of the page following response. A failed request
+// ends the iteration
+func (p *Pager) update(response *http.Response, err error) {
+	if p == nil {
+		return
 	}
 
-	v.Set("page", fmt.Sprint(p.p))
-	v.Set("size", fmt.Sprint(p.s))
+	p.started = true
+	p.cursor = ""
+	if err == nil && response != nil {
+		p.cursor = response.Header.Get("Next-Cursor")
+	}
+}
+
+// More returns true while there are pages left to list. A pager created
+// with Page has a single page
+func (p *Pager) More() bool {
+	if p == nil {
+		return false
+	}
+
+	return !p.started || (p.iterate && len(p.cursor) != 0)
 }
 
 // Page returns a pager
@@ -117,6 +211,11 @@ func Page(page, size int) *Pager {
 	return &Pager{p: page, s: size}
 }
 
+// Cursor returns a pager iterating over all the pages of a list
+func Cursor(size int) *Pager {
+	return &Pager{s: size, iterate: true}
+}
+
 // NewClient creates a new client, if identity is not nil, it will be used
 // to authenticate requests against the server
 func NewClient(u string, id Identity) (*Client, error) {
@@ -124,11 +223,27 @@ func NewClient(u string, id Identity) (*Client, error) {
 	if err != nil {
 		return nil, err
 	}
-	cl := &Client{
+
+	return newClient(h), nil
+}
+
+// NewTokenClient creates a new client that authenticates with an API token
+// instead of signing the requests. Tokens are read only and limited to their
+// scopes
+func NewTokenClient(u string, token string) (*Client, error) {
+	h, err := newHTTPClient(u, nil)
+	if err != nil {
+		return nil, err
+	}
+	h.token = token
+
+	return newClient(h), nil
+}
+
+func newClient(h *httpClient) *Client {
+	return &Client{
 		Phonebook: &httpPhonebook{h},
 		Directory: &httpDirectory{h},
 		Workloads: &httpWorkloads{h},
 	}
-
-	return cl, nil
 }
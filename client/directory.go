@@ -2,10 +2,12 @@ package client
 
 import (
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
 
 	"github.com/threefoldtech/tfexplorer/models/generated/directory"
+	dirtypes "github.com/threefoldtech/tfexplorer/pkg/directory/types"
 	"github.com/threefoldtech/tfexplorer/schema"
 	"github.com/threefoldtech/zos/pkg/capacity"
 	"github.com/threefoldtech/zos/pkg/capacity/dmi"
@@ -38,7 +40,8 @@ func (d *httpDirectory) FarmList(tid schema.ID, name string, page *Pager) (farms
 	if len(name) != 0 {
 		query.Set("name", name)
 	}
-	_, err = d.get(d.url("farms"), query, &farms, http.StatusOK)
+	response, err := d.get(d.url("farms"), query, &farms, http.StatusOK)
+	page.update(response, err)
 	return
 }
 
@@ -47,6 +50,63 @@ func (d *httpDirectory) FarmGet(id schema.ID) (farm directory.Farm, err error) {
 	return
 }
 
+func (d *httpDirectory) FarmAddAdmin(id schema.ID, tid int64, role directory.FarmRoleEnum) error {
+	admin := directory.FarmAdmin{
+		ThreebotId: tid,
+		Role:       role,
+	}
+	_, err := d.post(d.url("farms", fmt.Sprint(id), "admins"), admin, nil, http.StatusCreated)
+	return err
+}
+
+func (d *httpDirectory) FarmRemoveAdmin(id schema.ID, tid int64) error {
+	_, err := d.delete(d.url("farms", fmt.Sprint(id), "admins", fmt.Sprint(tid)), nil, nil, http.StatusOK)
+	return err
+}
+
+func (d *httpDirectory) FarmTransfer(id schema.ID, tid int64) error {
+	input := struct {
+		ThreebotID int64 `json:"threebot_id"`
+	}{
+		ThreebotID: tid,
+	}
+	_, err := d.post(d.url("farms", fmt.Sprint(id), "transfer"), input, nil, http.StatusOK)
+	return err
+}
+
+func (d *httpDirectory) FarmAcceptTransfer(id schema.ID) error {
+	_, err := d.post(d.url("farms", fmt.Sprint(id), "transfer", "accept"), struct{}{}, nil, http.StatusOK)
+	return err
+}
+
+func (d *httpDirectory) FarmAddIP(id schema.ID, ip directory.PublicIP) error {
+	_, err := d.post(d.url("farms", fmt.Sprint(id), "ip_addresses"), ip, nil, http.StatusCreated)
+	return err
+}
+
+func (d *httpDirectory) FarmRemoveIP(id schema.ID, ip net.IP) error {
+	_, err := d.delete(d.url("farms", fmt.Sprint(id), "ip_addresses", ip.String()), nil, nil, http.StatusOK)
+	return err
+}
+
+func (d *httpDirectory) FarmWalletChallenge(id schema.ID, address string) (string, error) {
+	var output struct {
+		Challenge string `json:"challenge"`
+	}
+
+	_, err := d.post(d.url("farms", fmt.Sprint(id), "wallet_addresses", address, "challenge"), nil, &output, http.StatusCreated)
+	return output.Challenge, err
+}
+
+func (d *httpDirectory) FarmWalletVerify(id schema.ID, address string, signature []byte) error {
+	input := struct {
+		Signature []byte `json:"signature"`
+	}{Signature: signature}
+
+	_, err := d.post(d.url("farms", fmt.Sprint(id), "wallet_addresses", address, "verify"), input, nil, http.StatusOK)
+	return err
+}
+
 func (d *httpDirectory) NodeRegister(node directory.Node) error {
 	_, err := d.post(d.url("nodes"), node, nil, http.StatusCreated)
 	return err
@@ -141,18 +201,56 @@ func (d *httpDirectory) NodeSetFreeToUse(id string, free bool) error {
 	return err
 }
 
+func (d *httpDirectory) NodeDecommission(id string) error {
+	_, err := d.delete(d.url("nodes", id), nil, nil, http.StatusOK)
+	return err
+}
+
+func (d *httpDirectory) NodeAcknowledgeHardwareChange(id string) error {
+	_, err := d.delete(d.url("nodes", id, "hardware_changed"), nil, nil, http.StatusOK)
+	return err
+}
+
+func (d *httpDirectory) NodeApprove(id string, reason string) error {
+	input := struct {
+		Reason string `json:"reason"`
+	}{Reason: reason}
+
+	_, err := d.post(d.url("nodes", id, "approve"), input, nil, http.StatusOK)
+	return err
+}
+
+func (d *httpDirectory) NodeRevoke(id string, reason string) error {
+	input := struct {
+		Reason string `json:"reason"`
+	}{Reason: reason}
+
+	_, err := d.post(d.url("nodes", id, "revoke"), input, nil, http.StatusOK)
+	return err
+}
+
+// GatewayDomains holds the domains managed by a gateway and the ones claimed by reservations
+type GatewayDomains struct {
+	ManagedDomains []string               `json:"managed_domains"`
+	Claims         []dirtypes.DomainClaim `json:"claims"`
+}
+
+func (d *httpDirectory) GatewayDomains(id string) (domains GatewayDomains, err error) {
+	_, err = d.get(d.url("gateways", id, "domains"), nil, &domains, http.StatusOK)
+	return
+}
+
 func (d *httpDirectory) GatewayRegister(Gateway directory.Gateway) error {
 	_, err := d.post(d.url("gateways"), Gateway, nil, http.StatusCreated)
 	return err
 }
 
-func (d *httpDirectory) GatewayList(tid schema.ID, name string, page *Pager) (Gateways []directory.Gateway, err error) {
+func (d *httpDirectory) GatewayList(filter GatewayFilter, page *Pager) (Gateways []directory.Gateway, err error) {
 	query := url.Values{}
 	page.apply(query)
-	if len(name) != 0 {
-		query.Set("name", name)
-	}
-	_, err = d.get(d.url("gateways"), query, &Gateways, http.StatusOK)
+	filter.Apply(query)
+	response, err := d.get(d.url("gateways"), query, &Gateways, http.StatusOK)
+	page.update(response, err)
 	return
 }
 
@@ -184,3 +282,50 @@ func (d *httpDirectory) GatewayUpdateReservedResources(id string, resources dire
 	_, err := d.post(d.url("gateways", id, "reserved_resources"), input, nil, http.StatusOK)
 	return err
 }
+
+func (d *httpDirectory) GatewaySetInterfaces(id string, ifaces []directory.Iface) error {
+	_, err := d.post(d.url("gateways", id, "interfaces"), ifaces, nil, http.StatusCreated)
+	return err
+}
+
+func (d *httpDirectory) GatewaySetPublic(id string, pub directory.PublicIface) error {
+	_, err := d.post(d.url("gateways", id, "configure_public"), pub, nil, http.StatusCreated)
+	return err
+}
+
+func (d *httpDirectory) GatewaySetFreeToUse(id string, free bool) error {
+	choice := struct {
+		FreeToUse bool `json:"free_to_use"`
+	}{FreeToUse: free}
+
+	_, err := d.post(d.url("gateways", id, "configure_free"), choice, nil, http.StatusOK)
+	return err
+}
+
+func (d *httpDirectory) GatewaySetCapacity(
+	id string,
+	resources directory.ResourceAmount,
+	dmiInfo dmi.DMI,
+	disksInfo capacity.Disks,
+	hypervisor []string) error {
+
+	payload := struct {
+		Capacity   directory.ResourceAmount `json:"capacity"`
+		DMI        dmi.DMI                  `json:"dmi"`
+		Disks      capacity.Disks           `json:"disks"`
+		Hypervisor []string                 `json:"hypervisor"`
+	}{
+		Capacity:   resources,
+		DMI:        dmiInfo,
+		Disks:      disksInfo,
+		Hypervisor: hypervisor,
+	}
+
+	_, err := d.post(d.url("gateways", id, "capacity"), payload, nil, http.StatusOK)
+	return err
+}
+
+func (d *httpDirectory) GatewayDecommission(id string) error {
+	_, err := d.delete(d.url("gateways", id), nil, nil, http.StatusOK)
+	return err
+}
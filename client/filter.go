@@ -15,6 +15,9 @@ type NodeFilter struct {
 	sru     *int64
 	hru     *int64
 	proofs  *bool
+
+	hardwareChanged *bool
+	approved        *bool
 }
 
 // WithFarm filter with farm
@@ -59,6 +62,18 @@ func (n NodeFilter) WithProofs(proofs bool) NodeFilter {
 	return n
 }
 
+// WithHardwareChanged filter nodes whose hardware changed since last acknowledged
+func (n NodeFilter) WithHardwareChanged(changed bool) NodeFilter {
+	n.hardwareChanged = &changed
+	return n
+}
+
+// WithApproved filter nodes approved by a certifier
+func (n NodeFilter) WithApproved(approved bool) NodeFilter {
+	n.approved = &approved
+	return n
+}
+
 // Apply fills query
 func (n NodeFilter) Apply(query url.Values) {
 
@@ -93,4 +108,118 @@ func (n NodeFilter) Apply(query url.Values) {
 	if n.proofs != nil {
 		query.Set("proofs", fmt.Sprint(*n.proofs))
 	}
+
+	if n.hardwareChanged != nil {
+		query.Set("hardware_changed", fmt.Sprint(*n.hardwareChanged))
+	}
+
+	if n.approved != nil {
+		query.Set("approved", fmt.Sprint(*n.approved))
+	}
+}
+
+// GatewayFilter used to build a query for gateway list
+type GatewayFilter struct {
+	farm    *int64
+	country *string
+	city    *string
+	cru     *int64
+	mru     *int64
+	sru     *int64
+	hru     *int64
+	domain  *string
+	proofs  *bool
+}
+
+// WithFarm filter with farm
+func (g GatewayFilter) WithFarm(id int64) GatewayFilter {
+	g.farm = &id
+	return g
+}
+
+// WithCountry filter with country
+func (g GatewayFilter) WithCountry(country string) GatewayFilter {
+	g.country = &country
+	return g
+}
+
+// WithCity filter with city
+func (g GatewayFilter) WithCity(city string) GatewayFilter {
+	g.city = &city
+	return g
+}
+
+// WithCRU filter with CRU
+func (g GatewayFilter) WithCRU(cru int64) GatewayFilter {
+	g.cru = &cru
+	return g
+}
+
+// WithMRU filter with MRU
+func (g GatewayFilter) WithMRU(mru int64) GatewayFilter {
+	g.mru = &mru
+	return g
+}
+
+// WithSRU filter with SRU
+func (g GatewayFilter) WithSRU(sru int64) GatewayFilter {
+	g.sru = &sru
+	return g
+}
+
+// WithHRU filter with HRU
+func (g GatewayFilter) WithHRU(hru int64) GatewayFilter {
+	g.hru = &hru
+	return g
+}
+
+// WithManagedDomain filter gateways that manage domain
+func (g GatewayFilter) WithManagedDomain(domain string) GatewayFilter {
+	g.domain = &domain
+	return g
+}
+
+// WithProofs filter with proofs
+func (g GatewayFilter) WithProofs(proofs bool) GatewayFilter {
+	g.proofs = &proofs
+	return g
+}
+
+// Apply fills query
+func (g GatewayFilter) Apply(query url.Values) {
+	if g.farm != nil {
+		query.Set("farm", fmt.Sprint(*g.farm))
+	}
+
+	if g.country != nil {
+		query.Set("country", *g.country)
+	}
+
+	if g.city != nil {
+		query.Set("city", *g.city)
+	}
+
+	if g.cru != nil {
+		query.Set("cru", fmt.Sprint(*g.cru))
+	}
+
+	if g.mru != nil {
+		query.Set("mru", fmt.Sprint(*g.mru))
+	}
+
+	if g.sru != nil {
+		query.Set("sru", fmt.Sprint(*g.sru))
+	}
+
+	if g.hru != nil {
+		query.Set("hru", fmt.Sprint(*g.hru))
+	}
+
+	if g.domain != nil {
+		query.Set("domain", *g.domain)
+	}
+
+	if g.proofs != nil {
+		query.Set("proofs", fmt.Sprint(*g.proofs))
+	}
 }
@@ -7,12 +7,17 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"path"
 	"path/filepath"
 
 	"github.com/pkg/errors"
+	"github.com/threefoldtech/tfexplorer/pkg/apierror"
 	"github.com/zaibon/httpsig"
 )
 
+// apiVersion is the version of the explorer API used by the client
+const apiVersion = "v2"
+
 var (
 	successCodes = []int{
 		http.StatusOK,
@@ -25,6 +30,8 @@ type httpClient struct {
 	cl       http.Client
 	signer   *httpsig.Signer
 	identity string
+	// token is an API token sent instead of signing the requests
+	token string
 }
 
 // HTTPError is the error type returned by the client
@@ -43,12 +50,47 @@ func (h HTTPError) Response() http.Response {
 	return *h.resp
 }
 
+// APIError returns the typed error returned by the explorer. Errors that
+// are not typed get the code of the status of the response
+func (h HTTPError) APIError() apierror.APIError {
+	return *apierror.AsAPIError(h.resp.StatusCode, h.err)
+}
+
+// Code returns the machine readable code of the error
+func (h HTTPError) Code() apierror.ErrorCode {
+	return h.APIError().Code
+}
+
+// Field returns the invalid field of the request, if any
+func (h HTTPError) Field() string {
+	return h.APIError().Field
+}
+
+// Details returns the details of the error, if any
+func (h HTTPError) Details() map[string]interface{} {
+	return h.APIError().Details
+}
+
+// ErrorCode returns the code of an error returned by the explorer, it
+// returns an empty code if err does not come from the explorer
+func ErrorCode(err error) apierror.ErrorCode {
+	var h HTTPError
+	if errors.As(err, &h) {
+		return h.Code()
+	}
+	return ""
+}
+
 func newHTTPClient(raw string, id Identity) (*httpClient, error) {
 	u, err := url.Parse(raw)
 	if err != nil {
 		return nil, fmt.Errorf("invalid url: %w", err)
 	}
 
+	if path.Base(u.Path) != apiVersion {
+		u.Path = path.Join("/", u.Path, apiVersion)
+	}
+
 	client := &httpClient{
 		u: u,
 	}
@@ -69,6 +111,11 @@ func (c *httpClient) url(p ...string) string {
 }
 
 func (c *httpClient) sign(r *http.Request) error {
+	if len(c.token) != 0 {
+		r.Header.Set("Authorization", "Bearer "+c.token)
+		return nil
+	}
+
 	if c.signer == nil {
 		return nil
 	}
@@ -96,7 +143,7 @@ func (c *httpClient) process(response *http.Response, output interface{}, expect
 	dec := json.NewDecoder(response.Body)
 	if !in(response.StatusCode, expect) {
 		var output struct {
-			E string `json:"error"`
+			E json.RawMessage `json:"error"`
 		}
 
 		if err := dec.Decode(&output); err != nil {
@@ -107,7 +154,7 @@ func (c *httpClient) process(response *http.Response, output interface{}, expect
 		}
 
 		return HTTPError{
-			err:  fmt.Errorf(output.E),
+			err:  decodeError(output.E),
 			resp: response,
 		}
 	}
@@ -128,6 +175,22 @@ func (c *httpClient) process(response *http.Response, output interface{}, expect
 	return nil
 }
 
+// decodeError decodes the typed error of the v2 API, the error message of
+// the v1 API is supported as well
+func decodeError(raw json.RawMessage) error {
+	var typed apierror.APIError
+	if err := json.Unmarshal(raw, &typed); err == nil {
+		return &typed
+	}
+
+	var message string
+	if err := json.Unmarshal(raw, &message); err != nil {
+		message = string(raw)
+	}
+
+	return errors.New(message)
+}
+
 func (c *httpClient) post(u string, input interface{}, output interface{}, expect ...int) (*http.Response, error) {
 	var buf bytes.Buffer
 	if err := json.NewEncoder(&buf).Encode(input); err != nil {
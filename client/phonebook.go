@@ -1,11 +1,15 @@
 package client
 
 import (
+	"encoding/hex"
 	"fmt"
 	"net/http"
 	"net/url"
+	"time"
 
 	"github.com/threefoldtech/tfexplorer/models/generated/phonebook"
+	pb "github.com/threefoldtech/tfexplorer/pkg/phonebook"
+	pbtypes "github.com/threefoldtech/tfexplorer/pkg/phonebook/types"
 	"github.com/threefoldtech/tfexplorer/schema"
 )
 
@@ -32,7 +36,8 @@ func (p *httpPhonebook) List(name, email string, page *Pager) (output []phoneboo
 		query.Set("email", email)
 	}
 
-	_, err = p.get(p.url("users"), query, &output, http.StatusOK)
+	response, err := p.get(p.url("users"), query, &output, http.StatusOK)
+	page.update(response, err)
 
 	return
 }
@@ -42,6 +47,96 @@ func (p *httpPhonebook) Get(id schema.ID) (user phonebook.User, err error) {
 	return
 }
 
+// Update the user info, signature is the signature of the user.Encode() message
+// with the current key of the user. The key itself is changed with RotateKey
+func (p *httpPhonebook) Update(user phonebook.User, signature []byte) error {
+	input := struct {
+		phonebook.User
+		Signature string `json:"sender_signature_hex"`
+	}{
+		User:      user,
+		Signature: hex.EncodeToString(signature),
+	}
+
+	_, err := p.put(p.url("users", fmt.Sprint(user.ID)), input, nil, http.StatusOK)
+	return err
+}
+
+// RotateKey replaces the key of the user with pubkey. signature is the signature of
+// the rotation message made by signer, which is either the current key of the user
+// or one of its recovery keys
+func (p *httpPhonebook) RotateKey(id schema.ID, pubkey, signer string, signature []byte) error {
+	input := struct {
+		Pubkey    string `json:"pubkey"`
+		Signer    string `json:"signer"`
+		Signature string `json:"signature"`
+	}{
+		Pubkey:    pubkey,
+		Signer:    signer,
+		Signature: hex.EncodeToString(signature),
+	}
+
+	_, err := p.post(p.url("users", fmt.Sprint(id), "keys", "rotate"), input, nil, http.StatusOK)
+	return err
+}
+
+// SetRecoveryKeys sets the keys allowed to rotate the key of the user. The signature
+// is made over types.RecoveryKeysMessage with signer, which must be a recovery key
+// of the user if any is registered, or the current key otherwise
+func (p *httpPhonebook) SetRecoveryKeys(id schema.ID, keys []string, signer string, signature []byte) error {
+	input := struct {
+		RecoveryKeys []string `json:"recovery_keys"`
+		Signer       string   `json:"signer"`
+		Signature    string   `json:"signature"`
+	}{
+		RecoveryKeys: keys,
+		Signer:       signer,
+		Signature:    hex.EncodeToString(signature),
+	}
+
+	_, err := p.put(p.url("users", fmt.Sprint(id), "keys", "recovery"), input, nil, http.StatusOK)
+	return err
+}
+
+// SetBudget replaces the budget of the user at version, the budget_version of
+// the user. The signature is made over types.BudgetMessage with signer, the
+// current key of the user can only lower the budget, raising it must be signed
+// with a recovery key of the user or the key of an explorer admin
+func (p *httpPhonebook) SetBudget(id schema.ID, budget phonebook.Budget, version int64, signer string, signature []byte) error {
+	input := struct {
+		Budget    phonebook.Budget `json:"budget"`
+		Version   int64            `json:"version"`
+		Signer    string           `json:"signer"`
+		Signature string           `json:"signature"`
+	}{
+		Budget:    budget,
+		Version:   version,
+		Signer:    signer,
+		Signature: hex.EncodeToString(signature),
+	}
+
+	_, err := p.put(p.url("users", fmt.Sprint(id), "budget"), input, nil, http.StatusOK)
+	return err
+}
+
+// RequestEmailVerification sends a new verification token to the email of the user
+func (p *httpPhonebook) RequestEmailVerification(id schema.ID) error {
+	_, err := p.post(p.url("users", fmt.Sprint(id), "email", "verification"), nil, nil, http.StatusCreated)
+	return err
+}
+
+// VerifyEmail marks the email of the user as verified using the token sent by email
+func (p *httpPhonebook) VerifyEmail(id schema.ID, token string) error {
+	input := struct {
+		Token string `json:"token"`
+	}{
+		Token: token,
+	}
+
+	_, err := p.post(p.url("users", fmt.Sprint(id), "email", "verify"), input, nil, http.StatusOK)
+	return err
+}
+
 // Validate the signature of this message for the user, signature and message are hex encoded
 func (p *httpPhonebook) Validate(id schema.ID, message, signature string) (bool, error) {
 	var input struct {
@@ -62,3 +157,99 @@ func (p *httpPhonebook) Validate(id schema.ID, message, signature string) (bool,
 
 	return output.V, nil
 }
+
+func (p *httpPhonebook) OrganizationCreate(name, description string) (phonebook.Organization, error) {
+	input := struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	}{
+		Name:        name,
+		Description: description,
+	}
+
+	var org phonebook.Organization
+	_, err := p.post(p.url("organizations"), input, &org, http.StatusCreated)
+	return org, err
+}
+
+func (p *httpPhonebook) OrganizationList(name string, member int64, page *Pager) (output []phonebook.Organization, err error) {
+	query := url.Values{}
+	page.apply(query)
+	if len(name) != 0 {
+		query.Set("name", name)
+	}
+	if member != 0 {
+		query.Set("member", fmt.Sprint(member))
+	}
+
+	response, err := p.get(p.url("organizations"), query, &output, http.StatusOK)
+	page.update(response, err)
+	return
+}
+
+func (p *httpPhonebook) OrganizationGet(id schema.ID) (org phonebook.Organization, err error) {
+	_, err = p.get(p.url("organizations", fmt.Sprint(id)), nil, &org, http.StatusOK)
+	return
+}
+
+func (p *httpPhonebook) OrganizationSetMember(id schema.ID, tid int64, role phonebook.OrganizationRoleEnum) error {
+	member := phonebook.OrganizationMember{Tid: tid, Role: role}
+	_, err := p.post(p.url("organizations", fmt.Sprint(id), "members"), member, nil, http.StatusCreated)
+	return err
+}
+
+func (p *httpPhonebook) OrganizationRemoveMember(id schema.ID, tid int64) error {
+	_, err := p.delete(p.url("organizations", fmt.Sprint(id), "members", fmt.Sprint(tid)), nil, nil, http.StatusOK)
+	return err
+}
+
+// OrganizationSetBudget replaces the budget of the organization at version, the
+// budget_version of the organization. The signature is made over
+// types.OrganizationBudgetMessage with signer, the current key of an owner can
+// only lower the budget, raising it must be signed with a recovery key of an
+// owner or the key of an explorer admin
+func (p *httpPhonebook) OrganizationSetBudget(id schema.ID, budget phonebook.Budget, version int64, signer string, signature []byte) error {
+	input := struct {
+		Budget    phonebook.Budget `json:"budget"`
+		Version   int64            `json:"version"`
+		Signer    string           `json:"signer"`
+		Signature string           `json:"signature"`
+	}{
+		Budget:    budget,
+		Version:   version,
+		Signer:    signer,
+		Signature: hex.EncodeToString(signature),
+	}
+
+	_, err := p.put(p.url("organizations", fmt.Sprint(id), "budget"), input, nil, http.StatusOK)
+	return err
+}
+
+// TokenCreate mints an API token for the user, the secret of the token is only
+// returned once
+func (p *httpPhonebook) TokenCreate(id schema.ID, name string, scopes []string, lifetime time.Duration) (token pb.TokenCreateResponse, err error) {
+	input := struct {
+		Name      string   `json:"name"`
+		Scopes    []string `json:"scopes"`
+		ExpiresIn int64    `json:"expires_in"`
+	}{
+		Name:      name,
+		Scopes:    scopes,
+		ExpiresIn: int64(lifetime.Seconds()),
+	}
+
+	_, err = p.post(p.url("users", fmt.Sprint(id), "tokens"), input, &token, http.StatusCreated)
+	return
+}
+
+// TokenList lists the valid API tokens of the user
+func (p *httpPhonebook) TokenList(id schema.ID) (tokens []pbtypes.Token, err error) {
+	_, err = p.get(p.url("users", fmt.Sprint(id), "tokens"), nil, &tokens, http.StatusOK)
+	return
+}
+
+// TokenRevoke revokes an API token of the user
+func (p *httpPhonebook) TokenRevoke(id schema.ID, tokenID schema.ID) error {
+	_, err := p.delete(p.url("users", fmt.Sprint(id), "tokens", fmt.Sprint(tokenID)), nil, nil, http.StatusOK)
+	return err
+}
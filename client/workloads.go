@@ -9,6 +9,7 @@ import (
 
 	"github.com/stellar/go/support/errors"
 	"github.com/threefoldtech/tfexplorer/models/generated/workloads"
+	escrowtypes "github.com/threefoldtech/tfexplorer/pkg/escrow/types"
 	wrklds "github.com/threefoldtech/tfexplorer/pkg/workloads"
 	"github.com/threefoldtech/tfexplorer/schema"
 )
@@ -32,7 +33,33 @@ func (w *httpWorkloads) List(nextAction *workloads.NextActionEnum, customerTid i
 	}
 	page.apply(query)
 
-	_, err = w.get(w.url("reservations"), query, &reservation, http.StatusOK)
+	response, err := w.get(w.url("reservations"), query, &reservation, http.StatusOK)
+	page.update(response, err)
+	return
+}
+
+func (w *httpWorkloads) ListOrganization(nextAction *workloads.NextActionEnum, customerOrg int64, page *Pager) (reservation []workloads.Reservation, err error) {
+	query := url.Values{}
+	if nextAction != nil {
+		query.Set("next_action", fmt.Sprintf("%d", nextAction))
+	}
+	query.Set("customer_org", fmt.Sprint(customerOrg))
+	page.apply(query)
+
+	response, err := w.get(w.url("reservations"), query, &reservation, http.StatusOK)
+	page.update(response, err)
+	return
+}
+
+// Usage returns how much of the budget of the user is used
+func (w *httpWorkloads) Usage(id schema.ID) (usage wrklds.Usage, err error) {
+	_, err = w.get(w.url("users", fmt.Sprint(id), "usage"), nil, &usage, http.StatusOK)
+	return
+}
+
+// OrganizationUsage returns how much of the budget of the organization is used
+func (w *httpWorkloads) OrganizationUsage(id schema.ID) (usage wrklds.Usage, err error) {
+	_, err = w.get(w.url("organizations", fmt.Sprint(id), "usage"), nil, &usage, http.StatusOK)
 	return
 }
 
@@ -137,6 +164,12 @@ func (wl *intermediateWL) Workload() (result workloads.ReservationWorkload, err
 			return result, err
 		}
 		result.Content = o
+	case workloads.WorkloadTypePublicIP:
+		var o workloads.PublicIP
+		if err := json.Unmarshal(wl.Content, &o); err != nil {
+			return result, err
+		}
+		result.Content = o
 	default:
 		return result, fmt.Errorf("unknown workload type")
 	}
@@ -197,6 +230,23 @@ func (w *httpWorkloads) WorkloadPutResult(nodeID, gwid string, result workloads.
 }
 
 func (w *httpWorkloads) WorkloadPutDeleted(nodeID, gwid string) error {
-	_, err := w.delete(w.url("reservations", "workloads", gwid, nodeID), nil, nil, http.StatusOK)
+	// the explorer refuses deletions signed by anyone but the node of the
+	// workload. Other clients send it unsigned, which is accepted as long as
+	// the explorer does not require signed deletions
+	client := w.httpClient
+	if w.identity != nodeID {
+		unsigned := *w.httpClient
+		unsigned.signer = nil
+		unsigned.token = ""
+		client = &unsigned
+	}
+
+	_, err := client.delete(w.url("reservations", "workloads", gwid, nodeID), nil, nil, http.StatusOK)
 	return err
 }
+
+// Escrow returns the escrow details of a reservation of the user
+func (w *httpWorkloads) Escrow(id schema.ID) (info escrowtypes.CustomerEscrowInformation, err error) {
+	_, err = w.get(w.url("reservations", fmt.Sprint(id), "escrow"), nil, &info, http.StatusOK)
+	return
+}
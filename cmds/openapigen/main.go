@@ -0,0 +1,51 @@
+// openapigen generates the OpenAPI document of the explorer API from the
+// route manifest and the schema files
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/tfexplorer/schema"
+)
+
+func main() {
+	var (
+		routes  string
+		dir     string
+		out     string
+		version string
+	)
+
+	flag.StringVar(&routes, "routes", "models/routes.yaml", "path to the route manifest")
+	flag.StringVar(&dir, "schema", "models/schema", "directory of the schema files")
+	flag.StringVar(&out, "out", "dist/openapi.json", "path of the generated document")
+	flag.StringVar(&version, "version", "2", "version of the API")
+	flag.Parse()
+
+	manifest, err := schema.LoadRoutes(routes)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to load route manifest")
+	}
+
+	objects, err := schema.NewFromDir(dir)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to load schema")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(out), 0755); err != nil {
+		log.Fatal().Err(err).Msg("failed to create output directory")
+	}
+
+	f, err := os.Create(out)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create document")
+	}
+	defer f.Close()
+
+	if err := schema.GenerateOpenAPI(f, "TF Grid Explorer", version, manifest, objects); err != nil {
+		log.Fatal().Err(err).Msg("failed to generate document")
+	}
+}
@@ -2,8 +2,11 @@ package main
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 
+	"github.com/pkg/errors"
+	"github.com/stellar/go/keypair"
 	"github.com/threefoldtech/tfexplorer/models/generated/directory"
 	"github.com/threefoldtech/tfexplorer/schema"
 	"github.com/urfave/cli"
@@ -84,6 +87,92 @@ func updateFarm(c *cli.Context) error {
 	return nil
 }
 
+func addFarmAdmin(c *cli.Context) error {
+	tid, err := strconv.ParseInt(c.Args().First(), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid threebot id: %w", err)
+	}
+
+	var role directory.FarmRoleEnum
+	switch c.String("role") {
+	case directory.FarmRoleOwner.String():
+		role = directory.FarmRoleOwner
+	case directory.FarmRoleOperator.String():
+		role = directory.FarmRoleOperator
+	default:
+		return fmt.Errorf("unsupported role '%s'", c.String("role"))
+	}
+
+	if err := db.FarmAddAdmin(schema.ID(c.Int64("id")), tid, role); err != nil {
+		return err
+	}
+
+	fmt.Printf("threebot %d added as %s of the farm\n", tid, role)
+	return nil
+}
+
+func removeFarmAdmin(c *cli.Context) error {
+	tid, err := strconv.ParseInt(c.Args().First(), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid threebot id: %w", err)
+	}
+
+	if err := db.FarmRemoveAdmin(schema.ID(c.Int64("id")), tid); err != nil {
+		return err
+	}
+
+	fmt.Printf("threebot %d removed from the farm admins\n", tid)
+	return nil
+}
+
+func transferFarm(c *cli.Context) error {
+	tid, err := strconv.ParseInt(c.Args().First(), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid threebot id: %w", err)
+	}
+
+	if err := db.FarmTransfer(schema.ID(c.Int64("id")), tid); err != nil {
+		return err
+	}
+
+	fmt.Printf("ownership of the farm offered to threebot %d, the transfer is effective once accepted\n", tid)
+	return nil
+}
+
+func acceptFarmTransfer(c *cli.Context) error {
+	if err := db.FarmAcceptTransfer(schema.ID(c.Int64("id"))); err != nil {
+		return err
+	}
+
+	fmt.Println("you are now the owner of the farm")
+	return nil
+}
+
+func verifyFarmAddress(c *cli.Context) error {
+	kp, err := keypair.ParseFull(c.String("seed"))
+	if err != nil {
+		return errors.Wrap(err, "invalid wallet seed")
+	}
+
+	id := schema.ID(c.Int64("id"))
+	challenge, err := db.FarmWalletChallenge(id, kp.Address())
+	if err != nil {
+		return errors.Wrap(err, "failed to get challenge")
+	}
+
+	signature, err := kp.Sign([]byte(challenge))
+	if err != nil {
+		return errors.Wrap(err, "failed to sign challenge")
+	}
+
+	if err := db.FarmWalletVerify(id, kp.Address(), signature); err != nil {
+		return err
+	}
+
+	fmt.Printf("wallet address %s verified\n", kp.Address())
+	return nil
+}
+
 func splitAddressCode(addr string) (string, string, error) {
 	ss := strings.Split(addr, ":")
 	if len(ss) != 2 {
@@ -101,7 +190,19 @@ func formatFarm(farm directory.Farm) string {
 	fmt.Fprintf(b, "IYO organization: %s\n", farm.IyoOrganization)
 	fmt.Fprintf(b, "Wallet addresses:\n")
 	for _, a := range farm.WalletAddresses {
-		fmt.Fprintf(b, "%s:%s\n", a.Asset, a.Address)
+		fmt.Fprintf(b, "%s:%s verified:%v\n", a.Asset, a.Address, a.Verified)
+	}
+	if len(farm.Admins) > 0 {
+		fmt.Fprintf(b, "Admins:\n")
+		for _, a := range farm.Admins {
+			fmt.Fprintf(b, "%d:%s\n", a.ThreebotId, a.Role)
+		}
+	}
+	if len(farm.IPAddresses) > 0 {
+		fmt.Fprintf(b, "IP addresses:\n")
+		for _, ip := range farm.IPAddresses {
+			fmt.Fprintf(b, "%s gw:%s reservation:%d\n", ip.Address, ip.Gateway, ip.ReservationId)
+		}
 	}
 	return b.String()
 }
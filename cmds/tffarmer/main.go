@@ -123,6 +123,84 @@ func main() {
 					},
 					Action: updateFarm,
 				},
+				{
+					Name:      "add-admin",
+					Usage:     "allow another threebot to manage the farm",
+					Category:  "admins",
+					ArgsUsage: "threebot_id",
+					Flags: []cli.Flag{
+						cli.Int64Flag{
+							Name:     "id",
+							Usage:    "farm ID",
+							Required: true,
+						},
+						cli.StringFlag{
+							Name:  "role",
+							Usage: "role of the admin, one of 'owner' or 'operator'",
+							Value: "operator",
+						},
+					},
+					Action: addFarmAdmin,
+				},
+				{
+					Name:      "remove-admin",
+					Usage:     "revoke the rights of a threebot on the farm",
+					Category:  "admins",
+					ArgsUsage: "threebot_id",
+					Flags: []cli.Flag{
+						cli.Int64Flag{
+							Name:     "id",
+							Usage:    "farm ID",
+							Required: true,
+						},
+					},
+					Action: removeFarmAdmin,
+				},
+				{
+					Name:      "transfer",
+					Usage:     "offer the ownership of the farm to another threebot. The transfer is effective once accepted by the new owner",
+					Category:  "admins",
+					ArgsUsage: "threebot_id",
+					Flags: []cli.Flag{
+						cli.Int64Flag{
+							Name:     "id",
+							Usage:    "farm ID",
+							Required: true,
+						},
+					},
+					Action: transferFarm,
+				},
+				{
+					Name:     "accept-transfer",
+					Usage:    "accept the ownership of a farm offered to you",
+					Category: "admins",
+					Flags: []cli.Flag{
+						cli.Int64Flag{
+							Name:     "id",
+							Usage:    "farm ID",
+							Required: true,
+						},
+					},
+					Action: acceptFarmTransfer,
+				},
+				{
+					Name:  "verify-address",
+					Usage: "prove the ownership of a wallet address of the farm by signing a challenge with its key. Unverified addresses might not receive payouts",
+					Flags: []cli.Flag{
+						cli.Int64Flag{
+							Name:     "id",
+							Usage:    "farm ID",
+							Required: true,
+						},
+						cli.StringFlag{
+							Name:     "seed",
+							Usage:    "stellar seed of the wallet address",
+							EnvVar:   "TFFARMER_WALLET_SEED",
+							Required: true,
+						},
+					},
+					Action: verifyFarmAddress,
+				},
 			},
 		},
 		{
@@ -148,9 +226,50 @@ You can specify multime time the ip and gw flag to configure multiple IP on the
 							Name:  "iface",
 							Usage: "name of the interface to use as public interface",
 						},
+						cli.BoolFlag{
+							Name:  "gateway",
+							Usage: "if set, the ID is a gateway ID instead of a node ID",
+						},
 					},
 					Action: configPublic,
 				},
+				{
+					Name:     "add-ip",
+					Category: "network",
+					Usage:    "add a public ipv4 address to the pool of the farm, to be used by reservations",
+					Flags: []cli.Flag{
+						cli.Int64Flag{
+							Name:     "id",
+							Usage:    "farm ID",
+							Required: true,
+						},
+						cli.StringFlag{
+							Name:     "ip",
+							Usage:    "ip address with the netmask of its network, e.g. 185.69.166.10/24",
+							Required: true,
+						},
+						cli.StringFlag{
+							Name:     "gw",
+							Usage:    "gateway of the network",
+							Required: true,
+						},
+					},
+					Action: addFarmIP,
+				},
+				{
+					Name:      "remove-ip",
+					Category:  "network",
+					Usage:     "remove a public ipv4 address from the pool of the farm",
+					ArgsUsage: "ip address",
+					Flags: []cli.Flag{
+						cli.Int64Flag{
+							Name:     "id",
+							Usage:    "farm ID",
+							Required: true,
+						},
+					},
+					Action: removeFarmIP,
+				},
 			},
 		},
 		{
@@ -170,9 +289,42 @@ You can specify multime time the ip and gw flag to configure multiple IP on the
 							Name:  "free",
 							Usage: "if set, the node is marked free, it not the node is mark not free",
 						},
+						cli.BoolFlag{
+							Name:  "gateway",
+							Usage: "if set, the IDs are gateway IDs instead of node IDs",
+						},
 					},
 					Action: markFree,
 				},
+				{
+					Name:     "remove",
+					Category: "nodes",
+					Usage: `decommission some nodes of the farm.
+Decommissioned nodes are hidden from the node listing and can't receive new reservations`,
+					Flags: []cli.Flag{
+						cli.StringSliceFlag{
+							Name:  "nodes",
+							Usage: "node IDs. can be specified multiple time",
+						},
+						cli.BoolFlag{
+							Name:  "gateway",
+							Usage: "if set, the IDs are gateway IDs instead of node IDs",
+						},
+					},
+					Action: removeNodes,
+				},
+				{
+					Name:     "ack-hardware",
+					Category: "nodes",
+					Usage:    "acknowledge the hardware change reported by some nodes",
+					Flags: []cli.Flag{
+						cli.StringSliceFlag{
+							Name:  "nodes",
+							Usage: "node IDs. can be specified multiple time",
+						},
+					},
+					Action: ackHardware,
+				},
 			},
 		},
 	}
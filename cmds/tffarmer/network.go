@@ -75,9 +75,55 @@ func configPublic(c *cli.Context) error {
 		pubIface.Ipv6 = schema.IPRange{IPNet: *nv6}
 	}
 
+	if c.Bool("gateway") {
+		if err := db.GatewaySetPublic(node, pubIface); err != nil {
+			return err
+		}
+		fmt.Printf("public interface configured on gateway %s\n", node)
+		return nil
+	}
+
 	if err := db.NodeSetPublic(node, pubIface); err != nil {
 		return err
 	}
 	fmt.Printf("public interface configured on node %s\n", node)
 	return nil
 }
+
+func addFarmIP(c *cli.Context) error {
+	address, err := schema.ParseIPRange(c.String("ip"))
+	if err != nil {
+		return fmt.Errorf("invalid cidr(%s): %s", c.String("ip"), err)
+	}
+
+	gw := net.ParseIP(c.String("gw"))
+	if gw == nil {
+		return fmt.Errorf("invalid gw '%s'", c.String("gw"))
+	}
+
+	ip := directory.PublicIP{
+		Address: address,
+		Gateway: gw,
+	}
+
+	if err := db.FarmAddIP(schema.ID(c.Int64("id")), ip); err != nil {
+		return err
+	}
+
+	fmt.Printf("ip address %s added to the farm\n", address)
+	return nil
+}
+
+func removeFarmIP(c *cli.Context) error {
+	ip := net.ParseIP(c.Args().First())
+	if ip == nil {
+		return fmt.Errorf("invalid ip address '%s'", c.Args().First())
+	}
+
+	if err := db.FarmRemoveIP(schema.ID(c.Int64("id")), ip); err != nil {
+		return err
+	}
+
+	fmt.Printf("ip address %s removed from the farm\n", ip)
+	return nil
+}
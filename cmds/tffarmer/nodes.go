@@ -9,10 +9,19 @@ import (
 func markFree(c *cli.Context) error {
 	nodes := c.StringSlice("nodes")
 	free := c.Bool("free")
+	gateway := c.Bool("gateway")
 
 	for _, id := range nodes {
 		fmt.Printf("node %s ", id)
-		if err := db.NodeSetFreeToUse(id, free); err != nil {
+
+		var err error
+		if gateway {
+			err = db.GatewaySetFreeToUse(id, free)
+		} else {
+			err = db.NodeSetFreeToUse(id, free)
+		}
+
+		if err != nil {
 			fmt.Printf(" error %v\n", err)
 			return err
 		}
@@ -20,3 +29,40 @@ func markFree(c *cli.Context) error {
 	}
 	return nil
 }
+
+func removeNodes(c *cli.Context) error {
+	nodes := c.StringSlice("nodes")
+	gateway := c.Bool("gateway")
+
+	for _, id := range nodes {
+		fmt.Printf("node %s ", id)
+
+		var err error
+		if gateway {
+			err = db.GatewayDecommission(id)
+		} else {
+			err = db.NodeDecommission(id)
+		}
+
+		if err != nil {
+			fmt.Printf(" error %v\n", err)
+			return err
+		}
+		fmt.Printf("decommissioned\n")
+	}
+	return nil
+}
+
+func ackHardware(c *cli.Context) error {
+	nodes := c.StringSlice("nodes")
+
+	for _, id := range nodes {
+		fmt.Printf("node %s ", id)
+		if err := db.NodeAcknowledgeHardwareChange(id); err != nil {
+			fmt.Printf(" error %v\n", err)
+			return err
+		}
+		fmt.Printf("hardware change acknowledged\n")
+	}
+	return nil
+}
@@ -2,7 +2,12 @@ package config
 
 import (
 	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/threefoldtech/tfexplorer/pkg/stellar"
 )
@@ -10,6 +15,248 @@ import (
 // Settings struct
 type Settings struct {
 	Network string
+	// Certifiers are the threebot ids allowed to approve nodes
+	Certifiers Certifiers
+	// Admins are the threebot ids of the explorer administrators, they
+	// can manage the nodes and gateways of any farm
+	Admins ThreebotIDs
+	// UnverifiedPayout is what the escrow does with the payout of
+	// farmers whose wallet address is not verified
+	UnverifiedPayout string
+	// Mailer is the url of the mailer used to send verification emails
+	// if empty, email verification is disabled
+	Mailer string
+	// RequireVerifiedEmail refuses reservations of users whose email is not verified
+	RequireVerifiedEmail bool
+	// Names is the policy applied to the names of new users
+	Names NamePolicy
+	// RequireSignedDeletion refuses workload deletion acknowledgements
+	// that are not signed by the node of the workload
+	RequireSignedDeletion bool
+	// RateLimits are the rate limits of the API routes by route name
+	RateLimits RateLimits
+	// TrustedProxies are the networks of the reverse proxies in front of the
+	// explorer, the client ip of their requests is read from X-Forwarded-For
+	TrustedProxies Networks
+}
+
+const (
+	// PayoutAllow pays unverified addresses like verified ones
+	PayoutAllow = "allow"
+	// PayoutRefuse does not pay unverified addresses, their share is refunded to the customer
+	PayoutRefuse = "refuse"
+	// PayoutHold keeps the funds on the escrow until the addresses are verified
+	PayoutHold = "hold"
+)
+
+// ThreebotIDs is a flag type for setting a list of threebot ids
+type ThreebotIDs []int64
+
+// Certifiers is a flag type for setting the threebots allowed to certify nodes
+type Certifiers = ThreebotIDs
+
+func (c *ThreebotIDs) String() string {
+	repr := make([]string, 0, len(*c))
+	for _, tid := range *c {
+		repr = append(repr, fmt.Sprint(tid))
+	}
+	return strings.Join(repr, " ")
+}
+
+// Set a value on the flag
+func (c *ThreebotIDs) Set(value string) error {
+	tid, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid threebot id '%s'", value)
+	}
+	*c = append(*c, tid)
+	return nil
+}
+
+// Has checks if tid is in the list
+func (c ThreebotIDs) Has(tid int64) bool {
+	for _, certifier := range c {
+		if certifier == tid {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultRateLimit is the name of the limit applied to the routes without
+// a rate limit of their own
+const DefaultRateLimit = "default"
+
+// BuiltinRateLimits are the limits of the routes that are always limited,
+// e.g. because they send emails. They can be overridden with the rate-limit flag
+var BuiltinRateLimits = RateLimits{
+	"user-email-verification": {Requests: 3, Period: time.Hour},
+}
+
+// RateLimit allows Requests requests per Period
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+}
+
+func (l RateLimit) String() string {
+	return fmt.Sprintf("%d/%s", l.Requests, l.Period)
+}
+
+// RateLimits is a flag type for setting the rate limits of routes,
+// as route=requests/period, e.g. reservation-create=10/1m
+type RateLimits map[string]RateLimit
+
+func (l *RateLimits) String() string {
+	repr := make([]string, 0, len(*l))
+	for name, limit := range *l {
+		repr = append(repr, fmt.Sprintf("%s=%s", name, limit))
+	}
+	sort.Strings(repr)
+	return strings.Join(repr, " ")
+}
+
+// Set a value on the rate limits flag
+func (l *RateLimits) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || len(parts[0]) == 0 {
+		return fmt.Errorf("invalid rate limit '%s', expecting route=requests/period", value)
+	}
+
+	limit := strings.SplitN(parts[1], "/", 2)
+	if len(limit) != 2 {
+		return fmt.Errorf("invalid rate limit '%s', expecting route=requests/period", value)
+	}
+
+	requests, err := strconv.Atoi(limit[0])
+	if err != nil || requests <= 0 {
+		return fmt.Errorf("invalid number of requests '%s'", limit[0])
+	}
+
+	period, err := time.ParseDuration(limit[1])
+	if err != nil || period <= 0 {
+		return fmt.Errorf("invalid period '%s'", limit[1])
+	}
+
+	if *l == nil {
+		*l = make(RateLimits)
+	}
+	(*l)[parts[0]] = RateLimit{Requests: requests, Period: period}
+	return nil
+}
+
+// Of returns the rate limit of the route name, routes without a limit of
+// their own use their builtin one, or else the default one. It returns false
+// if the route is not limited
+func (l RateLimits) Of(name string) (RateLimit, bool) {
+	if limit, ok := l[name]; ok {
+		return limit, true
+	}
+
+	if limit, ok := BuiltinRateLimits[name]; ok {
+		return limit, true
+	}
+
+	limit, ok := l[DefaultRateLimit]
+	return limit, ok
+}
+
+// NamePolicy restricts the names users can register
+type NamePolicy struct {
+	// Pattern is a regular expression names must match, any name is allowed if empty
+	Pattern string
+	// Reserved names can not be registered
+	Reserved ReservedNames
+}
+
+// Check returns an error if name is not allowed by the policy
+func (p NamePolicy) Check(name string) error {
+	if p.Reserved.Has(name) {
+		return fmt.Errorf("name '%s' is reserved", name)
+	}
+
+	if len(p.Pattern) == 0 {
+		return nil
+	}
+
+	ok, err := regexp.MatchString(p.Pattern, name)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return fmt.Errorf("name must match '%s'", p.Pattern)
+	}
+
+	return nil
+}
+
+// Networks is a flag type for setting a list of networks, as CIDRs or single ips
+type Networks []*net.IPNet
+
+func (n *Networks) String() string {
+	repr := make([]string, 0, len(*n))
+	for _, network := range *n {
+		repr = append(repr, network.String())
+	}
+	return strings.Join(repr, " ")
+}
+
+// Set a value on the networks flag
+func (n *Networks) Set(value string) error {
+	if !strings.Contains(value, "/") {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return fmt.Errorf("invalid ip '%s'", value)
+		}
+
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 8*net.IPv4len
+		}
+		*n = append(*n, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		return nil
+	}
+
+	_, network, err := net.ParseCIDR(value)
+	if err != nil {
+		return fmt.Errorf("invalid network '%s'", value)
+	}
+	*n = append(*n, network)
+	return nil
+}
+
+// Contains checks if ip is in any of the networks
+func (n Networks) Contains(ip net.IP) bool {
+	for _, network := range n {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReservedNames is a flag type for setting the names users can not register
+type ReservedNames []string
+
+func (n *ReservedNames) String() string {
+	return strings.Join(*n, " ")
+}
+
+// Set a value on the reserved names flag
+func (n *ReservedNames) Set(value string) error {
+	*n = append(*n, strings.ToLower(value))
+	return nil
+}
+
+// Has checks if name is reserved
+func (n ReservedNames) Has(name string) bool {
+	for _, reserved := range n {
+		if strings.EqualFold(reserved, name) {
+			return true
+		}
+	}
+	return false
 }
 
 var (
@@ -17,6 +264,7 @@ var (
 	Config Settings
 
 	possibleNetworks = []string{stellar.NetworkProduction, stellar.NetworkTest}
+	possiblePayouts  = []string{PayoutAllow, PayoutRefuse, PayoutHold}
 )
 
 // Valid checks if Config is filled with valid data
@@ -32,6 +280,15 @@ func Valid() error {
 	if Config.Network != "" && !in(Config.Network, possibleNetworks) {
 		return fmt.Errorf("invalid network '%s'", Config.Network)
 	}
+	if Config.UnverifiedPayout != "" && !in(Config.UnverifiedPayout, possiblePayouts) {
+		return fmt.Errorf("invalid unverified payout policy '%s'", Config.UnverifiedPayout)
+	}
+	if _, err := regexp.Compile(Config.Names.Pattern); err != nil {
+		return fmt.Errorf("invalid name pattern '%s': %w", Config.Names.Pattern, err)
+	}
+	if Config.RequireVerifiedEmail && Config.Mailer == "" {
+		return fmt.Errorf("a mailer is required to verify emails")
+	}
 
 	return nil
 }
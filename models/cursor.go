@@ -0,0 +1,66 @@
+package models
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Cursor points after the last item of a page. It holds the sort of the
+// listing, the sort key and the _id of the item, and is handed to the clients
+// as an opaque token. Value is the _id as well when sorting on _id
+type Cursor struct {
+	Sort  string        `bson:"s"`
+	Desc  bool          `bson:"d"`
+	Value bson.RawValue `bson:"v"`
+	ID    bson.RawValue `bson:"i"`
+}
+
+// ParseCursor decodes a cursor token
+func ParseCursor(token string) (Cursor, error) {
+	var cursor Cursor
+
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return cursor, errors.Wrap(err, "invalid cursor")
+	}
+
+	if err := bson.Unmarshal(data, &cursor); err != nil {
+		return cursor, errors.Wrap(err, "invalid cursor")
+	}
+
+	if len(cursor.Sort) == 0 || cursor.ID.Type == 0 || cursor.Value.Type == 0 {
+		return cursor, fmt.Errorf("invalid cursor")
+	}
+
+	return cursor, nil
+}
+
+// Encode encodes the cursor as a token
+func (c Cursor) Encode() (string, error) {
+	data, err := bson.Marshal(c)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to encode cursor")
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// condition returns the query matching the items after the cursor
+func (c Cursor) condition() bson.M {
+	op := "$gt"
+	if c.Desc {
+		op = "$lt"
+	}
+
+	if c.Sort == "_id" {
+		return bson.M{"_id": bson.M{op: c.ID}}
+	}
+
+	return bson.M{"$or": bson.A{
+		bson.M{c.Sort: bson.M{op: c.Value}},
+		bson.M{c.Sort: c.Value, "_id": bson.M{op: c.ID}},
+	}}
+}
@@ -0,0 +1,112 @@
+package models
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/tfexplorer/schema"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type item struct {
+	ID   int64  `bson:"_id"`
+	Name string `bson:"name"`
+}
+
+func TestPagerNext(t *testing.T) {
+	pager := Page(0, 2)
+
+	next, err := pager.Next([]item{{ID: 1}})
+	require.NoError(t, err)
+	assert.Empty(t, next, "a partial page is the last one")
+
+	next, err = pager.Next([]item{{ID: 1}, {ID: 5}})
+	require.NoError(t, err)
+	require.NotEmpty(t, next)
+
+	r := httptest.NewRequest("GET", "/farms?size=2&cursor="+next, nil)
+	pager, err = PageFromRequest(r)
+	require.NoError(t, err)
+	require.NotNil(t, pager.Cursor)
+	assert.False(t, pager.Paged())
+	assert.Equal(t, int64(2), pager.Limit)
+
+	opts := pager.Options()
+	assert.Nil(t, opts.Skip)
+	assert.Equal(t, bson.D{{Key: "_id", Value: 1}}, opts.Sort)
+
+	filter := pager.Filter(bson.D{{Key: "name", Value: "farm"}})
+	require.Len(t, filter, 2)
+	assert.Equal(t, "$and", filter[1].Key)
+
+	cond := filter[1].Value.(bson.A)[0].(bson.M)["_id"].(bson.M)["$gt"].(bson.RawValue)
+	assert.Equal(t, int64(5), cond.Int64())
+}
+
+func TestPagerNextSortKey(t *testing.T) {
+	pager := Page(0, 1)
+	pager.Sort = "name"
+	pager.Desc = true
+
+	next, err := pager.Next([]item{{ID: 3, Name: "bel"}})
+	require.NoError(t, err)
+
+	cursor, err := ParseCursor(next)
+	require.NoError(t, err)
+	assert.Equal(t, "name", cursor.Sort)
+	assert.True(t, cursor.Desc)
+	assert.Equal(t, "bel", cursor.Value.StringValue())
+	assert.Equal(t, int64(3), cursor.ID.Int64())
+
+	pager.Cursor = &cursor
+	assert.Equal(t, bson.D{{Key: "name", Value: -1}, {Key: "_id", Value: -1}}, pager.Options().Sort)
+}
+
+func TestParseCursorInvalid(t *testing.T) {
+	_, err := ParseCursor("not a cursor")
+	assert.Error(t, err)
+
+	r := httptest.NewRequest("GET", "/farms?cursor=abc", nil)
+	_, err = PageFromRequest(r)
+	assert.Error(t, err)
+}
+
+func TestQueryPageForgedCursor(t *testing.T) {
+	q, err := ParseQuery(httptest.NewRequest("GET", "/nodes", nil), testFields)
+	require.NoError(t, err)
+
+	value := func(v interface{}) bson.RawValue {
+		typ, data, err := bson.MarshalValue(v)
+		require.NoError(t, err)
+		return bson.RawValue{Type: typ, Value: data}
+	}
+
+	page := func(cursor Cursor) error {
+		token, err := cursor.Encode()
+		require.NoError(t, err)
+
+		pager, err := PageFromRequest(httptest.NewRequest("GET", "/nodes?cursor="+token, nil))
+		require.NoError(t, err)
+
+		_, err = q.Page(pager)
+		return err
+	}
+
+	assert.NoError(t, page(Cursor{Sort: "_id", Value: value(int64(5)), ID: value(int64(5))}))
+	assert.NoError(t, page(Cursor{Sort: "name", Value: value("bel"), ID: value(int64(5))}))
+	assert.True(t, Field{Type: FieldDate}.accepts(value(schema.Date{Time: time.Unix(100, 0)})))
+
+	for name, cursor := range map[string]Cursor{
+		"unknown sort":      {Sort: "password", Value: value("x"), ID: value(int64(5))},
+		"not sortable":      {Sort: "location", Value: value(bson.M{}), ID: value(int64(5))},
+		"operator in value": {Sort: "name", Value: value(bson.M{"$ne": ""}), ID: value(int64(5))},
+		"operator in id":    {Sort: "_id", Value: value(int64(5)), ID: value(bson.M{"$gt": 0})},
+		"wrong value type":  {Sort: "cru", Value: value("8"), ID: value(int64(5))},
+		"array id":          {Sort: "name", Value: value("bel"), ID: value(bson.A{int64(1)})},
+	} {
+		assert.Error(t, page(cursor), name)
+	}
+}
@@ -18,6 +18,9 @@ type Farm struct {
 	Email           schema.Email        `bson:"email" json:"email"`
 	ResourcePrices  []NodeResourcePrice `bson:"resource_prices" json:"resource_prices"`
 	PrefixZero      schema.IPRange      `bson:"prefix_zero" json:"prefix_zero"`
+	Admins          []FarmAdmin         `bson:"admins" json:"admins"`
+	PendingOwner    int64               `bson:"pending_owner" json:"pending_owner"`
+	IPAddresses     []PublicIP          `bson:"ip_addresses" json:"ip_addresses"`
 }
 
 func NewFarm() (Farm, error) {
@@ -29,9 +32,40 @@ func NewFarm() (Farm, error) {
 	return object, nil
 }
 
+type FarmAdmin struct {
+	ThreebotId int64        `bson:"threebot_id" json:"threebot_id"`
+	Role       FarmRoleEnum `bson:"role" json:"role"`
+}
+
+func NewFarmAdmin() (FarmAdmin, error) {
+	const value = "{}"
+	var object FarmAdmin
+	if err := json.Unmarshal([]byte(value), &object); err != nil {
+		return object, err
+	}
+	return object, nil
+}
+
+type PublicIP struct {
+	Address       schema.IPRange `bson:"address" json:"address"`
+	Gateway       net.IP         `bson:"gateway" json:"gateway"`
+	ReservationId int64          `bson:"reservation_id" json:"reservation_id"`
+}
+
+func NewPublicIP() (PublicIP, error) {
+	const value = "{}"
+	var object PublicIP
+	if err := json.Unmarshal([]byte(value), &object); err != nil {
+		return object, err
+	}
+	return object, nil
+}
+
 type WalletAddress struct {
-	Asset   string `bson:"asset" json:"asset"`
-	Address string `bson:"address" json:"address"`
+	Asset      string      `bson:"asset" json:"asset"`
+	Address    string      `bson:"address" json:"address"`
+	Verified   bool        `bson:"verified" json:"verified"`
+	VerifiedAt schema.Date `bson:"verified_at" json:"verified_at"`
 }
 
 type NodeResourcePrice struct {
@@ -91,6 +125,11 @@ type Node struct {
 	Approved          bool           `bson:"approved" json:"approved"`
 	PublicKeyHex      string         `bson:"public_key_hex" json:"public_key_hex"`
 	WgPorts           []int64        `bson:"wg_ports" json:"wg_ports"`
+	Retired           bool           `bson:"retired" json:"retired"`
+	RetiredAt         schema.Date    `bson:"retired_at" json:"retired_at"`
+	HardwareChanged   bool           `bson:"hardware_changed" json:"hardware_changed"`
+	HardwareChangedAt schema.Date    `bson:"hardware_changed_at" json:"hardware_changed_at"`
+	Approvals         []NodeApproval `bson:"approvals" json:"approvals"`
 }
 
 func NewNode() (Node, error) {
@@ -102,6 +141,22 @@ func NewNode() (Node, error) {
 	return object, nil
 }
 
+type NodeApproval struct {
+	Certifier int64       `bson:"certifier" json:"certifier"`
+	Approved  bool        `bson:"approved" json:"approved"`
+	Reason    string      `bson:"reason" json:"reason"`
+	Timestamp schema.Date `bson:"timestamp" json:"timestamp"`
+}
+
+func NewNodeApproval() (NodeApproval, error) {
+	const value = "{}"
+	var object NodeApproval
+	if err := json.Unmarshal([]byte(value), &object); err != nil {
+		return object, err
+	}
+	return object, nil
+}
+
 type Iface struct {
 	Name       string            `bson:"name" json:"name"`
 	Addrs      []schema.IPRange  `bson:"addrs" json:"addrs"`
@@ -249,6 +304,26 @@ func (e IfaceTypeEnum) String() string {
 	return "UNKNOWN"
 }
 
+type FarmRoleEnum uint8
+
+const (
+	FarmRoleNone FarmRoleEnum = iota
+	FarmRoleOperator
+	FarmRoleOwner
+)
+
+func (e FarmRoleEnum) String() string {
+	switch e {
+	case FarmRoleNone:
+		return "none"
+	case FarmRoleOperator:
+		return "operator"
+	case FarmRoleOwner:
+		return "owner"
+	}
+	return "UNKNOWN"
+}
+
 type PriceCurrencyEnum uint8
 
 const (
@@ -276,19 +351,26 @@ func (e PriceCurrencyEnum) String() string {
 }
 
 type Gateway struct {
-	ID             schema.ID      `bson:"_id" json:"id"`
-	NodeId         string         `bson:"node_id" json:"node_id"`
-	FarmId         int64          `bson:"farm_id" json:"farm_id"`
-	OsVersion      string         `bson:"os_version" json:"os_version"`
-	Created        schema.Date    `bson:"created" json:"created"`
-	Updated        schema.Date    `bson:"updated" json:"updated"`
-	Uptime         int64          `bson:"uptime" json:"uptime"`
-	Address        string         `bson:"address" json:"address"`
-	Location       Location       `bson:"location" json:"location"`
-	PublicKeyHex   string         `bson:"public_key_hex" json:"public_key_hex"`
-	Workloads      WorkloadAmount `bson:"workloads" json:"workloads"`
-	ManagedDomains []string       `bson:"managed_domains" json:"managed_domains"`
-	TcpRouterPort  int64          `bson:"tcp_router_port" json:"tcp_router_port"`
-	DnsNameserver  []string       `bson:"dns_nameserver" json:"dns_nameserver"`
-	FreeToUse      bool           `bson:"free_to_use" json:"free_to_use"`
+	ID                schema.ID      `bson:"_id" json:"id"`
+	NodeId            string         `bson:"node_id" json:"node_id"`
+	FarmId            int64          `bson:"farm_id" json:"farm_id"`
+	OsVersion         string         `bson:"os_version" json:"os_version"`
+	Created           schema.Date    `bson:"created" json:"created"`
+	Updated           schema.Date    `bson:"updated" json:"updated"`
+	Uptime            int64          `bson:"uptime" json:"uptime"`
+	Address           string         `bson:"address" json:"address"`
+	Location          Location       `bson:"location" json:"location"`
+	PublicKeyHex      string         `bson:"public_key_hex" json:"public_key_hex"`
+	Workloads         WorkloadAmount `bson:"workloads" json:"workloads"`
+	ManagedDomains    []string       `bson:"managed_domains" json:"managed_domains"`
+	TcpRouterPort     int64          `bson:"tcp_router_port" json:"tcp_router_port"`
+	DnsNameserver     []string       `bson:"dns_nameserver" json:"dns_nameserver"`
+	FreeToUse         bool           `bson:"free_to_use" json:"free_to_use"`
+	Retired           bool           `bson:"retired" json:"retired"`
+	RetiredAt         schema.Date    `bson:"retired_at" json:"retired_at"`
+	TotalResources    ResourceAmount `bson:"total_resources" json:"total_resources"`
+	ReservedResources ResourceAmount `bson:"reserved_resources" json:"reserved_resources"`
+	Proofs            []Proof        `bson:"proofs" json:"proofs"`
+	Ifaces            []Iface        `bson:"ifaces" json:"ifaces"`
+	PublicConfig      *PublicIface   `bson:"public_config,omitempty" json:"public_config"`
 }
@@ -7,13 +7,18 @@ import (
 )
 
 type User struct {
-	ID          schema.ID `bson:"_id" json:"id"`
-	Name        string    `bson:"name" json:"name"`
-	Email       string    `bson:"email" json:"email"`
-	Pubkey      string    `bson:"pubkey" json:"pubkey"`
-	Host        string    `bson:"host" json:"host"`
-	Description string    `bson:"description" json:"description"`
-	Signature   string    `bson:"signature" json:"signature"`
+	ID            schema.ID `bson:"_id" json:"id"`
+	Name          string    `bson:"name" json:"name"`
+	Email         string    `bson:"email" json:"email"`
+	EmailVerified bool      `bson:"email_verified" json:"email_verified"`
+	Pubkey        string    `bson:"pubkey" json:"pubkey"`
+	Host          string    `bson:"host" json:"host"`
+	Description   string    `bson:"description" json:"description"`
+	Signature     string    `bson:"signature" json:"signature"`
+	RecoveryKeys  []string  `bson:"recovery_keys" json:"recovery_keys"`
+	KeyHistory    []UserKey `bson:"key_history" json:"key_history"`
+	Budget        Budget    `bson:"budget" json:"budget"`
+	BudgetVersion int64     `bson:"budget_version" json:"budget_version"`
 }
 
 func NewUser() (User, error) {
@@ -24,3 +29,101 @@ func NewUser() (User, error) {
 	}
 	return object, nil
 }
+
+type UserKey struct {
+	Pubkey     string      `bson:"pubkey" json:"pubkey"`
+	ValidFrom  schema.Date `bson:"valid_from" json:"valid_from"`
+	ValidUntil schema.Date `bson:"valid_until" json:"valid_until"`
+}
+
+func NewUserKey() (UserKey, error) {
+	const value = "{}"
+	var object UserKey
+	if err := json.Unmarshal([]byte(value), &object); err != nil {
+		return object, err
+	}
+	return object, nil
+}
+
+type Organization struct {
+	ID            schema.ID            `bson:"_id" json:"id"`
+	Name          string               `bson:"name" json:"name"`
+	Description   string               `bson:"description" json:"description"`
+	Members       []OrganizationMember `bson:"members" json:"members"`
+	Budget        Budget               `bson:"budget" json:"budget"`
+	BudgetVersion int64                `bson:"budget_version" json:"budget_version"`
+}
+
+func NewOrganization() (Organization, error) {
+	const value = "{}"
+	var object Organization
+	if err := json.Unmarshal([]byte(value), &object); err != nil {
+		return object, err
+	}
+	return object, nil
+}
+
+type OrganizationMember struct {
+	Tid  int64                `bson:"tid" json:"tid"`
+	Role OrganizationRoleEnum `bson:"role" json:"role"`
+}
+
+func NewOrganizationMember() (OrganizationMember, error) {
+	const value = "{}"
+	var object OrganizationMember
+	if err := json.Unmarshal([]byte(value), &object); err != nil {
+		return object, err
+	}
+	return object, nil
+}
+
+type OrganizationRoleEnum uint8
+
+const (
+	OrganizationRoleOwner OrganizationRoleEnum = iota
+	OrganizationRoleAdmin
+	OrganizationRoleMember
+)
+
+func (e OrganizationRoleEnum) String() string {
+	switch e {
+	case OrganizationRoleOwner:
+		return "owner"
+	case OrganizationRoleAdmin:
+		return "admin"
+	case OrganizationRoleMember:
+		return "member"
+	}
+	return "UNKNOWN"
+}
+
+type Budget struct {
+	MaxReservations int64           `bson:"max_reservations" json:"max_reservations"`
+	MaxCu           float64         `bson:"max_cu" json:"max_cu"`
+	MaxSu           float64         `bson:"max_su" json:"max_su"`
+	Spending        []SpendingLimit `bson:"spending" json:"spending"`
+}
+
+func NewBudget() (Budget, error) {
+	const value = "{}"
+	var object Budget
+	if err := json.Unmarshal([]byte(value), &object); err != nil {
+		return object, err
+	}
+	return object, nil
+}
+
+type SpendingLimit struct {
+	Asset  string  `bson:"asset" json:"asset"`
+	Amount float64 `bson:"amount" json:"amount"`
+	Period int64   `bson:"period" json:"period"`
+}
+
+func NewSpendingLimit() (SpendingLimit, error) {
+	const value = "{}"
+	var object SpendingLimit
+	if err := json.Unmarshal([]byte(value), &object); err != nil {
+		return object, err
+	}
+	return object, nil
+}
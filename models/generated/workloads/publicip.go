@@ -0,0 +1,13 @@
+package workloads
+
+import schema "github.com/threefoldtech/tfexplorer/schema"
+
+type PublicIP struct {
+	WorkloadId int64          `bson:"workload_id" json:"workload_id"`
+	NodeId     string         `bson:"node_id" json:"node_id"`
+	IPaddress  schema.IPRange `bson:"ipaddress" json:"ipaddress"`
+}
+
+func (p PublicIP) WorkloadID() int64 {
+	return p.WorkloadId
+}
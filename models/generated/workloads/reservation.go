@@ -19,6 +19,8 @@ type Reservation struct {
 	Epoch               schema.Date        `bson:"epoch" json:"epoch"`
 	Metadata            string             `bson:"metadata" json:"metadata"`
 	Results             []Result           `bson:"results" json:"results"`
+	RetiredNodes        []string           `bson:"retired_nodes" json:"retired_nodes"`
+	Version             int64              `bson:"version" json:"version"`
 }
 
 type NextActionEnum uint8
@@ -55,6 +57,7 @@ func (e NextActionEnum) String() string {
 
 type ReservationData struct {
 	Description             string                `bson:"description" json:"description"`
+	CustomerOrg             int64                 `bson:"customer_org" json:"customer_org"`
 	Currencies              []string              `bson:"currencies" json:"currencies"`
 	SigningRequestProvision SigningRequest        `bson:"signing_request_provision" json:"signing_request_provision"`
 	SigningRequestDelete    SigningRequest        `bson:"signing_request_delete" json:"signing_request_delete"`
@@ -68,12 +71,15 @@ type ReservationData struct {
 	Subdomains              []GatewaySubdomain    `bson:"subdomains" json:"subdomains"`
 	DomainDelegates         []GatewayDelegate     `bson:"domain_delegates" json:"domain_delegates"`
 	Gateway4To6s            []Gateway4To6         `bson:"gateway4to6" json:"gateway4to6"`
+	PublicIPs               []PublicIP            `bson:"public_ips" json:"public_ips"`
 	ExpirationProvisioning  schema.Date           `bson:"expiration_provisioning" json:"expiration_provisioning"`
 	ExpirationReservation   schema.Date           `bson:"expiration_reservation" json:"expiration_reservation"`
+	CertifiedOnly           bool                  `bson:"certified_only" json:"certified_only"`
 }
 
 type SigningRequest struct {
 	Signers   []int64 `bson:"signers" json:"signers"`
+	OrgId     int64   `bson:"org_id" json:"org_id"`
 	QuorumMin int64   `bson:"quorum_min" json:"quorum_min"`
 }
 
@@ -138,6 +144,7 @@ const (
 	WorkloadTypeSubDomain
 	WorkloadTypeDomainDelegate
 	WorkloadTypeGateway4To6
+	WorkloadTypePublicIP
 )
 
 // WorkloadTypes is a map of all the supported workload type
@@ -152,6 +159,7 @@ var WorkloadTypes = map[WorkloadTypeEnum]string{
 	WorkloadTypeSubDomain:      "subdomains",
 	WorkloadTypeDomainDelegate: "domain_delegates",
 	WorkloadTypeGateway4To6:    "gateway4to6",
+	WorkloadTypePublicIP:       "public_ips",
 }
 
 func (e WorkloadTypeEnum) String() string {
@@ -21,11 +21,30 @@ var (
 	ErrFailedToGetID = errors.New("failed to generate new id")
 )
 
-// NextID for a collection
-func NextID(ctx context.Context, db *mongo.Database, collection string) (schema.ID, error) {
-	result := db.Collection(Counters).FindOneAndUpdate(
+// IDGenerator gives the ids of the documents of a collection
+type IDGenerator interface {
+	// NextID returns a new id
+	NextID(ctx context.Context) (schema.ID, error)
+	// LastID returns the last id given, 0 if none was
+	LastID(ctx context.Context) (schema.ID, error)
+}
+
+// NewIDGenerator returns the IDGenerator of collection, the ids are counted
+// in the counters collection of db
+func NewIDGenerator(db *mongo.Database, collection string) IDGenerator {
+	return &counter{db: db, name: collection}
+}
+
+type counter struct {
+	db   *mongo.Database
+	name string
+}
+
+// NextID increments the counter of the collection
+func (c *counter) NextID(ctx context.Context) (schema.ID, error) {
+	result := c.db.Collection(Counters).FindOneAndUpdate(
 		ctx,
-		bson.M{"_id": collection},
+		bson.M{"_id": c.name},
 		bson.M{"$inc": bson.M{"sequence": 1}},
 		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
 	)
@@ -40,19 +59,9 @@ func NextID(ctx context.Context, db *mongo.Database, collection string) (schema.
 	return value.Sequence, err
 }
 
-//MustID must get next available ID, or panic with an error that has error.Is(err, ErrFailedToGetID) == true
-func MustID(ctx context.Context, db *mongo.Database, collection string) schema.ID {
-	id, err := NextID(ctx, db, collection)
-	if err != nil {
-		panic(fmt.Errorf("%w: %s", ErrFailedToGetID, err.Error()))
-	}
-
-	return id
-}
-
-// LastID get the last max value in the collection
-func LastID(ctx context.Context, db *mongo.Database, collection string) (schema.ID, error) {
-	result := db.Collection(Counters).FindOne(ctx, bson.M{"_id": collection})
+// LastID reads the counter of the collection
+func (c *counter) LastID(ctx context.Context) (schema.ID, error) {
+	result := c.db.Collection(Counters).FindOne(ctx, bson.M{"_id": c.name})
 
 	if result.Err() == mongo.ErrNoDocuments {
 		return 0, nil
@@ -66,3 +75,13 @@ func LastID(ctx context.Context, db *mongo.Database, collection string) (schema.
 	err := result.Decode(&value)
 	return value.Sequence, err
 }
+
+//MustID must get next available ID, or panic with an error that has error.Is(err, ErrFailedToGetID) == true
+func MustID(ctx context.Context, ids IDGenerator) schema.ID {
+	id, err := ids.NextID(ctx)
+	if err != nil {
+		panic(fmt.Errorf("%w: %s", ErrFailedToGetID, err.Error()))
+	}
+
+	return id
+}
@@ -0,0 +1,603 @@
+package models
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The memory collections evaluate the filters of the repositories like the
+// database does, for the subset of the query language the filters use:
+// equality, comparisons, $in, $nin, $exists, $regex, $elemMatch, $not, $and,
+// $or and $nor on dotted paths, which traverse arrays
+
+func match(doc, filter bson.Raw) (bool, error) {
+	elements, err := filter.Elements()
+	if err != nil {
+		return false, err
+	}
+
+	for _, element := range elements {
+		var (
+			ok  bool
+			err error
+		)
+
+		switch key := element.Key(); key {
+		case "$and", "$or", "$nor":
+			ok, err = matchLogical(doc, key, element.Value())
+		default:
+			if strings.HasPrefix(key, "$") {
+				return false, fmt.Errorf("unsupported query operator '%s'", key)
+			}
+			ok, err = matchField(doc, key, element.Value())
+		}
+
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func matchLogical(doc bson.Raw, op string, value bson.RawValue) (bool, error) {
+	arr, ok := value.ArrayOK()
+	if !ok {
+		return false, fmt.Errorf("%s must be an array", op)
+	}
+
+	values, err := arr.Values()
+	if err != nil {
+		return false, err
+	}
+
+	for _, v := range values {
+		sub, ok := v.DocumentOK()
+		if !ok {
+			return false, fmt.Errorf("%s must be an array of documents", op)
+		}
+
+		matched, err := match(doc, sub)
+		if err != nil {
+			return false, err
+		}
+
+		switch {
+		case op == "$and" && !matched:
+			return false, nil
+		case op == "$or" && matched:
+			return true, nil
+		case op == "$nor" && matched:
+			return false, nil
+		}
+	}
+
+	return op != "$or", nil
+}
+
+// lookup returns the values at path in doc. Arrays on the way are
+// traversed, and the elements of an array at the end of the path are
+// returned with the array itself
+func lookup(value bson.RawValue, path []string) []bson.RawValue {
+	if len(path) == 0 {
+		out := []bson.RawValue{value}
+		if arr, ok := value.ArrayOK(); ok {
+			elements, _ := arr.Values()
+			out = append(out, elements...)
+		}
+		return out
+	}
+
+	if doc, ok := value.DocumentOK(); ok {
+		v, err := doc.LookupErr(path[0])
+		if err != nil {
+			return nil
+		}
+		return lookup(v, path[1:])
+	}
+
+	arr, ok := value.ArrayOK()
+	if !ok {
+		return nil
+	}
+
+	if index, err := strconv.Atoi(path[0]); err == nil {
+		v, err := arr.LookupErr(strconv.Itoa(index))
+		if err != nil {
+			return nil
+		}
+		return lookup(v, path[1:])
+	}
+
+	elements, _ := arr.Values()
+	var out []bson.RawValue
+	for _, element := range elements {
+		if element.Type == bsontype.EmbeddedDocument {
+			out = append(out, lookup(element, path)...)
+		}
+	}
+
+	return out
+}
+
+func lookupDoc(doc bson.Raw, path string) []bson.RawValue {
+	return lookup(bson.RawValue{Type: bsontype.EmbeddedDocument, Value: doc}, strings.Split(path, "."))
+}
+
+// isOperators tests if value is a document of query operators
+func isOperators(value bson.RawValue) (bson.Raw, bool) {
+	doc, ok := value.DocumentOK()
+	if !ok {
+		return nil, false
+	}
+
+	elements, err := doc.Elements()
+	if err != nil || len(elements) == 0 {
+		return nil, false
+	}
+
+	return doc, strings.HasPrefix(elements[0].Key(), "$")
+}
+
+func matchField(doc bson.Raw, path string, cond bson.RawValue) (bool, error) {
+	values := lookupDoc(doc, path)
+
+	operators, ok := isOperators(cond)
+	if !ok {
+		return matchEqual(values, cond), nil
+	}
+
+	return matchOperators(values, operators)
+}
+
+func matchOperators(values []bson.RawValue, operators bson.Raw) (bool, error) {
+	elements, err := operators.Elements()
+	if err != nil {
+		return false, err
+	}
+
+	for _, element := range elements {
+		var (
+			ok  bool
+			err error
+			arg = element.Value()
+		)
+
+		switch op := element.Key(); op {
+		case "$eq":
+			ok = matchEqual(values, arg)
+		case "$ne":
+			ok = !matchEqual(values, arg)
+		case "$gt", "$gte", "$lt", "$lte":
+			ok = matchCompare(values, op, arg)
+		case "$in", "$nin":
+			ok, err = matchIn(values, arg)
+			if op == "$nin" {
+				ok = !ok
+			}
+		case "$exists":
+			ok = (len(values) > 0) == arg.Boolean()
+		case "$regex":
+			ok, err = matchRegex(values, arg, operators.Lookup("$options"))
+		case "$options":
+			ok = true
+		case "$elemMatch":
+			ok, err = matchElem(values, arg)
+		case "$not":
+			sub, isDoc := isOperators(arg)
+			if !isDoc {
+				return false, fmt.Errorf("$not must be a document of operators")
+			}
+			ok, err = matchOperators(values, sub)
+			ok = !ok
+		default:
+			return false, fmt.Errorf("unsupported query operator '%s'", op)
+		}
+
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func matchEqual(values []bson.RawValue, arg bson.RawValue) bool {
+	if len(values) == 0 {
+		// missing fields are equal to null
+		return arg.Type == bsontype.Null
+	}
+
+	for _, v := range values {
+		if arg.Type == bsontype.Regex && v.Type == bsontype.String {
+			if ok, _ := matchRegex([]bson.RawValue{v}, arg, bson.RawValue{}); ok {
+				return true
+			}
+		}
+
+		if equal(v, arg) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchCompare(values []bson.RawValue, op string, arg bson.RawValue) bool {
+	for _, v := range values {
+		c, ok := compare(v, arg)
+		if !ok {
+			continue
+		}
+
+		switch {
+		case op == "$gt" && c > 0,
+			op == "$gte" && c >= 0,
+			op == "$lt" && c < 0,
+			op == "$lte" && c <= 0:
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchIn(values []bson.RawValue, arg bson.RawValue) (bool, error) {
+	arr, ok := arg.ArrayOK()
+	if !ok {
+		return false, fmt.Errorf("$in and $nin must be arrays")
+	}
+
+	candidates, err := arr.Values()
+	if err != nil {
+		return false, err
+	}
+
+	for _, candidate := range candidates {
+		if matchEqual(values, candidate) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func matchRegex(values []bson.RawValue, arg, opts bson.RawValue) (bool, error) {
+	var pattern, flags string
+	switch arg.Type {
+	case bsontype.Regex:
+		pattern, flags = arg.Regex()
+	case bsontype.String:
+		pattern = arg.StringValue()
+	default:
+		return false, fmt.Errorf("$regex must be a string or a regular expression")
+	}
+
+	if opts.Type == bsontype.String {
+		flags = opts.StringValue()
+	}
+
+	// only the flags supported by go
+	var prefix string
+	for _, f := range flags {
+		if strings.ContainsRune("ims", f) {
+			prefix += string(f)
+		}
+	}
+	if len(prefix) != 0 {
+		pattern = "(?" + prefix + ")" + pattern
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return false, errors.Wrap(err, "invalid regular expression")
+	}
+
+	for _, v := range values {
+		if v.Type == bsontype.String && re.MatchString(v.StringValue()) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func matchElem(values []bson.RawValue, arg bson.RawValue) (bool, error) {
+	cond, ok := arg.DocumentOK()
+	if !ok {
+		return false, fmt.Errorf("$elemMatch must be a document")
+	}
+
+	_, operators := isOperators(arg)
+	for _, v := range values {
+		arr, ok := v.ArrayOK()
+		if !ok {
+			continue
+		}
+
+		elements, err := arr.Values()
+		if err != nil {
+			return false, err
+		}
+
+		for _, element := range elements {
+			var matched bool
+			if operators {
+				matched, err = matchOperators([]bson.RawValue{element}, cond)
+			} else if doc, isDoc := element.DocumentOK(); isDoc {
+				matched, err = match(doc, cond)
+			}
+
+			if err != nil {
+				return false, err
+			}
+			if matched {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// rank is the position of the type of v in the order the database sorts
+// values of different types
+func rank(v bson.RawValue) int {
+	switch v.Type {
+	case bsontype.MinKey:
+		return 0
+	case bsontype.Null, bsontype.Undefined, 0:
+		return 1
+	case bsontype.Int32, bsontype.Int64, bsontype.Double, bsontype.Decimal128:
+		return 2
+	case bsontype.String, bsontype.Symbol:
+		return 3
+	case bsontype.EmbeddedDocument:
+		return 4
+	case bsontype.Array:
+		return 5
+	case bsontype.Binary:
+		return 6
+	case bsontype.ObjectID:
+		return 7
+	case bsontype.Boolean:
+		return 8
+	case bsontype.DateTime:
+		return 9
+	case bsontype.Timestamp:
+		return 10
+	case bsontype.Regex:
+		return 11
+	default:
+		return 12
+	}
+}
+
+func integer(v bson.RawValue) int64 {
+	if v.Type == bsontype.Int32 {
+		return int64(v.Int32())
+	}
+
+	return v.Int64()
+}
+
+func number(v bson.RawValue) float64 {
+	switch v.Type {
+	case bsontype.Int32:
+		return float64(v.Int32())
+	case bsontype.Int64:
+		return float64(v.Int64())
+	case bsontype.Double:
+		return v.Double()
+	}
+
+	return 0
+}
+
+// compare compares values of the same kind, ok is false if they can not
+// be compared
+func compare(a, b bson.RawValue) (c int, ok bool) {
+	if rank(a) != rank(b) {
+		return 0, false
+	}
+
+	switch rank(a) {
+	case 1:
+		return 0, true
+	case 2:
+		if a.Type == bsontype.Decimal128 || b.Type == bsontype.Decimal128 {
+			return 0, false
+		}
+		if a.Type != bsontype.Double && b.Type != bsontype.Double {
+			return cmpInt(integer(a), integer(b)), true
+		}
+		x, y := number(a), number(b)
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	case 3:
+		return strings.Compare(str(a), str(b)), true
+	case 4:
+		return compareDocuments(a.Document(), b.Document())
+	case 8:
+		x, y := a.Boolean(), b.Boolean()
+		switch {
+		case x == y:
+			return 0, true
+		case !x:
+			return -1, true
+		}
+		return 1, true
+	case 9:
+		return cmpInt(a.DateTime(), b.DateTime()), true
+	case 10:
+		at, ai := a.Timestamp()
+		bt, bi := b.Timestamp()
+		if at != bt {
+			return cmpInt(int64(at), int64(bt)), true
+		}
+		return cmpInt(int64(ai), int64(bi)), true
+	}
+
+	// documents, arrays and the other types are only compared for equality
+	if a.Type == b.Type && bytes.Equal(a.Value, b.Value) {
+		return 0, true
+	}
+
+	return bytes.Compare(a.Value, b.Value), a.Type == b.Type
+}
+
+// compareDocuments orders documents like mongo, comparing their fields in
+// order by type, name then value
+func compareDocuments(a, b bson.Raw) (int, bool) {
+	x, err := a.Elements()
+	if err != nil {
+		return 0, false
+	}
+	y, err := b.Elements()
+	if err != nil {
+		return 0, false
+	}
+
+	for i := 0; i < len(x) && i < len(y); i++ {
+		if c := cmpInt(int64(rank(x[i].Value())), int64(rank(y[i].Value()))); c != 0 {
+			return c, true
+		}
+		if c := strings.Compare(x[i].Key(), y[i].Key()); c != 0 {
+			return c, true
+		}
+		if c, ok := compare(x[i].Value(), y[i].Value()); !ok || c != 0 {
+			return c, ok
+		}
+	}
+
+	return cmpInt(int64(len(x)), int64(len(y))), true
+}
+
+func str(v bson.RawValue) string {
+	if v.Type == bsontype.Symbol {
+		return v.Symbol()
+	}
+
+	return v.StringValue()
+}
+
+func cmpInt(x, y int64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+
+	return 0
+}
+
+func equal(a, b bson.RawValue) bool {
+	c, ok := compare(a, b)
+	return ok && c == 0
+}
+
+// order compares values of any type for sorting
+func order(a, b bson.RawValue) int {
+	if ra, rb := rank(a), rank(b); ra != rb {
+		return cmpInt(int64(ra), int64(rb))
+	}
+
+	c, _ := compare(a, b)
+	return c
+}
+
+// find returns the documents of docs matching filter, sorted, skipped and
+// limited like the options. Projections are not supported
+func find(docs []bson.Raw, filter interface{}, opts ...*options.FindOptions) ([]bson.Raw, error) {
+	// like mongo, an unset filter matches all the documents
+	if d, ok := filter.(bson.D); ok && d == nil {
+		filter = nil
+	}
+
+	raw, err := bson.Marshal(bson.D{})
+	if filter != nil {
+		raw, err = bson.Marshal(filter)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid filter")
+	}
+
+	out := []bson.Raw{}
+	for _, doc := range docs {
+		ok, err := match(doc, raw)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			out = append(out, doc)
+		}
+	}
+
+	opt := options.MergeFindOptions(opts...)
+	if opt.Sort != nil {
+		keys, err := bson.Marshal(opt.Sort)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid sort")
+		}
+
+		elements, err := bson.Raw(keys).Elements()
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid sort")
+		}
+
+		first := func(doc bson.Raw, path string) bson.RawValue {
+			if values := lookupDoc(doc, path); len(values) > 0 {
+				return values[0]
+			}
+			return bson.RawValue{Type: bsontype.Null}
+		}
+
+		sort.SliceStable(out, func(i, j int) bool {
+			for _, element := range elements {
+				c := order(first(out[i], element.Key()), first(out[j], element.Key()))
+				if number(element.Value()) < 0 {
+					c = -c
+				}
+				if c != 0 {
+					return c < 0
+				}
+			}
+			return false
+		})
+	}
+
+	if opt.Skip != nil {
+		skip := int(*opt.Skip)
+		if skip > len(out) {
+			skip = len(out)
+		}
+		out = out[skip:]
+	}
+
+	if opt.Limit != nil && *opt.Limit != 0 {
+		limit := int(*opt.Limit)
+		if limit < 0 {
+			limit = -limit
+		}
+		if limit < len(out) {
+			out = out[:limit]
+		}
+	}
+
+	return out, nil
+}
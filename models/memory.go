@@ -0,0 +1,138 @@
+package models
+
+import (
+	"reflect"
+
+	"github.com/threefoldtech/tfexplorer/schema"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MemoryCollection keeps bson encoded documents by key. It is used by the
+// in memory repositories, so documents are copied in and out like with the
+// database. It is not safe for concurrent use.
+type MemoryCollection struct {
+	docs map[interface{}][]byte
+	// keys are kept in insertion order, which is the natural order of the
+	// documents when a find is not sorted
+	keys   []interface{}
+	lastID schema.ID
+}
+
+// NewMemoryCollection creates an empty collection
+func NewMemoryCollection() *MemoryCollection {
+	return &MemoryCollection{docs: make(map[interface{}][]byte)}
+}
+
+// NextID returns the next id of the collection, like IDGenerator.NextID
+func (c *MemoryCollection) NextID() schema.ID {
+	c.lastID++
+	return c.lastID
+}
+
+// LastID returns the last id given by NextID, like IDGenerator.LastID
+func (c *MemoryCollection) LastID() schema.ID {
+	return c.lastID
+}
+
+// Get decodes the document with key in out, mongo.ErrNoDocuments
+// is returned if it does not exist
+func (c *MemoryCollection) Get(key, out interface{}) error {
+	data, ok := c.docs[key]
+	if !ok {
+		return mongo.ErrNoDocuments
+	}
+
+	return bson.Unmarshal(data, out)
+}
+
+// Put stores doc with key, replacing the existing document if any
+func (c *MemoryCollection) Put(key, doc interface{}) error {
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := c.docs[key]; !ok {
+		c.keys = append(c.keys, key)
+	}
+
+	c.docs[key] = data
+	return nil
+}
+
+// Delete removes the document with key
+func (c *MemoryCollection) Delete(key interface{}) {
+	if _, ok := c.docs[key]; !ok {
+		return
+	}
+
+	delete(c.docs, key)
+	for i, k := range c.keys {
+		if k == key {
+			c.keys = append(c.keys[:i], c.keys[i+1:]...)
+			break
+		}
+	}
+}
+
+// Keys returns the keys of all the documents, in insertion order
+func (c *MemoryCollection) Keys() []interface{} {
+	keys := make([]interface{}, len(c.keys))
+	copy(keys, c.keys)
+
+	return keys
+}
+
+// Find returns the documents matching filter, sorted, skipped and limited
+// like the database does with the options. Projections are ignored
+func (c *MemoryCollection) Find(filter interface{}, opts ...*options.FindOptions) ([]bson.Raw, error) {
+	docs := make([]bson.Raw, 0, len(c.keys))
+	for _, key := range c.keys {
+		docs = append(docs, append(bson.Raw(nil), c.docs[key]...))
+	}
+
+	return find(docs, filter, opts...)
+}
+
+// Count returns the number of documents matching filter
+func (c *MemoryCollection) Count(filter interface{}) (int64, error) {
+	docs, err := c.Find(filter)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(len(docs)), nil
+}
+
+// List decodes the page of the documents matching filter in out, a pointer
+// to a slice, and counts them like List
+func (c *MemoryCollection) List(filter bson.D, pager Pager, out interface{}, opts ...*options.FindOptions) (int64, error) {
+	if filter == nil {
+		filter = bson.D{}
+	}
+
+	opts = append([]*options.FindOptions{pager.Options()}, opts...)
+	docs, err := c.Find(pager.Filter(filter), opts...)
+	if err != nil {
+		return 0, err
+	}
+
+	v := reflect.ValueOf(out).Elem()
+	items := reflect.MakeSlice(v.Type(), 0, len(docs))
+	for _, doc := range docs {
+		item := reflect.New(v.Type().Elem())
+		if err := bson.Unmarshal(doc, item.Interface()); err != nil {
+			return 0, err
+		}
+		items = reflect.Append(items, item.Elem())
+	}
+	v.Set(items)
+
+	if !pager.Paged() {
+		return 0, nil
+	}
+
+	return c.Count(filter)
+}
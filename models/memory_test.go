@@ -0,0 +1,144 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type node struct {
+	ID      int64    `bson:"_id"`
+	NodeID  string   `bson:"node_id"`
+	FarmID  int64    `bson:"farm_id"`
+	Free    bool     `bson:"free_to_use"`
+	Country string   `bson:"location,omitempty"`
+	Ports   []int64  `bson:"wg_ports"`
+	Ifaces  []iface  `bson:"ifaces"`
+	Tags    []string `bson:"tags,omitempty"`
+}
+
+type iface struct {
+	Name string `bson:"name"`
+	Addr string `bson:"addr"`
+}
+
+func newNodes(t *testing.T) *MemoryCollection {
+	c := NewMemoryCollection()
+	for _, n := range []node{
+		{NodeID: "c", FarmID: 1, Free: true, Country: "Belgium", Ports: []int64{1, 2}, Ifaces: []iface{{"eth0", "10.0.0.1"}}},
+		{NodeID: "a", FarmID: 2, Country: "Egypt", Ifaces: []iface{{"eth0", "10.0.0.2"}, {"zos", "fe80::1"}}},
+		{NodeID: "b", FarmID: 1, Ports: []int64{3}},
+	} {
+		n.ID = int64(c.NextID())
+		require.NoError(t, c.Put(n.ID, n))
+	}
+
+	return c
+}
+
+func ids(t *testing.T, docs []bson.Raw) []string {
+	out := []string{}
+	for _, doc := range docs {
+		var n node
+		require.NoError(t, bson.Unmarshal(doc, &n))
+		out = append(out, n.NodeID)
+	}
+
+	return out
+}
+
+func TestMemoryCollectionFind(t *testing.T) {
+	c := newNodes(t)
+
+	cases := []struct {
+		name   string
+		filter interface{}
+		nodes  []string
+	}{
+		{"all", nil, []string{"c", "a", "b"}},
+		{"empty", bson.D{}, []string{"c", "a", "b"}},
+		{"equal", bson.D{{Key: "farm_id", Value: 1}}, []string{"c", "b"}},
+		{"several fields", bson.M{"farm_id": 1, "free_to_use": true}, []string{"c"}},
+		{"missing is null", bson.M{"location": nil}, []string{"b"}},
+		{"ne missing", bson.M{"location": bson.M{"$ne": "Egypt"}}, []string{"c", "b"}},
+		{"in", bson.M{"node_id": bson.M{"$in": []string{"a", "b", "z"}}}, []string{"a", "b"}},
+		{"nin", bson.M{"node_id": bson.M{"$nin": []string{"a"}}}, []string{"c", "b"}},
+		{"gt", bson.M{"_id": bson.M{"$gt": int64(1)}}, []string{"a", "b"}},
+		{"range", bson.M{"_id": bson.M{"$gte": 2, "$lt": 3}}, []string{"a"}},
+		{"array element", bson.M{"wg_ports": 2}, []string{"c"}},
+		{"array comparison", bson.M{"wg_ports": bson.M{"$gt": 2}}, []string{"b"}},
+		{"array path", bson.M{"ifaces.name": "zos"}, []string{"a"}},
+		{"document comparison", bson.M{"ifaces": bson.M{"$gt": iface{"eth0", "10.0.0.1"}}}, []string{"a"}},
+		{"exists", bson.M{"location": bson.M{"$exists": true}}, []string{"c", "a"}},
+		{"regex", bson.M{"location": primitive.Regex{Pattern: "^bel", Options: "i"}}, []string{"c"}},
+		{"regex operator", bson.M{"location": bson.M{"$regex": "gyp"}}, []string{"a"}},
+		{"elem match", bson.M{"ifaces": bson.M{"$elemMatch": bson.M{"name": "eth0", "addr": "10.0.0.2"}}}, []string{"a"}},
+		{"elem match operators", bson.M{"wg_ports": bson.M{"$elemMatch": bson.M{"$gte": 2, "$lt": 3}}}, []string{"c"}},
+		{"not", bson.M{"_id": bson.M{"$not": bson.M{"$gt": 1}}}, []string{"c"}},
+		{"or", bson.M{"$or": bson.A{bson.M{"node_id": "a"}, bson.M{"free_to_use": true}}}, []string{"c", "a"}},
+		{"and", bson.D{{Key: "$and", Value: bson.A{bson.M{"farm_id": 1}, bson.M{"node_id": bson.M{"$ne": "c"}}}}}, []string{"b"}},
+		{"nor", bson.M{"$nor": bson.A{bson.M{"farm_id": 2}}}, []string{"c", "b"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			docs, err := c.Find(tc.filter)
+			require.NoError(t, err)
+			assert.Equal(t, tc.nodes, ids(t, docs))
+
+			count, err := c.Count(tc.filter)
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(tc.nodes)), count)
+		})
+	}
+
+	_, err := c.Find(bson.M{"$text": bson.M{"$search": "node"}})
+	assert.Error(t, err, "unsupported operators are refused")
+}
+
+func TestMemoryCollectionFindOptions(t *testing.T) {
+	c := newNodes(t)
+
+	docs, err := c.Find(nil, options.Find().SetSort(bson.D{{Key: "node_id", Value: 1}}))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, ids(t, docs))
+
+	docs, err = c.Find(nil, options.Find().SetSort(bson.D{{Key: "farm_id", Value: -1}, {Key: "_id", Value: 1}}).SetSkip(1).SetLimit(1))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c"}, ids(t, docs))
+
+	// the pages of a cursor listing cover the collection
+	pager := Page(0, 2)
+	pager.Sort = "node_id"
+
+	var listed []string
+	for {
+		docs, err := c.Find(pager.Filter(bson.D{}), pager.Options())
+		require.NoError(t, err)
+		listed = append(listed, ids(t, docs)...)
+
+		next, err := pager.After(len(docs), docs[len(docs)-1])
+		require.NoError(t, err)
+		if len(next) == 0 {
+			break
+		}
+
+		cursor, err := ParseCursor(next)
+		require.NoError(t, err)
+		pager.Cursor = &cursor
+	}
+
+	assert.Equal(t, []string{"a", "b", "c"}, listed)
+}
+
+func TestMemoryCollectionKeys(t *testing.T) {
+	c := newNodes(t)
+	c.Delete(int64(2))
+	require.NoError(t, c.Put(int64(2), node{ID: 2, NodeID: "a"}))
+
+	assert.Equal(t, []interface{}{int64(1), int64(3), int64(2)}, c.Keys())
+}
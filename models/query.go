@@ -0,0 +1,396 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/threefoldtech/tfexplorer/schema"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// FieldType is the type of the values of a queryable field
+type FieldType int
+
+const (
+	// FieldString is a string field
+	FieldString FieldType = iota
+	// FieldInt is an integer field
+	FieldInt
+	// FieldFloat is a floating point field
+	FieldFloat
+	// FieldBool is a boolean field
+	FieldBool
+	// FieldDate is a date field, values are either unix timestamps or RFC3339 dates
+	FieldDate
+	// FieldObject is a document or a list, it can only be used in fields=
+	FieldObject
+)
+
+// Field is a field of a resource that can be used in list queries
+type Field struct {
+	// Key is the bson key of the field, the query name is used if empty
+	Key  string
+	Type FieldType
+	// Sortable fields can be used in sort=, they should be indexed
+	Sortable bool
+}
+
+// Fields is the allowlist of the fields of a resource that can be used in
+// list queries, by name. Names of fields with a top level key are the json
+// names of the field and can be used in fields= as well
+type Fields map[string]Field
+
+func (f Fields) key(name string) string {
+	if field := f[name]; len(field.Key) != 0 {
+		return field.Key
+	}
+	return name
+}
+
+// operators supported in field__op=value
+var operators = map[string]string{
+	"eq":  "$eq",
+	"ne":  "$ne",
+	"gt":  "$gt",
+	"gte": "$gte",
+	"lt":  "$lt",
+	"lte": "$lte",
+	"in":  "$in",
+	"nin": "$nin",
+}
+
+// Query is a list query made of comparisons on fields (field__op=value), a
+// sort (sort=field or sort=-field for descending order) and a projection
+// (fields=a,b). in and nin take comma separated lists. Parameters without an
+// operator are left to the list endpoints, as they predate the query language
+type Query struct {
+	// allowed are the fields of the resource, cursors are checked against them
+	allowed    Fields
+	conditions bson.M
+	sort       string
+	desc       bool
+	fields     []string
+	projection bson.D
+}
+
+// ParseQuery parses the list query of the request, only fields in the
+// allowlist can be used
+func ParseQuery(r *http.Request, fields Fields) (Query, error) {
+	q := Query{allowed: fields, conditions: bson.M{}}
+
+	params := r.URL.Query()
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	// parse in a stable order so errors are reproducible
+	sort.Strings(names)
+
+	for _, name := range names {
+		idx := strings.LastIndex(name, "__")
+		if idx < 0 {
+			continue
+		}
+
+		fieldName, opName := name[:idx], name[idx+2:]
+		field, ok := fields[fieldName]
+		if !ok {
+			return q, fmt.Errorf("unknown field '%s'", fieldName)
+		}
+
+		op, ok := operators[opName]
+		if !ok {
+			return q, fmt.Errorf("unknown operator '%s' on field '%s'", opName, fieldName)
+		}
+
+		value, err := field.parse(params.Get(name), op == "$in" || op == "$nin")
+		if err != nil {
+			return q, fmt.Errorf("invalid value for '%s': %w", name, err)
+		}
+
+		key := fields.key(fieldName)
+		cond, ok := q.conditions[key].(bson.M)
+		if !ok {
+			cond = bson.M{}
+			q.conditions[key] = cond
+		}
+		cond[op] = value
+	}
+
+	if s := params.Get("sort"); len(s) != 0 {
+		if strings.HasPrefix(s, "-") {
+			q.desc = true
+			s = s[1:]
+		}
+
+		field, ok := fields[s]
+		if !ok || !field.Sortable {
+			return q, fmt.Errorf("can not sort on '%s'", s)
+		}
+		q.sort = fields.key(s)
+	}
+
+	if s := params.Get("fields"); len(s) != 0 {
+		for _, name := range strings.Split(s, ",") {
+			name = strings.TrimSpace(name)
+			if _, ok := fields[name]; !ok || strings.Contains(fields.key(name), ".") {
+				return q, fmt.Errorf("unknown field '%s' in fields", name)
+			}
+
+			q.fields = append(q.fields, name)
+			q.projection = append(q.projection, bson.E{Key: fields.key(name), Value: 1})
+		}
+	}
+
+	return q, nil
+}
+
+// field returns the field with the bson key
+func (f Fields) field(key string) (Field, bool) {
+	for name, field := range f {
+		if f.key(name) == key {
+			return field, true
+		}
+	}
+
+	return Field{}, false
+}
+
+// check makes sure the cursor sorts on a sortable field and that its values
+// have the type of the fields. Cursors are opaque to the clients but are not
+// signed, so they must not carry values that mongo would read as operators
+func (f Fields) check(c Cursor) error {
+	field, ok := f.field(c.Sort)
+	if !ok || !field.Sortable {
+		return fmt.Errorf("invalid cursor, can not sort on '%s'", c.Sort)
+	}
+
+	if !field.accepts(c.Value) {
+		return fmt.Errorf("invalid cursor value for '%s'", c.Sort)
+	}
+
+	id, ok := f.field("_id")
+	if !ok {
+		return fmt.Errorf("invalid cursor, resource has no id field")
+	}
+
+	if !id.accepts(c.ID) {
+		return fmt.Errorf("invalid cursor id")
+	}
+
+	return nil
+}
+
+// accepts checks if v is a scalar of the type of the field
+func (f Field) accepts(v bson.RawValue) bool {
+	switch f.Type {
+	case FieldString:
+		return v.Type == bsontype.String
+	case FieldInt:
+		return v.Type == bsontype.Int32 || v.Type == bsontype.Int64
+	case FieldFloat:
+		return v.Type == bsontype.Double || v.Type == bsontype.Int32 || v.Type == bsontype.Int64
+	case FieldBool:
+		return v.Type == bsontype.Boolean
+	case FieldDate:
+		if v.Type == bsontype.DateTime {
+			return true
+		}
+
+		// schema.Date is stored as a document holding the time only
+		doc, ok := v.DocumentOK()
+		if !ok {
+			return false
+		}
+
+		elements, err := doc.Elements()
+		return err == nil && len(elements) == 1 &&
+			elements[0].Key() == "time" && elements[0].Value().Type == bsontype.DateTime
+	}
+
+	return false
+}
+
+func (f Field) parse(s string, list bool) (interface{}, error) {
+	if list {
+		parts := strings.Split(s, ",")
+		values := make(bson.A, 0, len(parts))
+		for _, part := range parts {
+			value, err := f.parse(part, false)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		return values, nil
+	}
+
+	switch f.Type {
+	case FieldObject:
+		return nil, fmt.Errorf("field can not be compared")
+	case FieldInt:
+		return strconv.ParseInt(s, 10, 64)
+	case FieldFloat:
+		return strconv.ParseFloat(s, 64)
+	case FieldBool:
+		return strconv.ParseBool(s)
+	case FieldDate:
+		if ts, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return schema.Date{Time: time.Unix(ts, 0)}, nil
+		}
+
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return nil, fmt.Errorf("expecting a unix timestamp or a RFC3339 date")
+		}
+		return schema.Date{Time: t}, nil
+	}
+
+	return s, nil
+}
+
+// Apply adds the conditions of the query to filter
+func (q Query) Apply(filter bson.D) bson.D {
+	if len(q.conditions) == 0 {
+		return filter
+	}
+
+	keys := make([]string, 0, len(q.conditions))
+	for key := range q.conditions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	conditions := make([]bson.M, 0, len(keys))
+	for _, key := range keys {
+		conditions = append(conditions, bson.M{key: q.conditions[key]})
+	}
+
+	return And(filter, conditions...)
+}
+
+// Page sets the sort of the query on pager. A cursor can only be used with
+// the sort it was created for, and only on a sortable field of the resource
+func (q Query) Page(pager Pager) (Pager, error) {
+	if pager.Cursor != nil {
+		if err := q.allowed.check(*pager.Cursor); err != nil {
+			return pager, err
+		}
+	}
+
+	if len(q.sort) == 0 {
+		return pager, nil
+	}
+
+	if pager.Cursor != nil && (pager.Sort != q.sort || pager.Desc != q.desc) {
+		return pager, fmt.Errorf("sort does not match the cursor")
+	}
+
+	pager.Sort = q.sort
+	pager.Desc = q.desc
+	return pager, nil
+}
+
+// Projected returns true if the query selects the fields to return
+func (q Query) Projected() bool {
+	return len(q.fields) != 0
+}
+
+// Projection returns the find options loading only the fields of the query.
+// The _id and the sort key are always loaded since cursors are built on them
+func (q Query) Projection() *options.FindOptions {
+	if !q.Projected() {
+		return options.Find()
+	}
+
+	keys := []string{}
+	for _, e := range q.projection {
+		keys = append(keys, e.Key)
+	}
+	keys = append(keys, "_id", q.sort)
+
+	// mongo rejects a projection with a path and one of its parents
+	seen := make(map[string]struct{})
+	projection := bson.D{}
+	for _, key := range keys {
+		top := strings.SplitN(key, ".", 2)[0]
+		if _, ok := seen[top]; ok || len(key) == 0 {
+			continue
+		}
+
+		seen[top] = struct{}{}
+		projection = append(projection, bson.E{Key: key, Value: 1})
+	}
+
+	return options.Find().SetProjection(projection)
+}
+
+// Project returns items with only the fields of the query, items are returned
+// as is if the query has no projection
+func (q Query) Project(items interface{}) (interface{}, error) {
+	if !q.Projected() {
+		return items, nil
+	}
+
+	data, err := json.Marshal(items)
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []map[string]json.RawMessage
+	if err := json.Unmarshal(data, &objects); err != nil {
+		return nil, err
+	}
+
+	projected := make([]map[string]json.RawMessage, 0, len(objects))
+	for _, object := range objects {
+		selected := make(map[string]json.RawMessage, len(q.fields))
+		for _, name := range q.fields {
+			if value, ok := object[name]; ok {
+				selected[name] = value
+			}
+		}
+		projected = append(projected, selected)
+	}
+
+	return projected, nil
+}
+
+// And adds conditions to filter in its top level $and, so they can not clash
+// with the keys already used by filter
+func And(filter bson.D, conditions ...bson.M) bson.D {
+	if len(conditions) == 0 {
+		return filter
+	}
+
+	for i, e := range filter {
+		if e.Key != "$and" {
+			continue
+		}
+
+		existing, _ := e.Value.(bson.A)
+		and := append(bson.A{}, existing...)
+		for _, cond := range conditions {
+			and = append(and, cond)
+		}
+
+		// copy so filter is not modified, filters are built by appending
+		result := append(bson.D{}, filter...)
+		result[i] = bson.E{Key: "$and", Value: and}
+		return result
+	}
+
+	and := make(bson.A, 0, len(conditions))
+	for _, cond := range conditions {
+		and = append(and, cond)
+	}
+
+	return append(filter, bson.E{Key: "$and", Value: and})
+}
@@ -0,0 +1,90 @@
+package models
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/tfexplorer/schema"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var testFields = Fields{
+	"id":       {Key: "_id", Type: FieldInt, Sortable: true},
+	"name":     {Type: FieldString, Sortable: true},
+	"cru":      {Key: "total_resources.cru", Type: FieldInt, Sortable: true},
+	"updated":  {Type: FieldDate},
+	"location": {Type: FieldObject},
+}
+
+func TestParseQuery(t *testing.T) {
+	r := httptest.NewRequest("GET", "/nodes?cru__gte=2&cru__lt=8&id__in=1,2&updated__lt=100&farm=3&sort=-cru&fields=id,name", nil)
+	q, err := ParseQuery(r, testFields)
+	require.NoError(t, err)
+
+	filter := q.Apply(bson.D{{Key: "farm_id", Value: 3}})
+	assert.Equal(t, bson.D{
+		{Key: "farm_id", Value: 3},
+		{Key: "$and", Value: bson.A{
+			bson.M{"_id": bson.M{"$in": bson.A{int64(1), int64(2)}}},
+			bson.M{"total_resources.cru": bson.M{"$gte": int64(2), "$lt": int64(8)}},
+			bson.M{"updated": bson.M{"$lt": schema.Date{Time: time.Unix(100, 0)}}},
+		}},
+	}, filter)
+
+	pager, err := q.Page(Page(0))
+	require.NoError(t, err)
+	assert.Equal(t, "total_resources.cru", pager.Sort)
+	assert.True(t, pager.Desc)
+
+	assert.Equal(t, bson.D{
+		{Key: "_id", Value: 1},
+		{Key: "name", Value: 1},
+		{Key: "total_resources.cru", Value: 1},
+	}, q.Projection().Projection)
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	for _, query := range []string{
+		"owner__eq=1",
+		"cru__like=1",
+		"cru__gte=abc",
+		"location__eq=x",
+		"sort=updated",
+		"fields=cru",
+	} {
+		r := httptest.NewRequest("GET", "/nodes?"+query, nil)
+		_, err := ParseQuery(r, testFields)
+		assert.Error(t, err, query)
+	}
+}
+
+func TestQueryProject(t *testing.T) {
+	type node struct {
+		ID   int64  `json:"id"`
+		Name string `json:"name"`
+		OS   string `json:"os"`
+	}
+
+	r := httptest.NewRequest("GET", "/nodes?fields=name", nil)
+	q, err := ParseQuery(r, testFields)
+	require.NoError(t, err)
+
+	out, err := q.Project([]node{{ID: 1, Name: "a", OS: "zos"}})
+	require.NoError(t, err)
+
+	data, err := json.Marshal(out)
+	require.NoError(t, err)
+	assert.Equal(t, `[{"name":"a"}]`, string(data))
+}
+
+func TestAnd(t *testing.T) {
+	filter := bson.D{{Key: "$and", Value: bson.A{bson.M{"a": 1}}}}
+	result := And(filter, bson.M{"b": 2})
+
+	assert.Equal(t, bson.D{{Key: "$and", Value: bson.A{bson.M{"a": 1}, bson.M{"b": 2}}}}, result)
+	assert.Len(t, filter[0].Value, 1, "the original filter must not change")
+}
@@ -0,0 +1,243 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/tfexplorer/schema"
+)
+
+// route is a route registered with a name
+type route struct {
+	method string
+	path   string
+}
+
+// namedRoutes finds the routes registered with a name in the sources of
+// dirs, it returns them by name. The path of a route is the concatenation of
+// the prefixes of the subrouters it is registered on, the routers the
+// functions receive are assumed to be the api routers
+func namedRoutes(t *testing.T, dirs ...string) map[string]route {
+	routes := make(map[string]route)
+	fset := token.NewFileSet()
+
+	for _, dir := range dirs {
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if info.IsDir() || filepath.Ext(path) != ".go" || strings.HasSuffix(path, "_test.go") {
+				return nil
+			}
+
+			file, err := parser.ParseFile(fset, path, nil, 0)
+			if err != nil {
+				return err
+			}
+
+			for _, decl := range file.Decls {
+				fn, ok := decl.(*ast.FuncDecl)
+				if !ok || fn.Body == nil {
+					continue
+				}
+
+				prefixes := make(map[string]string)
+				ast.Inspect(fn.Body, func(n ast.Node) bool {
+					switch n := n.(type) {
+					case *ast.AssignStmt:
+						// matches <sub> := <router>.PathPrefix("<prefix>").Subrouter()
+						if len(n.Lhs) != 1 || len(n.Rhs) != 1 {
+							return true
+						}
+
+						ident, ok := n.Lhs[0].(*ast.Ident)
+						if !ok {
+							return true
+						}
+
+						if router, prefix, ok := subrouter(n.Rhs[0]); ok {
+							prefixes[ident.Name] = prefixes[router] + prefix
+						}
+					case *ast.CallExpr:
+						// matches <router>.HandleFunc("<path>", ...)...Methods(<method>).Name("<name>")
+						sel, ok := n.Fun.(*ast.SelectorExpr)
+						if !ok || sel.Sel.Name != "Name" || len(n.Args) != 1 {
+							return true
+						}
+
+						name := literal(n.Args[0])
+						if len(name) == 0 {
+							return true
+						}
+
+						r := route{}
+						for expr := sel.X; ; {
+							call, ok := expr.(*ast.CallExpr)
+							if !ok {
+								break
+							}
+
+							sel, ok := call.Fun.(*ast.SelectorExpr)
+							if !ok {
+								break
+							}
+
+							switch sel.Sel.Name {
+							case "Methods":
+								if len(call.Args) == 1 {
+									r.method = method(call.Args[0])
+								}
+							case "HandleFunc", "Handle", "Path":
+								if router, ok := sel.X.(*ast.Ident); ok && len(call.Args) > 0 {
+									r.path = prefixes[router.Name] + literal(call.Args[0])
+								}
+							}
+
+							expr = sel.X
+						}
+
+						routes[name] = r
+					}
+
+					return true
+				})
+			}
+
+			return nil
+		})
+		require.NoError(t, err)
+	}
+
+	return routes
+}
+
+// subrouter matches <router>.PathPrefix("<prefix>").Subrouter() and returns
+// the name of the router and the prefix
+func subrouter(expr ast.Expr) (string, string, bool) {
+	call, ok := expr.(*ast.CallExpr)
+	if !ok {
+		return "", "", false
+	}
+
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != "Subrouter" {
+		return "", "", false
+	}
+
+	call, ok = sel.X.(*ast.CallExpr)
+	if !ok || len(call.Args) != 1 {
+		return "", "", false
+	}
+
+	sel, ok = call.Fun.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != "PathPrefix" {
+		return "", "", false
+	}
+
+	router, ok := sel.X.(*ast.Ident)
+	if !ok {
+		return "", "", false
+	}
+
+	return router.Name, literal(call.Args[0]), true
+}
+
+func literal(expr ast.Expr) string {
+	for {
+		paren, ok := expr.(*ast.ParenExpr)
+		if !ok {
+			break
+		}
+		expr = paren.X
+	}
+
+	lit, ok := expr.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return ""
+	}
+
+	value, _ := strconv.Unquote(lit.Value)
+	return value
+}
+
+func method(expr ast.Expr) string {
+	// http.MethodGet
+	if sel, ok := expr.(*ast.SelectorExpr); ok {
+		return strings.ToUpper(strings.TrimPrefix(sel.Sel.Name, "Method"))
+	}
+
+	return literal(expr)
+}
+
+func TestRoutesDocumented(t *testing.T) {
+	manifest, err := schema.LoadRoutes("routes.yaml")
+	require.NoError(t, err)
+
+	documented := make(map[string]route)
+	for _, r := range manifest {
+		documented[r.Name] = route{method: r.Method, path: r.Path}
+	}
+
+	// routes served by the root router, outside of the api
+	internal := map[string]bool{
+		"metrics": true,
+	}
+
+	registered := namedRoutes(t, "../pkg", "../cmds")
+	require.NotEmpty(t, registered)
+
+	for name, r := range registered {
+		if internal[name] {
+			continue
+		}
+
+		if assert.Contains(t, documented, name, "route is not documented in routes.yaml") {
+			assert.Equal(t, r.method, documented[name].method, "method of route '%s'", name)
+			assert.Equal(t, r.path, documented[name].path, "path of route '%s'", name)
+		}
+	}
+
+	for name := range documented {
+		assert.Contains(t, registered, name, "documented route is not registered")
+	}
+}
+
+func TestOpenAPIGenerate(t *testing.T) {
+	manifest, err := schema.LoadRoutes("routes.yaml")
+	require.NoError(t, err)
+
+	objects, err := schema.NewFromDir("schema")
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, schema.GenerateOpenAPI(&buf, "explorer", "2", manifest, objects))
+
+	var document struct {
+		Paths map[string]map[string]struct {
+			OperationID string `json:"operationId"`
+		} `json:"paths"`
+	}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &document))
+
+	operations := make(map[string]struct{})
+	for _, item := range document.Paths {
+		for _, operation := range item {
+			operations[operation.OperationID] = struct{}{}
+		}
+	}
+
+	for _, route := range manifest {
+		assert.Contains(t, operations, route.Name)
+	}
+}
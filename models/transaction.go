@@ -0,0 +1,62 @@
+package models
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Transactor runs functions in a transaction
+type Transactor interface {
+	// WithTransaction runs fn in a transaction. The writes done with the
+	// context given to fn are only committed if fn returns no error. fn can
+	// be called more than once when the transaction hits a transient error
+	WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// NoTransaction is a Transactor that runs fn directly, the writes it does are not atomic
+var NoTransaction Transactor = noTransaction{}
+
+type noTransaction struct{}
+
+func (noTransaction) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+// NewTransactor returns a Transactor using the multi-document transactions of
+// db. Transactions are only supported by replica sets and sharded clusters, on
+// a standalone server NoTransaction is returned
+func NewTransactor(ctx context.Context, db *mongo.Database) (Transactor, error) {
+	var server struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+
+	if err := db.RunCommand(ctx, bson.D{{Key: "isMaster", Value: 1}}).Decode(&server); err != nil {
+		return nil, errors.Wrap(err, "failed to check the database topology")
+	}
+
+	// mongos answers with msg isdbgrid
+	if len(server.SetName) == 0 && server.Msg != "isdbgrid" {
+		log.Warn().Msg("database is a standalone server, multi-document writes are not transactional")
+		return NoTransaction, nil
+	}
+
+	return &mongoTransactor{db: db}, nil
+}
+
+type mongoTransactor struct {
+	db *mongo.Database
+}
+
+func (t *mongoTransactor) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return t.db.Client().UseSession(ctx, func(sc mongo.SessionContext) error {
+		_, err := sc.WithTransaction(sc, func(sc mongo.SessionContext) (interface{}, error) {
+			return nil, fn(sc)
+		})
+		return err
+	})
+}
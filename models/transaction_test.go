@@ -0,0 +1,129 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// testReplicaSet returns a new database of the replica set at the uri in
+// TFEXPLORER_TEST_MONGO, like mongodb://localhost:27017/?replicaSet=rs0.
+// The test is skipped if it is not set since transactions need a replica set
+func testReplicaSet(t *testing.T) *mongo.Database {
+	uri := os.Getenv("TFEXPLORER_TEST_MONGO")
+	if len(uri) == 0 {
+		t.Skip("TFEXPLORER_TEST_MONGO is not set")
+	}
+
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	require.NoError(t, err)
+
+	db := client.Database(fmt.Sprintf("tfexplorer_test_%d", time.Now().UnixNano()))
+	t.Cleanup(func() {
+		_ = db.Drop(ctx)
+		_ = client.Disconnect(ctx)
+	})
+
+	return db
+}
+
+func TestMongoTransactor(t *testing.T) {
+	db := testReplicaSet(t)
+	ctx := context.Background()
+
+	tx, err := NewTransactor(ctx, db)
+	require.NoError(t, err)
+	require.NotEqual(t, NoTransaction, tx, "TFEXPLORER_TEST_MONGO must be a replica set")
+
+	// collections can not be created in a transaction before mongo 4.4
+	col := db.Collection("docs")
+	_, err = col.InsertOne(ctx, bson.M{"_id": "x", "n": 0})
+	require.NoError(t, err)
+
+	ids := NewIDGenerator(db, "docs")
+	_, err = ids.NextID(ctx)
+	require.NoError(t, err)
+
+	count := func(id string) int64 {
+		n, err := col.CountDocuments(ctx, bson.M{"_id": id})
+		require.NoError(t, err)
+		return n
+	}
+
+	t.Run("rollback", func(t *testing.T) {
+		failure := errors.New("failure")
+		err := tx.WithTransaction(ctx, func(ctx context.Context) error {
+			if _, err := ids.NextID(ctx); err != nil {
+				return err
+			}
+
+			if _, err := col.InsertOne(ctx, bson.M{"_id": "y"}); err != nil {
+				return err
+			}
+
+			return failure
+		})
+		require.True(t, errors.Is(err, failure))
+
+		assert.Zero(t, count("y"))
+		last, err := ids.LastID(ctx)
+		require.NoError(t, err)
+		assert.EqualValues(t, 1, last, "the ids taken in the transaction are given back")
+	})
+
+	t.Run("commit", func(t *testing.T) {
+		err := tx.WithTransaction(ctx, func(ctx context.Context) error {
+			if _, err := ids.NextID(ctx); err != nil {
+				return err
+			}
+
+			_, err := col.InsertOne(ctx, bson.M{"_id": "y"})
+			return err
+		})
+		require.NoError(t, err)
+
+		assert.EqualValues(t, 1, count("y"))
+		last, err := ids.LastID(ctx)
+		require.NoError(t, err)
+		assert.EqualValues(t, 2, last)
+	})
+
+	t.Run("retry", func(t *testing.T) {
+		attempts := 0
+		err := tx.WithTransaction(ctx, func(sc context.Context) error {
+			attempts++
+			// the snapshot of the transaction is taken by its first read
+			if err := col.FindOne(sc, bson.M{"_id": "x"}).Err(); err != nil {
+				return err
+			}
+
+			if attempts == 1 {
+				// a concurrent write, outside of the transaction
+				if _, err := col.UpdateOne(ctx, bson.M{"_id": "x"}, bson.M{"$inc": bson.M{"n": 1}}); err != nil {
+					return err
+				}
+			}
+
+			_, err := col.UpdateOne(sc, bson.M{"_id": "x"}, bson.M{"$inc": bson.M{"n": 10}})
+			return err
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 2, attempts, "the write conflict is retried")
+
+		var doc struct {
+			N int64 `bson:"n"`
+		}
+		require.NoError(t, col.FindOne(ctx, bson.M{"_id": "x"}).Decode(&doc))
+		assert.EqualValues(t, 11, doc.N)
+	})
+}
@@ -1,11 +1,17 @@
 package models
 
 import (
+	"context"
+	"fmt"
 	"math"
 	"net/http"
+	"reflect"
 	"strconv"
+	"strings"
 
+	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
@@ -14,8 +20,21 @@ const (
 	DefaultPageSize int64 = 100
 )
 
-// Pager is the find options wrapper
-type Pager *options.FindOptions
+// Pager holds the pagination of a list request. Items are sorted on Sort,
+// then on _id to break ties. A page is either selected by its number, or by a
+// cursor pointing after the last item of the previous page. Cursors do not
+// need to count the collection and are stable when items are inserted while
+// listing
+type Pager struct {
+	Limit int64
+	// Skip is only used by page numbers, it is ignored when Cursor is set
+	Skip int64
+	// Sort is the field the items are sorted on, defaults to _id
+	Sort string
+	Desc bool
+
+	Cursor *Cursor
+}
 
 // Page creates a Pager
 func Page(p int64, size ...int64) Pager {
@@ -23,15 +42,17 @@ func Page(p int64, size ...int64) Pager {
 	if len(size) > 0 {
 		ps = size[0]
 	}
-	skip := p * ps
-	return options.Find().SetLimit(ps).SetSkip(skip).SetSort(bson.D{{Key: "_id", Value: 1}})
+
+	return Pager{Limit: ps, Skip: p * ps, Sort: "_id"}
 }
 
-// PageFromRequest return page information from the page & size url params
-func PageFromRequest(r *http.Request) Pager {
+// PageFromRequest return page information from the page & size url params, or
+// from the cursor param if set
+func PageFromRequest(r *http.Request) (Pager, error) {
 	var (
 		p = r.FormValue("page")
 		s = r.FormValue("size")
+		c = r.FormValue("cursor")
 
 		page int64
 		size = DefaultPageSize
@@ -54,12 +75,143 @@ func PageFromRequest(r *http.Request) Pager {
 		size = 1000
 	}
 
-	return Page(page, size)
+	if size <= 0 {
+		size = DefaultPageSize
+	}
+
+	pager := Page(page, size)
+	if len(c) == 0 {
+		return pager, nil
+	}
+
+	cursor, err := ParseCursor(c)
+	if err != nil {
+		return pager, err
+	}
+
+	// the cursor carries the sort of the listing it was created for
+	pager.Skip = 0
+	pager.Sort = cursor.Sort
+	pager.Desc = cursor.Desc
+	pager.Cursor = &cursor
+
+	return pager, nil
+}
+
+// Paged returns true if the page is selected by its number, in which case the
+// total number of pages can be reported
+func (p Pager) Paged() bool {
+	return p.Cursor == nil
+}
+
+// Options returns the find options of the page
+func (p Pager) Options() *options.FindOptions {
+	dir := 1
+	if p.Desc {
+		dir = -1
+	}
+
+	sort := bson.D{{Key: "_id", Value: dir}}
+	if len(p.Sort) != 0 && p.Sort != "_id" {
+		sort = append(bson.D{{Key: p.Sort, Value: dir}}, sort...)
+	}
+
+	opts := options.Find().SetLimit(p.Limit).SetSort(sort)
+	if p.Cursor == nil {
+		opts = opts.SetSkip(p.Skip)
+	}
+
+	return opts
+}
+
+// Filter returns filter restricted to the items after the cursor of the page
+func (p Pager) Filter(filter bson.D) bson.D {
+	if p.Cursor == nil {
+		return filter
+	}
+
+	// filters can already use $or, so the condition is added as an $and
+	return And(filter, p.Cursor.condition())
+}
+
+// Next returns the cursor of the page following items, the slice of items of
+// the current page. It is empty if items is not a full page, as there is no
+// page after it
+func (p Pager) Next(items interface{}) (string, error) {
+	v := reflect.ValueOf(items)
+	if v.Kind() != reflect.Slice {
+		return "", fmt.Errorf("items must be a slice, got %s", v.Kind())
+	}
+
+	if v.Len() == 0 {
+		return "", nil
+	}
+
+	return p.After(v.Len(), v.Index(v.Len()-1).Interface())
+}
+
+// After is like Next for a page where n items were read from the collection,
+// the last one being last. last can be a bson.Raw document
+func (p Pager) After(n int, last interface{}) (string, error) {
+	if n == 0 || int64(n) < p.Limit {
+		return "", nil
+	}
+
+	raw, ok := last.(bson.Raw)
+	if !ok {
+		var err error
+		if raw, err = bson.Marshal(last); err != nil {
+			return "", errors.Wrap(err, "failed to build cursor")
+		}
+	}
+
+	cursor := Cursor{Sort: p.Sort, Desc: p.Desc}
+	if len(cursor.Sort) == 0 {
+		cursor.Sort = "_id"
+	}
+
+	var err error
+	if cursor.ID, err = raw.LookupErr("_id"); err != nil {
+		return "", errors.Wrap(err, "failed to build cursor")
+	}
+
+	if cursor.Value, err = raw.LookupErr(strings.Split(cursor.Sort, ".")...); err != nil {
+		return "", errors.Wrapf(err, "failed to build cursor on '%s'", cursor.Sort)
+	}
+
+	return cursor.Encode()
+}
+
+// List decodes the page of the documents of col matching filter in out, a
+// pointer to a slice. The documents matching filter are only counted when the
+// page is selected by its number, the count is 0 otherwise
+func List(ctx context.Context, col *mongo.Collection, filter bson.D, pager Pager, out interface{}, opts ...*options.FindOptions) (int64, error) {
+	if filter == nil {
+		filter = bson.D{}
+	}
+
+	opts = append([]*options.FindOptions{pager.Options()}, opts...)
+	cur, err := col.Find(ctx, pager.Filter(filter), opts...)
+	if err != nil {
+		return 0, err
+	}
+	defer cur.Close(ctx)
+
+	if err := cur.All(ctx, out); err != nil {
+		return 0, err
+	}
+
+	// counting is only needed to report the number of pages
+	if !pager.Paged() {
+		return 0, nil
+	}
+
+	return col.CountDocuments(ctx, filter)
 }
 
 // Pages return number of pages based on the total number
 func Pages(p Pager, total int64) int64 {
-	return int64(math.Ceil(float64(total) / float64(*p.Limit)))
+	return NrPages(total, p.Limit)
 }
 
 // NrPages compute the number of page of a collection
@@ -43,11 +43,7 @@ func AsHandlerFunc(a Action) http.HandlerFunc {
 			w.WriteHeader(result.Status())
 			if err := result.Err(); err != nil {
 				log.Error().Msgf("%s", err.Error())
-				object = struct {
-					Error string `json:"error"`
-				}{
-					Error: err.Error(),
-				}
+				object = errorObject(r, result.Status(), err)
 			}
 		}
 
@@ -16,19 +16,17 @@ import (
 	"github.com/threefoldtech/tfexplorer/pkg/phonebook/types"
 	"github.com/threefoldtech/tfexplorer/schema"
 	"github.com/zaibon/httpsig"
-
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // UserKeyGetter implements httpsig.KeyGetter for the users collections
 type UserKeyGetter struct {
-	db *mongo.Database
+	users types.UserRepository
 }
 
-// NewUserKeyGetter create a httpsig.KeyGetter that uses the users collection
+// NewUserKeyGetter create a httpsig.KeyGetter that uses the users repository
 // to find the key
-func NewUserKeyGetter(db *mongo.Database) UserKeyGetter {
-	return UserKeyGetter{db: db}
+func NewUserKeyGetter(users types.UserRepository) UserKeyGetter {
+	return UserKeyGetter{users: users}
 }
 
 // GetKey implements httpsig.KeyGetter
@@ -40,10 +38,7 @@ func (u UserKeyGetter) GetKey(id string) interface{} {
 		return nil
 	}
 
-	f := types.UserFilter{}
-	f = f.WithID(schema.ID(uid))
-
-	user, err := f.Get(ctx, u.db)
+	user, err := u.users.Get(ctx, schema.ID(uid))
 	if err != nil {
 		return nil
 	}
@@ -68,9 +63,17 @@ func NewNodeKeyGetter() NodeKeyGetter {
 func (m NodeKeyGetter) GetKey(id string) interface{} {
 	// the node ID is its public key base58 encoded, so we just need
 	// to decode it to get the []byte version of the key
+	if !isNodeID(id) {
+		return nil
+	}
 	return ed25519.PublicKey(base58.Decode(id))
 }
 
+// isNodeID checks if id is a node id, the base58 encoding of an ed25519 public key
+func isNodeID(id string) bool {
+	return len(base58.Decode(id)) == ed25519.PublicKeySize
+}
+
 // requiredHeaders are the parameters to be used to generated the http signature
 var requiredHeaders = []string{"(created)", "date", "threebot-id"}
 
@@ -90,6 +93,16 @@ func NewAuthMiddleware(v *httpsig.Verifier) *AuthMiddleware {
 
 // Middleware implements mux.Middlware interface
 func (a *AuthMiddleware) Middleware(handler http.Handler) http.Handler {
+	return a.middleware(handler, false)
+}
+
+// Optional is a middleware like Middleware that lets unsigned requests
+// through, without a key id. Signed requests are still verified
+func (a *AuthMiddleware) Optional(handler http.Handler) http.Handler {
+	return a.middleware(handler, true)
+}
+
+func (a *AuthMiddleware) middleware(handler http.Handler, optional bool) http.Handler {
 	var challengeParams []string
 	if headers := a.verifier.RequiredHeaders(); len(headers) > 0 {
 		challengeParams = append(challengeParams,
@@ -102,18 +115,19 @@ func (a *AuthMiddleware) Middleware(handler http.Handler) http.Handler {
 	}
 
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if optional && !signed(req) {
+			handler.ServeHTTP(w, req)
+			return
+		}
+
 		keyID, err := a.verifier.Verify(req)
 		if err != nil {
 			w.Header()["WWW-Authenticate"] = []string{challenge}
 			w.WriteHeader(http.StatusUnauthorized)
 
-			log.Error().Err(err).Msgf("unauthorized access to %s", req.URL.Path)
+			SecurityLog(req).Err(err).Msg("unauthorized access")
 
-			object := struct {
-				Error string `json:"error"`
-			}{
-				Error: errors.Wrap(err, "unauthorized access").Error(),
-			}
+			object := errorObject(req, http.StatusUnauthorized, errors.Wrap(err, "unauthorized access"))
 			if err := json.NewEncoder(w).Encode(object); err != nil {
 				log.Error().Err(err).Msg("failed to encode return object")
 			}
@@ -122,3 +136,9 @@ func (a *AuthMiddleware) Middleware(handler http.Handler) http.Handler {
 		handler.ServeHTTP(w, req.WithContext(httpsig.WithKeyID(req.Context(), keyID)))
 	})
 }
+
+// signed checks if the request carries an http signature
+func signed(req *http.Request) bool {
+	return len(req.Header.Get("Signature")) != 0 ||
+		strings.HasPrefix(req.Header.Get("Authorization"), "Signature ")
+}
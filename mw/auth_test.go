@@ -0,0 +1,36 @@
+package mw
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zaibon/httpsig"
+)
+
+func TestAuthMiddlewareOptional(t *testing.T) {
+	auth := NewAuthMiddleware(httpsig.NewVerifier(NewNodeKeyGetter()))
+
+	var keyID string
+	called := false
+	handler := auth.Optional(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		keyID = httpsig.KeyIDFromContext(r.Context())
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("DELETE", "/reservations/workloads/1-1/node", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, called)
+	assert.Empty(t, keyID)
+
+	called = false
+	r := httptest.NewRequest("DELETE", "/reservations/workloads/1-1/node", nil)
+	r.Header.Set("Authorization", `Signature keyId="node",algorithm="ed25519",signature="invalid"`)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.False(t, called, "signed requests must still be verified")
+}
@@ -0,0 +1,37 @@
+package mw
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/threefoldtech/tfexplorer/pkg/apierror"
+)
+
+type versionKey struct{}
+
+// V2 is the middleware of the v2 API router, the handlers are shared by the
+// API versions and only the errors differ
+func V2(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), versionKey{}, 2)
+		handler.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// errorObject returns the body of an error response for the API version
+// of the request
+func errorObject(r *http.Request, status int, err error) interface{} {
+	if version, _ := r.Context().Value(versionKey{}).(int); version >= 2 {
+		return struct {
+			Error *apierror.APIError `json:"error"`
+		}{
+			Error: apierror.AsAPIError(status, err),
+		}
+	}
+
+	return struct {
+		Error string `json:"error"`
+	}{
+		Error: err.Error(),
+	}
+}
@@ -0,0 +1,45 @@
+package mw
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/tfexplorer/pkg/apierror"
+)
+
+func TestErrorVersions(t *testing.T) {
+	handler := AsHandlerFunc(func(r *http.Request) (interface{}, Response) {
+		return nil, BadRequest(apierror.FieldError("q", fmt.Errorf("q is required")))
+	})
+
+	router := mux.NewRouter()
+	v2 := router.PathPrefix("/explorer/v2").Subrouter()
+	v2.Use(V2)
+	v2.HandleFunc("/search", handler)
+	router.PathPrefix("/explorer").Subrouter().HandleFunc("/search", handler)
+
+	do := func(path string) map[string]interface{} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		require.Equal(t, http.StatusBadRequest, w.Code)
+
+		var body map[string]interface{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+		return body
+	}
+
+	assert.Equal(t, map[string]interface{}{"error": "q is required"}, do("/explorer/search"))
+	assert.Equal(t, map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    "invalid_field",
+			"message": "q is required",
+			"field":   "q",
+		},
+	}, do("/explorer/v2/search"))
+}
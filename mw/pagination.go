@@ -0,0 +1,26 @@
+package mw
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Paginated returns an ok response for a page of a list. next is the cursor of
+// the following page, empty on the last page. It is set in the Next-Cursor
+// header, and as a link to the next page in the Link header
+func Paginated(r *http.Request, next string) Response {
+	response := Ok()
+	if len(next) == 0 {
+		return response
+	}
+
+	u := *r.URL
+	query := u.Query()
+	query.Del("page")
+	query.Set("cursor", next)
+	u.RawQuery = query.Encode()
+
+	return response.
+		WithHeader("Next-Cursor", next).
+		WithHeader("Link", fmt.Sprintf(`<%s>; rel="next"`, u.RequestURI()))
+}
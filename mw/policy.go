@@ -0,0 +1,427 @@
+package mw
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/zaibon/httpsig"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Role is a type of principal a route can require
+type Role string
+
+const (
+	// RoleUser is any registered user
+	RoleUser Role = "user"
+	// RoleNode is the node of the {node_id} route variable, or any node if the
+	// route has no such variable
+	RoleNode Role = "node"
+	// RoleFarmer is an admin of the farm of the {node_id} route variable
+	RoleFarmer Role = "farmer"
+	// RoleAdmin is an administrator of the explorer
+	RoleAdmin Role = "admin"
+	// RoleSelf is the user of the {user_id} route variable, or of the
+	// {threebot_id} one if the route has no user id
+	RoleSelf Role = "self"
+	// RoleRecovery is a recovery key of the user of the {user_id} route
+	// variable, it can only be required with RequireSigner
+	RoleRecovery Role = "recovery"
+	// RoleFarmOwner is an owner of the farm of the {farm_id} route variable
+	RoleFarmOwner Role = "farm_owner"
+	// RoleFarmAdmin is an owner or an operator of the farm of the {farm_id}
+	// route variable
+	RoleFarmAdmin Role = "farm_admin"
+	// RoleFarmPendingOwner is the user the farm of the {farm_id} route
+	// variable is being transferred to
+	RoleFarmPendingOwner Role = "farm_pending_owner"
+	// RoleOrgOwner is an owner of the organization of the {org_id} route variable
+	RoleOrgOwner Role = "organization_owner"
+	// RoleOrgAdmin is an owner or an admin of the organization of the {org_id}
+	// route variable
+	RoleOrgAdmin Role = "organization_admin"
+	// RoleOrgMember is any member of the organization of the {org_id} route variable
+	RoleOrgMember Role = "organization_member"
+)
+
+type principalKey struct{}
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	Role Role
+	// ID is the threebot id of users, farmers and admins, the node id of nodes
+	// and the hex encoded key of the principals of RequireSigner
+	ID string
+	// Scopes limit the principals authenticated with an API token, the
+	// principals authenticated with an http signature have no scopes
+	Scopes []string
+}
+
+// PrincipalFromContext returns the principal set by a Policy on the request
+// context, if any
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// FarmerResolver checks if the threebot tid is an admin of the farm of the
+// node nodeID. It returns mongo.ErrNoDocuments if the node does not exist
+type FarmerResolver func(ctx context.Context, nodeID string, tid int64) (bool, error)
+
+// RoleResolver returns the roles the threebot tid has on the object id of a
+// route variable. It returns mongo.ErrNoDocuments if the object does not exist
+type RoleResolver func(ctx context.Context, id string, tid int64) ([]Role, error)
+
+// SignerResolver returns the roles the key signer has for the user userID,
+// among RoleSelf, RoleRecovery and RoleAdmin. It returns mongo.ErrNoDocuments
+// if the user does not exist
+type SignerResolver func(ctx context.Context, userID string, signer string) ([]Role, error)
+
+// UserResolver checks if the threebot tid is a registered user
+type UserResolver func(ctx context.Context, tid int64) (bool, error)
+
+// Policy resolves the signer of a request into a principal and only lets the
+// request through if the principal has one of the roles required by the route.
+// It relies on the key id set by an AuthMiddleware, so it must run after it
+type Policy struct {
+	admins  []int64
+	farmers FarmerResolver
+	farms   RoleResolver
+	orgs    RoleResolver
+	signers SignerResolver
+	users   UserResolver
+}
+
+// NewPolicy creates a policy where admins are the threebot ids of the
+// explorer administrators and farmers resolves the farmers of nodes.
+// farmers can be nil if the routes do not require RoleFarmer
+func NewPolicy(admins []int64, farmers FarmerResolver) *Policy {
+	return &Policy{admins: admins, farmers: farmers}
+}
+
+// WithFarms sets the resolver of the farm roles, it is required by the routes
+// that require RoleFarmOwner, RoleFarmAdmin or RoleFarmPendingOwner
+func (p *Policy) WithFarms(farms RoleResolver) *Policy {
+	p.farms = farms
+	return p
+}
+
+// WithOrganizations sets the resolver of the organization roles, it is required
+// by the routes that require RoleOrgOwner, RoleOrgAdmin or RoleOrgMember
+func (p *Policy) WithOrganizations(orgs RoleResolver) *Policy {
+	p.orgs = orgs
+	return p
+}
+
+// WithSigners sets the resolver of the payload signers used by RequireSigner
+func (p *Policy) WithSigners(signers SignerResolver) *Policy {
+	p.signers = signers
+	return p
+}
+
+// WithUsers sets the resolver of the users used by RequireSignedBy
+func (p *Policy) WithUsers(users UserResolver) *Policy {
+	p.users = users
+	return p
+}
+
+// Require returns a middleware that lets through the requests signed by a
+// principal with any of roles, the principal is then set on the request context
+func (p *Policy) Require(roles ...Role) mux.MiddlewareFunc {
+	return p.middleware(roles, p.resolve)
+}
+
+// RequireSigner returns a middleware for the routes authenticated by a signature
+// in their payload instead of an http signature. It lets through the requests
+// where the signer key of the payload has any of roles for the user of the
+// {user_id} route variable. The signature itself is verified by the handler
+func (p *Policy) RequireSigner(roles ...Role) mux.MiddlewareFunc {
+	return p.middleware(roles, p.resolveSigner)
+}
+
+// RequireSignedBy returns a middleware for the routes authenticated by a payload
+// signed with the current key of a user instead of an http signature. The user
+// is the threebot id in field of the payload, or the user of the {user_id} route
+// variable if field is empty. It lets through the requests where this user is
+// registered and has any of roles. The signature itself is verified by the handler
+func (p *Policy) RequireSignedBy(field string, roles ...Role) mux.MiddlewareFunc {
+	return p.middleware(roles, func(r *http.Request, roles []Role) (Principal, Response) {
+		return p.resolveSignedBy(r, field, roles)
+	})
+}
+
+func (p *Policy) middleware(roles []Role, resolve func(*http.Request, []Role) (Principal, Response)) mux.MiddlewareFunc {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, merr := resolve(r, roles)
+			if merr != nil {
+				if status := merr.Status(); status == http.StatusUnauthorized || status == http.StatusForbidden {
+					SecurityLog(r).
+						Str("key_id", httpsig.KeyIDFromContext(r.Context())).
+						Str("roles", rolesString(roles)).
+						Int("status", status).
+						Err(merr.Err()).
+						Msg("access denied")
+				}
+
+				AsHandlerFunc(func(*http.Request) (interface{}, Response) {
+					return nil, merr
+				})(w, r)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), principalKey{}, principal)
+			handler.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// Deny reports a request denied by a handler to the security log and returns
+// merr. It is meant for the checks that depend on the request payload, which
+// can not be declared on the route
+func Deny(r *http.Request, merr Response) Response {
+	event := SecurityLog(r).
+		Str("key_id", httpsig.KeyIDFromContext(r.Context())).
+		Int("status", merr.Status()).
+		Err(merr.Err())
+
+	if principal, ok := PrincipalFromContext(r.Context()); ok {
+		event = event.Str("principal", principal.ID).Str("role", string(principal.Role))
+	}
+
+	event.Msg("access denied")
+	return merr
+}
+
+func (p *Policy) resolve(r *http.Request, roles []Role) (Principal, Response) {
+	keyID := httpsig.KeyIDFromContext(r.Context())
+	if len(keyID) == 0 {
+		return Principal{}, UnAuthorized(fmt.Errorf("request is not signed"))
+	}
+
+	for _, role := range roles {
+		ok, merr := p.has(r, role, keyID)
+		if merr != nil {
+			return Principal{}, merr
+		}
+
+		if ok {
+			return Principal{Role: role, ID: keyID, Scopes: tokenScopes(r.Context())}, nil
+		}
+	}
+
+	return Principal{}, Forbidden(fmt.Errorf("'%s' is not allowed, requires %s", keyID, rolesString(roles)))
+}
+
+func (p *Policy) resolveSigner(r *http.Request, roles []Role) (Principal, Response) {
+	if p.signers == nil {
+		return Principal{}, Forbidden(fmt.Errorf("payload signers are not accepted"))
+	}
+
+	var payload struct {
+		Signer string `json:"signer"`
+	}
+	if merr := decodePayload(r, &payload); merr != nil {
+		return Principal{}, merr
+	}
+
+	if len(payload.Signer) == 0 {
+		return Principal{}, UnAuthorized(fmt.Errorf("payload is not signed"))
+	}
+
+	userID := mux.Vars(r)["user_id"]
+	granted, err := p.signers(r.Context(), userID, payload.Signer)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return Principal{}, NotFound(fmt.Errorf("user '%s' not found", userID))
+	} else if err != nil {
+		return Principal{}, Error(err)
+	}
+
+	for _, role := range roles {
+		if hasRole(granted, role) {
+			return Principal{Role: role, ID: payload.Signer}, nil
+		}
+	}
+
+	return Principal{}, Forbidden(fmt.Errorf("key '%s' is not allowed, requires %s", payload.Signer, rolesString(roles)))
+}
+
+func (p *Policy) resolveSignedBy(r *http.Request, field string, roles []Role) (Principal, Response) {
+	if p.users == nil {
+		return Principal{}, Forbidden(fmt.Errorf("payload signers are not accepted"))
+	}
+
+	var signer string
+	if len(field) == 0 {
+		signer = mux.Vars(r)["user_id"]
+	} else {
+		var payload map[string]json.RawMessage
+		if merr := decodePayload(r, &payload); merr != nil {
+			return Principal{}, merr
+		}
+
+		if value, ok := payload[field]; ok {
+			signer = string(value)
+		}
+	}
+
+	tid, err := strconv.ParseInt(signer, 10, 64)
+	if err != nil || tid == 0 {
+		return Principal{}, UnAuthorized(fmt.Errorf("payload is not signed"))
+	}
+
+	ok, err := p.users(r.Context(), tid)
+	if err != nil {
+		return Principal{}, Error(err)
+	} else if !ok {
+		return Principal{}, UnAuthorized(fmt.Errorf("user '%d' not found", tid))
+	}
+
+	keyID := strconv.FormatInt(tid, 10)
+	for _, role := range roles {
+		ok, merr := p.has(r, role, keyID)
+		if merr != nil {
+			return Principal{}, merr
+		}
+
+		if ok {
+			return Principal{Role: role, ID: keyID}, nil
+		}
+	}
+
+	return Principal{}, Forbidden(fmt.Errorf("user '%s' is not allowed, requires %s", keyID, rolesString(roles)))
+}
+
+// decodePayload decodes the json body of r in out, the body is restored so
+// the handler can decode the payload again
+func decodePayload(r *http.Request, out interface{}) Response {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return BadRequest(err)
+	}
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	if err := json.Unmarshal(body, out); err != nil {
+		return BadRequest(err)
+	}
+
+	return nil
+}
+
+func (p *Policy) has(r *http.Request, role Role, keyID string) (bool, Response) {
+	vars := mux.Vars(r)
+	nodeID, hasNode := vars["node_id"]
+
+	if role == RoleNode {
+		// users are verified with their own key, so a user signature can
+		// not pass for a node one on the routes without a node id
+		if !isNodeID(keyID) {
+			return false, nil
+		}
+		return !hasNode || nodeID == keyID, nil
+	}
+
+	tid, err := strconv.ParseInt(keyID, 10, 64)
+	if err != nil {
+		// not signed by a user
+		return false, nil
+	}
+
+	switch role {
+	case RoleUser:
+		return true, nil
+	case RoleAdmin:
+		for _, admin := range p.admins {
+			if admin == tid {
+				return true, nil
+			}
+		}
+		return false, nil
+	case RoleFarmer:
+		if p.farmers == nil || !hasNode {
+			return false, nil
+		}
+
+		ok, err := p.farmers(r.Context(), nodeID, tid)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return false, NotFound(fmt.Errorf("node '%s' not found", nodeID))
+		} else if err != nil {
+			return false, Error(err)
+		}
+		return ok, nil
+	case RoleSelf:
+		user, ok := vars["user_id"]
+		if !ok {
+			user, ok = vars["threebot_id"]
+		}
+		return ok && user == keyID, nil
+	case RoleFarmOwner, RoleFarmAdmin, RoleFarmPendingOwner:
+		return resolveRole(r, p.farms, "farm_id", "farm", role, tid)
+	case RoleOrgOwner, RoleOrgAdmin, RoleOrgMember:
+		return resolveRole(r, p.orgs, "org_id", "organization", role, tid)
+	}
+
+	return false, nil
+}
+
+// resolveRole checks if tid has role on the object of the route variable name
+func resolveRole(r *http.Request, resolver RoleResolver, name, object string, role Role, tid int64) (bool, Response) {
+	id, ok := mux.Vars(r)[name]
+	if resolver == nil || !ok {
+		return false, nil
+	}
+
+	granted, err := resolver(r.Context(), id, tid)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false, NotFound(fmt.Errorf("%s '%s' not found", object, id))
+	} else if err != nil {
+		return false, Error(err)
+	}
+
+	return hasRole(granted, role), nil
+}
+
+func hasRole(roles []Role, role Role) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+func rolesString(roles []Role) string {
+	names := make([]string, 0, len(roles))
+	for _, role := range roles {
+		names = append(names, string(role))
+	}
+	return strings.Join(names, " or ")
+}
+
+// SecurityLog returns an event of the security log, where failed
+// authentications and denied accesses are reported
+func SecurityLog(r *http.Request) *zerolog.Event {
+	event := log.Warn().
+		Str("log", "security").
+		Str("method", r.Method).
+		Str("path", r.URL.Path).
+		Str("remote", r.RemoteAddr)
+
+	if route := mux.CurrentRoute(r); route != nil {
+		event = event.Str("route", route.GetName())
+	}
+
+	return event
+}
@@ -0,0 +1,274 @@
+package mw
+
+import (
+	"context"
+	"crypto/ed25519"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jbenet/go-base58"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zaibon/httpsig"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestPolicyRequire(t *testing.T) {
+	node1, node2 := newNodeID(t), newNodeID(t)
+	farmers := func(ctx context.Context, nodeID string, tid int64) (bool, error) {
+		if nodeID == "unknown" {
+			return false, mongo.ErrNoDocuments
+		}
+		return nodeID == node1 && tid == 10, nil
+	}
+
+	policy := NewPolicy([]int64{1}, farmers)
+
+	request := func(keyID, nodeID string, roles ...Role) (int, Principal) {
+		r := httptest.NewRequest("POST", "/nodes/"+nodeID, nil)
+		ctx := r.Context()
+		if len(keyID) != 0 {
+			ctx = httpsig.WithKeyID(ctx, keyID)
+		}
+
+		vars := map[string]string{}
+		if len(nodeID) != 0 {
+			vars["node_id"] = nodeID
+		}
+		r = mux.SetURLVars(r.WithContext(ctx), vars)
+
+		var principal Principal
+		handler := policy.Require(roles...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, _ = PrincipalFromContext(r.Context())
+		}))
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code, principal
+	}
+
+	code, principal := request(node1, node1, RoleNode)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, Principal{Role: RoleNode, ID: node1}, principal)
+
+	code, _ = request(node2, node1, RoleNode)
+	assert.Equal(t, http.StatusForbidden, code)
+
+	code, _ = request("", node1, RoleUser)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	code, principal = request("10", node1, RoleFarmer, RoleAdmin)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, RoleFarmer, principal.Role)
+
+	code, principal = request("1", node1, RoleFarmer, RoleAdmin)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, RoleAdmin, principal.Role)
+
+	code, _ = request("11", node1, RoleFarmer, RoleAdmin)
+	assert.Equal(t, http.StatusForbidden, code)
+
+	code, _ = request("10", "unknown", RoleFarmer)
+	assert.Equal(t, http.StatusNotFound, code)
+
+	code, _ = request(node1, node1, RoleUser)
+	assert.Equal(t, http.StatusForbidden, code)
+
+	// on routes without a node id, only nodes have the node role
+	code, _ = request(node2, "", RoleNode)
+	assert.Equal(t, http.StatusOK, code)
+
+	code, _ = request("10", "", RoleNode)
+	assert.Equal(t, http.StatusForbidden, code)
+}
+
+func TestPolicyRequireResources(t *testing.T) {
+	// threebot 10 owns farm 1, 11 operates it and 12 is the pending owner
+	farms := func(ctx context.Context, id string, tid int64) ([]Role, error) {
+		if id != "1" {
+			return nil, mongo.ErrNoDocuments
+		}
+
+		switch tid {
+		case 10:
+			return []Role{RoleFarmOwner, RoleFarmAdmin}, nil
+		case 11:
+			return []Role{RoleFarmAdmin}, nil
+		case 12:
+			return []Role{RoleFarmPendingOwner}, nil
+		}
+		return nil, nil
+	}
+
+	policy := NewPolicy([]int64{1}, nil).WithFarms(farms)
+
+	request := func(keyID string, vars map[string]string, roles ...Role) (int, Principal) {
+		r := httptest.NewRequest("POST", "/farms", nil)
+		r = mux.SetURLVars(r.WithContext(httpsig.WithKeyID(r.Context(), keyID)), vars)
+
+		var principal Principal
+		handler := policy.Require(roles...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, _ = PrincipalFromContext(r.Context())
+		}))
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code, principal
+	}
+
+	farm := map[string]string{"farm_id": "1"}
+
+	code, principal := request("10", farm, RoleFarmOwner)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, RoleFarmOwner, principal.Role)
+
+	code, _ = request("11", farm, RoleFarmOwner)
+	assert.Equal(t, http.StatusForbidden, code)
+
+	code, _ = request("11", farm, RoleFarmAdmin)
+	assert.Equal(t, http.StatusOK, code)
+
+	code, _ = request("12", farm, RoleFarmAdmin)
+	assert.Equal(t, http.StatusForbidden, code)
+
+	code, _ = request("12", farm, RoleFarmPendingOwner)
+	assert.Equal(t, http.StatusOK, code)
+
+	code, _ = request("10", map[string]string{"farm_id": "2"}, RoleFarmOwner)
+	assert.Equal(t, http.StatusNotFound, code)
+
+	// no organization resolver, organization roles are never granted
+	code, _ = request("10", map[string]string{"org_id": "1"}, RoleOrgMember)
+	assert.Equal(t, http.StatusForbidden, code)
+
+	// admins can remove themselves from the farm
+	code, principal = request("11", map[string]string{"farm_id": "1", "threebot_id": "11"}, RoleFarmOwner, RoleSelf)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, RoleSelf, principal.Role)
+
+	code, _ = request("11", map[string]string{"farm_id": "1", "threebot_id": "12"}, RoleFarmOwner, RoleSelf)
+	assert.Equal(t, http.StatusForbidden, code)
+
+	code, _ = request("11", map[string]string{"user_id": "11", "threebot_id": "12"}, RoleSelf)
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestPolicyRequireSigner(t *testing.T) {
+	signers := func(ctx context.Context, userID string, signer string) ([]Role, error) {
+		if userID != "10" {
+			return nil, mongo.ErrNoDocuments
+		}
+
+		switch signer {
+		case "current":
+			return []Role{RoleSelf}, nil
+		case "recovery":
+			return []Role{RoleRecovery}, nil
+		}
+		return nil, nil
+	}
+
+	policy := NewPolicy([]int64{1}, nil).WithSigners(signers)
+
+	request := func(userID, body string, roles ...Role) (int, Principal, string) {
+		r := httptest.NewRequest("POST", "/users/"+userID+"/keys/rotate", strings.NewReader(body))
+		r = mux.SetURLVars(r, map[string]string{"user_id": userID})
+
+		var principal Principal
+		var payload []byte
+		handler := policy.RequireSigner(roles...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, _ = PrincipalFromContext(r.Context())
+			payload, _ = ioutil.ReadAll(r.Body)
+		}))
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code, principal, string(payload)
+	}
+
+	body := `{"signer": "recovery", "pubkey": "new"}`
+	code, principal, payload := request("10", body, RoleSelf, RoleRecovery)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, Principal{Role: RoleRecovery, ID: "recovery"}, principal)
+	// the handler still gets the whole payload
+	assert.Equal(t, body, payload)
+
+	code, _, _ = request("10", `{"signer": "recovery"}`, RoleSelf)
+	assert.Equal(t, http.StatusForbidden, code)
+
+	code, _, _ = request("10", `{"signer": "other"}`, RoleSelf, RoleRecovery)
+	assert.Equal(t, http.StatusForbidden, code)
+
+	code, _, _ = request("10", `{}`, RoleSelf)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	code, _, _ = request("11", `{"signer": "current"}`, RoleSelf)
+	assert.Equal(t, http.StatusNotFound, code)
+
+	code, _, _ = request("10", `not json`, RoleSelf)
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestPolicyRequireSignedBy(t *testing.T) {
+	users := func(ctx context.Context, tid int64) (bool, error) {
+		return tid == 1 || tid == 10, nil
+	}
+
+	policy := NewPolicy([]int64{1}, nil).WithUsers(users)
+
+	request := func(field, userID, body string, roles ...Role) (int, Principal, string) {
+		r := httptest.NewRequest("POST", "/reservations", strings.NewReader(body))
+		if len(userID) != 0 {
+			r = mux.SetURLVars(r, map[string]string{"user_id": userID})
+		}
+
+		var principal Principal
+		var payload []byte
+		handler := policy.RequireSignedBy(field, roles...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, _ = PrincipalFromContext(r.Context())
+			payload, _ = ioutil.ReadAll(r.Body)
+		}))
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code, principal, string(payload)
+	}
+
+	body := `{"customer_tid": 10, "json": "{}"}`
+	code, principal, payload := request("customer_tid", "", body, RoleUser)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, Principal{Role: RoleUser, ID: "10"}, principal)
+	// the handler still gets the whole payload
+	assert.Equal(t, body, payload)
+
+	code, principal, _ = request("tid", "", `{"tid": 1}`, RoleAdmin)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, Principal{Role: RoleAdmin, ID: "1"}, principal)
+
+	code, _, _ = request("tid", "", `{"tid": 10}`, RoleAdmin)
+	assert.Equal(t, http.StatusForbidden, code)
+
+	code, _, _ = request("tid", "", `{"tid": 11}`, RoleUser)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	code, _, _ = request("tid", "", `{}`, RoleUser)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	// the user of the route signs the payload
+	code, principal, _ = request("", "10", `{}`, RoleSelf)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, Principal{Role: RoleSelf, ID: "10"}, principal)
+
+	code, _, _ = request("", "11", `{}`, RoleSelf)
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
+
+func newNodeID(t *testing.T) string {
+	pk, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	return base58.Encode(pk)
+}
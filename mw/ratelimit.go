@@ -0,0 +1,203 @@
+package mw
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/threefoldtech/tfexplorer/config"
+	"github.com/threefoldtech/tfexplorer/pkg/apierror"
+	"github.com/zaibon/httpsig"
+)
+
+// RateLimiter limits the requests rate of the clients with a token bucket per
+// client and route. Clients are identified by the key id of their http
+// signature, or their ip if the request is not signed. The ip of the requests
+// of trusted proxies is read from their X-Forwarded-For header
+type RateLimiter struct {
+	limits  config.RateLimits
+	proxies config.Networks
+
+	m       sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+	now     func() time.Time
+}
+
+type bucket struct {
+	limit  config.RateLimit
+	tokens float64
+	last   time.Time
+}
+
+// rate returns the tokens added to the bucket per second
+func (b *bucket) rate() float64 {
+	return float64(b.limit.Requests) / b.limit.Period.Seconds()
+}
+
+func (b *bucket) refill(now time.Time) {
+	b.tokens = math.Min(float64(b.limit.Requests), b.tokens+now.Sub(b.last).Seconds()*b.rate())
+	b.last = now
+}
+
+// full returns the time until the bucket is full
+func (b *bucket) full() time.Duration {
+	return seconds((float64(b.limit.Requests) - b.tokens) / b.rate())
+}
+
+// NewRateLimiter creates a rate limiter with limits by route name, proxies
+// are the networks of the trusted reverse proxies
+func NewRateLimiter(limits config.RateLimits, proxies config.Networks) *RateLimiter {
+	return &RateLimiter{
+		limits:  limits,
+		proxies: proxies,
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Apply limits the routes of router. It must be called after all the routes
+// are registered, the limit is checked after the route middlewares so the
+// key id of signed requests is known
+func (l *RateLimiter) Apply(router *mux.Router) error {
+	return router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		handler := route.GetHandler()
+		if handler == nil {
+			return nil
+		}
+
+		name := route.GetName()
+		limit, ok := l.limits.Of(name)
+		if !ok {
+			return nil
+		}
+
+		route.Handler(l.limit(name, limit, handler))
+		return nil
+	})
+}
+
+func (l *RateLimiter) limit(name string, limit config.RateLimit, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := l.client(r)
+		q := l.take(name+"|"+client, limit)
+
+		w.Header().Set("RateLimit-Limit", fmt.Sprint(limit.Requests))
+		w.Header().Set("RateLimit-Remaining", fmt.Sprint(q.remaining))
+		w.Header().Set("RateLimit-Reset", fmt.Sprint(int64(q.reset.Seconds())))
+
+		if !q.allowed {
+			SecurityLog(r).Str("client", client).Msg("rate limit exceeded")
+
+			AsHandlerFunc(func(*http.Request) (interface{}, Response) {
+				err := &apierror.APIError{
+					Code:    apierror.CodeRateLimited,
+					Message: fmt.Sprintf("rate limit exceeded, retry in %s", q.retry),
+				}
+				err.WithDetail("retry_after", int64(q.retry.Seconds()))
+
+				return nil, Error(err, http.StatusTooManyRequests).
+					WithHeader("Retry-After", fmt.Sprint(int64(q.retry.Seconds())))
+			})(w, r)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// quota is the state of a bucket after a request
+type quota struct {
+	allowed   bool
+	remaining int
+	// reset is the time until the bucket is full
+	reset time.Duration
+	// retry is the time until the next request is allowed
+	retry time.Duration
+}
+
+// take takes a token from the bucket of key if there is one
+func (l *RateLimiter) take(key string, limit config.RateLimit) quota {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok || b.limit != limit {
+		b = &bucket{limit: limit, tokens: float64(limit.Requests), last: now}
+		l.buckets[key] = b
+	}
+
+	b.refill(now)
+	if b.tokens < 1 {
+		return quota{reset: b.full(), retry: seconds((1 - b.tokens) / b.rate())}
+	}
+
+	b.tokens--
+	return quota{allowed: true, remaining: int(b.tokens), reset: b.full()}
+}
+
+// sweep drops the full buckets, at most once a minute
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < time.Minute {
+		return
+	}
+	l.swept = now
+
+	for key, b := range l.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.limit.Requests) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// client returns the key id of signed requests or the ip of the client
+func (l *RateLimiter) client(r *http.Request) string {
+	if keyID := httpsig.KeyIDFromContext(r.Context()); len(keyID) != 0 {
+		return "key:" + keyID
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	if !l.proxies.Contains(net.ParseIP(host)) {
+		return "ip:" + host
+	}
+
+	// each proxy appends the address it got the request from, the client is
+	// the last address that was not added by a trusted proxy. The addresses
+	// before it are set by the client and can not be trusted
+	var forwarded []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(header, ",")...)
+	}
+
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if ip == nil {
+			break
+		}
+
+		host = ip.String()
+		if !l.proxies.Contains(ip) {
+			break
+		}
+	}
+
+	return "ip:" + host
+}
+
+// seconds converts s to a duration rounded up to the second
+func seconds(s float64) time.Duration {
+	return time.Duration(math.Ceil(s)) * time.Second
+}
@@ -0,0 +1,98 @@
+package mw
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/tfexplorer/config"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Unix(1000, 0)
+	limiter := NewRateLimiter(config.RateLimits{
+		"users-create": {Requests: 2, Period: time.Minute},
+	}, nil)
+	limiter.now = func() time.Time { return now }
+
+	router := mux.NewRouter()
+	router.HandleFunc("/users", func(w http.ResponseWriter, r *http.Request) {}).Methods("POST").Name("users-create")
+	router.HandleFunc("/users", func(w http.ResponseWriter, r *http.Request) {}).Methods("GET").Name("users-list")
+	require.NoError(t, limiter.Apply(router))
+
+	do := func(method, remote string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/users", nil)
+		r.RemoteAddr = remote
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	w := do("POST", "10.0.0.1:1234")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "2", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "30", w.Header().Get("RateLimit-Reset"))
+
+	assert.Equal(t, http.StatusOK, do("POST", "10.0.0.1:1235").Code)
+
+	w = do("POST", "10.0.0.1:1236")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "30", w.Header().Get("Retry-After"))
+
+	// other clients and routes have their own limits
+	assert.Equal(t, http.StatusOK, do("POST", "10.0.0.2:1234").Code)
+	w = do("GET", "10.0.0.1:1234")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("RateLimit-Limit"))
+
+	now = now.Add(30 * time.Second)
+	assert.Equal(t, http.StatusOK, do("POST", "10.0.0.1:1234").Code)
+}
+
+func TestRateLimiterBuiltin(t *testing.T) {
+	limiter := NewRateLimiter(nil, nil)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/users/1/email/verification", func(w http.ResponseWriter, r *http.Request) {}).Methods("POST").Name("user-email-verification")
+	require.NoError(t, limiter.Apply(router))
+
+	builtin := config.BuiltinRateLimits["user-email-verification"]
+	for i := 0; i <= builtin.Requests; i++ {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/users/1/email/verification", nil))
+
+		if i < builtin.Requests {
+			assert.Equal(t, http.StatusOK, w.Code)
+		} else {
+			assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		}
+	}
+}
+
+func TestRateLimiterClient(t *testing.T) {
+	var proxies config.Networks
+	require.NoError(t, proxies.Set("10.0.0.0/8"))
+	require.NoError(t, proxies.Set("192.168.1.1"))
+	limiter := NewRateLimiter(nil, proxies)
+
+	client := func(remote string, forwarded ...string) string {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = remote
+		for _, f := range forwarded {
+			r.Header.Add("X-Forwarded-For", f)
+		}
+		return limiter.client(r)
+	}
+
+	// untrusted peers can not choose their ip
+	assert.Equal(t, "ip:1.2.3.4", client("1.2.3.4:1234", "5.6.7.8"))
+
+	assert.Equal(t, "ip:5.6.7.8", client("10.0.0.1:1234", "5.6.7.8"))
+	assert.Equal(t, "ip:5.6.7.8", client("10.0.0.1:1234", "9.9.9.9, 5.6.7.8", "192.168.1.1"), "spoofed addresses are ignored")
+	assert.Equal(t, "ip:10.0.0.1", client("10.0.0.1:1234"))
+}
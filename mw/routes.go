@@ -0,0 +1,114 @@
+package mw
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+
+	"github.com/gorilla/mux"
+)
+
+// mutatingMethods are the methods of the routes that must declare a principal
+var mutatingMethods = map[string]bool{
+	http.MethodPost:   true,
+	http.MethodPut:    true,
+	http.MethodPatch:  true,
+	http.MethodDelete: true,
+}
+
+var routeVar = regexp.MustCompile(`\{([^:}]+)(?::([^}]+))?\}`)
+
+// UnguardedRoutes returns the names of the mutating routes of router that
+// reach their handler with an anonymous request, so that declare no principal.
+// open are the names of the routes that are meant to be reachable anonymously.
+// The handlers of the routes are replaced, so router must only be built for
+// this check
+func UnguardedRoutes(router *mux.Router, open ...string) ([]string, error) {
+	allowed := map[string]bool{}
+	for _, name := range open {
+		allowed[name] = true
+	}
+
+	type target struct {
+		name   string
+		method string
+		url    string
+	}
+
+	var (
+		targets []target
+		reached string
+	)
+
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		methods, err := route.GetMethods()
+		if err != nil {
+			// a path prefix of subrouter
+			return nil
+		}
+
+		name := route.GetName()
+		route.Handler(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+			reached = name
+		}))
+
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+
+		url, err := route.URLPath(routeValues(template)...)
+		if err != nil {
+			return fmt.Errorf("failed to build the url of route '%s': %w", name, err)
+		}
+
+		for _, method := range methods {
+			if mutatingMethods[method] && !allowed[name] {
+				targets = append(targets, target{name: name, method: method, url: url.String()})
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var unguarded []string
+	for _, target := range targets {
+		reached = ""
+		r := httptest.NewRequest(target.method, target.url, bytes.NewBufferString("{}"))
+		router.ServeHTTP(httptest.NewRecorder(), r)
+
+		if len(reached) != 0 {
+			unguarded = append(unguarded, reached)
+		}
+	}
+
+	sort.Strings(unguarded)
+	return unguarded, nil
+}
+
+// routeValues returns the pairs of the variables of a route template, with
+// values matching their patterns
+func routeValues(template string) []string {
+	var pairs []string
+	for _, match := range routeVar.FindAllStringSubmatch(template, -1) {
+		value := "1"
+		if pattern := match[2]; len(pattern) != 0 {
+			for _, candidate := range []string{"1", "1-1", "a"} {
+				if ok, _ := regexp.MatchString("^(?:"+pattern+")$", candidate); ok {
+					value = candidate
+					break
+				}
+			}
+		}
+
+		pairs = append(pairs, match[1], value)
+	}
+
+	return pairs
+}
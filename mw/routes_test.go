@@ -0,0 +1,30 @@
+package mw
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnguardedRoutes(t *testing.T) {
+	handler := func(http.ResponseWriter, *http.Request) {}
+	policy := NewPolicy(nil, nil)
+
+	router := mux.NewRouter()
+	open := router.PathPrefix("/things").Subrouter()
+	guarded := router.PathPrefix("/things").Subrouter()
+	guarded.Use(policy.Require(RoleUser))
+
+	open.HandleFunc("", handler).Methods(http.MethodGet).Name("thing-list")
+	open.HandleFunc("", handler).Methods(http.MethodPost).Name("thing-create")
+	open.HandleFunc("/{thing_id:\\d+-\\d+}", handler).Methods(http.MethodPut).Name("thing-update")
+	open.HandleFunc("/{thing_id:\\d+-\\d+}/register", handler).Methods(http.MethodPost).Name("thing-register")
+	guarded.HandleFunc("/{thing_id}", handler).Methods(http.MethodDelete).Name("thing-delete")
+
+	unguarded, err := UnguardedRoutes(router, "thing-register")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"thing-create", "thing-update"}, unguarded)
+}
@@ -0,0 +1,97 @@
+package mw
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/tfexplorer/pkg/phonebook/types"
+	"github.com/zaibon/httpsig"
+)
+
+type tokenKey struct{}
+
+// Tokens returns a middleware that accepts a bearer API token with scope as
+// an alternative to the http signature of the user. The token authenticates
+// the request as its user, limited to the scopes of the token. Tokens are read
+// only so they are refused on requests other than GET and HEAD
+func (a *AuthMiddleware) Tokens(tokens types.TokenRepository, users types.UserRepository, scope string) mux.MiddlewareFunc {
+	return func(handler http.Handler) http.Handler {
+		signed := a.Middleware(handler)
+
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			secret, ok := bearer(req)
+			if !ok {
+				signed.ServeHTTP(w, req)
+				return
+			}
+
+			token, merr := checkToken(req, tokens, users, secret, scope)
+			if merr != nil {
+				SecurityLog(req).
+					Str("scope", scope).
+					Int("status", merr.Status()).
+					Err(merr.Err()).
+					Msg("token refused")
+
+				AsHandlerFunc(func(*http.Request) (interface{}, Response) {
+					return nil, merr
+				})(w, req)
+				return
+			}
+
+			ctx := httpsig.WithKeyID(req.Context(), fmt.Sprint(int64(token.UserID)))
+			ctx = context.WithValue(ctx, tokenKey{}, token.Scopes)
+			handler.ServeHTTP(w, req.WithContext(ctx))
+		})
+	}
+}
+
+func checkToken(req *http.Request, tokens types.TokenRepository, users types.UserRepository, secret, scope string) (types.Token, Response) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return types.Token{}, Forbidden(fmt.Errorf("tokens can only be used to read"))
+	}
+
+	token, err := types.TokenGet(req.Context(), tokens, secret)
+	if errors.Is(err, types.ErrTokenNotFound) {
+		return token, UnAuthorized(err)
+	} else if err != nil {
+		return token, Error(err)
+	}
+
+	if !token.Has(scope) {
+		return token, Forbidden(fmt.Errorf("token does not have the '%s' scope", scope))
+	}
+
+	// rotating the key of the user revokes the tokens minted with the previous one
+	user, err := users.Get(req.Context(), token.UserID)
+	if err != nil {
+		return token, UnAuthorized(errors.Wrap(types.ErrTokenNotFound, "token user not found"))
+	}
+
+	if token.Created.Before(user.RotatedAt()) {
+		return token, UnAuthorized(errors.Wrap(types.ErrTokenNotFound, "token was minted before the key of the user was rotated"))
+	}
+
+	return token, nil
+}
+
+// bearer returns the token of the Authorization header, if any
+func bearer(req *http.Request) (string, bool) {
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return "", false
+	}
+
+	return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer ")), true
+}
+
+// tokenScopes returns the scopes of the token the request was authenticated
+// with, nil if the request was not authenticated with a token
+func tokenScopes(ctx context.Context) []string {
+	scopes, _ := ctx.Value(tokenKey{}).([]string)
+	return scopes
+}
@@ -0,0 +1,48 @@
+package mw
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zaibon/httpsig"
+)
+
+func TestTokensReadOnly(t *testing.T) {
+	auth := NewAuthMiddleware(httpsig.NewVerifier(NewNodeKeyGetter()))
+
+	called := false
+	handler := auth.Tokens(nil, nil, "escrow:read")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	r := httptest.NewRequest("POST", "/reservations", nil)
+	r.Header.Set("Authorization", "Bearer tfx_secret")
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.False(t, called)
+
+	// requests without a token must be signed
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/reservations/1/escrow", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.False(t, called)
+}
+
+func TestBearer(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	_, ok := bearer(r)
+	assert.False(t, ok)
+
+	r.Header.Set("Authorization", `Signature keyId="1"`)
+	_, ok = bearer(r)
+	assert.False(t, ok)
+
+	r.Header.Set("Authorization", "Bearer tfx_secret")
+	token, ok := bearer(r)
+	assert.True(t, ok)
+	assert.Equal(t, "tfx_secret", token)
+}
@@ -0,0 +1,127 @@
+// Package apierror holds the typed errors of the v2 API. It is shared by the
+// explorer and its client, so it must not depend on the server packages
+package apierror
+
+import (
+	"errors"
+	"net/http"
+)
+
+// ErrorCode is the machine readable cause of an error of the v2 API
+type ErrorCode string
+
+const (
+	// CodeBadRequest is returned when the request is not valid
+	CodeBadRequest ErrorCode = "bad_request"
+	// CodeInvalidField is returned when a field of the request is not valid,
+	// the field is set on the error
+	CodeInvalidField ErrorCode = "invalid_field"
+	// CodeUnauthorized is returned when the request is not authenticated
+	CodeUnauthorized ErrorCode = "unauthorized"
+	// CodeForbidden is returned when the caller is not allowed to do the request
+	CodeForbidden ErrorCode = "forbidden"
+	// CodeNotFound is returned when the object of the request does not exist
+	CodeNotFound ErrorCode = "not_found"
+	// CodeConflict is returned when the request conflicts with the current
+	// state of the object
+	CodeConflict ErrorCode = "conflict"
+	// CodeRateLimited is returned when the client did too many requests, the
+	// seconds to wait are in the retry_after detail
+	CodeRateLimited ErrorCode = "rate_limited"
+	// CodeNotImplemented is returned when the feature is disabled on the explorer
+	CodeNotImplemented ErrorCode = "not_implemented"
+	// CodeInternal is returned on unexpected failures
+	CodeInternal ErrorCode = "internal"
+)
+
+// codes of the domain errors
+const (
+	// CodeUserExists is returned when the name or email of a user is taken
+	CodeUserExists ErrorCode = "user_exists"
+	// CodeOrganizationExists is returned when the name of an organization is taken
+	CodeOrganizationExists ErrorCode = "organization_exists"
+	// CodeKeyChanged is returned when the key of a user changed while it was
+	// being updated, the update must be signed again
+	CodeKeyChanged ErrorCode = "key_changed"
+	// CodeInvalidVerificationToken is returned when an email verification
+	// token is wrong or expired
+	CodeInvalidVerificationToken ErrorCode = "invalid_verification_token"
+	// CodeVerificationCooldown is returned when a verification email was
+	// sent too recently
+	CodeVerificationCooldown ErrorCode = "verification_cooldown"
+	// CodeReservationConflict is returned when a reservation was updated
+	// concurrently too many times
+	CodeReservationConflict ErrorCode = "reservation_conflict"
+	// CodeBudgetExceeded is returned when a reservation does not fit in the
+	// budget of its customer
+	CodeBudgetExceeded ErrorCode = "budget_exceeded"
+	// CodeDomainClaimed is returned when a domain is held by another reservation
+	CodeDomainClaimed ErrorCode = "domain_claimed"
+	// CodeIPNotAvailable is returned when a public ip of a farm is not free
+	CodeIPNotAvailable ErrorCode = "ip_not_available"
+	// CodeAssetNotSupported is returned when a currency can not be used
+	CodeAssetNotSupported ErrorCode = "asset_not_supported"
+)
+
+// statusCodes are the codes of the errors that are not typed
+var statusCodes = map[int]ErrorCode{
+	http.StatusBadRequest:      CodeBadRequest,
+	http.StatusUnauthorized:    CodeUnauthorized,
+	http.StatusForbidden:       CodeForbidden,
+	http.StatusNotFound:        CodeNotFound,
+	http.StatusConflict:        CodeConflict,
+	http.StatusTooManyRequests: CodeRateLimited,
+	http.StatusNotImplemented:  CodeNotImplemented,
+}
+
+// APIError is a typed error. Handlers return it in a Response to give more
+// than the status of the response to the clients of the v2 API. The domain
+// errors are APIError values so they keep their code when wrapped
+type APIError struct {
+	Code    ErrorCode              `json:"code"`
+	Message string                 `json:"message"`
+	Field   string                 `json:"field,omitempty"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// New creates a typed error
+func New(code ErrorCode, message string) *APIError {
+	return &APIError{Code: code, Message: message}
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// FieldError returns the error of an invalid field of the request
+func FieldError(field string, err error) *APIError {
+	return &APIError{Code: CodeInvalidField, Message: err.Error(), Field: field}
+}
+
+// WithDetail sets a detail of the error
+func (e *APIError) WithDetail(key string, value interface{}) *APIError {
+	if e.Details == nil {
+		e.Details = make(map[string]interface{})
+	}
+	e.Details[key] = value
+	return e
+}
+
+// AsAPIError returns the typed error of err. The code of errors that are not
+// typed is the one of status
+func AsAPIError(status int, err error) *APIError {
+	var typed *APIError
+	if errors.As(err, &typed) {
+		e := *typed
+		// keep the context wrapped around the typed error
+		e.Message = err.Error()
+		return &e
+	}
+
+	code, ok := statusCodes[status]
+	if !ok {
+		code = CodeInternal
+	}
+
+	return &APIError{Code: code, Message: err.Error()}
+}
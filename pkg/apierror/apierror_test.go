@@ -0,0 +1,39 @@
+package apierror
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAsAPIError(t *testing.T) {
+	err := AsAPIError(http.StatusNotFound, fmt.Errorf("user not found"))
+	assert.Equal(t, &APIError{Code: CodeNotFound, Message: "user not found"}, err)
+
+	err = AsAPIError(http.StatusInternalServerError, fmt.Errorf("boom"))
+	assert.Equal(t, CodeInternal, err.Code)
+
+	typed := FieldError("limit", fmt.Errorf("limit should be an integer"))
+	err = AsAPIError(http.StatusBadRequest, errors.Wrap(typed, "invalid query"))
+	assert.Equal(t, &APIError{
+		Code:    CodeInvalidField,
+		Message: "invalid query: limit should be an integer",
+		Field:   "limit",
+	}, err)
+	// the typed error is not modified
+	assert.Equal(t, "limit should be an integer", typed.Message)
+}
+
+func TestDomainError(t *testing.T) {
+	exists := New(CodeUserExists, "user exists")
+
+	wrapped := errors.Wrap(exists, "failed to create user")
+	assert.True(t, errors.Is(wrapped, exists))
+
+	err := AsAPIError(http.StatusConflict, wrapped)
+	assert.Equal(t, CodeUserExists, err.Code)
+	assert.Equal(t, "failed to create user: user exists", err.Message)
+}
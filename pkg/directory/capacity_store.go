@@ -0,0 +1,117 @@
+package directory
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/tfexplorer/models"
+	"github.com/threefoldtech/tfexplorer/mw"
+	"github.com/threefoldtech/tfexplorer/pkg/apierror"
+	directory "github.com/threefoldtech/tfexplorer/pkg/directory/types"
+	"github.com/threefoldtech/tfexplorer/schema"
+)
+
+const (
+	defaultHistoryRange = 24 * time.Hour
+	defaultHistoryStep  = time.Hour
+)
+
+type historyQuery struct {
+	From time.Time
+	To   time.Time
+	Step time.Duration
+}
+
+// Parse reads the from and to unix timestamps and the step in seconds
+// of a capacity history query
+func (q *historyQuery) Parse(r *http.Request) mw.Response {
+	to, err := models.QueryInt(r, "to")
+	if err != nil {
+		return mw.BadRequest(apierror.FieldError("to", errors.Wrap(err, "invalid to")))
+	}
+	from, err := models.QueryInt(r, "from")
+	if err != nil {
+		return mw.BadRequest(apierror.FieldError("from", errors.Wrap(err, "invalid from")))
+	}
+	step, err := models.QueryInt(r, "step")
+	if err != nil {
+		return mw.BadRequest(apierror.FieldError("step", errors.Wrap(err, "invalid step")))
+	}
+
+	q.To = time.Now()
+	if to > 0 {
+		q.To = time.Unix(to, 0)
+	}
+
+	q.From = q.To.Add(-defaultHistoryRange)
+	if from > 0 {
+		q.From = time.Unix(from, 0)
+	}
+
+	q.Step = defaultHistoryStep
+	if step > 0 {
+		q.Step = time.Duration(step) * time.Second
+	}
+
+	if q.Step < directory.Resolutions[0].Step {
+		return mw.BadRequest(apierror.FieldError("step", fmt.Errorf("step can not be smaller than %d seconds", directory.Resolutions[0].Step/time.Second)))
+	}
+
+	if !q.From.Before(q.To) {
+		return mw.BadRequest(apierror.FieldError("from", fmt.Errorf("from must be before to")))
+	}
+
+	return nil
+}
+
+func (q *historyQuery) filter() (directory.CapacityFilter, error) {
+	resolution, err := directory.ResolutionFor(q.From, q.Step)
+	if err != nil {
+		return nil, err
+	}
+
+	var filter directory.CapacityFilter
+	filter = filter.WithResolution(resolution)
+	// include the bucket the range starts in
+	filter = filter.WithRange(q.From.Truncate(q.Step), q.To)
+	return filter, nil
+}
+
+// recordCapacity samples the current capacity of the node into its history.
+// the history is not critical so failures are only logged
+func (s *NodeAPI) recordCapacity(ctx context.Context, nodeID string) {
+	node, err := s.Get(ctx, nodeID, false)
+	if err == nil {
+		err = s.history.Record(ctx, node, time.Now())
+	}
+
+	if err != nil {
+		log.Error().Err(err).Str("node", nodeID).Msg("failed to record capacity history")
+	}
+}
+
+// CapacityHistory returns the capacity of a node over time
+func (s *NodeAPI) CapacityHistory(ctx context.Context, nodeID string, q historyQuery) ([]directory.CapacityPoint, error) {
+	filter, err := q.filter()
+	if err != nil {
+		return nil, err
+	}
+
+	filter = filter.WithNodeID(nodeID)
+	return s.history.History(ctx, filter, q.Step)
+}
+
+// CapacityHistory returns the capacity of all the nodes of a farm over time
+func (s *FarmAPI) CapacityHistory(ctx context.Context, farmID schema.ID, q historyQuery) ([]directory.CapacityPoint, error) {
+	filter, err := q.filter()
+	if err != nil {
+		return nil, err
+	}
+
+	filter = filter.WithFarmID(farmID)
+	return s.history.History(ctx, filter, q.Step)
+}
@@ -1,15 +1,20 @@
 package directory
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"strconv"
 
+	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 	"github.com/zaibon/httpsig"
+	"go.mongodb.org/mongo-driver/mongo"
 
 	"github.com/threefoldtech/tfexplorer/models"
+	generated "github.com/threefoldtech/tfexplorer/models/generated/directory"
 	"github.com/threefoldtech/tfexplorer/mw"
 	directory "github.com/threefoldtech/tfexplorer/pkg/directory/types"
 	"github.com/threefoldtech/tfexplorer/schema"
@@ -20,7 +25,6 @@ import (
 func (s *FarmAPI) registerFarm(r *http.Request) (interface{}, mw.Response) {
 	log.Info().Msg("farm register request received")
 
-	db := mw.Database(r)
 	defer r.Body.Close()
 
 	var info directory.Farm
@@ -32,7 +36,7 @@ func (s *FarmAPI) registerFarm(r *http.Request) (interface{}, mw.Response) {
 		return nil, mw.BadRequest(err)
 	}
 
-	id, err := s.Add(r.Context(), db, info)
+	id, err := s.Add(r.Context(), info)
 	if err != nil {
 		return nil, mw.Error(err)
 	}
@@ -45,6 +49,72 @@ func (s *FarmAPI) registerFarm(r *http.Request) (interface{}, mw.Response) {
 }
 
 func (s *FarmAPI) updateFarm(r *http.Request) (interface{}, mw.Response) {
+	farm, merr := s.loadFarm(r)
+	if merr != nil {
+		return nil, merr
+	}
+
+	defer r.Body.Close()
+
+	var info directory.Farm
+	if err := json.NewDecoder(r.Body).Decode(&info); err != nil {
+		return nil, mw.BadRequest(err)
+	}
+
+	// only the fields the owner can edit are written, ownership, admins and
+	// ip pool are managed through their dedicated endpoints
+	err := s.Update(r.Context(), farm.ID, info)
+	if errors.Is(err, directory.ErrFarmConflict) {
+		return nil, mw.Conflict(err)
+	} else if err != nil {
+		return nil, mw.Error(err)
+	}
+
+	return nil, mw.Ok()
+}
+
+func (s *FarmAPI) listFarm(r *http.Request) (interface{}, mw.Response) {
+	q := directory.FarmQuery{}
+	if err := q.Parse(r); err != nil {
+		return nil, err
+	}
+	var filter directory.FarmFilter
+	filter = filter.WithFarmQuery(q)
+
+	pager, err := models.PageFromRequest(r)
+	if err != nil {
+		return nil, mw.BadRequest(err)
+	}
+
+	pager, err = q.Query.Page(pager)
+	if err != nil {
+		return nil, mw.BadRequest(err)
+	}
+
+	farms, total, err := s.List(r.Context(), filter, pager, q.Query.Projection())
+	if err != nil {
+		return nil, mw.Error(err)
+	}
+
+	next, err := pager.Next(farms)
+	if err != nil {
+		return nil, mw.Error(err)
+	}
+
+	out, err := q.Query.Project(farms)
+	if err != nil {
+		return nil, mw.Error(err)
+	}
+
+	response := mw.Paginated(r, next)
+	if pager.Paged() {
+		response = response.WithHeader("Pages", fmt.Sprint(models.Pages(pager, total)))
+	}
+
+	return out, response
+}
+
+func (s *FarmAPI) getFarm(r *http.Request) (interface{}, mw.Response) {
 	sid := mux.Vars(r)["farm_id"]
 
 	id, err := strconv.ParseInt(sid, 10, 64)
@@ -52,71 +122,363 @@ func (s *FarmAPI) updateFarm(r *http.Request) (interface{}, mw.Response) {
 		return nil, mw.BadRequest(err)
 	}
 
-	db := mw.Database(r)
+	farm, err := s.GetByID(r.Context(), id)
+	if err != nil {
+		return nil, mw.NotFound(err)
+	}
+
+	return farm, nil
+}
+
+func (s *FarmAPI) capacityHistory(r *http.Request) (interface{}, mw.Response) {
+	sid := mux.Vars(r)["farm_id"]
 
-	farm, err := s.GetByID(r.Context(), db, id)
+	id, err := strconv.ParseInt(sid, 10, 64)
 	if err != nil {
+		return nil, mw.BadRequest(err)
+	}
+
+	q := historyQuery{}
+	if err := q.Parse(r); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.GetByID(r.Context(), id); err != nil {
 		return nil, mw.NotFound(err)
 	}
 
-	sfarmerID := httpsig.KeyIDFromContext(r.Context())
-	requestFarmerID, err := strconv.ParseInt(sfarmerID, 10, 64)
+	points, err := s.CapacityHistory(r.Context(), schema.ID(id), q)
 	if err != nil {
+		return nil, mw.Error(err)
+	}
+
+	return points, nil
+}
+
+func (s *FarmAPI) addAdmin(r *http.Request) (interface{}, mw.Response) {
+	farm, merr := s.loadFarm(r)
+	if merr != nil {
+		return nil, merr
+	}
+
+	defer r.Body.Close()
+
+	var admin generated.FarmAdmin
+	if err := json.NewDecoder(r.Body).Decode(&admin); err != nil {
 		return nil, mw.BadRequest(err)
 	}
 
+	if admin.ThreebotId == 0 {
+		return nil, mw.BadRequest(fmt.Errorf("threebot_id is required"))
+	}
+
+	if admin.Role != generated.FarmRoleOwner && admin.Role != generated.FarmRoleOperator {
+		return nil, mw.BadRequest(fmt.Errorf("unsupported role '%d'", admin.Role))
+	}
+
+	if admin.ThreebotId == farm.ThreebotId {
+		return nil, mw.Conflict(fmt.Errorf("threebot %d is already the owner of the farm", admin.ThreebotId))
+	}
+
+	if _, err := s.users.Get(r.Context(), schema.ID(admin.ThreebotId)); err != nil {
+		return nil, mw.NotFound(fmt.Errorf("user with id %d not found", admin.ThreebotId))
+	}
+
+	// adding an existing admin updates its role
+	admins := make([]generated.FarmAdmin, 0, len(farm.Admins)+1)
+	for _, a := range farm.Admins {
+		if a.ThreebotId == admin.ThreebotId {
+			continue
+		}
+		admins = append(admins, a)
+	}
+	admins = append(admins, admin)
+
+	// the checks above hold as long as the admins did not change
+	err := s.SetAdmins(r.Context(), farm.ID, farm.Admins, admins)
+	if errors.Is(err, directory.ErrAdminsChanged) {
+		return nil, mw.Conflict(err)
+	} else if err != nil {
+		return nil, mw.Error(err)
+	}
+
+	return nil, mw.Created()
+}
+
+func (s *FarmAPI) removeAdmin(r *http.Request) (interface{}, mw.Response) {
+	farm, merr := s.loadFarm(r)
+	if merr != nil {
+		return nil, merr
+	}
+
+	tid, err := strconv.ParseInt(mux.Vars(r)["threebot_id"], 10, 64)
+	if err != nil {
+		return nil, mw.BadRequest(errors.Wrap(err, "invalid threebot id"))
+	}
+
+	if tid == farm.ThreebotId {
+		return nil, mw.BadRequest(fmt.Errorf("the farm owner can not be removed, transfer the ownership of the farm instead"))
+	}
+
+	admins := make([]generated.FarmAdmin, 0, len(farm.Admins))
+	for _, a := range farm.Admins {
+		if a.ThreebotId == tid {
+			continue
+		}
+		admins = append(admins, a)
+	}
+
+	if len(admins) == len(farm.Admins) {
+		return nil, mw.NotFound(fmt.Errorf("threebot %d is not an admin of the farm", tid))
+	}
+
+	// the checks above hold as long as the admins did not change
+	err = s.SetAdmins(r.Context(), farm.ID, farm.Admins, admins)
+	if errors.Is(err, directory.ErrAdminsChanged) {
+		return nil, mw.Conflict(err)
+	} else if err != nil {
+		return nil, mw.Error(err)
+	}
+
+	return nil, mw.Ok()
+}
+
+func (s *FarmAPI) transferOwnership(r *http.Request) (interface{}, mw.Response) {
+	farm, merr := s.loadFarm(r)
+	if merr != nil {
+		return nil, merr
+	}
+
+	requestFarmerID, merr := requesterID(r)
+	if merr != nil {
+		return nil, merr
+	}
+
+	// the owners granted by the admins of the farm can not give it away
 	if farm.ThreebotId != requestFarmerID {
-		return nil, mw.Forbidden(fmt.Errorf("only the farm owner can update the information of its farm"))
+		return nil, mw.Deny(r, mw.Forbidden(fmt.Errorf("only the farm owner can transfer the ownership of its farm")))
 	}
 
-	var info directory.Farm
-	if err := json.NewDecoder(r.Body).Decode(&info); err != nil {
+	defer r.Body.Close()
+
+	input := struct {
+		ThreebotID int64 `json:"threebot_id"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
 		return nil, mw.BadRequest(err)
 	}
 
-	info.ID = schema.ID(id)
+	if input.ThreebotID == farm.ThreebotId {
+		return nil, mw.BadRequest(fmt.Errorf("threebot %d is already the owner of the farm", input.ThreebotID))
+	}
+
+	// a zero threebot_id cancels the pending transfer
+	if input.ThreebotID != 0 {
+		if _, err := s.users.Get(r.Context(), schema.ID(input.ThreebotID)); err != nil {
+			return nil, mw.NotFound(fmt.Errorf("user with id %d not found", input.ThreebotID))
+		}
+	}
 
-	err = s.Update(r.Context(), db, info.ID, info)
-	if err != nil {
+	if err := s.SetPendingOwner(r.Context(), farm.ID, input.ThreebotID); err != nil {
 		return nil, mw.Error(err)
 	}
 
 	return nil, mw.Ok()
 }
 
-func (s *FarmAPI) listFarm(r *http.Request) (interface{}, mw.Response) {
-	q := directory.FarmQuery{}
-	if err := q.Parse(r); err != nil {
-		return nil, err
+func (s *FarmAPI) acceptOwnership(r *http.Request) (interface{}, mw.Response) {
+	farm, merr := s.loadFarm(r)
+	if merr != nil {
+		return nil, merr
 	}
-	var filter directory.FarmFilter
-	filter = filter.WithFarmQuery(q)
-	db := mw.Database(r)
 
-	pager := models.PageFromRequest(r)
-	farms, total, err := s.List(r.Context(), db, filter, pager)
-	if err != nil {
+	requestFarmerID, merr := requesterID(r)
+	if merr != nil {
+		return nil, merr
+	}
+
+	if err := s.TransferOwnership(r.Context(), farm.ID, requestFarmerID); err != nil {
 		return nil, mw.Error(err)
 	}
 
-	pages := fmt.Sprintf("%d", models.Pages(pager, total))
-	return farms, mw.Ok().WithHeader("Pages", pages)
+	log.Info().
+		Int64("farm_id", int64(farm.ID)).
+		Int64("previous_owner", farm.ThreebotId).
+		Int64("new_owner", requestFarmerID).
+		Msg("farm ownership transferred")
+
+	return nil, mw.Ok()
 }
 
-func (s *FarmAPI) getFarm(r *http.Request) (interface{}, mw.Response) {
-	sid := mux.Vars(r)["farm_id"]
+func (s *FarmAPI) addIP(r *http.Request) (interface{}, mw.Response) {
+	farm, merr := s.loadFarm(r)
+	if merr != nil {
+		return nil, merr
+	}
 
-	id, err := strconv.ParseInt(sid, 10, 64)
-	if err != nil {
+	defer r.Body.Close()
+
+	var ip generated.PublicIP
+	if err := json.NewDecoder(r.Body).Decode(&ip); err != nil {
 		return nil, mw.BadRequest(err)
 	}
 
-	db := mw.Database(r)
+	if err := directory.ValidatePublicIP(ip); err != nil {
+		return nil, mw.BadRequest(err)
+	}
+
+	if farm.IPIndex(ip.Address.IP) >= 0 {
+		return nil, mw.Conflict(fmt.Errorf("ip address %s is already part of the farm", ip.Address.IP))
+	}
+
+	if err := s.AddIP(r.Context(), farm.ID, ip); err != nil {
+		return nil, mw.Error(err)
+	}
+
+	return nil, mw.Created()
+}
+
+func (s *FarmAPI) removeIP(r *http.Request) (interface{}, mw.Response) {
+	farm, merr := s.loadFarm(r)
+	if merr != nil {
+		return nil, merr
+	}
+
+	address := net.ParseIP(mux.Vars(r)["ip"])
+	if address == nil {
+		return nil, mw.BadRequest(fmt.Errorf("invalid ip address"))
+	}
+
+	i := farm.IPIndex(address)
+	if i < 0 {
+		return nil, mw.NotFound(fmt.Errorf("ip address %s is not part of the farm", address))
+	}
+
+	if farm.IPAddresses[i].ReservationId != 0 {
+		return nil, mw.Conflict(fmt.Errorf("ip address %s is used by reservation %d", address, farm.IPAddresses[i].ReservationId))
+	}
+
+	if err := s.RemoveIP(r.Context(), farm.ID, address); err != nil {
+		if errors.Is(err, directory.ErrIPNotAvailable) {
+			return nil, mw.Conflict(fmt.Errorf("ip address %s is in use", address))
+		}
+		return nil, mw.Error(err)
+	}
+
+	return nil, mw.Ok()
+}
+
+// walletChallenge creates a challenge the farm owner has to sign with the
+// private key of a wallet address to prove owning it
+func (s *FarmAPI) walletChallenge(r *http.Request) (interface{}, mw.Response) {
+	farm, address, merr := s.loadWallet(r)
+	if merr != nil {
+		return nil, merr
+	}
 
-	farm, err := s.GetByID(r.Context(), db, id)
+	challenge, err := s.WalletChallenge(r.Context(), farm.ID, address)
 	if err != nil {
+		return nil, mw.Error(err)
+	}
+
+	return challenge, mw.Created()
+}
+
+// verifyWallet checks the signed challenge of a wallet address and marks
+// the address as verified
+func (s *FarmAPI) verifyWallet(r *http.Request) (interface{}, mw.Response) {
+	farm, address, merr := s.loadWallet(r)
+	if merr != nil {
+		return nil, merr
+	}
+
+	defer r.Body.Close()
+
+	// signature is the base64 encoded signature of the challenge
+	// made with the private key of the address
+	input := struct {
+		Signature []byte `json:"signature"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return nil, mw.BadRequest(err)
+	}
+
+	err := s.VerifyWallet(r.Context(), farm.ID, address, input.Signature)
+	if errors.Is(err, directory.ErrChallengeNotFound) {
 		return nil, mw.NotFound(err)
+	} else if errors.Is(err, ErrInvalidSignature) {
+		return nil, mw.BadRequest(err)
+	} else if err != nil {
+		return nil, mw.Error(err)
+	}
+
+	log.Info().Int64("farm", int64(farm.ID)).Str("address", address).Msg("wallet address verified")
+	return nil, mw.Ok()
+}
+
+// loadWallet loads the farm and wallet address of the request
+func (s *FarmAPI) loadWallet(r *http.Request) (directory.Farm, string, mw.Response) {
+	farm, merr := s.loadFarm(r)
+	if merr != nil {
+		return farm, "", merr
+	}
+
+	address := mux.Vars(r)["address"]
+	if !farm.HasWalletAddress(address) {
+		return farm, "", mw.NotFound(fmt.Errorf("farm has no wallet address '%s'", address))
+	}
+
+	return farm, address, nil
+}
+
+// loadFarm loads the farm identified by the farm_id url parameter
+func (s *FarmAPI) loadFarm(r *http.Request) (directory.Farm, mw.Response) {
+	id, err := strconv.ParseInt(mux.Vars(r)["farm_id"], 10, 64)
+	if err != nil {
+		return directory.Farm{}, mw.BadRequest(err)
+	}
+
+	farm, err := s.GetByID(r.Context(), id)
+	if err != nil {
+		return directory.Farm{}, mw.NotFound(err)
 	}
 
 	return farm, nil
 }
+
+// roles implements mw.RoleResolver for the farms
+func (s *FarmAPI) roles(ctx context.Context, farmID string, tid int64) ([]mw.Role, error) {
+	id, err := strconv.ParseInt(farmID, 10, 64)
+	if err != nil {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	farm, err := s.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var roles []mw.Role
+	if farm.IsOwner(tid) {
+		roles = append(roles, mw.RoleFarmOwner)
+	}
+	if farm.IsAdmin(tid) {
+		roles = append(roles, mw.RoleFarmAdmin)
+	}
+	if farm.PendingOwner != 0 && farm.PendingOwner == tid {
+		roles = append(roles, mw.RoleFarmPendingOwner)
+	}
+
+	return roles, nil
+}
+
+// requesterID returns the threebot id of the user that signed the request
+func requesterID(r *http.Request) (int64, mw.Response) {
+	sid := httpsig.KeyIDFromContext(r.Context())
+	id, err := strconv.ParseInt(sid, 10, 64)
+	if err != nil {
+		return 0, mw.BadRequest(err)
+	}
+
+	return id, nil
+}
@@ -2,68 +2,113 @@ package directory
 
 import (
 	"context"
+	"net"
 
 	"github.com/pkg/errors"
+	"github.com/threefoldtech/tfexplorer/models"
+	generated "github.com/threefoldtech/tfexplorer/models/generated/directory"
 	directory "github.com/threefoldtech/tfexplorer/pkg/directory/types"
+	phonebook "github.com/threefoldtech/tfexplorer/pkg/phonebook/types"
+	"github.com/threefoldtech/tfexplorer/pkg/stellar"
 	"github.com/threefoldtech/tfexplorer/schema"
-	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // FarmAPI holds farm releated handlers
-type FarmAPI struct{}
+type FarmAPI struct {
+	farms      directory.FarmRepository
+	users      phonebook.UserRepository
+	challenges directory.WalletChallengeRepository
+	history    directory.CapacityRepository
+}
 
-// List farms
-// TODO: add paging arguments
-func (s *FarmAPI) List(ctx context.Context, db *mongo.Database, filter directory.FarmFilter, opts ...*options.FindOptions) ([]directory.Farm, int64, error) {
+var (
+	// ErrInvalidSignature is returned when the signature of a wallet challenge does not match the address
+	ErrInvalidSignature = errors.New("invalid challenge signature")
+)
 
-	cur, err := filter.Find(ctx, db, opts...)
+// List farms, the count of farms matching filter is only returned if the page
+// is selected by its number
+func (s *FarmAPI) List(ctx context.Context, filter directory.FarmFilter, pager models.Pager, opts ...*options.FindOptions) ([]directory.Farm, int64, error) {
+	farms, total, err := s.farms.List(ctx, filter, pager, opts...)
 	if err != nil {
 		return nil, 0, errors.Wrap(err, "failed to list farms")
 	}
-	defer cur.Close(ctx)
-	out := []directory.Farm{}
-	if err := cur.All(ctx, &out); err != nil {
-		return nil, 0, errors.Wrap(err, "failed to load farm list")
-	}
-
-	count, err := filter.Count(ctx, db)
-	if err != nil {
-		return nil, 0, errors.Wrap(err, "failed to count entries in farms collection")
-	}
 
-	return out, count, nil
+	return farms, total, nil
 }
 
 // GetByName gets a farm by name
-func (s *FarmAPI) GetByName(ctx context.Context, db *mongo.Database, name string) (directory.Farm, error) {
-	var filter directory.FarmFilter
-	filter = filter.WithName(name)
-
-	return filter.Get(ctx, db)
+func (s *FarmAPI) GetByName(ctx context.Context, name string) (directory.Farm, error) {
+	return s.farms.GetByName(ctx, name)
 }
 
 // GetByID gets a farm by ID
-func (s *FarmAPI) GetByID(ctx context.Context, db *mongo.Database, id int64) (directory.Farm, error) {
-	var filter directory.FarmFilter
-	filter = filter.WithID(schema.ID(id))
-
-	return filter.Get(ctx, db)
+func (s *FarmAPI) GetByID(ctx context.Context, id int64) (directory.Farm, error) {
+	return s.farms.Get(ctx, schema.ID(id))
 }
 
 // Add add farm to store
-func (s *FarmAPI) Add(ctx context.Context, db *mongo.Database, farm directory.Farm) (schema.ID, error) {
-	return directory.FarmCreate(ctx, db, farm)
+func (s *FarmAPI) Add(ctx context.Context, farm directory.Farm) (schema.ID, error) {
+	return s.farms.Create(ctx, farm)
 }
 
 // Update farm information
-func (s *FarmAPI) Update(ctx context.Context, db *mongo.Database, id schema.ID, farm directory.Farm) error {
-	return directory.FarmUpdate(ctx, db, id, farm)
+func (s *FarmAPI) Update(ctx context.Context, id schema.ID, farm directory.Farm) error {
+	return s.farms.Update(ctx, id, farm)
+}
+
+// SetAdmins replaces the list of admins of a farm if current is still its list
+// of admins
+func (s *FarmAPI) SetAdmins(ctx context.Context, id schema.ID, current, admins []generated.FarmAdmin) error {
+	return s.farms.SetAdmins(ctx, id, current, admins)
+}
+
+// SetPendingOwner offers the ownership of the farm to tid
+func (s *FarmAPI) SetPendingOwner(ctx context.Context, id schema.ID, tid int64) error {
+	return s.farms.SetPendingOwner(ctx, id, tid)
+}
+
+// TransferOwnership makes tid the owner of the farm
+func (s *FarmAPI) TransferOwnership(ctx context.Context, id schema.ID, tid int64) error {
+	return s.farms.TransferOwnership(ctx, id, tid)
+}
+
+// AddIP adds a public address to the farm ip pool
+func (s *FarmAPI) AddIP(ctx context.Context, id schema.ID, ip generated.PublicIP) error {
+	return s.farms.AddIP(ctx, id, ip)
+}
+
+// RemoveIP removes a free public address from the farm ip pool
+func (s *FarmAPI) RemoveIP(ctx context.Context, id schema.ID, address net.IP) error {
+	return s.farms.RemoveIP(ctx, id, address)
+}
+
+// WalletChallenge creates a challenge to be signed with the key of address
+func (s *FarmAPI) WalletChallenge(ctx context.Context, id schema.ID, address string) (directory.WalletChallenge, error) {
+	return s.challenges.Create(ctx, id, address)
+}
+
+// VerifyWallet checks that signature is the signature of the pending challenge
+// of address and if so marks the address as verified
+func (s *FarmAPI) VerifyWallet(ctx context.Context, id schema.ID, address string, signature []byte) error {
+	challenge, err := s.challenges.Get(ctx, id, address)
+	if err != nil {
+		return err
+	}
+
+	if err := stellar.VerifySignature(address, []byte(challenge.Challenge), signature); err != nil {
+		return errors.Wrap(ErrInvalidSignature, err.Error())
+	}
+
+	if err := s.farms.SetWalletVerified(ctx, id, address); err != nil {
+		return errors.Wrap(err, "failed to mark address as verified")
+	}
+
+	return s.challenges.Delete(ctx, id, address)
 }
 
 // Delete deletes a farm by ID
-func (s FarmAPI) Delete(ctx context.Context, db *mongo.Database, id int64) error {
-	var filter directory.FarmFilter
-	filter = filter.WithID(schema.ID(id))
-	return filter.Delete(ctx, db)
+func (s FarmAPI) Delete(ctx context.Context, id int64) error {
+	return s.farms.Delete(ctx, schema.ID(id))
 }
@@ -1,17 +1,19 @@
 package directory
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 
 	"github.com/rs/zerolog/log"
-	"github.com/zaibon/httpsig"
 
 	"github.com/threefoldtech/tfexplorer/models"
 	generated "github.com/threefoldtech/tfexplorer/models/generated/directory"
 	"github.com/threefoldtech/tfexplorer/mw"
 	directory "github.com/threefoldtech/tfexplorer/pkg/directory/types"
+	"github.com/threefoldtech/zos/pkg/capacity"
+	"github.com/threefoldtech/zos/pkg/capacity/dmi"
 
 	"github.com/gorilla/mux"
 )
@@ -26,8 +28,9 @@ func (s *GatewayAPI) registerGateway(r *http.Request) (interface{}, mw.Response)
 		return nil, mw.BadRequest(err)
 	}
 
-	db := mw.Database(r)
-	if _, err := s.Add(r.Context(), db, gw); err != nil {
+	//make sure gateway can not set public config
+	gw.PublicConfig = nil
+	if _, err := s.Add(r.Context(), gw); err != nil {
 		return nil, mw.Error(err)
 	}
 
@@ -42,42 +45,190 @@ func (s *GatewayAPI) gatewayDetail(r *http.Request) (interface{}, mw.Response) {
 	if err := q.Parse(r); err != nil {
 		return nil, err
 	}
-	db := mw.Database(r)
-
-	node, err := s.Get(r.Context(), db, nodeID)
+	node, err := s.Get(r.Context(), nodeID)
 	if err != nil {
 		return nil, mw.NotFound(err)
 	}
 
+	if !q.Proofs {
+		node.Proofs = nil
+	}
+
 	return node, nil
 }
 
+func (s *GatewayAPI) listDomains(r *http.Request) (interface{}, mw.Response) {
+	nodeID := mux.Vars(r)["node_id"]
+	gw, err := s.Get(r.Context(), nodeID)
+	if err != nil {
+		return nil, mw.NotFound(err)
+	}
+
+	claims, err := s.Domains(r.Context(), nodeID)
+	if err != nil {
+		return nil, mw.Error(err)
+	}
+
+	return struct {
+		ManagedDomains []string                `json:"managed_domains"`
+		Claims         []directory.DomainClaim `json:"claims"`
+	}{
+		ManagedDomains: gw.ManagedDomains,
+		Claims:         claims,
+	}, nil
+}
+
 func (s *GatewayAPI) listGateways(r *http.Request) (interface{}, mw.Response) {
 	q := gatewayQuery{}
 	if err := q.Parse(r); err != nil {
 		return nil, err
 	}
 
-	db := mw.Database(r)
-	pager := models.PageFromRequest(r)
-	nodes, total, err := s.List(r.Context(), db, q, pager)
+	pager, err := models.PageFromRequest(r)
+	if err != nil {
+		return nil, mw.BadRequest(err)
+	}
+
+	pager, err = q.Query.Page(pager)
+	if err != nil {
+		return nil, mw.BadRequest(err)
+	}
+
+	nodes, total, err := s.List(r.Context(), q, pager)
+	if err != nil {
+		return nil, mw.Error(err)
+	}
+
+	next, err := pager.Next(nodes)
+	if err != nil {
+		return nil, mw.Error(err)
+	}
+
+	out, err := q.Query.Project(nodes)
 	if err != nil {
 		return nil, mw.Error(err)
 	}
 
-	pages := fmt.Sprintf("%d", models.Pages(pager, total))
-	return nodes, mw.Ok().WithHeader("Pages", pages)
+	response := mw.Paginated(r, next)
+	if pager.Paged() {
+		response = response.WithHeader("Pages", fmt.Sprint(models.Pages(pager, total)))
+	}
+
+	return out, response
 }
 
-func (s *GatewayAPI) updateUptimeHandler(r *http.Request) (interface{}, mw.Response) {
+func (s *GatewayAPI) registerCapacity(r *http.Request) (interface{}, mw.Response) {
+	x := struct {
+		Capacity   generated.ResourceAmount `json:"capacity,omitempty"`
+		DMI        dmi.DMI                  `json:"dmi,omitempty"`
+		Disks      capacity.Disks           `json:"disks,omitempty"`
+		Hypervisor []string                 `json:"hypervisor,omitempty"`
+	}{}
+
 	defer r.Body.Close()
 
+	if err := json.NewDecoder(r.Body).Decode(&x); err != nil {
+		return nil, mw.BadRequest(err)
+	}
+
 	nodeID := mux.Vars(r)["node_id"]
-	hNodeID := httpsig.KeyIDFromContext(r.Context())
-	if nodeID != hNodeID {
-		return nil, mw.Forbidden(fmt.Errorf("trying to register uptime for nodeID %s while you are %s", nodeID, hNodeID))
+	if err := s.updateTotalCapacity(r.Context(), nodeID, x.Capacity); err != nil {
+		return nil, mw.NotFound(err)
 	}
 
+	if err := s.StoreProof(r.Context(), nodeID, x.DMI, x.Disks, x.Hypervisor); err != nil {
+		return nil, mw.Error(err)
+	}
+
+	return nil, nil
+}
+
+func (s *GatewayAPI) registerIfaces(r *http.Request) (interface{}, mw.Response) {
+	log.Debug().Msg("gateway network interfaces register request received")
+
+	defer r.Body.Close()
+
+	var input []generated.Iface
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		return nil, mw.BadRequest(err)
+	}
+
+	nodeID := mux.Vars(r)["node_id"]
+	if err := s.SetInterfaces(r.Context(), nodeID, input); err != nil {
+		return nil, mw.Error(err)
+	}
+
+	return nil, mw.Created()
+}
+
+func (s *GatewayAPI) configurePublic(r *http.Request) (interface{}, mw.Response) {
+	var iface generated.PublicIface
+
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&iface); err != nil {
+		return nil, mw.BadRequest(err)
+	}
+
+	if err := iface.Validate(); err != nil {
+		return nil, mw.BadRequest(fmt.Errorf("error during validation of public config: %w", err))
+	}
+
+	nodeID := mux.Vars(r)["node_id"]
+
+	if err := s.SetPublicConfig(r.Context(), nodeID, iface); err != nil {
+		return nil, mw.Error(err)
+	}
+
+	return nil, mw.Created()
+}
+
+func (s *GatewayAPI) configureFreeToUse(r *http.Request) (interface{}, mw.Response) {
+	nodeID := mux.Vars(r)["node_id"]
+
+	gw, err := s.Get(r.Context(), nodeID)
+	if err != nil {
+		return nil, mw.NotFound(err)
+	}
+
+	choice := struct {
+		FreeToUse bool `json:"free_to_use"`
+	}{}
+
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&choice); err != nil {
+		return nil, mw.BadRequest(err)
+	}
+
+	if err := s.updateFreeToUse(r.Context(), gw.NodeId, choice.FreeToUse); err != nil {
+		return nil, mw.Error(err)
+	}
+
+	return nil, mw.Ok()
+}
+
+func (s *GatewayAPI) decommission(r *http.Request) (interface{}, mw.Response) {
+	nodeID := mux.Vars(r)["node_id"]
+
+	gw, err := s.Get(r.Context(), nodeID)
+	if err != nil {
+		return nil, mw.NotFound(err)
+	}
+
+	if gw.Retired {
+		return nil, mw.Conflict(fmt.Errorf("gateway '%s' is already decommissioned", nodeID))
+	}
+
+	if err := s.Retire(r.Context(), nodeID); err != nil {
+		return nil, mw.Error(err)
+	}
+
+	return nil, mw.Ok()
+}
+
+func (s *GatewayAPI) updateUptimeHandler(r *http.Request) (interface{}, mw.Response) {
+	defer r.Body.Close()
+
+	nodeID := mux.Vars(r)["node_id"]
 	input := struct {
 		Uptime uint64 `json:"uptime"`
 	}{}
@@ -85,10 +236,9 @@ func (s *GatewayAPI) updateUptimeHandler(r *http.Request) (interface{}, mw.Respo
 		return nil, mw.BadRequest(err)
 	}
 
-	db := mw.Database(r)
 	log.Debug().Str("gateway", nodeID).Uint64("uptime", input.Uptime).Msg("gateway uptime received")
 
-	if err := s.updateUptime(r.Context(), db, nodeID, int64(input.Uptime)); err != nil {
+	if err := s.updateUptime(r.Context(), nodeID, int64(input.Uptime)); err != nil {
 		return nil, mw.NotFound(err)
 	}
 
@@ -99,11 +249,6 @@ func (s *GatewayAPI) updateReservedResources(r *http.Request) (interface{}, mw.R
 	defer r.Body.Close()
 
 	nodeID := mux.Vars(r)["node_id"]
-	hNodeID := httpsig.KeyIDFromContext(r.Context())
-	if nodeID != hNodeID {
-		return nil, mw.Forbidden(fmt.Errorf("trying to update reserved capacity for nodeID %s while you are %s", nodeID, hNodeID))
-	}
-
 	input := struct {
 		generated.ResourceAmount
 		generated.WorkloadAmount
@@ -113,13 +258,22 @@ func (s *GatewayAPI) updateReservedResources(r *http.Request) (interface{}, mw.R
 		return nil, mw.BadRequest(err)
 	}
 
-	db := mw.Database(r)
-	if err := s.updateReservedCapacity(r.Context(), db, nodeID, input.ResourceAmount); err != nil {
+	if err := s.updateReservedCapacity(r.Context(), nodeID, input.ResourceAmount); err != nil {
 		return nil, mw.NotFound(err)
 	}
-	if err := s.updateWorkloadsAmount(r.Context(), db, nodeID, input.WorkloadAmount); err != nil {
+	if err := s.updateWorkloadsAmount(r.Context(), nodeID, input.WorkloadAmount); err != nil {
 		return nil, mw.NotFound(err)
 	}
 
 	return nil, nil
 }
+
+// farmer implements mw.FarmerResolver for the gateways
+func (s *GatewayAPI) farmer(ctx context.Context, nodeID string, tid int64) (bool, error) {
+	gw, err := s.Get(ctx, nodeID)
+	if err != nil {
+		return false, err
+	}
+
+	return isFarmAdmin(ctx, s.farms, gw.FarmId, tid)
+}
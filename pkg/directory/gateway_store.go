@@ -7,92 +7,220 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/tfexplorer/models"
 	generated "github.com/threefoldtech/tfexplorer/models/generated/directory"
 	"github.com/threefoldtech/tfexplorer/mw"
 	directory "github.com/threefoldtech/tfexplorer/pkg/directory/types"
+	workloads "github.com/threefoldtech/tfexplorer/pkg/workloads/types"
 	"github.com/threefoldtech/tfexplorer/schema"
-	"go.mongodb.org/mongo-driver/mongo"
+	"github.com/threefoldtech/zos/pkg/capacity"
+	"github.com/threefoldtech/zos/pkg/capacity/dmi"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // GatewayAPI holds api for gateways
-type GatewayAPI struct{}
+type GatewayAPI struct {
+	gateways     directory.GatewayRepository
+	farms        directory.FarmRepository
+	reservations workloads.ReservationRepository
+}
 
 type gatewayQuery struct {
+	FarmID  int64
 	Country string
 	City    string
+	CRU     int64
+	MRU     int64
+	SRU     int64
+	HRU     int64
+	Domain  string
+	Proofs  bool
+	Retired bool
+	Query   models.Query
 }
 
 func (n *gatewayQuery) Parse(r *http.Request) mw.Response {
+	var err error
+	n.FarmID, err = models.QueryInt(r, "farm")
+	if err != nil {
+		return mw.BadRequest(errors.Wrap(err, "invalid farm id"))
+	}
 	n.Country = r.URL.Query().Get("country")
 	n.City = r.URL.Query().Get("city")
+	n.CRU, err = models.QueryInt(r, "cru")
+	if err != nil {
+		return mw.BadRequest(errors.Wrap(err, "invalid cru"))
+	}
+	n.MRU, err = models.QueryInt(r, "mru")
+	if err != nil {
+		return mw.BadRequest(errors.Wrap(err, "invalid mru"))
+	}
+	n.SRU, err = models.QueryInt(r, "sru")
+	if err != nil {
+		return mw.BadRequest(errors.Wrap(err, "invalid sru"))
+	}
+	n.HRU, err = models.QueryInt(r, "hru")
+	if err != nil {
+		return mw.BadRequest(errors.Wrap(err, "invalid hru"))
+	}
+	n.Domain = r.URL.Query().Get("domain")
+	n.Proofs = r.URL.Query().Get("proofs") == "true"
+	n.Retired = r.URL.Query().Get("retired") == "true"
+
+	n.Query, err = models.ParseQuery(r, directory.GatewayQueryFields)
+	if err != nil {
+		return mw.BadRequest(err)
+	}
+
 	return nil
 }
 
-// List all gateways
-func (s *GatewayAPI) List(ctx context.Context, db *mongo.Database, q gatewayQuery, opts ...*options.FindOptions) ([]directory.Gateway, int64, error) {
+// List all gateways, the count of gateways matching the query is only returned
+// if the page is selected by its number
+func (s *GatewayAPI) List(ctx context.Context, q gatewayQuery, pager models.Pager) ([]directory.Gateway, int64, error) {
 	var filter directory.GatewayFilter
+	if q.FarmID > 0 {
+		filter = filter.WithFarmID(schema.ID(q.FarmID))
+	}
+	filter = filter.WithTotalCap(q.CRU, q.MRU, q.HRU, q.SRU)
 	filter = filter.WithLocation(q.Country, q.City)
-
-	cur, err := filter.Find(ctx, db, opts...)
-	if err != nil {
-		return nil, 0, errors.Wrap(err, "failed to list nodes")
+	if q.Domain != "" {
+		filter = filter.WithManagedDomain(q.Domain)
 	}
+	filter = filter.WithRetired(q.Retired)
 
-	defer cur.Close(ctx)
-	out := []directory.Gateway{}
-	if err := cur.All(ctx, &out); err != nil {
-		return nil, 0, errors.Wrap(err, "failed to load node list")
+	filter = filter.WithQuery(q.Query)
+
+	var opts []*options.FindOptions
+	if q.Query.Projected() {
+		opts = append(opts, q.Query.Projection())
+	} else if !q.Proofs {
+		projection := bson.D{
+			{Key: "proofs", Value: 0},
+		}
+		opts = append(opts, options.Find().SetProjection(projection))
 	}
 
-	count, err := filter.Count(ctx, db)
+	gateways, total, err := s.gateways.List(ctx, filter, pager, opts...)
 	if err != nil {
-		return nil, 0, errors.Wrap(err, "failed to count entries in nodes collection")
+		return nil, 0, errors.Wrap(err, "failed to list gateways")
 	}
 
-	return out, count, nil
+	return gateways, total, nil
 }
 
 // Get a single gateway
-func (s *GatewayAPI) Get(ctx context.Context, db *mongo.Database, gwID string) (directory.Gateway, error) {
-	var filter directory.GatewayFilter
-	filter = filter.WithGWID(gwID)
-	return filter.Get(ctx, db)
+func (s *GatewayAPI) Get(ctx context.Context, gwID string) (directory.Gateway, error) {
+	return s.gateways.Get(ctx, gwID)
 }
 
 // Exists tests if node exists
-func (s *GatewayAPI) Exists(ctx context.Context, db *mongo.Database, gwID string) (bool, error) {
-	var filter directory.GatewayFilter
-	filter = filter.WithGWID(gwID)
+func (s *GatewayAPI) Exists(ctx context.Context, gwID string) (bool, error) {
+	return s.gateways.Exists(ctx, gwID)
+}
+
+// Count counts the number of document in the collection
+func (s *GatewayAPI) Count(ctx context.Context, filter directory.GatewayFilter) (int64, error) {
+	return s.gateways.Count(ctx, filter)
+}
+
+// Add a node to the store
+func (s *GatewayAPI) Add(ctx context.Context, gw directory.Gateway) (schema.ID, error) {
+	return s.gateways.Create(ctx, gw)
+}
+
+func (s *GatewayAPI) updateTotalCapacity(ctx context.Context, gwID string, capacity generated.ResourceAmount) error {
+	return s.gateways.SetTotalResources(ctx, gwID, capacity)
+}
 
-	count, err := filter.Count(ctx, db)
+func (s *GatewayAPI) updateReservedCapacity(ctx context.Context, gwID string, capacity generated.ResourceAmount) error {
+	return s.gateways.SetReservedResources(ctx, gwID, capacity)
+}
+
+func (s *GatewayAPI) updateUptime(ctx context.Context, gwID string, uptime int64) error {
+	return s.gateways.SetUptime(ctx, gwID, uptime)
+}
+
+func (s *GatewayAPI) updateFreeToUse(ctx context.Context, gwID string, freeToUse bool) error {
+	return s.gateways.SetFreeToUse(ctx, gwID, freeToUse)
+}
+
+func (s *GatewayAPI) updateWorkloadsAmount(ctx context.Context, gwID string, workloads generated.WorkloadAmount) error {
+	return s.gateways.SetWorkloadsAmount(ctx, gwID, workloads)
+}
+
+// Retire marks the gateway as decommissioned and flags all the active reservations using it
+func (s *GatewayAPI) Retire(ctx context.Context, gwID string) error {
+	if err := s.gateways.SetRetired(ctx, gwID); err != nil {
+		return errors.Wrap(err, "failed to mark gateway as retired")
+	}
+
+	flagged, err := s.reservations.FlagRetiredNode(ctx, gwID)
 	if err != nil {
-		return false, err
+		return errors.Wrap(err, "failed to flag reservations using the gateway")
 	}
 
-	return count > 0, nil
+	log.Info().Str("gateway", gwID).Int64("reservations", flagged).Msg("gateway decommissioned")
+	return nil
 }
 
-// Count counts the number of document in the collection
-func (s *GatewayAPI) Count(ctx context.Context, db *mongo.Database, filter directory.GatewayFilter) (int64, error) {
-	return filter.Count(ctx, db)
-}
+// StoreProof stores gateway hardware proof. Like for nodes, a proof is
+// only kept when the hardware differs from the last one
+func (s *GatewayAPI) StoreProof(ctx context.Context, gwID string, dmi dmi.DMI, disks capacity.Disks, hypervisor []string) error {
+	proof, err := newProof(dmi, disks, hypervisor)
+	if err != nil {
+		return err
+	}
 
-// Add a node to the store
-func (s *GatewayAPI) Add(ctx context.Context, db *mongo.Database, gw directory.Gateway) (schema.ID, error) {
-	return directory.GatewayCreate(ctx, db, gw)
+	gw, err := s.Get(ctx, gwID)
+	if err != nil {
+		return err
+	}
+
+	last, ok := lastProof(gw.Proofs)
+	if !ok {
+		return s.gateways.PushProof(ctx, gwID, proof)
+	}
+
+	changed, err := proofChanged(last, proof)
+	if err != nil {
+		return err
+	}
+
+	if !changed {
+		return nil
+	}
+
+	log.Warn().Str("gateway", gwID).Msg("gateway hardware changed since last proof")
+	return s.gateways.PushProof(ctx, gwID, proof)
 }
 
-func (s *GatewayAPI) updateReservedCapacity(ctx context.Context, db *mongo.Database, gwID string, capacity generated.ResourceAmount) error {
-	return directory.GatewayUpdateReservedResources(ctx, db, gwID, capacity)
+// SetInterfaces updates gateway interfaces
+func (s *GatewayAPI) SetInterfaces(ctx context.Context, gwID string, ifaces []generated.Iface) error {
+	return s.gateways.SetInterfaces(ctx, gwID, ifaces)
 }
 
-func (s *GatewayAPI) updateUptime(ctx context.Context, db *mongo.Database, gwID string, uptime int64) error {
-	return directory.GatewayUpdateUptime(ctx, db, gwID, uptime)
+// SetPublicConfig sets gateway public config
+func (s *GatewayAPI) SetPublicConfig(ctx context.Context, gwID string, cfg generated.PublicIface) error {
+	gw, err := s.Get(ctx, gwID)
+	if err != nil {
+		return err
+	}
+
+	if gw.PublicConfig == nil {
+		cfg.Version = 1
+	} else {
+		cfg.Version = gw.PublicConfig.Version + 1
+	}
+
+	return s.gateways.SetPublicConfig(ctx, gwID, cfg)
 }
 
-func (s *GatewayAPI) updateWorkloadsAmount(ctx context.Context, db *mongo.Database, gwID string, workloads generated.WorkloadAmount) error {
-	return directory.GatewayUpdateWorkloadsAmount(ctx, db, gwID, workloads)
+// Domains lists the domains claimed by reservations on the gateway
+func (s *GatewayAPI) Domains(ctx context.Context, gwID string) ([]directory.DomainClaim, error) {
+	return s.gateways.Domains(ctx, gwID)
 }
 
 // Requires is a wrapper that makes sure gateway with that key exists before
@@ -105,9 +233,7 @@ func (s *GatewayAPI) Requires(key string, handler mw.Action) mw.Action {
 			panic("invalid node-id key")
 		}
 
-		db := mw.Database(r)
-
-		exists, err := s.Exists(r.Context(), db, gwID)
+		exists, err := s.Exists(r.Context(), gwID)
 		if err != nil {
 			return nil, mw.Error(err)
 		} else if !exists {
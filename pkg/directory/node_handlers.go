@@ -6,16 +6,14 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"strconv"
 
 	"github.com/rs/zerolog/log"
-	"github.com/zaibon/httpsig"
 	"go.mongodb.org/mongo-driver/mongo"
 
+	"github.com/threefoldtech/tfexplorer/config"
 	"github.com/threefoldtech/tfexplorer/models"
 	generated "github.com/threefoldtech/tfexplorer/models/generated/directory"
 	"github.com/threefoldtech/tfexplorer/mw"
-	"github.com/threefoldtech/tfexplorer/pkg/directory/types"
 	directory "github.com/threefoldtech/tfexplorer/pkg/directory/types"
 	"github.com/threefoldtech/tfexplorer/schema"
 	"github.com/threefoldtech/zos/pkg/capacity"
@@ -36,8 +34,7 @@ func (s *NodeAPI) registerNode(r *http.Request) (interface{}, mw.Response) {
 
 	//make sure node can not set public config
 	n.PublicConfig = nil
-	db := mw.Database(r)
-	if _, err := s.Add(r.Context(), db, n); err != nil {
+	if _, err := s.Add(r.Context(), n); err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
 			return nil, mw.NotFound(fmt.Errorf("farm with id:%d does not exists", n.FarmId))
 		}
@@ -55,9 +52,8 @@ func (s *NodeAPI) nodeDetail(r *http.Request) (interface{}, mw.Response) {
 	if err := q.Parse(r); err != nil {
 		return nil, err
 	}
-	db := mw.Database(r)
 
-	node, err := s.Get(r.Context(), db, nodeID, q.Proofs)
+	node, err := s.Get(r.Context(), nodeID, q.Proofs)
 	if err != nil {
 		return nil, mw.NotFound(err)
 	}
@@ -71,15 +67,37 @@ func (s *NodeAPI) listNodes(r *http.Request) (interface{}, mw.Response) {
 		return nil, err
 	}
 
-	db := mw.Database(r)
-	pager := models.PageFromRequest(r)
-	nodes, total, err := s.List(r.Context(), db, q, pager)
+	pager, err := models.PageFromRequest(r)
+	if err != nil {
+		return nil, mw.BadRequest(err)
+	}
+
+	pager, err = q.Query.Page(pager)
+	if err != nil {
+		return nil, mw.BadRequest(err)
+	}
+
+	nodes, total, err := s.List(r.Context(), q, pager)
+	if err != nil {
+		return nil, mw.Error(err)
+	}
+
+	next, err := pager.Next(nodes)
 	if err != nil {
 		return nil, mw.Error(err)
 	}
 
-	pages := fmt.Sprintf("%d", models.Pages(pager, total))
-	return nodes, mw.Ok().WithHeader("Pages", pages)
+	out, err := q.Query.Project(nodes)
+	if err != nil {
+		return nil, mw.Error(err)
+	}
+
+	response := mw.Paginated(r, next)
+	if pager.Paged() {
+		response = response.WithHeader("Pages", fmt.Sprint(models.Pages(pager, total)))
+	}
+
+	return out, response
 }
 
 func (s *NodeAPI) registerCapacity(r *http.Request) (interface{}, mw.Response) {
@@ -97,21 +115,17 @@ func (s *NodeAPI) registerCapacity(r *http.Request) (interface{}, mw.Response) {
 	}
 
 	nodeID := mux.Vars(r)["node_id"]
-	hNodeID := httpsig.KeyIDFromContext(r.Context())
-	if nodeID != hNodeID {
-		return nil, mw.Forbidden(fmt.Errorf("trying to register capacity for nodeID %s while you are %s", nodeID, hNodeID))
-	}
 
-	db := mw.Database(r)
-
-	if err := s.updateTotalCapacity(r.Context(), db, nodeID, x.Capacity); err != nil {
+	if err := s.updateTotalCapacity(r.Context(), nodeID, x.Capacity); err != nil {
 		return nil, mw.NotFound(err)
 	}
 
-	if err := s.StoreProof(r.Context(), db, nodeID, x.DMI, x.Disks, x.Hypervisor); err != nil {
+	if err := s.StoreProof(r.Context(), nodeID, x.DMI, x.Disks, x.Hypervisor); err != nil {
 		return nil, mw.Error(err)
 	}
 
+	s.recordCapacity(r.Context(), nodeID)
+
 	return nil, nil
 }
 
@@ -126,13 +140,7 @@ func (s *NodeAPI) registerIfaces(r *http.Request) (interface{}, mw.Response) {
 	}
 
 	nodeID := mux.Vars(r)["node_id"]
-	hNodeID := httpsig.KeyIDFromContext(r.Context())
-	if nodeID != hNodeID {
-		return nil, mw.Forbidden(fmt.Errorf("trying to register interfaces for nodeID %s while you are %s", nodeID, hNodeID))
-	}
-
-	db := mw.Database(r)
-	if err := s.SetInterfaces(r.Context(), db, nodeID, input); err != nil {
+	if err := s.SetInterfaces(r.Context(), nodeID, input); err != nil {
 		return nil, mw.Error(err)
 	}
 
@@ -151,63 +159,123 @@ func (s *NodeAPI) configurePublic(r *http.Request) (interface{}, mw.Response) {
 		return nil, mw.BadRequest(fmt.Errorf("error during validation of public config: %w", err))
 	}
 
-	db := mw.Database(r)
 	nodeID := mux.Vars(r)["node_id"]
 
-	node, err := s.Get(r.Context(), db, nodeID, false)
-	if err != nil {
-		return nil, mw.NotFound(err)
+	if err := s.SetPublicConfig(r.Context(), nodeID, iface); err != nil {
+		return nil, mw.Error(err)
 	}
 
-	// ensure it is the farmer that does the call
-	authorized, merr := isFarmerAuthorized(r, node, db)
+	return nil, mw.Created()
+}
+
+func (s *NodeAPI) configureFreeToUse(r *http.Request) (interface{}, mw.Response) {
+	nodeID := mux.Vars(r)["node_id"]
+
+	node, err := s.Get(r.Context(), nodeID, false)
 	if err != nil {
-		return nil, merr
+		return nil, mw.NotFound(err)
 	}
 
-	if !authorized {
-		return nil, mw.Forbidden(fmt.Errorf("only the farmer can configured the public interface of its nodes"))
+	choice := struct {
+		FreeToUse bool `json:"free_to_use"`
+	}{}
+
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&choice); err != nil {
+		return nil, mw.BadRequest(err)
 	}
 
-	if err := s.SetPublicConfig(r.Context(), db, nodeID, iface); err != nil {
+	if err := s.updateFreeToUse(r.Context(), node.NodeId, choice.FreeToUse); err != nil {
 		return nil, mw.Error(err)
 	}
 
-	return nil, mw.Created()
+	return nil, mw.Ok()
 }
 
-func (s *NodeAPI) configureFreeToUse(r *http.Request) (interface{}, mw.Response) {
-	db := mw.Database(r)
+func (s *NodeAPI) decommission(r *http.Request) (interface{}, mw.Response) {
 	nodeID := mux.Vars(r)["node_id"]
 
-	node, err := s.Get(r.Context(), db, nodeID, false)
+	node, err := s.Get(r.Context(), nodeID, false)
 	if err != nil {
 		return nil, mw.NotFound(err)
 	}
 
-	// ensure it is the farmer that does the call
-	authorized, merr := isFarmerAuthorized(r, node, db)
+	if node.Retired {
+		return nil, mw.Conflict(fmt.Errorf("node '%s' is already decommissioned", nodeID))
+	}
+
+	if err := s.Retire(r.Context(), nodeID); err != nil {
+		return nil, mw.Error(err)
+	}
+
+	return nil, mw.Ok()
+}
+
+func (s *NodeAPI) proofsDiff(r *http.Request) (interface{}, mw.Response) {
+	nodeID := mux.Vars(r)["node_id"]
+
+	diffs, err := s.ProofsDiff(r.Context(), nodeID)
 	if err != nil {
+		return nil, mw.Error(err)
+	}
+
+	return diffs, nil
+}
+
+func (s *NodeAPI) acknowledgeHardwareChange(r *http.Request) (interface{}, mw.Response) {
+	nodeID := mux.Vars(r)["node_id"]
+
+	if err := s.AcknowledgeHardwareChange(r.Context(), nodeID); err != nil {
+		return nil, mw.Error(err)
+	}
+
+	return nil, mw.Ok()
+}
+
+func (s *NodeAPI) approveNode(r *http.Request) (interface{}, mw.Response) {
+	return s.setApproval(r, true)
+}
+
+func (s *NodeAPI) revokeNode(r *http.Request) (interface{}, mw.Response) {
+	return s.setApproval(r, false)
+}
+
+func (s *NodeAPI) setApproval(r *http.Request, approved bool) (interface{}, mw.Response) {
+	certifier, merr := requesterID(r)
+	if merr != nil {
 		return nil, merr
 	}
 
-	if !authorized {
-		return nil, mw.Forbidden(fmt.Errorf("only the farmer can configured the if the node is free to use"))
+	if !config.Config.Certifiers.Has(certifier) {
+		return nil, mw.Forbidden(fmt.Errorf("threebot %d is not allowed to certify nodes", certifier))
 	}
 
-	choice := struct {
-		FreeToUse bool `json:"free_to_use"`
+	input := struct {
+		Reason string `json:"reason"`
 	}{}
 
 	defer r.Body.Close()
-	if err := json.NewDecoder(r.Body).Decode(&choice); err != nil {
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
 		return nil, mw.BadRequest(err)
 	}
 
-	if err := s.updateFreeToUse(r.Context(), db, node.NodeId, choice.FreeToUse); err != nil {
+	if len(input.Reason) == 0 {
+		return nil, mw.BadRequest(fmt.Errorf("a reason is required"))
+	}
+
+	nodeID := mux.Vars(r)["node_id"]
+
+	if err := s.SetApproval(r.Context(), nodeID, certifier, approved, input.Reason); err != nil {
 		return nil, mw.Error(err)
 	}
 
+	log.Info().
+		Str("node", nodeID).
+		Int64("certifier", certifier).
+		Bool("approved", approved).
+		Str("reason", input.Reason).
+		Msg("node certification changed")
+
 	return nil, mw.Ok()
 }
 
@@ -216,11 +284,6 @@ func (s *NodeAPI) registerPorts(r *http.Request) (interface{}, mw.Response) {
 	defer r.Body.Close()
 
 	nodeID := mux.Vars(r)["node_id"]
-	hNodeID := httpsig.KeyIDFromContext(r.Context())
-	if nodeID != hNodeID {
-		return nil, mw.Forbidden(fmt.Errorf("trying to register ports for nodeID %s while you are %s", nodeID, hNodeID))
-	}
-
 	input := struct {
 		Ports []uint `json:"ports"`
 	}{}
@@ -230,8 +293,7 @@ func (s *NodeAPI) registerPorts(r *http.Request) (interface{}, mw.Response) {
 
 	log.Debug().Uints("ports", input.Ports).Msg("wireguard ports received")
 
-	db := mw.Database(r)
-	if err := s.SetWGPorts(r.Context(), db, nodeID, input.Ports); err != nil {
+	if err := s.SetWGPorts(r.Context(), nodeID, input.Ports); err != nil {
 		return nil, mw.NotFound(err)
 	}
 
@@ -242,11 +304,6 @@ func (s *NodeAPI) updateUptimeHandler(r *http.Request) (interface{}, mw.Response
 	defer r.Body.Close()
 
 	nodeID := mux.Vars(r)["node_id"]
-	hNodeID := httpsig.KeyIDFromContext(r.Context())
-	if nodeID != hNodeID {
-		return nil, mw.Forbidden(fmt.Errorf("trying to register uptime for nodeID %s while you are %s", nodeID, hNodeID))
-	}
-
 	input := struct {
 		Uptime uint64 `json:"uptime"`
 	}{}
@@ -254,10 +311,9 @@ func (s *NodeAPI) updateUptimeHandler(r *http.Request) (interface{}, mw.Response
 		return nil, mw.BadRequest(err)
 	}
 
-	db := mw.Database(r)
 	log.Debug().Str("node", nodeID).Uint64("uptime", input.Uptime).Msg("node uptime received")
 
-	if err := s.updateUptime(r.Context(), db, nodeID, int64(input.Uptime)); err != nil {
+	if err := s.updateUptime(r.Context(), nodeID, int64(input.Uptime)); err != nil {
 		return nil, mw.NotFound(err)
 	}
 
@@ -268,11 +324,6 @@ func (s *NodeAPI) updateReservedResources(r *http.Request) (interface{}, mw.Resp
 	defer r.Body.Close()
 
 	nodeID := mux.Vars(r)["node_id"]
-	hNodeID := httpsig.KeyIDFromContext(r.Context())
-	if nodeID != hNodeID {
-		return nil, mw.Forbidden(fmt.Errorf("trying to update reserved capacity for nodeID %s while you are %s", nodeID, hNodeID))
-	}
-
 	input := struct {
 		generated.ResourceAmount
 		generated.WorkloadAmount
@@ -282,41 +333,51 @@ func (s *NodeAPI) updateReservedResources(r *http.Request) (interface{}, mw.Resp
 		return nil, mw.BadRequest(err)
 	}
 
-	db := mw.Database(r)
-	if err := s.updateReservedCapacity(r.Context(), db, nodeID, input.ResourceAmount); err != nil {
+	if err := s.updateReservedCapacity(r.Context(), nodeID, input.ResourceAmount); err != nil {
 		return nil, mw.NotFound(err)
 	}
-	if err := s.updateWorkloadsAmount(r.Context(), db, nodeID, input.WorkloadAmount); err != nil {
+	if err := s.updateWorkloadsAmount(r.Context(), nodeID, input.WorkloadAmount); err != nil {
 		return nil, mw.NotFound(err)
 	}
 
+	s.recordCapacity(r.Context(), nodeID)
+
 	return nil, nil
 }
 
-func farmOwner(ctx context.Context, farmID int64, db *mongo.Database) (int64, mw.Response) {
-	ff := types.FarmFilter{}
-	ff = ff.WithID(schema.ID(farmID))
+func (s *NodeAPI) capacityHistory(r *http.Request) (interface{}, mw.Response) {
+	q := historyQuery{}
+	if err := q.Parse(r); err != nil {
+		return nil, err
+	}
 
-	farm, err := ff.Get(ctx, db)
+	nodeID := mux.Vars(r)["node_id"]
+	points, err := s.CapacityHistory(r.Context(), nodeID, q)
 	if err != nil {
-		return 0, mw.Error(err) //TODO
+		return nil, mw.Error(err)
 	}
 
-	return farm.ThreebotId, nil
+	return points, nil
 }
 
-// isFarmerAuthorized ensure it is the farmer authenticated in request r is owning the node
-func isFarmerAuthorized(r *http.Request, node directory.Node, db *mongo.Database) (bool, mw.Response) {
-	actualFarmerID, merr := farmOwner(r.Context(), node.FarmId, db)
-	if merr != nil {
-		return false, merr
+// farmer implements mw.FarmerResolver for the nodes
+func (s *NodeAPI) farmer(ctx context.Context, nodeID string, tid int64) (bool, error) {
+	node, err := s.Get(ctx, nodeID, false)
+	if err != nil {
+		return false, err
 	}
 
-	sfarmerID := httpsig.KeyIDFromContext(r.Context())
-	requestFarmerID, err := strconv.ParseInt(sfarmerID, 10, 64)
-	if err != nil {
-		return false, mw.BadRequest(err)
+	return isFarmAdmin(ctx, s.farms, node.FarmId, tid)
+}
+
+// isFarmAdmin checks if tid is an admin (owner or operator) of the farm farmID
+func isFarmAdmin(ctx context.Context, farms directory.FarmRepository, farmID int64, tid int64) (bool, error) {
+	farm, err := farms.Get(ctx, schema.ID(farmID))
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false, nil
+	} else if err != nil {
+		return false, err
 	}
-	log.Debug().Int64("actualFarmerID", actualFarmerID).Int64("requestFarmID", requestFarmerID).Send()
-	return (requestFarmerID == actualFarmerID), nil
+
+	return farm.IsAdmin(tid), nil
 }
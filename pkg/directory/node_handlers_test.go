@@ -0,0 +1,174 @@
+package directory
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jbenet/go-base58"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	generated "github.com/threefoldtech/tfexplorer/models/generated/directory"
+	workloadsgen "github.com/threefoldtech/tfexplorer/models/generated/workloads"
+	"github.com/threefoldtech/tfexplorer/mw"
+	directory "github.com/threefoldtech/tfexplorer/pkg/directory/types"
+	workloads "github.com/threefoldtech/tfexplorer/pkg/workloads/types"
+)
+
+func newTestNodeAPI(t *testing.T) (*NodeAPI, int64) {
+	farms := directory.NewMemoryFarmRepository()
+	farmID, err := farms.Create(context.Background(), directory.Farm{
+		Name:            "farm",
+		ThreebotId:      1,
+		WalletAddresses: []generated.WalletAddress{{Asset: "TFT", Address: "address"}},
+	})
+	require.NoError(t, err)
+
+	return &NodeAPI{
+		nodes:        directory.NewMemoryNodeRepository(farms),
+		farms:        farms,
+		reservations: workloads.NewMemoryReservationRepository(),
+		history:      directory.NewMemoryCapacityRepository(),
+	}, int64(farmID)
+}
+
+func newTestNode(t *testing.T, farmID int64) directory.Node {
+	pk, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	return directory.Node{
+		NodeId:       base58.Encode(pk),
+		FarmId:       farmID,
+		OsVersion:    "v1",
+		PublicKeyHex: hex.EncodeToString(pk),
+		Location:     generated.Location{Country: "Belgium", City: "Ghent"},
+	}
+}
+
+func nodeRequest(t *testing.T, method, nodeID string, body interface{}) *http.Request {
+	var buf bytes.Buffer
+	if body != nil {
+		require.NoError(t, json.NewEncoder(&buf).Encode(body))
+	}
+
+	r := httptest.NewRequest(method, "/nodes/"+nodeID, &buf)
+	return mux.SetURLVars(r, map[string]string{"node_id": nodeID})
+}
+
+func TestRegisterNode(t *testing.T) {
+	api, farmID := newTestNodeAPI(t)
+	node := newTestNode(t, farmID)
+
+	_, resp := api.registerNode(nodeRequest(t, http.MethodPost, "", node))
+	require.NotNil(t, resp)
+	require.Equal(t, http.StatusCreated, resp.Status())
+
+	result, resp := api.nodeDetail(nodeRequest(t, http.MethodGet, node.NodeId, nil))
+	require.Nil(t, resp)
+	assert.Equal(t, farmID, result.(directory.Node).FarmId)
+
+	_, resp = api.updateUptimeHandler(nodeRequest(t, http.MethodPost, node.NodeId, map[string]uint64{"uptime": 42}))
+	require.Nil(t, resp)
+
+	stored, err := api.Get(context.Background(), node.NodeId, false)
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), stored.Uptime)
+
+	unknown := newTestNode(t, farmID+1)
+	_, resp = api.registerNode(nodeRequest(t, http.MethodPost, "", unknown))
+	require.NotNil(t, resp)
+	assert.Equal(t, http.StatusNotFound, resp.Status())
+
+	_, resp = api.nodeDetail(nodeRequest(t, http.MethodGet, unknown.NodeId, nil))
+	require.NotNil(t, resp)
+	assert.Equal(t, http.StatusNotFound, resp.Status())
+}
+
+func TestDecommissionNode(t *testing.T) {
+	api, farmID := newTestNodeAPI(t)
+	node := newTestNode(t, farmID)
+
+	_, err := api.Add(context.Background(), node)
+	require.NoError(t, err)
+
+	var reservation workloads.Reservation
+	reservation.NextAction = workloads.Deploy
+	reservation.DataReservation.Volumes = []workloadsgen.Volume{{WorkloadId: 1, NodeId: node.NodeId}}
+	id, err := api.reservations.Create(context.Background(), reservation)
+	require.NoError(t, err)
+
+	_, resp := api.decommission(nodeRequest(t, http.MethodDelete, node.NodeId, nil))
+	require.NotNil(t, resp)
+	require.Equal(t, http.StatusOK, resp.Status())
+
+	stored, err := api.Get(context.Background(), node.NodeId, false)
+	require.NoError(t, err)
+	assert.True(t, stored.Retired)
+
+	reservation, err = api.reservations.Get(context.Background(), id)
+	require.NoError(t, err)
+	assert.Equal(t, []string{node.NodeId}, reservation.RetiredNodes)
+
+	_, resp = api.decommission(nodeRequest(t, http.MethodDelete, node.NodeId, nil))
+	require.NotNil(t, resp)
+	assert.Equal(t, http.StatusConflict, resp.Status())
+}
+
+func TestListNodes(t *testing.T) {
+	api, farmID := newTestNodeAPI(t)
+
+	other, err := api.farms.Create(context.Background(), directory.Farm{
+		Name:            "other",
+		ThreebotId:      2,
+		WalletAddresses: []generated.WalletAddress{{Asset: "TFT", Address: "address"}},
+	})
+	require.NoError(t, err)
+
+	for _, farm := range []int64{farmID, farmID, int64(other)} {
+		_, err := api.Add(context.Background(), newTestNode(t, farm))
+		require.NoError(t, err)
+	}
+
+	list := func(query string) ([]directory.Node, mw.Response) {
+		result, resp := api.listNodes(httptest.NewRequest(http.MethodGet, "/nodes?"+query, nil))
+		require.NotNil(t, resp)
+		require.Equal(t, http.StatusOK, resp.Status())
+		return result.([]directory.Node), resp
+	}
+
+	nodes, _ := list(fmt.Sprintf("farm=%d", farmID))
+	assert.Len(t, nodes, 2)
+
+	nodes, resp := list("page=1&size=2")
+	assert.Len(t, nodes, 2)
+	assert.Equal(t, "2", resp.Header().Get("Pages"))
+}
+
+func TestNodeCapacityHistory(t *testing.T) {
+	api, farmID := newTestNodeAPI(t)
+	node := newTestNode(t, farmID)
+
+	_, err := api.Add(context.Background(), node)
+	require.NoError(t, err)
+
+	for _, cru := range []uint64{2, 4} {
+		require.NoError(t, api.updateTotalCapacity(context.Background(), node.NodeId, generated.ResourceAmount{Cru: cru}))
+		api.recordCapacity(context.Background(), node.NodeId)
+	}
+
+	r := nodeRequest(t, http.MethodGet, node.NodeId, nil)
+	r.URL.RawQuery = "step=86400"
+	result, resp := api.capacityHistory(r)
+	require.Nil(t, resp)
+
+	points := result.([]directory.CapacityPoint)
+	require.Len(t, points, 1)
+	assert.Equal(t, float64(3), points[0].Total.Cru)
+}
@@ -2,195 +2,248 @@ package directory
 
 import (
 	"context"
-	"crypto/md5"
-	"encoding/json"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"net/http"
-	"sort"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
 	"github.com/threefoldtech/tfexplorer/models"
 	generated "github.com/threefoldtech/tfexplorer/models/generated/directory"
 	"github.com/threefoldtech/tfexplorer/mw"
+	"github.com/threefoldtech/tfexplorer/pkg/apierror"
 	directory "github.com/threefoldtech/tfexplorer/pkg/directory/types"
+	workloads "github.com/threefoldtech/tfexplorer/pkg/workloads/types"
 	"github.com/threefoldtech/tfexplorer/schema"
 	"github.com/threefoldtech/zos/pkg/capacity"
 	"github.com/threefoldtech/zos/pkg/capacity/dmi"
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // NodeAPI holds api for nodes
-type NodeAPI struct{}
+type NodeAPI struct {
+	nodes        directory.NodeRepository
+	farms        directory.FarmRepository
+	reservations workloads.ReservationRepository
+	history      directory.CapacityRepository
+}
 
 type nodeQuery struct {
-	FarmID  int64
-	Country string
-	City    string
-	CRU     int64
-	MRU     int64
-	SRU     int64
-	HRU     int64
-	Proofs  bool
+	FarmID          int64
+	Country         string
+	City            string
+	CRU             int64
+	MRU             int64
+	SRU             int64
+	HRU             int64
+	Proofs          bool
+	Retired         bool
+	HardwareChanged bool
+	Approved        bool
+	Query           models.Query
 }
 
 func (n *nodeQuery) Parse(r *http.Request) mw.Response {
 	var err error
 	n.FarmID, err = models.QueryInt(r, "farm")
 	if err != nil {
-		return mw.BadRequest(errors.Wrap(err, "invalid farm id"))
+		return mw.BadRequest(apierror.FieldError("farm", errors.Wrap(err, "invalid farm id")))
 	}
 	n.Country = r.URL.Query().Get("country")
 	n.City = r.URL.Query().Get("city")
 	n.CRU, err = models.QueryInt(r, "cru")
 	if err != nil {
-		return mw.BadRequest(errors.Wrap(err, "invalid cru"))
+		return mw.BadRequest(apierror.FieldError("cru", errors.Wrap(err, "invalid cru")))
 	}
 	n.MRU, err = models.QueryInt(r, "mru")
 	if err != nil {
-		return mw.BadRequest(errors.Wrap(err, "invalid mru"))
+		return mw.BadRequest(apierror.FieldError("mru", errors.Wrap(err, "invalid mru")))
 	}
 	n.SRU, err = models.QueryInt(r, "sru")
 	if err != nil {
-		return mw.BadRequest(errors.Wrap(err, "invalid sru"))
+		return mw.BadRequest(apierror.FieldError("sru", errors.Wrap(err, "invalid sru")))
 	}
 	n.HRU, err = models.QueryInt(r, "hru")
 	if err != nil {
-		return mw.BadRequest(errors.Wrap(err, "invalid hru"))
+		return mw.BadRequest(apierror.FieldError("hru", errors.Wrap(err, "invalid hru")))
 	}
 	n.Proofs = r.URL.Query().Get("proofs") == "true"
+	n.Retired = r.URL.Query().Get("retired") == "true"
+	n.HardwareChanged = r.URL.Query().Get("hardware_changed") == "true"
+	n.Approved = r.URL.Query().Get("approved") == "true"
+
+	n.Query, err = models.ParseQuery(r, directory.NodeQueryFields)
+	if err != nil {
+		return mw.BadRequest(err)
+	}
 
 	return nil
 }
 
-// List farms
-// TODO: add paging arguments
-func (s *NodeAPI) List(ctx context.Context, db *mongo.Database, q nodeQuery, opts ...*options.FindOptions) ([]directory.Node, int64, error) {
+// List nodes, the count of nodes matching the query is only returned if the
+// page is selected by its number
+func (s *NodeAPI) List(ctx context.Context, q nodeQuery, pager models.Pager) ([]directory.Node, int64, error) {
 	var filter directory.NodeFilter
 	if q.FarmID > 0 {
 		filter = filter.WithFarmID(schema.ID(q.FarmID))
 	}
 	filter = filter.WithTotalCap(q.CRU, q.MRU, q.HRU, q.SRU)
 	filter = filter.WithLocation(q.Country, q.City)
+	filter = filter.WithRetired(q.Retired)
+	if q.HardwareChanged {
+		filter = filter.WithHardwareChanged(true)
+	}
+	if q.Approved {
+		filter = filter.WithApproved(true)
+	}
+
+	filter = filter.WithQuery(q.Query)
 
-	if !q.Proofs {
+	var opts []*options.FindOptions
+	if q.Query.Projected() {
+		opts = append(opts, q.Query.Projection())
+	} else if !q.Proofs {
 		projection := bson.D{
 			{Key: "proofs", Value: 0},
 		}
 		opts = append(opts, options.Find().SetProjection(projection))
 	}
 
-	cur, err := filter.Find(ctx, db, opts...)
+	nodes, total, err := s.nodes.List(ctx, filter, pager, opts...)
 	if err != nil {
 		return nil, 0, errors.Wrap(err, "failed to list nodes")
 	}
 
-	defer cur.Close(ctx)
-	out := []directory.Node{}
-	if err := cur.All(ctx, &out); err != nil {
-		return nil, 0, errors.Wrap(err, "failed to load node list")
-	}
-
-	count, err := filter.Count(ctx, db)
-	if err != nil {
-		return nil, 0, errors.Wrap(err, "failed to count entries in nodes collection")
-	}
-
-	return out, count, nil
+	return nodes, total, nil
 }
 
 // Get a single node
-func (s *NodeAPI) Get(ctx context.Context, db *mongo.Database, nodeID string, includeProofs bool) (directory.Node, error) {
-	var filter directory.NodeFilter
-	filter = filter.WithNodeID(nodeID)
-	return filter.Get(ctx, db, includeProofs)
+func (s *NodeAPI) Get(ctx context.Context, nodeID string, includeProofs bool) (directory.Node, error) {
+	return s.nodes.Get(ctx, nodeID, includeProofs)
 }
 
 // Exists tests if node exists
-func (s *NodeAPI) Exists(ctx context.Context, db *mongo.Database, nodeID string) (bool, error) {
-	var filter directory.NodeFilter
-	filter = filter.WithNodeID(nodeID)
-
-	count, err := filter.Count(ctx, db)
-	if err != nil {
-		return false, err
-	}
-
-	return count > 0, nil
+func (s *NodeAPI) Exists(ctx context.Context, nodeID string) (bool, error) {
+	return s.nodes.Exists(ctx, nodeID)
 }
 
 // Count counts the number of document in the collection
-func (s *NodeAPI) Count(ctx context.Context, db *mongo.Database, filter directory.NodeFilter) (int64, error) {
-	return filter.Count(ctx, db)
+func (s *NodeAPI) Count(ctx context.Context, filter directory.NodeFilter) (int64, error) {
+	return s.nodes.Count(ctx, filter)
 }
 
 // Add a node to the store
-func (s *NodeAPI) Add(ctx context.Context, db *mongo.Database, node directory.Node) (schema.ID, error) {
-	return directory.NodeCreate(ctx, db, node)
+func (s *NodeAPI) Add(ctx context.Context, node directory.Node) (schema.ID, error) {
+	return s.nodes.Create(ctx, node)
 }
 
-func (s *NodeAPI) updateTotalCapacity(ctx context.Context, db *mongo.Database, nodeID string, capacity generated.ResourceAmount) error {
-	return directory.NodeUpdateTotalResources(ctx, db, nodeID, capacity)
+func (s *NodeAPI) updateTotalCapacity(ctx context.Context, nodeID string, capacity generated.ResourceAmount) error {
+	return s.nodes.SetTotalResources(ctx, nodeID, capacity)
 }
 
-func (s *NodeAPI) updateReservedCapacity(ctx context.Context, db *mongo.Database, nodeID string, capacity generated.ResourceAmount) error {
-	return directory.NodeUpdateReservedResources(ctx, db, nodeID, capacity)
+func (s *NodeAPI) updateReservedCapacity(ctx context.Context, nodeID string, capacity generated.ResourceAmount) error {
+	return s.nodes.SetReservedResources(ctx, nodeID, capacity)
 }
 
-func (s *NodeAPI) updateUptime(ctx context.Context, db *mongo.Database, nodeID string, uptime int64) error {
-	return directory.NodeUpdateUptime(ctx, db, nodeID, uptime)
+func (s *NodeAPI) updateUptime(ctx context.Context, nodeID string, uptime int64) error {
+	return s.nodes.SetUptime(ctx, nodeID, uptime)
 }
 
-func (s *NodeAPI) updateFreeToUse(ctx context.Context, db *mongo.Database, nodeID string, freeToUse bool) error {
-	return directory.NodeUpdateFreeToUse(ctx, db, nodeID, freeToUse)
+func (s *NodeAPI) updateFreeToUse(ctx context.Context, nodeID string, freeToUse bool) error {
+	return s.nodes.SetFreeToUse(ctx, nodeID, freeToUse)
 }
 
-func (s *NodeAPI) updateWorkloadsAmount(ctx context.Context, db *mongo.Database, nodeID string, workloads generated.WorkloadAmount) error {
-	return directory.NodeUpdateWorkloadsAmount(ctx, db, nodeID, workloads)
+func (s *NodeAPI) updateWorkloadsAmount(ctx context.Context, nodeID string, workloads generated.WorkloadAmount) error {
+	return s.nodes.SetWorkloadsAmount(ctx, nodeID, workloads)
 }
 
-// StoreProof stores node hardware proof
-func (s *NodeAPI) StoreProof(ctx context.Context, db *mongo.Database, nodeID string, dmi dmi.DMI, disks capacity.Disks, hypervisor []string) error {
-	var err error
-	proof := generated.Proof{
-		Created:    schema.Date{Time: time.Now()},
-		Hypervisor: hypervisor,
+// Retire marks the node as decommissioned and flags all the active reservations using it
+func (s *NodeAPI) Retire(ctx context.Context, nodeID string) error {
+	if err := s.nodes.SetRetired(ctx, nodeID); err != nil {
+		return errors.Wrap(err, "failed to mark node as retired")
 	}
 
-	proof.Hardware = map[string]interface{}{
-		"sections": dmi.Sections,
-		"tooling":  dmi.Tooling,
+	flagged, err := s.reservations.FlagRetiredNode(ctx, nodeID)
+	if err != nil {
+		return errors.Wrap(err, "failed to flag reservations using the node")
 	}
-	proof.HardwareHash, err = hashProof(proof.Hardware)
+
+	log.Info().Str("node", nodeID).Int64("reservations", flagged).Msg("node decommissioned")
+	return nil
+}
+
+// StoreProof stores node hardware proof
+func (s *NodeAPI) StoreProof(ctx context.Context, nodeID string, dmi dmi.DMI, disks capacity.Disks, hypervisor []string) error {
+	proof, err := newProof(dmi, disks, hypervisor)
 	if err != nil {
 		return err
 	}
 
-	proof.Disks = map[string]interface{}{
-		"aggregator":  disks.Aggregator,
-		"environment": disks.Environment,
-		"devices":     disks.Devices,
-		"tool":        disks.Tool,
+	node, err := s.Get(ctx, nodeID, true)
+	if err != nil {
+		return err
+	}
+
+	last, ok := lastProof(node.Proofs)
+	if !ok {
+		return s.nodes.PushProof(ctx, nodeID, proof)
 	}
-	proof.DiskHash, err = hashProof(proof.Disks)
+
+	changed, err := proofChanged(last, proof)
 	if err != nil {
 		return err
 	}
 
-	return directory.NodePushProof(ctx, db, nodeID, proof)
+	if !changed {
+		// same hardware as last time, no need to keep a copy of it
+		return nil
+	}
+
+	if err := s.nodes.PushProof(ctx, nodeID, proof); err != nil {
+		return err
+	}
+
+	log.Warn().Str("node", nodeID).Msg("node hardware changed since last proof")
+	return s.nodes.SetHardwareChanged(ctx, nodeID, true)
+}
+
+// AcknowledgeHardwareChange clears the hardware changed flag of the node
+func (s *NodeAPI) AcknowledgeHardwareChange(ctx context.Context, nodeID string) error {
+	return s.nodes.SetHardwareChanged(ctx, nodeID, false)
+}
+
+// SetApproval approves or revokes a node on behalf of certifier
+func (s *NodeAPI) SetApproval(ctx context.Context, nodeID string, certifier int64, approved bool, reason string) error {
+	return s.nodes.SetApproval(ctx, nodeID, generated.NodeApproval{
+		Certifier: certifier,
+		Approved:  approved,
+		Reason:    reason,
+		Timestamp: schema.Date{Time: time.Now()},
+	})
+}
+
+// ProofsDiff returns the changes between all the successive proofs of a node
+func (s *NodeAPI) ProofsDiff(ctx context.Context, nodeID string) ([]ProofDiff, error) {
+	node, err := s.Get(ctx, nodeID, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return diffProofs(node.Proofs)
 }
 
 // SetInterfaces updates node interfaces
-func (s *NodeAPI) SetInterfaces(ctx context.Context, db *mongo.Database, nodeID string, ifaces []generated.Iface) error {
-	return directory.NodeSetInterfaces(ctx, db, nodeID, ifaces)
+func (s *NodeAPI) SetInterfaces(ctx context.Context, nodeID string, ifaces []generated.Iface) error {
+	return s.nodes.SetInterfaces(ctx, nodeID, ifaces)
 }
 
 // SetPublicConfig sets node public config
-func (s *NodeAPI) SetPublicConfig(ctx context.Context, db *mongo.Database, nodeID string, cfg generated.PublicIface) error {
-	node, err := s.Get(ctx, db, nodeID, false)
+func (s *NodeAPI) SetPublicConfig(ctx context.Context, nodeID string, cfg generated.PublicIface) error {
+	node, err := s.Get(ctx, nodeID, false)
 	if err != nil {
 		return err
 	}
@@ -201,12 +254,12 @@ func (s *NodeAPI) SetPublicConfig(ctx context.Context, db *mongo.Database, nodeI
 		cfg.Version = node.PublicConfig.Version + 1
 	}
 
-	return directory.NodeSetPublicConfig(ctx, db, nodeID, cfg)
+	return s.nodes.SetPublicConfig(ctx, nodeID, cfg)
 }
 
 // SetWGPorts sets node gateway ports
-func (s *NodeAPI) SetWGPorts(ctx context.Context, db *mongo.Database, nodeID string, ports []uint) error {
-	return directory.NodeSetWGPorts(ctx, db, nodeID, ports)
+func (s *NodeAPI) SetWGPorts(ctx context.Context, nodeID string, ports []uint) error {
+	return s.nodes.SetWGPorts(ctx, nodeID, ports)
 }
 
 // Requires is a wrapper that makes sure node with that case exists before
@@ -219,9 +272,7 @@ func (s *NodeAPI) Requires(key string, handler mw.Action) mw.Action {
 			panic("invalid node-id key")
 		}
 
-		db := mw.Database(r)
-
-		exists, err := s.Exists(r.Context(), db, nodeID)
+		exists, err := s.Exists(r.Context(), nodeID)
 		if err != nil {
 			return nil, mw.Error(err)
 		} else if !exists {
@@ -232,29 +283,13 @@ func (s *NodeAPI) Requires(key string, handler mw.Action) mw.Action {
 	}
 }
 
-// hashProof return the hex encoded md5 hash of the json encoded version of p
+// hashProof return the hex encoded sha256 hash of the canonical json encoding of p
 func hashProof(p map[string]interface{}) (string, error) {
-
-	// we are trying to have always produce same hash for same content of p
-	// so we convert the map into a list so we can sort
-	// the key and workaround the fact that maps are not sorted
-
-	type kv struct {
-		k string
-		v interface{}
-	}
-
-	kvs := make([]kv, len(p))
-	for k, v := range p {
-		kvs = append(kvs, kv{k: k, v: v})
-	}
-	sort.Slice(kvs, func(i, j int) bool { return kvs[i].k < kvs[j].k })
-
-	b, err := json.Marshal(kvs)
+	b, err := canonicalJSON(p)
 	if err != nil {
 		return "", err
 	}
-	h := md5.New()
-	bh := h.Sum(b)
-	return fmt.Sprintf("%x", bh), nil
+
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:]), nil
 }
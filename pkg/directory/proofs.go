@@ -0,0 +1,235 @@
+package directory
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	generated "github.com/threefoldtech/tfexplorer/models/generated/directory"
+	"github.com/threefoldtech/tfexplorer/schema"
+	"github.com/threefoldtech/zos/pkg/capacity"
+	"github.com/threefoldtech/zos/pkg/capacity/dmi"
+)
+
+// ProofChange is a single value that differs between two proofs
+type ProofChange struct {
+	Path string      `json:"path"`
+	Old  interface{} `json:"old"`
+	New  interface{} `json:"new"`
+}
+
+// ProofDiff holds the changes between two successive proofs of a node
+type ProofDiff struct {
+	From       schema.Date   `json:"from"`
+	To         schema.Date   `json:"to"`
+	Hardware   []ProofChange `json:"hardware"`
+	Disks      []ProofChange `json:"disks"`
+	Hypervisor []ProofChange `json:"hypervisor"`
+}
+
+// newProof builds a hashed proof from the hardware report sent with the capacity
+func newProof(dmi dmi.DMI, disks capacity.Disks, hypervisor []string) (generated.Proof, error) {
+	var err error
+	proof := generated.Proof{
+		Created:    schema.Date{Time: time.Now()},
+		Hypervisor: hypervisor,
+	}
+
+	proof.Hardware = map[string]interface{}{
+		"sections": dmi.Sections,
+		"tooling":  dmi.Tooling,
+	}
+	proof.HardwareHash, err = hashProof(proof.Hardware)
+	if err != nil {
+		return proof, err
+	}
+
+	proof.Disks = map[string]interface{}{
+		"aggregator":  disks.Aggregator,
+		"environment": disks.Environment,
+		"devices":     disks.Devices,
+		"tool":        disks.Tool,
+	}
+	proof.DiskHash, err = hashProof(proof.Disks)
+	if err != nil {
+		return proof, err
+	}
+
+	return proof, nil
+}
+
+// canonicalJSON encodes v so that the same content always gives the same bytes
+// no matter if it comes from the zos structures or was decoded from the database.
+// v is first turned into plain maps and slices, for which encoding/json sorts the keys
+func canonicalJSON(v interface{}) ([]byte, error) {
+	plain, err := normalize(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(plain)
+}
+
+// normalize converts v into the generic json representation
+// (map[string]interface{}, []interface{}, float64, string, bool or nil)
+func normalize(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var out interface{}
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// lastProof returns the most recent proof
+func lastProof(proofs []generated.Proof) (generated.Proof, bool) {
+	if len(proofs) == 0 {
+		return generated.Proof{}, false
+	}
+
+	last := proofs[0]
+	for _, proof := range proofs[1:] {
+		if !proof.Created.Before(last.Created.Time) {
+			last = proof
+		}
+	}
+
+	return last, true
+}
+
+// proofChanged checks if the hardware or disks of proof b are different from a
+func proofChanged(a, b generated.Proof) (bool, error) {
+	var err error
+	// proofs stored before the hashing was made canonical carry
+	// a different kind of hash, so compute it again from the content
+	if len(a.HardwareHash) != len(b.HardwareHash) {
+		if a.HardwareHash, err = hashProof(a.Hardware); err != nil {
+			return false, err
+		}
+	}
+	if len(a.DiskHash) != len(b.DiskHash) {
+		if a.DiskHash, err = hashProof(a.Disks); err != nil {
+			return false, err
+		}
+	}
+
+	return a.HardwareHash != b.HardwareHash || a.DiskHash != b.DiskHash, nil
+}
+
+// diffProofs sorts the proofs by creation date and returns the changes between
+// each of them and the one before
+func diffProofs(proofs []generated.Proof) ([]ProofDiff, error) {
+	sorted := make([]generated.Proof, len(proofs))
+	copy(sorted, proofs)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Created.Before(sorted[j].Created.Time)
+	})
+
+	diffs := []ProofDiff{}
+	for i := 1; i < len(sorted); i++ {
+		prev, next := sorted[i-1], sorted[i]
+		diff := ProofDiff{
+			From: prev.Created,
+			To:   next.Created,
+		}
+
+		var err error
+		if diff.Hardware, err = diffContent(prev.Hardware, next.Hardware); err != nil {
+			return nil, err
+		}
+		if diff.Disks, err = diffContent(prev.Disks, next.Disks); err != nil {
+			return nil, err
+		}
+		if diff.Hypervisor, err = diffContent(prev.Hypervisor, next.Hypervisor); err != nil {
+			return nil, err
+		}
+
+		diffs = append(diffs, diff)
+	}
+
+	return diffs, nil
+}
+
+func diffContent(a, b interface{}) ([]ProofChange, error) {
+	na, err := normalize(a)
+	if err != nil {
+		return nil, err
+	}
+	nb, err := normalize(b)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []ProofChange{}
+	diffValue("", na, nb, &changes)
+	return changes, nil
+}
+
+// diffValue walks a and b recursively and appends to changes every
+// leaf that differs, with the path leading to it
+func diffValue(path string, a, b interface{}, changes *[]ProofChange) {
+	switch va := a.(type) {
+	case map[string]interface{}:
+		vb, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+
+		keys := make(map[string]struct{}, len(va)+len(vb))
+		for k := range va {
+			keys[k] = struct{}{}
+		}
+		for k := range vb {
+			keys[k] = struct{}{}
+		}
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+
+		for _, k := range sorted {
+			diffValue(joinPath(path, k), va[k], vb[k], changes)
+		}
+		return
+	case []interface{}:
+		vb, ok := b.([]interface{})
+		if !ok {
+			break
+		}
+
+		size := len(va)
+		if len(vb) > size {
+			size = len(vb)
+		}
+		for i := 0; i < size; i++ {
+			var ea, eb interface{}
+			if i < len(va) {
+				ea = va[i]
+			}
+			if i < len(vb) {
+				eb = vb[i]
+			}
+			diffValue(joinPath(path, fmt.Sprint(i)), ea, eb, changes)
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(a, b) {
+		*changes = append(*changes, ProofChange{Path: path, Old: a, New: b})
+	}
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
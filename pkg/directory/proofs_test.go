@@ -0,0 +1,83 @@
+package directory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	generated "github.com/threefoldtech/tfexplorer/models/generated/directory"
+	"github.com/threefoldtech/tfexplorer/schema"
+)
+
+func TestHashProof(t *testing.T) {
+	type section struct {
+		Name  string `json:"name"`
+		Value int    `json:"value"`
+	}
+
+	a := map[string]interface{}{
+		"sections": []section{{Name: "cpu", Value: 4}},
+		"tooling":  "dmidecode",
+	}
+	// same content as a, but as it would be decoded from the database
+	b := map[string]interface{}{
+		"tooling": "dmidecode",
+		"sections": []interface{}{
+			map[string]interface{}{"value": 4, "name": "cpu"},
+		},
+	}
+	c := map[string]interface{}{
+		"sections": []section{{Name: "cpu", Value: 8}},
+		"tooling":  "dmidecode",
+	}
+
+	ha, err := hashProof(a)
+	require.NoError(t, err)
+	hb, err := hashProof(b)
+	require.NoError(t, err)
+	hc, err := hashProof(c)
+	require.NoError(t, err)
+
+	assert.Equal(t, ha, hb)
+	assert.NotEqual(t, ha, hc)
+	assert.Len(t, ha, 64)
+}
+
+func TestDiffProofs(t *testing.T) {
+	now := time.Now()
+	proofs := []generated.Proof{
+		{
+			Created: schema.Date{Time: now},
+			Disks: map[string]interface{}{
+				"devices": []interface{}{"sda", "sdb", "sdc"},
+			},
+			Hardware: map[string]interface{}{
+				"serial": "efgh",
+			},
+		},
+		{
+			Created: schema.Date{Time: now.Add(-time.Hour)},
+			Disks: map[string]interface{}{
+				"devices": []interface{}{"sda", "sdb"},
+			},
+			Hardware: map[string]interface{}{
+				"serial": "abcd",
+			},
+		},
+	}
+
+	diffs, err := diffProofs(proofs)
+	require.NoError(t, err)
+	require.Len(t, diffs, 1)
+
+	diff := diffs[0]
+	assert.True(t, diff.From.Before(diff.To.Time))
+	assert.Equal(t, []ProofChange{{Path: "serial", Old: "abcd", New: "efgh"}}, diff.Hardware)
+	assert.Equal(t, []ProofChange{{Path: "devices.2", Old: nil, New: "sdc"}}, diff.Disks)
+	assert.Empty(t, diff.Hypervisor)
+
+	last, ok := lastProof(proofs)
+	require.True(t, ok)
+	assert.Equal(t, proofs[0].Created, last.Created)
+}
@@ -4,61 +4,133 @@ import (
 	"context"
 
 	"github.com/gorilla/mux"
+	"github.com/threefoldtech/tfexplorer/config"
 	"github.com/threefoldtech/tfexplorer/mw"
 	directory "github.com/threefoldtech/tfexplorer/pkg/directory/types"
+	phonebook "github.com/threefoldtech/tfexplorer/pkg/phonebook/types"
+	workloads "github.com/threefoldtech/tfexplorer/pkg/workloads/types"
 	"github.com/zaibon/httpsig"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
-// Setup injects and initializes directory package
-func Setup(parent *mux.Router, db *mongo.Database) error {
+// Setup injects and initializes directory package, the handlers are built
+// once and mounted on all the parent routers
+func Setup(parents []*mux.Router, db *mongo.Database) error {
 	if err := directory.Setup(context.TODO(), db); err != nil {
 		return err
 	}
 
-	var farmAPI FarmAPI
-	farms := parent.PathPrefix("/farms").Subrouter()
-	farmsAuthenticated := parent.PathPrefix("/farms").Subrouter()
-	farmsAuthenticated.Use(mw.NewAuthMiddleware(httpsig.NewVerifier(mw.NewUserKeyGetter(db))).Middleware)
+	farmRepo := directory.NewFarmRepository(db)
+	userRepo := phonebook.NewUserRepository(db)
+	reservations := workloads.NewReservationRepository(db)
+	history := directory.NewCapacityRepository(db)
 
-	farms.HandleFunc("", mw.AsHandlerFunc(farmAPI.registerFarm)).Methods("POST").Name("farm-register")
-	farms.HandleFunc("", mw.AsHandlerFunc(farmAPI.listFarm)).Methods("GET").Name("farm-list")
-	farms.HandleFunc("/{farm_id}", mw.AsHandlerFunc(farmAPI.getFarm)).Methods("GET").Name("farm-get")
-	farmsAuthenticated.HandleFunc("/{farm_id}", mw.AsHandlerFunc(farmAPI.updateFarm)).Methods("PUT").Name("farm-update")
+	statsAPI := StatsAPI{stats: directory.NewStatsRepository(db), farms: farmRepo}
 
-	var nodeAPI NodeAPI
-	nodes := parent.PathPrefix("/nodes").Subrouter()
-	nodesAuthenticated := parent.PathPrefix("/nodes").Subrouter()
-	userAuthenticated := parent.PathPrefix("/nodes").Subrouter()
+	farmAPI := FarmAPI{
+		farms:      farmRepo,
+		users:      userRepo,
+		challenges: directory.NewWalletChallengeRepository(db),
+		history:    history,
+	}
 
-	nodeAuthMW := mw.NewAuthMiddleware(httpsig.NewVerifier(mw.NewNodeKeyGetter()))
-	userAuthMW := mw.NewAuthMiddleware(httpsig.NewVerifier(mw.NewUserKeyGetter(db)))
-
-	userAuthenticated.Use(userAuthMW.Middleware)
-	nodesAuthenticated.Use(nodeAuthMW.Middleware)
-
-	nodes.HandleFunc("", mw.AsHandlerFunc(nodeAPI.registerNode)).Methods("POST").Name("node-register")
-	nodes.HandleFunc("", mw.AsHandlerFunc(nodeAPI.listNodes)).Methods("GET").Name("nodes-list")
-	nodes.HandleFunc("/{node_id}", mw.AsHandlerFunc(nodeAPI.nodeDetail)).Methods("GET").Name(("node-get"))
-	nodesAuthenticated.HandleFunc("/{node_id}/interfaces", mw.AsHandlerFunc(nodeAPI.Requires("node_id", nodeAPI.registerIfaces))).Methods("POST").Name("node-interfaces")
-	nodesAuthenticated.HandleFunc("/{node_id}/ports", mw.AsHandlerFunc(nodeAPI.Requires("node_id", nodeAPI.registerPorts))).Methods("POST").Name("node-set-ports")
-	userAuthenticated.HandleFunc("/{node_id}/configure_public", mw.AsHandlerFunc(nodeAPI.Requires("node_id", nodeAPI.configurePublic))).Methods("POST").Name("node-configure-public")
-	userAuthenticated.HandleFunc("/{node_id}/configure_free", mw.AsHandlerFunc(nodeAPI.Requires("node_id", nodeAPI.configureFreeToUse))).Methods("POST").Name("node-configure-free")
-	nodesAuthenticated.HandleFunc("/{node_id}/capacity", mw.AsHandlerFunc(nodeAPI.Requires("node_id", nodeAPI.registerCapacity))).Methods("POST").Name("node-capacity")
-	nodesAuthenticated.HandleFunc("/{node_id}/uptime", mw.AsHandlerFunc(nodeAPI.Requires("node_id", nodeAPI.updateUptimeHandler))).Methods("POST").Name("node-uptime")
-	nodesAuthenticated.HandleFunc("/{node_id}/used_resources", mw.AsHandlerFunc(nodeAPI.Requires("node_id", nodeAPI.updateReservedResources))).Methods("POST").Name("node-reserved-resources")
-
-	var gwAPI GatewayAPI
-	gw := parent.PathPrefix("/gateways").Subrouter()
-	gwAuthenticated := parent.PathPrefix("/gateways").Subrouter()
-	gwAuthMW := mw.NewAuthMiddleware(httpsig.NewVerifier(mw.NewNodeKeyGetter()))
-	gwAuthenticated.Use(gwAuthMW.Middleware)
-
-	gw.HandleFunc("", mw.AsHandlerFunc(gwAPI.registerGateway)).Methods("POST").Name("gateway-register")
-	gw.HandleFunc("", mw.AsHandlerFunc(gwAPI.listGateways)).Methods("GET").Name("gateway-list")
-	gw.HandleFunc("/{node_id}", mw.AsHandlerFunc(gwAPI.gatewayDetail)).Methods("GET").Name(("gateway-get"))
-	gwAuthenticated.HandleFunc("/{node_id}/uptime", mw.AsHandlerFunc(gwAPI.Requires("node_id", gwAPI.updateUptimeHandler))).Methods("POST").Name("gateway-uptime")
-	gwAuthenticated.HandleFunc("/{node_id}/reserved_resources", mw.AsHandlerFunc(gwAPI.Requires("node_id", gwAPI.updateReservedResources))).Methods("POST").Name("gateway-reserved-resources")
+	nodeAPI := NodeAPI{
+		nodes:        directory.NewNodeRepository(db),
+		farms:        farmRepo,
+		reservations: reservations,
+		history:      history,
+	}
 
+	gwAPI := GatewayAPI{gateways: directory.NewGatewayRepository(db), farms: farmRepo, reservations: reservations}
+
+	mount(parents, &statsAPI, &farmAPI, &nodeAPI, &gwAPI)
 	return nil
 }
+
+// mount registers the routes of the apis on all the parent routers
+func mount(parents []*mux.Router, statsAPI *StatsAPI, farmAPI *FarmAPI, nodeAPI *NodeAPI, gwAPI *GatewayAPI) {
+	userAuthMW := mw.NewAuthMiddleware(httpsig.NewVerifier(mw.NewUserKeyGetter(farmAPI.users)))
+	nodeAuthMW := mw.NewAuthMiddleware(httpsig.NewVerifier(mw.NewNodeKeyGetter()))
+
+	farmPolicy := mw.NewPolicy(config.Config.Admins, nil).WithFarms(farmAPI.roles)
+	nodePolicy := mw.NewPolicy(config.Config.Admins, nodeAPI.farmer)
+	gwPolicy := mw.NewPolicy(config.Config.Admins, gwAPI.farmer)
+
+	for _, parent := range parents {
+		stats := parent.PathPrefix("/stats").Subrouter()
+
+		stats.HandleFunc("", mw.AsHandlerFunc(statsAPI.gridStats)).Methods("GET").Name("stats-grid")
+		stats.HandleFunc("/farms", mw.AsHandlerFunc(statsAPI.farmsStats)).Methods("GET").Name("stats-farms")
+		stats.HandleFunc("/countries", mw.AsHandlerFunc(statsAPI.countriesStats)).Methods("GET").Name("stats-countries")
+
+		farms := parent.PathPrefix("/farms").Subrouter()
+		farmOwner := parent.PathPrefix("/farms").Subrouter()
+		farmAdmin := parent.PathPrefix("/farms").Subrouter()
+		farmOwnerOrSelf := parent.PathPrefix("/farms").Subrouter()
+		farmPendingOwner := parent.PathPrefix("/farms").Subrouter()
+		farmOwner.Use(userAuthMW.Middleware, farmPolicy.Require(mw.RoleFarmOwner))
+		farmAdmin.Use(userAuthMW.Middleware, farmPolicy.Require(mw.RoleFarmAdmin))
+		// admins are allowed to remove themselves from the farm
+		farmOwnerOrSelf.Use(userAuthMW.Middleware, farmPolicy.Require(mw.RoleFarmOwner, mw.RoleSelf))
+		farmPendingOwner.Use(userAuthMW.Middleware, farmPolicy.Require(mw.RoleFarmPendingOwner))
+
+		farms.HandleFunc("", mw.AsHandlerFunc(farmAPI.registerFarm)).Methods("POST").Name("farm-register")
+		farms.HandleFunc("", mw.AsHandlerFunc(farmAPI.listFarm)).Methods("GET").Name("farm-list")
+		farms.HandleFunc("/{farm_id}", mw.AsHandlerFunc(farmAPI.getFarm)).Methods("GET").Name("farm-get")
+		farms.HandleFunc("/{farm_id}/capacity/history", mw.AsHandlerFunc(farmAPI.capacityHistory)).Methods("GET").Name("farm-capacity-history")
+		farms.HandleFunc("/{farm_id}/stats", mw.AsHandlerFunc(statsAPI.farmStats)).Methods("GET").Name("farm-stats")
+		farmOwner.HandleFunc("/{farm_id}", mw.AsHandlerFunc(farmAPI.updateFarm)).Methods("PUT").Name("farm-update")
+		farmOwner.HandleFunc("/{farm_id}/admins", mw.AsHandlerFunc(farmAPI.addAdmin)).Methods("POST").Name("farm-admin-add")
+		farmOwnerOrSelf.HandleFunc("/{farm_id}/admins/{threebot_id}", mw.AsHandlerFunc(farmAPI.removeAdmin)).Methods("DELETE").Name("farm-admin-remove")
+		farmOwner.HandleFunc("/{farm_id}/transfer", mw.AsHandlerFunc(farmAPI.transferOwnership)).Methods("POST").Name("farm-transfer")
+		farmPendingOwner.HandleFunc("/{farm_id}/transfer/accept", mw.AsHandlerFunc(farmAPI.acceptOwnership)).Methods("POST").Name("farm-transfer-accept")
+		farmOwner.HandleFunc("/{farm_id}/wallet_addresses/{address}/challenge", mw.AsHandlerFunc(farmAPI.walletChallenge)).Methods("POST").Name("farm-wallet-challenge")
+		farmOwner.HandleFunc("/{farm_id}/wallet_addresses/{address}/verify", mw.AsHandlerFunc(farmAPI.verifyWallet)).Methods("POST").Name("farm-wallet-verify")
+		farmAdmin.HandleFunc("/{farm_id}/ip_addresses", mw.AsHandlerFunc(farmAPI.addIP)).Methods("POST").Name("farm-ip-add")
+		farmAdmin.HandleFunc("/{farm_id}/ip_addresses/{ip}", mw.AsHandlerFunc(farmAPI.removeIP)).Methods("DELETE").Name("farm-ip-remove")
+
+		nodes := parent.PathPrefix("/nodes").Subrouter()
+		nodesAuthenticated := parent.PathPrefix("/nodes").Subrouter()
+		userAuthenticated := parent.PathPrefix("/nodes").Subrouter()
+		farmerAuthenticated := parent.PathPrefix("/nodes").Subrouter()
+
+		userAuthenticated.Use(userAuthMW.Middleware, nodePolicy.Require(mw.RoleUser))
+		farmerAuthenticated.Use(userAuthMW.Middleware, nodePolicy.Require(mw.RoleFarmer, mw.RoleAdmin))
+		nodesAuthenticated.Use(nodeAuthMW.Middleware, nodePolicy.Require(mw.RoleNode))
+
+		nodes.HandleFunc("", mw.AsHandlerFunc(nodeAPI.registerNode)).Methods("POST").Name("node-register")
+		nodes.HandleFunc("", mw.AsHandlerFunc(nodeAPI.listNodes)).Methods("GET").Name("nodes-list")
+		nodes.HandleFunc("/{node_id}", mw.AsHandlerFunc(nodeAPI.nodeDetail)).Methods("GET").Name(("node-get"))
+		nodes.HandleFunc("/{node_id}/capacity/history", mw.AsHandlerFunc(nodeAPI.Requires("node_id", nodeAPI.capacityHistory))).Methods("GET").Name("node-capacity-history")
+		nodes.HandleFunc("/{node_id}/proofs/diff", mw.AsHandlerFunc(nodeAPI.Requires("node_id", nodeAPI.proofsDiff))).Methods("GET").Name("node-proofs-diff")
+		nodesAuthenticated.HandleFunc("/{node_id}/interfaces", mw.AsHandlerFunc(nodeAPI.Requires("node_id", nodeAPI.registerIfaces))).Methods("POST").Name("node-interfaces")
+		nodesAuthenticated.HandleFunc("/{node_id}/ports", mw.AsHandlerFunc(nodeAPI.Requires("node_id", nodeAPI.registerPorts))).Methods("POST").Name("node-set-ports")
+		farmerAuthenticated.HandleFunc("/{node_id}/configure_public", mw.AsHandlerFunc(nodeAPI.Requires("node_id", nodeAPI.configurePublic))).Methods("POST").Name("node-configure-public")
+		farmerAuthenticated.HandleFunc("/{node_id}/configure_free", mw.AsHandlerFunc(nodeAPI.Requires("node_id", nodeAPI.configureFreeToUse))).Methods("POST").Name("node-configure-free")
+		farmerAuthenticated.HandleFunc("/{node_id}", mw.AsHandlerFunc(nodeAPI.Requires("node_id", nodeAPI.decommission))).Methods("DELETE").Name("node-decommission")
+		farmerAuthenticated.HandleFunc("/{node_id}/hardware_changed", mw.AsHandlerFunc(nodeAPI.Requires("node_id", nodeAPI.acknowledgeHardwareChange))).Methods("DELETE").Name("node-hardware-ack")
+		userAuthenticated.HandleFunc("/{node_id}/approve", mw.AsHandlerFunc(nodeAPI.Requires("node_id", nodeAPI.approveNode))).Methods("POST").Name("node-approve")
+		userAuthenticated.HandleFunc("/{node_id}/revoke", mw.AsHandlerFunc(nodeAPI.Requires("node_id", nodeAPI.revokeNode))).Methods("POST").Name("node-revoke")
+		nodesAuthenticated.HandleFunc("/{node_id}/capacity", mw.AsHandlerFunc(nodeAPI.Requires("node_id", nodeAPI.registerCapacity))).Methods("POST").Name("node-capacity")
+		nodesAuthenticated.HandleFunc("/{node_id}/uptime", mw.AsHandlerFunc(nodeAPI.Requires("node_id", nodeAPI.updateUptimeHandler))).Methods("POST").Name("node-uptime")
+		nodesAuthenticated.HandleFunc("/{node_id}/used_resources", mw.AsHandlerFunc(nodeAPI.Requires("node_id", nodeAPI.updateReservedResources))).Methods("POST").Name("node-reserved-resources")
+
+		gw := parent.PathPrefix("/gateways").Subrouter()
+		gwAuthenticated := parent.PathPrefix("/gateways").Subrouter()
+		gwUserAuthenticated := parent.PathPrefix("/gateways").Subrouter()
+		gwAuthenticated.Use(nodeAuthMW.Middleware, gwPolicy.Require(mw.RoleNode))
+		gwUserAuthenticated.Use(userAuthMW.Middleware, gwPolicy.Require(mw.RoleFarmer, mw.RoleAdmin))
+
+		gw.HandleFunc("", mw.AsHandlerFunc(gwAPI.registerGateway)).Methods("POST").Name("gateway-register")
+		gw.HandleFunc("", mw.AsHandlerFunc(gwAPI.listGateways)).Methods("GET").Name("gateway-list")
+		gw.HandleFunc("/{node_id}", mw.AsHandlerFunc(gwAPI.gatewayDetail)).Methods("GET").Name(("gateway-get"))
+		gw.HandleFunc("/{node_id}/domains", mw.AsHandlerFunc(gwAPI.listDomains)).Methods("GET").Name("gateway-domains")
+		gwAuthenticated.HandleFunc("/{node_id}/capacity", mw.AsHandlerFunc(gwAPI.Requires("node_id", gwAPI.registerCapacity))).Methods("POST").Name("gateway-capacity")
+		gwAuthenticated.HandleFunc("/{node_id}/interfaces", mw.AsHandlerFunc(gwAPI.Requires("node_id", gwAPI.registerIfaces))).Methods("POST").Name("gateway-interfaces")
+		gwAuthenticated.HandleFunc("/{node_id}/uptime", mw.AsHandlerFunc(gwAPI.Requires("node_id", gwAPI.updateUptimeHandler))).Methods("POST").Name("gateway-uptime")
+		gwAuthenticated.HandleFunc("/{node_id}/reserved_resources", mw.AsHandlerFunc(gwAPI.Requires("node_id", gwAPI.updateReservedResources))).Methods("POST").Name("gateway-reserved-resources")
+		gwUserAuthenticated.HandleFunc("/{node_id}/configure_public", mw.AsHandlerFunc(gwAPI.Requires("node_id", gwAPI.configurePublic))).Methods("POST").Name("gateway-configure-public")
+		gwUserAuthenticated.HandleFunc("/{node_id}/configure_free", mw.AsHandlerFunc(gwAPI.Requires("node_id", gwAPI.configureFreeToUse))).Methods("POST").Name("gateway-configure-free")
+		gwUserAuthenticated.HandleFunc("/{node_id}", mw.AsHandlerFunc(gwAPI.Requires("node_id", gwAPI.decommission))).Methods("DELETE").Name("gateway-decommission")
+	}
+}
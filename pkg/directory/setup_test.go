@@ -0,0 +1,23 @@
+package directory
+
+import (
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/tfexplorer/mw"
+)
+
+func TestRoutesDeclarePrincipals(t *testing.T) {
+	nodeAPI, _ := newTestNodeAPI(t)
+
+	router := mux.NewRouter()
+	mount([]*mux.Router{router}, &StatsAPI{}, &FarmAPI{}, nodeAPI, &GatewayAPI{})
+
+	// farms, nodes and gateways register themselves before they have
+	// any principal
+	unguarded, err := mw.UnguardedRoutes(router, "farm-register", "node-register", "gateway-register")
+	require.NoError(t, err)
+	assert.Empty(t, unguarded)
+}
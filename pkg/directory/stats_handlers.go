@@ -0,0 +1,63 @@
+package directory
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/threefoldtech/tfexplorer/mw"
+	directory "github.com/threefoldtech/tfexplorer/pkg/directory/types"
+	"github.com/threefoldtech/tfexplorer/schema"
+)
+
+func (s *StatsAPI) gridStats(r *http.Request) (interface{}, mw.Response) {
+	stats, err := s.Get(r.Context())
+	if err != nil {
+		return nil, mw.Error(err)
+	}
+
+	return stats.Grid, nil
+}
+
+func (s *StatsAPI) farmsStats(r *http.Request) (interface{}, mw.Response) {
+	stats, err := s.Get(r.Context())
+	if err != nil {
+		return nil, mw.Error(err)
+	}
+
+	return stats.Farms, nil
+}
+
+func (s *StatsAPI) countriesStats(r *http.Request) (interface{}, mw.Response) {
+	stats, err := s.Get(r.Context())
+	if err != nil {
+		return nil, mw.Error(err)
+	}
+
+	return stats.Countries, nil
+}
+
+func (s *StatsAPI) farmStats(r *http.Request) (interface{}, mw.Response) {
+	sid := mux.Vars(r)["farm_id"]
+
+	id, err := strconv.ParseInt(sid, 10, 64)
+	if err != nil {
+		return nil, mw.BadRequest(err)
+	}
+
+	stats, found, err := s.Farm(r.Context(), schema.ID(id))
+	if err != nil {
+		return nil, mw.Error(err)
+	}
+
+	if found {
+		return stats, nil
+	}
+
+	// a farm without nodes does not show up in the aggregation
+	if _, err := s.farms.Get(r.Context(), schema.ID(id)); err != nil {
+		return nil, mw.NotFound(err)
+	}
+
+	return directory.FarmStats{FarmID: schema.ID(id)}, nil
+}
@@ -0,0 +1,110 @@
+package directory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	directory "github.com/threefoldtech/tfexplorer/pkg/directory/types"
+	"github.com/threefoldtech/tfexplorer/schema"
+)
+
+const (
+	// statsTTL is how long aggregated statistics are served from cache
+	statsTTL = time.Minute
+	// onlineTimeout is the delay after which a node that did not
+	// report its uptime is not considered online anymore
+	onlineTimeout = 20 * time.Minute
+)
+
+// GridStats is the aggregated capacity of the grid, per farm and per country
+type GridStats struct {
+	Grid      directory.Stats          `json:"grid"`
+	Farms     []directory.FarmStats    `json:"farms"`
+	Countries []directory.CountryStats `json:"countries"`
+}
+
+// StatsAPI holds api for the grid statistics
+type StatsAPI struct {
+	stats directory.StatsRepository
+	farms directory.FarmRepository
+
+	m       sync.Mutex
+	cached  *GridStats
+	expires time.Time
+}
+
+// Get returns the grid statistics. Aggregating all the nodes is expensive so
+// results are cached and only computed again after statsTTL
+func (s *StatsAPI) Get(ctx context.Context) (*GridStats, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.cached != nil && time.Now().Before(s.expires) {
+		return s.cached, nil
+	}
+
+	stats, err := s.aggregate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.cached = stats
+	s.expires = time.Now().Add(statsTTL)
+	return stats, nil
+}
+
+func (s *StatsAPI) aggregate(ctx context.Context) (*GridStats, error) {
+	since := time.Now().Add(-onlineTimeout)
+
+	grid, err := s.stats.Grid(ctx, since)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to aggregate grid capacity")
+	}
+
+	farms, err := s.stats.PerFarm(ctx, since)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to aggregate farms capacity")
+	}
+
+	countries, err := s.stats.PerCountry(ctx, since)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to aggregate countries capacity")
+	}
+
+	counts, err := s.stats.CountReservations(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to count reservations")
+	}
+
+	grid.Reservations = counts.Grid
+	for i := range farms {
+		farms[i].Reservations = counts.Farms[farms[i].FarmID]
+	}
+	for i := range countries {
+		countries[i].Reservations = counts.Countries[countries[i].Country]
+	}
+
+	return &GridStats{
+		Grid:      grid,
+		Farms:     farms,
+		Countries: countries,
+	}, nil
+}
+
+// Farm returns the statistics of a single farm
+func (s *StatsAPI) Farm(ctx context.Context, farmID schema.ID) (directory.FarmStats, bool, error) {
+	stats, err := s.Get(ctx)
+	if err != nil {
+		return directory.FarmStats{}, false, err
+	}
+
+	for _, farm := range stats.Farms {
+		if farm.FarmID == farmID {
+			return farm, true, nil
+		}
+	}
+
+	return directory.FarmStats{}, false, nil
+}
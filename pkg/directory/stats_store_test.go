@@ -0,0 +1,90 @@
+package directory
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	generated "github.com/threefoldtech/tfexplorer/models/generated/directory"
+	workloadsgen "github.com/threefoldtech/tfexplorer/models/generated/workloads"
+	directory "github.com/threefoldtech/tfexplorer/pkg/directory/types"
+	workloads "github.com/threefoldtech/tfexplorer/pkg/workloads/types"
+)
+
+func TestGridStats(t *testing.T) {
+	ctx := context.Background()
+	api, farmID := newTestNodeAPI(t)
+
+	empty, err := api.farms.Create(ctx, directory.Farm{
+		Name:            "empty",
+		ThreebotId:      2,
+		WalletAddresses: []generated.WalletAddress{{Asset: "TFT", Address: "address"}},
+	})
+	require.NoError(t, err)
+
+	nodes := []directory.Node{newTestNode(t, farmID), newTestNode(t, farmID)}
+	nodes[1].Location.Country = "Egypt"
+	for _, node := range nodes {
+		_, err := api.Add(ctx, node)
+		require.NoError(t, err)
+		require.NoError(t, api.updateTotalCapacity(ctx, node.NodeId, generated.ResourceAmount{Cru: 4, Mru: 8}))
+	}
+
+	var reservation workloads.Reservation
+	reservation.NextAction = workloads.Deploy
+	reservation.DataReservation.Volumes = []workloadsgen.Volume{
+		{WorkloadId: 1, NodeId: nodes[0].NodeId},
+		{WorkloadId: 2, NodeId: nodes[1].NodeId},
+	}
+	_, err = api.reservations.Create(ctx, reservation)
+	require.NoError(t, err)
+
+	stats := StatsAPI{
+		stats: directory.NewMemoryStatsRepository(api.nodes, directory.NewMemoryGatewayRepository(), api.reservations),
+		farms: api.farms,
+	}
+
+	result, resp := stats.gridStats(httptest.NewRequest(http.MethodGet, "/stats", nil))
+	require.Nil(t, resp)
+	grid := result.(directory.Stats)
+	assert.Equal(t, int64(2), grid.Nodes)
+	assert.Equal(t, int64(2), grid.OnlineNodes)
+	assert.Equal(t, uint64(8), grid.TotalResources.Cru)
+	// the reservation is only counted once even if it uses 2 nodes
+	assert.Equal(t, int64(1), grid.Reservations)
+
+	result, resp = stats.countriesStats(httptest.NewRequest(http.MethodGet, "/stats/countries", nil))
+	require.Nil(t, resp)
+	countries := result.([]directory.CountryStats)
+	require.Len(t, countries, 2)
+	assert.Equal(t, "Belgium", countries[0].Country)
+	assert.Equal(t, int64(1), countries[0].Reservations)
+
+	farmStats := func(id int64) (interface{}, int) {
+		r := httptest.NewRequest(http.MethodGet, "/farms/id/stats", nil)
+		r = mux.SetURLVars(r, map[string]string{"farm_id": fmt.Sprint(id)})
+		result, resp := stats.farmStats(r)
+		if resp != nil {
+			return result, resp.Status()
+		}
+		return result, http.StatusOK
+	}
+
+	result, status := farmStats(farmID)
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, int64(2), result.(directory.FarmStats).Nodes)
+	assert.Equal(t, int64(1), result.(directory.FarmStats).Reservations)
+
+	// farms without nodes are not in the aggregation
+	result, status = farmStats(int64(empty))
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, int64(0), result.(directory.FarmStats).Nodes)
+
+	_, status = farmStats(int64(empty) + 1)
+	assert.Equal(t, http.StatusNotFound, status)
+}
@@ -0,0 +1,251 @@
+package types
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	generated "github.com/threefoldtech/tfexplorer/models/generated/directory"
+	"github.com/threefoldtech/tfexplorer/schema"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// CapacityCollection db collection name
+	CapacityCollection = "capacity"
+)
+
+// Resolution is the size of the buckets capacity samples are aggregated
+// into and how long these buckets are kept
+type Resolution struct {
+	Step      time.Duration
+	Retention time.Duration
+}
+
+// Resolutions is the downsampling policy of the capacity history, from the
+// finest to the coarsest. Every sample is accounted in all the resolutions
+var Resolutions = []Resolution{
+	{Step: 5 * time.Minute, Retention: 48 * time.Hour},
+	{Step: time.Hour, Retention: 30 * 24 * time.Hour},
+	{Step: 24 * time.Hour, Retention: 365 * 24 * time.Hour},
+}
+
+// CapacityAmount is an amount of resource units. Unlike generated.ResourceAmount
+// all units are floats since they are averaged over time
+type CapacityAmount struct {
+	Cru float64 `bson:"cru" json:"cru"`
+	Mru float64 `bson:"mru" json:"mru"`
+	Hru float64 `bson:"hru" json:"hru"`
+	Sru float64 `bson:"sru" json:"sru"`
+}
+
+func (c CapacityAmount) add(o CapacityAmount) CapacityAmount {
+	return CapacityAmount{
+		Cru: c.Cru + o.Cru,
+		Mru: c.Mru + o.Mru,
+		Hru: c.Hru + o.Hru,
+		Sru: c.Sru + o.Sru,
+	}
+}
+
+func (c CapacityAmount) div(n float64) CapacityAmount {
+	if n == 0 {
+		return CapacityAmount{}
+	}
+
+	return CapacityAmount{
+		Cru: c.Cru / n,
+		Mru: c.Mru / n,
+		Hru: c.Hru / n,
+		Sru: c.Sru / n,
+	}
+}
+
+// CapacityBucket accumulates the capacity samples reported by a node
+// during Step seconds starting at Timestamp
+type CapacityBucket struct {
+	NodeID    string         `bson:"node_id" json:"node_id"`
+	FarmID    int64          `bson:"farm_id" json:"farm_id"`
+	Step      int64          `bson:"step" json:"step"`
+	Timestamp time.Time      `bson:"timestamp" json:"timestamp"`
+	Samples   int64          `bson:"samples" json:"samples"`
+	Total     CapacityAmount `bson:"total" json:"total"`
+	Reserved  CapacityAmount `bson:"reserved" json:"reserved"`
+	Used      CapacityAmount `bson:"used" json:"used"`
+	ExpireAt  time.Time      `bson:"expire_at" json:"-"`
+}
+
+// CapacityPoint is the average capacity over a period of time
+type CapacityPoint struct {
+	Timestamp schema.Date    `json:"timestamp"`
+	Total     CapacityAmount `json:"total"`
+	Reserved  CapacityAmount `json:"reserved"`
+	Used      CapacityAmount `json:"used"`
+}
+
+// CapacityRecord accounts the current capacity of node in all
+// the resolutions of the history
+func CapacityRecord(ctx context.Context, db *mongo.Database, node Node, at time.Time) error {
+	col := db.Collection(CapacityCollection)
+	at = at.UTC()
+
+	inc := bson.M{"samples": 1}
+	for field, amount := range map[string]generated.ResourceAmount{
+		"total":    node.TotalResources,
+		"reserved": node.ReservedResources,
+		"used":     node.UsedResources,
+	} {
+		inc[field+".cru"] = float64(amount.Cru)
+		inc[field+".mru"] = amount.Mru
+		inc[field+".hru"] = amount.Hru
+		inc[field+".sru"] = amount.Sru
+	}
+
+	for _, resolution := range Resolutions {
+		timestamp := at.Truncate(resolution.Step)
+		filter := bson.M{
+			"node_id":   node.NodeId,
+			"step":      int64(resolution.Step / time.Second),
+			"timestamp": timestamp,
+		}
+
+		update := bson.M{
+			"$inc": inc,
+			"$set": bson.M{
+				"farm_id":   node.FarmId,
+				"expire_at": timestamp.Add(resolution.Step + resolution.Retention),
+			},
+		}
+
+		if _, err := col.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ResolutionFor selects the coarsest resolution not larger than step. If this
+// resolution does not hold data as old as from, a coarser one is used instead
+func ResolutionFor(from time.Time, step time.Duration) (Resolution, error) {
+	if step < Resolutions[0].Step {
+		return Resolution{}, fmt.Errorf("step can not be smaller than %s", Resolutions[0].Step)
+	}
+
+	i := 0
+	for i < len(Resolutions)-1 && Resolutions[i+1].Step <= step {
+		i++
+	}
+
+	age := time.Since(from)
+	for i < len(Resolutions)-1 && Resolutions[i].Retention < age {
+		i++
+	}
+
+	return Resolutions[i], nil
+}
+
+// CapacityFilter type
+type CapacityFilter bson.D
+
+// WithNodeID filter buckets of a node
+func (f CapacityFilter) WithNodeID(id string) CapacityFilter {
+	return append(f, bson.E{Key: "node_id", Value: id})
+}
+
+// WithFarmID filter buckets of the nodes of a farm
+func (f CapacityFilter) WithFarmID(id schema.ID) CapacityFilter {
+	return append(f, bson.E{Key: "farm_id", Value: id})
+}
+
+// WithResolution filter buckets of the given resolution
+func (f CapacityFilter) WithResolution(resolution Resolution) CapacityFilter {
+	return append(f, bson.E{Key: "step", Value: int64(resolution.Step / time.Second)})
+}
+
+// WithRange filter buckets that start in [from, to)
+func (f CapacityFilter) WithRange(from, to time.Time) CapacityFilter {
+	return append(f, bson.E{Key: "timestamp", Value: bson.M{
+		"$gte": from.UTC(),
+		"$lt":  to.UTC(),
+	}})
+}
+
+// Find run the filter and return a cursor result
+func (f CapacityFilter) Find(ctx context.Context, db *mongo.Database, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	col := db.Collection(CapacityCollection)
+	if f == nil {
+		f = CapacityFilter{}
+	}
+
+	return col.Find(ctx, f, opts...)
+}
+
+// CapacityHistory loads the buckets matching filter and returns the average
+// capacity for each step. When the filter matches multiple nodes, the
+// averages of all the nodes are summed
+func CapacityHistory(ctx context.Context, db *mongo.Database, filter CapacityFilter, step time.Duration) ([]CapacityPoint, error) {
+	cur, err := filter.Find(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var buckets []CapacityBucket
+	if err := cur.All(ctx, &buckets); err != nil {
+		return nil, err
+	}
+
+	return downsample(buckets, step), nil
+}
+
+// downsample merges buckets into points of step duration
+func downsample(buckets []CapacityBucket, step time.Duration) []CapacityPoint {
+	type key struct {
+		timestamp time.Time
+		node      string
+	}
+
+	merged := make(map[key]*CapacityBucket)
+	for _, bucket := range buckets {
+		k := key{timestamp: bucket.Timestamp.UTC().Truncate(step), node: bucket.NodeID}
+		m, ok := merged[k]
+		if !ok {
+			m = &CapacityBucket{Timestamp: k.timestamp}
+			merged[k] = m
+		}
+
+		m.Samples += bucket.Samples
+		m.Total = m.Total.add(bucket.Total)
+		m.Reserved = m.Reserved.add(bucket.Reserved)
+		m.Used = m.Used.add(bucket.Used)
+	}
+
+	points := make(map[time.Time]*CapacityPoint)
+	for k, bucket := range merged {
+		point, ok := points[k.timestamp]
+		if !ok {
+			point = &CapacityPoint{Timestamp: schema.Date{Time: k.timestamp}}
+			points[k.timestamp] = point
+		}
+
+		n := float64(bucket.Samples)
+		point.Total = point.Total.add(bucket.Total.div(n))
+		point.Reserved = point.Reserved.add(bucket.Reserved.div(n))
+		point.Used = point.Used.add(bucket.Used.div(n))
+	}
+
+	out := make([]CapacityPoint, 0, len(points))
+	for _, point := range points {
+		out = append(out, *point)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Timestamp.Before(out[j].Timestamp.Time)
+	})
+
+	return out
+}
@@ -0,0 +1,79 @@
+package types
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolutionFor(t *testing.T) {
+	now := time.Now()
+
+	_, err := ResolutionFor(now.Add(-time.Hour), time.Minute)
+	assert.Error(t, err)
+
+	resolution, err := ResolutionFor(now.Add(-time.Hour), 10*time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, 5*time.Minute, resolution.Step)
+
+	resolution, err = ResolutionFor(now.Add(-time.Hour), 2*time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, time.Hour, resolution.Step)
+
+	// the 5 minutes buckets are not kept for a week
+	resolution, err = ResolutionFor(now.Add(-7*24*time.Hour), 10*time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, time.Hour, resolution.Step)
+
+	resolution, err = ResolutionFor(now.Add(-7*24*time.Hour), 7*24*time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 24*time.Hour, resolution.Step)
+}
+
+func TestDownsample(t *testing.T) {
+	start := time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC)
+
+	buckets := []CapacityBucket{
+		{
+			NodeID:    "node1",
+			Timestamp: start,
+			Samples:   2,
+			Total:     CapacityAmount{Cru: 8, Mru: 32},
+			Used:      CapacityAmount{Cru: 2},
+		},
+		{
+			NodeID:    "node1",
+			Timestamp: start.Add(5 * time.Minute),
+			Samples:   2,
+			Total:     CapacityAmount{Cru: 8, Mru: 32},
+			Used:      CapacityAmount{Cru: 6},
+		},
+		{
+			NodeID:    "node2",
+			Timestamp: start,
+			Samples:   1,
+			Total:     CapacityAmount{Cru: 2, Mru: 4},
+			Used:      CapacityAmount{Cru: 1},
+		},
+		{
+			NodeID:    "node1",
+			Timestamp: start.Add(time.Hour),
+			Samples:   1,
+			Total:     CapacityAmount{Cru: 4, Mru: 16},
+		},
+	}
+
+	points := downsample(buckets, time.Hour)
+	require.Len(t, points, 2)
+
+	// node1 averages to 4 cru, 16 mru and 2 used cru over the first hour
+	// to which node2 is added
+	assert.True(t, points[0].Timestamp.Equal(start))
+	assert.Equal(t, CapacityAmount{Cru: 6, Mru: 20}, points[0].Total)
+	assert.Equal(t, CapacityAmount{Cru: 3}, points[0].Used)
+
+	assert.True(t, points[1].Timestamp.Equal(start.Add(time.Hour)))
+	assert.Equal(t, CapacityAmount{Cru: 4, Mru: 16}, points[1].Total)
+}
@@ -0,0 +1,163 @@
+package types
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/threefoldtech/tfexplorer/pkg/apierror"
+	"github.com/threefoldtech/tfexplorer/schema"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// DomainCollection db collection name
+	DomainCollection = "domain"
+)
+
+// DomainType is the kind of claim a reservation has on a domain
+type DomainType string
+
+const (
+	// DomainTypeSubdomain is a subdomain of one of the gateway managed domains
+	DomainTypeSubdomain DomainType = "subdomain"
+	// DomainTypeDelegate is a domain owned by the user and delegated to the gateway
+	DomainTypeDelegate DomainType = "delegate"
+)
+
+var (
+	// ErrDomainClaimed is returned when claiming a domain already held by another reservation
+	ErrDomainClaimed = apierror.New(apierror.CodeDomainClaimed, "domain is already claimed")
+)
+
+// DomainClaim records which reservation holds a domain name on a gateway
+type DomainClaim struct {
+	Domain        string      `bson:"_id" json:"domain"`
+	Type          DomainType  `bson:"type" json:"type"`
+	GatewayID     string      `bson:"gateway_id" json:"gateway_id"`
+	ReservationID schema.ID   `bson:"reservation_id" json:"reservation_id"`
+	CustomerTid   int64       `bson:"customer_tid" json:"customer_tid"`
+	Created       schema.Date `bson:"created" json:"created"`
+}
+
+// NormalizeDomain lower cases the domain and strips the trailing dot of fully qualified names
+func NormalizeDomain(domain string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+}
+
+// ValidateDomain checks that domain can be claimed on gateway gw
+// subdomains must be strictly inside one of the domains managed by the gateway
+// while delegated domains must be outside of them
+func ValidateDomain(gw Gateway, domain string, typ DomainType) error {
+	domain = NormalizeDomain(domain)
+	if len(domain) == 0 {
+		return fmt.Errorf("domain is required")
+	}
+
+	managed := ""
+	for _, m := range gw.ManagedDomains {
+		m = NormalizeDomain(m)
+		if domain == m || strings.HasSuffix(domain, "."+m) {
+			managed = m
+			break
+		}
+	}
+
+	switch typ {
+	case DomainTypeSubdomain:
+		if managed == "" {
+			return fmt.Errorf("domain '%s' is not managed by gateway '%s'", domain, gw.NodeId)
+		}
+		if domain == managed {
+			return fmt.Errorf("domain '%s' is managed by the gateway and can not be claimed", domain)
+		}
+	case DomainTypeDelegate:
+		if managed != "" {
+			return fmt.Errorf("domain '%s' is managed by gateway '%s' and can not be delegated", domain, gw.NodeId)
+		}
+	default:
+		return fmt.Errorf("unsupported domain type '%s'", typ)
+	}
+
+	return nil
+}
+
+// DomainFilter type
+type DomainFilter bson.D
+
+// WithDomain filter claims for domain
+func (f DomainFilter) WithDomain(domain string) DomainFilter {
+	return append(f, bson.E{Key: "_id", Value: NormalizeDomain(domain)})
+}
+
+// WithGatewayID filter claims made on gateway
+func (f DomainFilter) WithGatewayID(id string) DomainFilter {
+	return append(f, bson.E{Key: "gateway_id", Value: id})
+}
+
+// WithReservationID filter claims held by reservation
+func (f DomainFilter) WithReservationID(id schema.ID) DomainFilter {
+	return append(f, bson.E{Key: "reservation_id", Value: id})
+}
+
+// Find run the filter and return a cursor result
+func (f DomainFilter) Find(ctx context.Context, db *mongo.Database, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	col := db.Collection(DomainCollection)
+	if f == nil {
+		f = DomainFilter{}
+	}
+
+	return col.Find(ctx, f, opts...)
+}
+
+// Get one claim that matches the filter
+func (f DomainFilter) Get(ctx context.Context, db *mongo.Database) (claim DomainClaim, err error) {
+	if f == nil {
+		f = DomainFilter{}
+	}
+	col := db.Collection(DomainCollection)
+	result := col.FindOne(ctx, f, options.FindOne())
+	if err = result.Err(); err != nil {
+		return
+	}
+
+	err = result.Decode(&claim)
+	return
+}
+
+// Delete deletes all the claims that match the filter
+func (f DomainFilter) Delete(ctx context.Context, db *mongo.Database) error {
+	if f == nil {
+		f = DomainFilter{}
+	}
+	col := db.Collection(DomainCollection)
+	_, err := col.DeleteMany(ctx, f)
+	return err
+}
+
+// DomainClaimCreate records a claim on a domain. ErrDomainClaimed is returned
+// if the domain is already held by a reservation
+func DomainClaimCreate(ctx context.Context, db *mongo.Database, claim DomainClaim) error {
+	claim.Domain = NormalizeDomain(claim.Domain)
+
+	col := db.Collection(DomainCollection)
+	_, err := col.InsertOne(ctx, claim)
+	if err != nil {
+		if merr, ok := err.(mongo.WriteException); ok {
+			errCode := merr.WriteErrors[0].Code
+			if errCode == 11000 {
+				return ErrDomainClaimed
+			}
+		}
+		return err
+	}
+
+	return nil
+}
+
+// DomainClaimRelease frees all the domains held by reservation
+func DomainClaimRelease(ctx context.Context, db *mongo.Database, reservation schema.ID) error {
+	return DomainFilter{}.WithReservationID(reservation).Delete(ctx, db)
+}
@@ -0,0 +1,39 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateDomain(t *testing.T) {
+	gw := Gateway{
+		NodeId:         "gw1",
+		ManagedDomains: []string{"tfgw1.io", "Grid.TF."},
+	}
+
+	tests := []struct {
+		domain string
+		typ    DomainType
+		valid  bool
+	}{
+		{domain: "user1.tfgw1.io", typ: DomainTypeSubdomain, valid: true},
+		{domain: "a.b.grid.tf.", typ: DomainTypeSubdomain, valid: true},
+		{domain: "tfgw1.io", typ: DomainTypeSubdomain, valid: false},
+		{domain: "user1.othergw.io", typ: DomainTypeSubdomain, valid: false},
+		{domain: "notfgw1.io", typ: DomainTypeSubdomain, valid: false},
+		{domain: "example.com", typ: DomainTypeDelegate, valid: true},
+		{domain: "user1.tfgw1.io", typ: DomainTypeDelegate, valid: false},
+		{domain: "", typ: DomainTypeDelegate, valid: false},
+		{domain: "example.com", typ: DomainType("proxy"), valid: false},
+	}
+
+	for _, tt := range tests {
+		err := ValidateDomain(gw, tt.domain, tt.typ)
+		if tt.valid {
+			assert.NoError(t, err, tt.domain)
+		} else {
+			assert.Error(t, err, tt.domain)
+		}
+	}
+}
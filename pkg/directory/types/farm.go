@@ -3,8 +3,10 @@ package types
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"regexp"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
@@ -12,6 +14,7 @@ import (
 	"github.com/threefoldtech/tfexplorer/models"
 	generated "github.com/threefoldtech/tfexplorer/models/generated/directory"
 	"github.com/threefoldtech/tfexplorer/mw"
+	"github.com/threefoldtech/tfexplorer/pkg/apierror"
 	"github.com/threefoldtech/tfexplorer/pkg/stellar"
 	"github.com/threefoldtech/tfexplorer/schema"
 	"go.mongodb.org/mongo-driver/bson"
@@ -72,10 +75,75 @@ func (f *Farm) Validate() error {
 	return nil
 }
 
+// RoleOf returns the role of the threebot tid on the farm. The second return
+// value is false if tid has no administrative rights on the farm
+func (f *Farm) RoleOf(tid int64) (generated.FarmRoleEnum, bool) {
+	if f.ThreebotId == tid {
+		return generated.FarmRoleOwner, true
+	}
+
+	for _, admin := range f.Admins {
+		if admin.ThreebotId != tid {
+			continue
+		}
+
+		// an entry with an unknown role, like the zero value, grants nothing
+		switch admin.Role {
+		case generated.FarmRoleOwner, generated.FarmRoleOperator:
+			return admin.Role, true
+		}
+	}
+
+	return generated.FarmRoleNone, false
+}
+
+// IsOwner checks if tid is an owner of the farm
+func (f *Farm) IsOwner(tid int64) bool {
+	role, ok := f.RoleOf(tid)
+	return ok && role == generated.FarmRoleOwner
+}
+
+// IsAdmin checks if tid is allowed to manage the nodes of the farm, either as
+// an owner or as an operator
+func (f *Farm) IsAdmin(tid int64) bool {
+	_, ok := f.RoleOf(tid)
+	return ok
+}
+
+// KeepVerification makes sure the wallet addresses of f are only marked verified
+// if the same address was already verified in current
+func (f *Farm) KeepVerification(current []generated.WalletAddress) {
+	for i := range f.WalletAddresses {
+		addr := &f.WalletAddresses[i]
+		addr.Verified = false
+		addr.VerifiedAt = schema.Date{}
+
+		for _, c := range current {
+			if c.Address == addr.Address && c.Asset == addr.Asset {
+				addr.Verified = c.Verified
+				addr.VerifiedAt = c.VerifiedAt
+				break
+			}
+		}
+	}
+}
+
+// HasWalletAddress checks if address is one of the farm wallet addresses
+func (f *Farm) HasWalletAddress(address string) bool {
+	for _, a := range f.WalletAddresses {
+		if a.Address == address {
+			return true
+		}
+	}
+	return false
+}
+
 // FarmQuery helper to parse query string
 type FarmQuery struct {
 	FarmName string
 	OwnerID  int64
+	AdminID  int64
+	Query    models.Query
 }
 
 // Parse querystring from request
@@ -85,10 +153,35 @@ func (f *FarmQuery) Parse(r *http.Request) mw.Response {
 	if err != nil {
 		return mw.BadRequest(errors.Wrap(err, "owner should be a integer"))
 	}
+	f.AdminID, err = models.QueryInt(r, "admin")
+	if err != nil {
+		return mw.BadRequest(errors.Wrap(err, "admin should be a integer"))
+	}
 	f.FarmName = r.FormValue("name")
+	f.Query, err = models.ParseQuery(r, FarmQueryFields)
+	if err != nil {
+		return mw.BadRequest(err)
+	}
 	return nil
 }
 
+// FarmQueryFields are the fields of the farms that can be used in list queries
+var FarmQueryFields = models.Fields{
+	"id":               {Key: "_id", Type: models.FieldInt, Sortable: true},
+	"threebot_id":      {Type: models.FieldInt},
+	"iyo_organization": {Type: models.FieldString},
+	"name":             {Type: models.FieldString, Sortable: true},
+	"email":            {Type: models.FieldString},
+	"country":          {Key: "location.country", Type: models.FieldString},
+	"city":             {Key: "location.city", Type: models.FieldString},
+	"location":         {Type: models.FieldObject},
+	"wallet_addresses": {Type: models.FieldObject},
+	"resource_prices":  {Type: models.FieldObject},
+	"prefix_zero":      {Type: models.FieldObject},
+	"admins":           {Type: models.FieldObject},
+	"ip_addresses":     {Type: models.FieldObject},
+}
+
 // FarmFilter type
 type FarmFilter bson.D
 
@@ -107,6 +200,17 @@ func (f FarmFilter) WithOwner(tid int64) FarmFilter {
 	return append(f, bson.E{Key: "threebot_id", Value: tid})
 }
 
+// WithAdmin filter farm where tid is either the owner or one of the admins
+func (f FarmFilter) WithAdmin(tid int64) FarmFilter {
+	return append(f, bson.E{Key: "$or", Value: bson.A{
+		bson.M{"threebot_id": tid},
+		bson.M{"admins": bson.M{"$elemMatch": bson.M{
+			"threebot_id": tid,
+			"role":        bson.M{"$in": bson.A{generated.FarmRoleOwner, generated.FarmRoleOperator}},
+		}}},
+	}})
+}
+
 // WithFarmQuery filter based on FarmQuery
 func (f FarmFilter) WithFarmQuery(q FarmQuery) FarmFilter {
 	if len(q.FarmName) != 0 {
@@ -115,10 +219,19 @@ func (f FarmFilter) WithFarmQuery(q FarmQuery) FarmFilter {
 	if q.OwnerID != 0 {
 		f = f.WithOwner(q.OwnerID)
 	}
+	if q.AdminID != 0 {
+		f = f.WithAdmin(q.AdminID)
+	}
+	f = f.WithQuery(q.Query)
 	return f
 
 }
 
+// WithQuery filter farms with the conditions of a list query
+func (f FarmFilter) WithQuery(q models.Query) FarmFilter {
+	return FarmFilter(q.Apply(bson.D(f)))
+}
+
 // Find run the filter and return a cursor result
 func (f FarmFilter) Find(ctx context.Context, db *mongo.Database, opts ...*options.FindOptions) (*mongo.Cursor, error) {
 	col := db.Collection(FarmCollection)
@@ -167,12 +280,12 @@ func (f FarmFilter) Delete(ctx context.Context, db *mongo.Database) (err error)
 
 // FarmCreate creates a new farm
 func FarmCreate(ctx context.Context, db *mongo.Database, farm Farm) (schema.ID, error) {
-	if err := farm.Validate(); err != nil {
+	if err := farm.register(); err != nil {
 		return 0, err
 	}
 
 	col := db.Collection(FarmCollection)
-	id, err := models.NextID(ctx, db, FarmCollection)
+	id, err := models.NewIDGenerator(db, FarmCollection).NextID(ctx)
 	if err != nil {
 		return id, err
 	}
@@ -182,16 +295,318 @@ func FarmCreate(ctx context.Context, db *mongo.Database, farm Farm) (schema.ID,
 	return id, err
 }
 
-// FarmUpdate update an existing farm
+// register validates a new farm and resets the fields that can only be
+// changed through their own flows
+func (f *Farm) register() error {
+	if err := f.Validate(); err != nil {
+		return err
+	}
+
+	// ownership can only be changed through the transfer flow
+	f.PendingOwner = 0
+	// addresses are only verified through the signed challenge
+	f.KeepVerification(nil)
+	if f.Admins == nil {
+		f.Admins = make([]generated.FarmAdmin, 0)
+	}
+	// the ip pool is only managed through FarmIPAdd and FarmIPRemove
+	f.IPAddresses = make([]generated.PublicIP, 0)
+
+	return nil
+}
+
+// ErrFarmConflict is returned when the wallet addresses of a farm changed while
+// it was being updated
+var ErrFarmConflict = apierror.New(apierror.CodeConflict, "farm was updated concurrently")
+
+// ErrAdminsChanged is returned if the admins of the farm changed while they
+// were being updated
+var ErrAdminsChanged = apierror.New(apierror.CodeConflict, "admins of the farm changed concurrently")
+
+// editable returns the fields of the farm its owner can update. The ownership,
+// the admins and the ip pool are only changed through their own flows
+func (f *Farm) editable() bson.M {
+	return bson.M{
+		"name":             f.Name,
+		"iyo_organization": f.IyoOrganization,
+		"email":            f.Email,
+		"wallet_addresses": f.WalletAddresses,
+		"resource_prices":  f.ResourcePrices,
+		"location":         f.Location,
+		"prefix_zero":      f.PrefixZero,
+	}
+}
+
+// FarmUpdate updates the fields of an existing farm its owner can edit.
+// The verification of the wallet addresses is kept from the stored farm, the
+// update fails with ErrFarmConflict if they changed in the meantime
 func FarmUpdate(ctx context.Context, db *mongo.Database, id schema.ID, farm Farm) error {
-	farm.ID = id
+	current, err := FarmFilter{}.WithID(id).Get(ctx, db)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// like an update that matches no farm
+		return nil
+	} else if err != nil {
+		return err
+	}
 
+	farm.ID = id
+	farm.ThreebotId = current.ThreebotId
 	if err := farm.Validate(); err != nil {
 		return err
 	}
 
+	// changing an address requires verifying it again
+	farm.KeepVerification(current.WalletAddresses)
+
+	col := db.Collection(FarmCollection)
+	result, err := col.UpdateOne(ctx,
+		bson.M{"_id": id, "wallet_addresses": current.WalletAddresses},
+		bson.M{"$set": farm.editable()},
+	)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrFarmConflict
+	}
+
+	return nil
+}
+
+func farmUpdate(ctx context.Context, db *mongo.Database, id schema.ID, value interface{}) error {
+	col := db.Collection(FarmCollection)
+	f := FarmFilter{}.WithID(id)
+	result, err := col.UpdateOne(ctx, f, bson.M{"$set": value})
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+// FarmSetAdmins sets the list of admins of a farm, only if current is still
+// its list of admins. ErrAdminsChanged otherwise
+func FarmSetAdmins(ctx context.Context, db *mongo.Database, id schema.ID, current, admins []generated.FarmAdmin) error {
+	if admins == nil {
+		admins = make([]generated.FarmAdmin, 0)
+	}
+
+	col := db.Collection(FarmCollection)
+	result, err := col.UpdateOne(ctx,
+		bson.M{"_id": id, "admins": current},
+		bson.M{"$set": bson.M{"admins": admins}},
+	)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrAdminsChanged
+	}
+
+	return nil
+}
+
+// FarmSetPendingOwner records the threebot that is offered the ownership of the farm
+// the transfer is only effective once accepted with FarmTransferOwnership
+func FarmSetPendingOwner(ctx context.Context, db *mongo.Database, id schema.ID, tid int64) error {
+	return farmUpdate(ctx, db, id, bson.M{"pending_owner": tid})
+}
+
+// FarmTransferOwnership makes tid the owner of the farm and clears any pending transfer.
+// tid is removed from the admin list since the owner is implicitly an admin
+func FarmTransferOwnership(ctx context.Context, db *mongo.Database, id schema.ID, tid int64) error {
+	farm, err := FarmFilter{}.WithID(id).Get(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	admins := make([]generated.FarmAdmin, 0, len(farm.Admins))
+	for _, admin := range farm.Admins {
+		if admin.ThreebotId == tid {
+			continue
+		}
+		admins = append(admins, admin)
+	}
+
+	return farmUpdate(ctx, db, id, bson.M{
+		"threebot_id":   tid,
+		"pending_owner": 0,
+		"admins":        admins,
+	})
+}
+
+var (
+	// ErrIPNotAvailable is returned when reserving an address that is unknown
+	// to the farm or already used by another reservation
+	ErrIPNotAvailable = apierror.New(apierror.CodeIPNotAvailable, "ip address is not available")
+)
+
+// ValidatePublicIP checks that ip can be added to a farm ip pool
+func ValidatePublicIP(ip generated.PublicIP) error {
+	if ip.Address.IP.To4() == nil {
+		return fmt.Errorf("only ipv4 addresses are supported")
+	}
+
+	if ip.Address.IP.Equal(ip.Address.IPNet.IP.Mask(ip.Address.Mask)) {
+		return fmt.Errorf("address can not be the network address")
+	}
+
+	if ip.Gateway.To4() == nil {
+		return fmt.Errorf("invalid gateway")
+	}
+
+	if !ip.Address.Contains(ip.Gateway) {
+		return fmt.Errorf("gateway '%s' is not in network '%s'", ip.Gateway, ip.Address.String())
+	}
+
+	if ip.Gateway.Equal(ip.Address.IP) {
+		return fmt.Errorf("address can not be the gateway")
+	}
+
+	return nil
+}
+
+// IPIndex return the position of address in the farm ip pool, -1 if not found
+func (f *Farm) IPIndex(address net.IP) int {
+	for i, ip := range f.IPAddresses {
+		if ip.Address.IP.Equal(address) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// FarmIPAdd adds a free address to the farm ip pool
+func FarmIPAdd(ctx context.Context, db *mongo.Database, id schema.ID, ip generated.PublicIP) error {
+	ip.ReservationId = 0
+
+	col := db.Collection(FarmCollection)
+	f := FarmFilter{}.WithID(id)
+	result, err := col.UpdateOne(ctx, f, bson.M{"$push": bson.M{"ip_addresses": ip}})
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+// FarmIPRemove removes address from the farm ip pool if it's not used by any reservation
+func FarmIPRemove(ctx context.Context, db *mongo.Database, id schema.ID, address net.IP) error {
+	farm, err := FarmFilter{}.WithID(id).Get(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	i := farm.IPIndex(address)
+	if i < 0 {
+		return ErrIPNotAvailable
+	}
+
 	col := db.Collection(FarmCollection)
 	f := FarmFilter{}.WithID(id)
-	_, err := col.UpdateOne(ctx, f, bson.M{"$set": farm})
+	result, err := col.UpdateOne(ctx, f, bson.M{
+		"$pull": bson.M{
+			"ip_addresses": bson.M{
+				"address":        farm.IPAddresses[i].Address,
+				"reservation_id": 0,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if result.ModifiedCount == 0 {
+		// the address got reserved in the mean time
+		return ErrIPNotAvailable
+	}
+
+	return nil
+}
+
+// FarmIPReserve marks address of the farm pool as used by reservation.
+// ErrIPNotAvailable is returned if the address is not part of the pool or is already reserved
+func FarmIPReserve(ctx context.Context, db *mongo.Database, id schema.ID, address net.IP, reservation schema.ID) error {
+	farm, err := FarmFilter{}.WithID(id).Get(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	i := farm.IPIndex(address)
+	if i < 0 || farm.IPAddresses[i].ReservationId != 0 {
+		return ErrIPNotAvailable
+	}
+
+	// the update only goes through if the address at this position is still
+	// the same and still free, which protects against concurrent reservations
+	f := FarmFilter{}.WithID(id)
+	f = append(f,
+		bson.E{Key: fmt.Sprintf("ip_addresses.%d.address", i), Value: farm.IPAddresses[i].Address},
+		bson.E{Key: fmt.Sprintf("ip_addresses.%d.reservation_id", i), Value: 0},
+	)
+
+	col := db.Collection(FarmCollection)
+	result, err := col.UpdateOne(ctx, f, bson.M{
+		"$set": bson.M{
+			fmt.Sprintf("ip_addresses.%d.reservation_id", i): reservation,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrIPNotAvailable
+	}
+
+	return nil
+}
+
+// FarmIPRelease frees all the addresses used by reservation
+func FarmIPRelease(ctx context.Context, db *mongo.Database, reservation schema.ID) error {
+	col := db.Collection(FarmCollection)
+	_, err := col.UpdateMany(ctx,
+		bson.M{"ip_addresses.reservation_id": reservation},
+		bson.M{"$set": bson.M{"ip_addresses.$[ip].reservation_id": 0}},
+		options.Update().SetArrayFilters(options.ArrayFilters{
+			Filters: []interface{}{bson.M{"ip.reservation_id": reservation}},
+		}),
+	)
+
 	return err
 }
+
+// FarmWalletSetVerified marks address as verified in all the wallet addresses of the farm
+func FarmWalletSetVerified(ctx context.Context, db *mongo.Database, id schema.ID, address string) error {
+	col := db.Collection(FarmCollection)
+	result, err := col.UpdateOne(ctx,
+		bson.M{"_id": id, "wallet_addresses.address": address},
+		bson.M{"$set": bson.M{
+			"wallet_addresses.$[addr].verified":    true,
+			"wallet_addresses.$[addr].verified_at": schema.Date{Time: time.Now()},
+		}},
+		options.Update().SetArrayFilters(options.ArrayFilters{
+			Filters: []interface{}{bson.M{"addr.address": address}},
+		}),
+	)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
@@ -0,0 +1,139 @@
+package types
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	generated "github.com/threefoldtech/tfexplorer/models/generated/directory"
+	"github.com/threefoldtech/tfexplorer/schema"
+)
+
+func TestFarmRoles(t *testing.T) {
+	farm := Farm{
+		ThreebotId: 1,
+		Admins: []generated.FarmAdmin{
+			{ThreebotId: 2, Role: generated.FarmRoleOwner},
+			{ThreebotId: 3, Role: generated.FarmRoleOperator},
+			{ThreebotId: 5},
+		},
+	}
+
+	tests := []struct {
+		tid     int64
+		isOwner bool
+		isAdmin bool
+	}{
+		{tid: 1, isOwner: true, isAdmin: true},
+		{tid: 2, isOwner: true, isAdmin: true},
+		{tid: 3, isOwner: false, isAdmin: true},
+		{tid: 4, isOwner: false, isAdmin: false},
+		{tid: 5, isOwner: false, isAdmin: false},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.isOwner, farm.IsOwner(tt.tid), "owner %d", tt.tid)
+		assert.Equal(t, tt.isAdmin, farm.IsAdmin(tt.tid), "admin %d", tt.tid)
+	}
+}
+
+func TestValidatePublicIP(t *testing.T) {
+	tests := []struct {
+		address string
+		gateway string
+		valid   bool
+	}{
+		{address: "185.69.166.10/24", gateway: "185.69.166.1", valid: true},
+		{address: "185.69.166.0/24", gateway: "185.69.166.1", valid: false},
+		{address: "185.69.166.1/24", gateway: "185.69.166.1", valid: false},
+		{address: "185.69.166.10/24", gateway: "185.69.167.1", valid: false},
+		{address: "2a02:1802:5e::10/64", gateway: "2a02:1802:5e::1", valid: false},
+	}
+
+	for _, tt := range tests {
+		ip := generated.PublicIP{
+			Address: schema.MustParseIPRange(tt.address),
+			Gateway: net.ParseIP(tt.gateway),
+		}
+		err := ValidatePublicIP(ip)
+		if tt.valid {
+			assert.NoError(t, err, tt.address)
+		} else {
+			assert.Error(t, err, tt.address)
+		}
+	}
+}
+
+func TestFarmIPIndex(t *testing.T) {
+	farm := Farm{
+		IPAddresses: []generated.PublicIP{
+			{Address: schema.MustParseIPRange("185.69.166.10/24")},
+			{Address: schema.MustParseIPRange("185.69.166.11/24")},
+		},
+	}
+
+	assert.Equal(t, 1, farm.IPIndex(net.ParseIP("185.69.166.11")))
+	assert.Equal(t, -1, farm.IPIndex(net.ParseIP("185.69.166.12")))
+}
+
+func TestFarmKeepVerification(t *testing.T) {
+	verifiedAt := schema.Date{Time: time.Now()}
+	current := []generated.WalletAddress{
+		{Asset: "TFT", Address: "GA1", Verified: true, VerifiedAt: verifiedAt},
+		{Asset: "FreeTFT", Address: "GA2", Verified: true, VerifiedAt: verifiedAt},
+	}
+
+	farm := Farm{
+		WalletAddresses: []generated.WalletAddress{
+			// unchanged
+			{Asset: "TFT", Address: "GA1"},
+			// address changed
+			{Asset: "FreeTFT", Address: "GA3"},
+			// can not be set by the farmer
+			{Asset: "TFTA", Address: "GA2", Verified: true},
+		},
+	}
+
+	farm.KeepVerification(current)
+	assert.True(t, farm.WalletAddresses[0].Verified)
+	assert.Equal(t, verifiedAt, farm.WalletAddresses[0].VerifiedAt)
+	assert.False(t, farm.WalletAddresses[1].Verified)
+	assert.False(t, farm.WalletAddresses[2].Verified)
+
+	farm.KeepVerification(nil)
+	assert.False(t, farm.WalletAddresses[0].Verified)
+	assert.True(t, farm.HasWalletAddress("GA3"))
+	assert.False(t, farm.HasWalletAddress("GA4"))
+}
+
+func TestFarmSetAdminsConflict(t *testing.T) {
+	farms := NewMemoryFarmRepository()
+	ctx := context.Background()
+
+	id, err := farms.Create(ctx, Farm{
+		Name:            "farm",
+		ThreebotId:      1,
+		WalletAddresses: []generated.WalletAddress{{Asset: "TFT", Address: "address"}},
+	})
+	require.NoError(t, err)
+
+	farm, err := farms.Get(ctx, id)
+	require.NoError(t, err)
+
+	first := []generated.FarmAdmin{{ThreebotId: 2, Role: generated.FarmRoleOwner}}
+	require.NoError(t, farms.SetAdmins(ctx, id, farm.Admins, first))
+
+	// a change made from the same stale list is refused instead of
+	// dropping the first one
+	second := []generated.FarmAdmin{{ThreebotId: 3, Role: generated.FarmRoleOperator}}
+	err = farms.SetAdmins(ctx, id, farm.Admins, second)
+	assert.True(t, errors.Is(err, ErrAdminsChanged))
+
+	farm, err = farms.Get(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, first, farm.Admins)
+}
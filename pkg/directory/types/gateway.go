@@ -57,6 +57,32 @@ func (n *Gateway) Validate() error {
 	return nil
 }
 
+// GatewayQueryFields are the fields of the gateways that can be used in list queries
+var GatewayQueryFields = models.Fields{
+	"id":                 {Key: "_id", Type: models.FieldInt, Sortable: true},
+	"node_id":            {Type: models.FieldString, Sortable: true},
+	"farm_id":            {Type: models.FieldInt, Sortable: true},
+	"os_version":         {Type: models.FieldString},
+	"created":            {Type: models.FieldDate, Sortable: true},
+	"updated":            {Type: models.FieldDate, Sortable: true},
+	"uptime":             {Type: models.FieldInt, Sortable: true},
+	"address":            {Type: models.FieldString},
+	"country":            {Key: "location.country", Type: models.FieldString},
+	"city":               {Key: "location.city", Type: models.FieldString},
+	"tcp_router_port":    {Type: models.FieldInt},
+	"free_to_use":        {Type: models.FieldBool},
+	"retired":            {Type: models.FieldBool},
+	"public_key_hex":     {Type: models.FieldString},
+	"location":           {Type: models.FieldObject},
+	"managed_domains":    {Type: models.FieldObject},
+	"dns_nameserver":     {Type: models.FieldObject},
+	"total_resources":    {Type: models.FieldObject},
+	"reserved_resources": {Type: models.FieldObject},
+	"workloads":          {Type: models.FieldObject},
+	"ifaces":             {Type: models.FieldObject},
+	"public_config":      {Type: models.FieldObject},
+}
+
 // GatewayFilter type
 type GatewayFilter bson.D
 
@@ -79,6 +105,32 @@ func (f GatewayFilter) WithGWIDs(ids []string) GatewayFilter {
 	return append(f, bson.E{Key: "node_id", Value: bson.M{"$in": a}})
 }
 
+// WithFarmID search gateways with given farmID
+func (f GatewayFilter) WithFarmID(id schema.ID) GatewayFilter {
+	return append(f, bson.E{Key: "farm_id", Value: id})
+}
+
+// WithTotalCap filter with total cap only units that > 0 are used
+// in the query
+func (f GatewayFilter) WithTotalCap(cru, mru, hru, sru int64) GatewayFilter {
+	for k, v := range map[string]int64{
+		"total_resources.cru": cru,
+		"total_resources.mru": mru,
+		"total_resources.hru": hru,
+		"total_resources.sru": sru} {
+		if v > 0 {
+			f = append(f, bson.E{Key: k, Value: bson.M{"$gte": v}})
+		}
+	}
+
+	return f
+}
+
+// WithManagedDomain search the gateways that manage domain
+func (f GatewayFilter) WithManagedDomain(domain string) GatewayFilter {
+	return append(f, bson.E{Key: "managed_domains", Value: NormalizeDomain(domain)})
+}
+
 // WithLocation search the nodes that are located in country and or city
 func (f GatewayFilter) WithLocation(country, city string) GatewayFilter {
 	if country != "" {
@@ -96,6 +148,19 @@ func (f GatewayFilter) WithFreeToUse(freeToUse bool) GatewayFilter {
 	return append(f, bson.E{Key: "free_to_use", Value: freeToUse})
 }
 
+// WithRetired search the gateways that are (or are not) decommissioned
+func (f GatewayFilter) WithRetired(retired bool) GatewayFilter {
+	if retired {
+		return append(f, bson.E{Key: "retired", Value: true})
+	}
+	return append(f, bson.E{Key: "retired", Value: bson.M{"$ne": true}})
+}
+
+// WithQuery filter gateways with the conditions of a list query
+func (f GatewayFilter) WithQuery(q models.Query) GatewayFilter {
+	return GatewayFilter(q.Apply(bson.D(f)))
+}
+
 // Find run the filter and return a cursor result
 func (f GatewayFilter) Find(ctx context.Context, db *mongo.Database, opts ...*options.FindOptions) (*mongo.Cursor, error) {
 	col := db.Collection(GatewayCollection)
@@ -158,26 +223,48 @@ func GatewayCreate(ctx context.Context, db *mongo.Database, gw Gateway) (schema.
 	current, err := filter.Get(ctx, db)
 	if err != nil {
 		//TODO: check that this is a NOT FOUND error
-		id, err = models.NextID(ctx, db, GatewayCollection)
+		id, err = models.NewIDGenerator(db, GatewayCollection).NextID(ctx)
 		if err != nil {
 			return id, err
 		}
-		gw.Created = schema.Date{Time: time.Now()}
+		gw.register(nil)
 	} else {
 		id = current.ID
-		// make sure we do NOT overwrite these field
-		gw.Created = current.Created
-		// gw.FreeToUse = current.FreeToUse
+		gw.register(&current)
 	}
 
 	gw.ID = id
-	gw.Updated = schema.Date{Time: time.Now()}
 
 	col := db.Collection(GatewayCollection)
 	_, err = col.UpdateOne(ctx, filter, bson.M{"$set": gw}, options.Update().SetUpsert(true))
 	return id, err
 }
 
+// register prepares the gateway to be stored, current is the gateway as it
+// is already registered if any. The fields that are not reported by the
+// gateway itself are kept
+func (n *Gateway) register(current *Gateway) {
+	if current == nil {
+		n.Created = schema.Date{Time: time.Now()}
+	} else {
+		// make sure we do NOT overwrite these field
+		n.Created = current.Created
+		n.FreeToUse = current.FreeToUse
+		// proofs are only pushed with the capacity report, re-registering
+		// must not wipe the history we compare new proofs against
+		n.Proofs = current.Proofs
+		n.PublicConfig = current.PublicConfig
+		n.Retired = current.Retired
+		n.RetiredAt = current.RetiredAt
+	}
+
+	if n.Proofs == nil {
+		n.Proofs = make([]generated.Proof, 0)
+	}
+
+	n.Updated = schema.Date{Time: time.Now()}
+}
+
 func gwUpdate(ctx context.Context, db *mongo.Database, nodeID string, value interface{}) error {
 	if nodeID == "" {
 		return fmt.Errorf("invalid node id")
@@ -193,10 +280,10 @@ func gwUpdate(ctx context.Context, db *mongo.Database, nodeID string, value inte
 	return err
 }
 
-// // gwUpdateTotalResources sets the node total resources
-// func gwUpdateTotalResources(ctx context.Context, db *mongo.Database, nodeID string, capacity generated.ResourceAmount) error {
-// 	return gwUpdate(ctx, db, nodeID, bson.M{"total_resources": capacity})
-// }
+// GatewayUpdateTotalResources sets the gateway total resources
+func GatewayUpdateTotalResources(ctx context.Context, db *mongo.Database, nodeID string, capacity generated.ResourceAmount) error {
+	return gwUpdate(ctx, db, nodeID, bson.M{"total_resources": capacity})
+}
 
 // GatewayUpdateReservedResources sets the node reserved resources
 func GatewayUpdateReservedResources(ctx context.Context, db *mongo.Database, nodeID string, capacity generated.ResourceAmount) error {
@@ -215,3 +302,50 @@ func GatewayUpdateUptime(ctx context.Context, db *mongo.Database, nodeID string,
 		"updated": schema.Date{Time: time.Now()},
 	})
 }
+
+// GatewaySetInterfaces updates gateway interfaces
+func GatewaySetInterfaces(ctx context.Context, db *mongo.Database, nodeID string, ifaces []generated.Iface) error {
+	return gwUpdate(ctx, db, nodeID, bson.M{
+		"ifaces": ifaces,
+	})
+}
+
+// GatewaySetPublicConfig sets gateway public config
+func GatewaySetPublicConfig(ctx context.Context, db *mongo.Database, nodeID string, cfg generated.PublicIface) error {
+	return gwUpdate(ctx, db, nodeID, bson.M{
+		"public_config": cfg,
+	})
+}
+
+// GatewayUpdateFreeToUse sets gateway free to use flag
+func GatewayUpdateFreeToUse(ctx context.Context, db *mongo.Database, nodeID string, freeToUse bool) error {
+	return gwUpdate(ctx, db, nodeID, bson.M{
+		"free_to_use": freeToUse,
+	})
+}
+
+// GatewaySetRetired marks a gateway as decommissioned
+func GatewaySetRetired(ctx context.Context, db *mongo.Database, nodeID string) error {
+	return gwUpdate(ctx, db, nodeID, bson.M{
+		"retired":    true,
+		"retired_at": schema.Date{Time: time.Now()},
+	})
+}
+
+// GatewayPushProof push proof to gateway
+func GatewayPushProof(ctx context.Context, db *mongo.Database, nodeID string, proof generated.Proof) error {
+	if nodeID == "" {
+		return fmt.Errorf("invalid node id")
+	}
+
+	col := db.Collection(GatewayCollection)
+	var filter GatewayFilter
+	filter = filter.WithGWID(nodeID)
+	_, err := col.UpdateOne(ctx, filter, bson.M{
+		"$addToSet": bson.M{
+			"proofs": proof,
+		},
+	})
+
+	return err
+}
@@ -62,6 +62,36 @@ func (n *Node) Validate() error {
 	return nil
 }
 
+// NodeQueryFields are the fields of the nodes that can be used in list queries
+var NodeQueryFields = models.Fields{
+	"id":                 {Key: "_id", Type: models.FieldInt, Sortable: true},
+	"node_id":            {Type: models.FieldString, Sortable: true},
+	"farm_id":            {Type: models.FieldInt, Sortable: true},
+	"os_version":         {Type: models.FieldString},
+	"created":            {Type: models.FieldDate, Sortable: true},
+	"updated":            {Type: models.FieldDate, Sortable: true},
+	"uptime":             {Type: models.FieldInt, Sortable: true},
+	"address":            {Type: models.FieldString},
+	"country":            {Key: "location.country", Type: models.FieldString},
+	"city":               {Key: "location.city", Type: models.FieldString},
+	"cru":                {Key: "total_resources.cru", Type: models.FieldInt, Sortable: true},
+	"mru":                {Key: "total_resources.mru", Type: models.FieldFloat, Sortable: true},
+	"hru":                {Key: "total_resources.hru", Type: models.FieldFloat, Sortable: true},
+	"sru":                {Key: "total_resources.sru", Type: models.FieldFloat, Sortable: true},
+	"free_to_use":        {Type: models.FieldBool},
+	"approved":           {Type: models.FieldBool},
+	"retired":            {Type: models.FieldBool},
+	"public_key_hex":     {Type: models.FieldString},
+	"location":           {Type: models.FieldObject},
+	"total_resources":    {Type: models.FieldObject},
+	"used_resources":     {Type: models.FieldObject},
+	"reserved_resources": {Type: models.FieldObject},
+	"workloads":          {Type: models.FieldObject},
+	"ifaces":             {Type: models.FieldObject},
+	"public_config":      {Type: models.FieldObject},
+	"wg_ports":           {Type: models.FieldObject},
+}
+
 // NodeFilter type
 type NodeFilter bson.D
 
@@ -122,6 +152,35 @@ func (f NodeFilter) WithFreeToUse(freeToUse bool) NodeFilter {
 	return append(f, bson.E{Key: "free_to_use", Value: freeToUse})
 }
 
+// WithRetired search the nodes that are (or are not) decommissioned
+func (f NodeFilter) WithRetired(retired bool) NodeFilter {
+	if retired {
+		return append(f, bson.E{Key: "retired", Value: true})
+	}
+	// nodes registered before decommissioning was introduced
+	// don't have the field set at all
+	return append(f, bson.E{Key: "retired", Value: bson.M{"$ne": true}})
+}
+
+// WithHardwareChanged search the nodes whose hardware changed (or not) since
+// the last acknowledgment of the farmer
+func (f NodeFilter) WithHardwareChanged(changed bool) NodeFilter {
+	if changed {
+		return append(f, bson.E{Key: "hardware_changed", Value: true})
+	}
+	return append(f, bson.E{Key: "hardware_changed", Value: bson.M{"$ne": true}})
+}
+
+// WithApproved search the nodes that are (or are not) approved by a certifier
+func (f NodeFilter) WithApproved(approved bool) NodeFilter {
+	return append(f, bson.E{Key: "approved", Value: approved})
+}
+
+// WithQuery filter nodes with the conditions of a list query
+func (f NodeFilter) WithQuery(q models.Query) NodeFilter {
+	return NodeFilter(q.Apply(bson.D(f)))
+}
+
 // Find run the filter and return a cursor result
 func (f NodeFilter) Find(ctx context.Context, db *mongo.Database, opts ...*options.FindOptions) (*mongo.Cursor, error) {
 	col := db.Collection(NodeCollection)
@@ -195,32 +254,59 @@ func NodeCreate(ctx context.Context, db *mongo.Database, node Node) (schema.ID,
 	var filter NodeFilter
 	filter = filter.WithNodeID(node.NodeId)
 	var id schema.ID
-	current, err := filter.Get(ctx, db, false)
+	current, err := filter.Get(ctx, db, true)
 	if err != nil {
 		//TODO: check that this is a NOT FOUND error
-		id, err = models.NextID(ctx, db, NodeCollection)
+		id, err = models.NewIDGenerator(db, NodeCollection).NextID(ctx)
 		if err != nil {
 			return id, err
 		}
-		node.Created = schema.Date{Time: time.Now()}
+		node.register(nil)
 	} else {
 		id = current.ID
-		// make sure we do NOT overwrite these field
-		node.Created = current.Created
-		node.FreeToUse = current.FreeToUse
+		node.register(&current)
 	}
 
 	node.ID = id
-	if node.Proofs == nil {
-		node.Proofs = make([]generated.Proof, 0)
-	}
-
-	node.Updated = schema.Date{Time: time.Now()}
 	col := db.Collection(NodeCollection)
 	_, err = col.UpdateOne(ctx, filter, bson.M{"$set": node}, options.Update().SetUpsert(true))
 	return id, err
 }
 
+// register prepares the node to be stored, current is the node as it is
+// already registered if any. The fields that are not reported by the node
+// itself are kept
+func (n *Node) register(current *Node) {
+	if current == nil {
+		n.Created = schema.Date{Time: time.Now()}
+		// only certifiers can approve a node
+		n.Approved = false
+		n.Approvals = nil
+	} else {
+		// make sure we do NOT overwrite these field
+		n.Created = current.Created
+		n.FreeToUse = current.FreeToUse
+		n.Retired = current.Retired
+		n.RetiredAt = current.RetiredAt
+		n.HardwareChanged = current.HardwareChanged
+		n.HardwareChangedAt = current.HardwareChangedAt
+		// proofs are only pushed with the capacity report, re-registering
+		// must not wipe the history we compare new proofs against
+		n.Proofs = current.Proofs
+		n.Approved = current.Approved
+		n.Approvals = current.Approvals
+	}
+
+	if n.Proofs == nil {
+		n.Proofs = make([]generated.Proof, 0)
+	}
+	if n.Approvals == nil {
+		n.Approvals = make([]generated.NodeApproval, 0)
+	}
+
+	n.Updated = schema.Date{Time: time.Now()}
+}
+
 func nodeUpdate(ctx context.Context, db *mongo.Database, nodeID string, value interface{}) error {
 	if nodeID == "" {
 		return fmt.Errorf("invalid node id")
@@ -285,6 +371,44 @@ func NodeUpdateFreeToUse(ctx context.Context, db *mongo.Database, nodeID string,
 	})
 }
 
+// NodeSetRetired marks a node as decommissioned
+func NodeSetRetired(ctx context.Context, db *mongo.Database, nodeID string) error {
+	return nodeUpdate(ctx, db, nodeID, bson.M{
+		"retired":    true,
+		"retired_at": schema.Date{Time: time.Now()},
+	})
+}
+
+// NodeSetHardwareChanged sets or clears the hardware changed flag of a node
+func NodeSetHardwareChanged(ctx context.Context, db *mongo.Database, nodeID string, changed bool) error {
+	value := bson.M{"hardware_changed": changed}
+	if changed {
+		value["hardware_changed_at"] = schema.Date{Time: time.Now()}
+	}
+	return nodeUpdate(ctx, db, nodeID, value)
+}
+
+// NodeSetApproval approves or revokes a node and records the decision in the node approvals history
+func NodeSetApproval(ctx context.Context, db *mongo.Database, nodeID string, approval generated.NodeApproval) error {
+	if nodeID == "" {
+		return fmt.Errorf("invalid node id")
+	}
+
+	col := db.Collection(NodeCollection)
+	var filter NodeFilter
+	filter = filter.WithNodeID(nodeID)
+	_, err := col.UpdateOne(ctx, filter, bson.M{
+		"$set": bson.M{
+			"approved": approval.Approved,
+		},
+		"$push": bson.M{
+			"approvals": approval,
+		},
+	})
+
+	return err
+}
+
 // NodeSetWGPorts update wireguard ports
 func NodeSetWGPorts(ctx context.Context, db *mongo.Database, nodeID string, ports []uint) error {
 	return nodeUpdate(ctx, db, nodeID, bson.M{
@@ -0,0 +1,466 @@
+package types
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/tfexplorer/models"
+	generated "github.com/threefoldtech/tfexplorer/models/generated/directory"
+	"github.com/threefoldtech/tfexplorer/schema"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The repositories return mongo.ErrNoDocuments when the farm, node or gateway
+// an operation reads does not exist. The updates of unknown nodes and
+// gateways are ignored
+
+// FarmRepository stores the farms
+type FarmRepository interface {
+	// Get returns the farm with id
+	Get(ctx context.Context, id schema.ID) (Farm, error)
+	// GetByName returns the farm called name
+	GetByName(ctx context.Context, name string) (Farm, error)
+	// List returns the page of the farms matching filter, the count of the
+	// matching farms is only returned if the page is selected by its number
+	List(ctx context.Context, filter FarmFilter, pager models.Pager, opts ...*options.FindOptions) ([]Farm, int64, error)
+	// Create validates and stores a new farm, it returns its id
+	Create(ctx context.Context, farm Farm) (schema.ID, error)
+	// Update sets the fields of the farm with id its owner can edit, the
+	// verification of the wallet addresses that did not change is kept
+	Update(ctx context.Context, id schema.ID, farm Farm) error
+	// SetAdmins replaces the list of admins of the farm, only if current is
+	// still its list of admins. ErrAdminsChanged otherwise
+	SetAdmins(ctx context.Context, id schema.ID, current, admins []generated.FarmAdmin) error
+	// SetPendingOwner offers the ownership of the farm to tid
+	SetPendingOwner(ctx context.Context, id schema.ID, tid int64) error
+	// TransferOwnership makes tid the owner of the farm
+	TransferOwnership(ctx context.Context, id schema.ID, tid int64) error
+	// AddIP adds a free address to the farm ip pool
+	AddIP(ctx context.Context, id schema.ID, ip generated.PublicIP) error
+	// RemoveIP removes a free address from the farm ip pool
+	RemoveIP(ctx context.Context, id schema.ID, address net.IP) error
+	// ReserveIP marks an address of the farm ip pool as used by reservation
+	ReserveIP(ctx context.Context, id schema.ID, address net.IP, reservation schema.ID) error
+	// ReleaseIPs frees all the addresses used by reservation
+	ReleaseIPs(ctx context.Context, reservation schema.ID) error
+	// SetWalletVerified marks a wallet address of the farm as verified
+	SetWalletVerified(ctx context.Context, id schema.ID, address string) error
+	// Delete removes the farm with id
+	Delete(ctx context.Context, id schema.ID) error
+}
+
+// NodeRepository stores the nodes
+type NodeRepository interface {
+	// Get returns the node with nodeID, its proofs are only loaded if includeProofs is set
+	Get(ctx context.Context, nodeID string, includeProofs bool) (Node, error)
+	// Exists tests if the node with nodeID exists
+	Exists(ctx context.Context, nodeID string) (bool, error)
+	// List returns the page of the nodes matching filter, the count of the
+	// matching nodes is only returned if the page is selected by its number
+	List(ctx context.Context, filter NodeFilter, pager models.Pager, opts ...*options.FindOptions) ([]Node, int64, error)
+	// Count returns the number of nodes matching filter
+	Count(ctx context.Context, filter NodeFilter) (int64, error)
+	// Create registers a node, or updates it if it is already registered
+	Create(ctx context.Context, node Node) (schema.ID, error)
+	SetTotalResources(ctx context.Context, nodeID string, capacity generated.ResourceAmount) error
+	SetReservedResources(ctx context.Context, nodeID string, capacity generated.ResourceAmount) error
+	SetWorkloadsAmount(ctx context.Context, nodeID string, workloads generated.WorkloadAmount) error
+	SetUptime(ctx context.Context, nodeID string, uptime int64) error
+	SetFreeToUse(ctx context.Context, nodeID string, freeToUse bool) error
+	SetInterfaces(ctx context.Context, nodeID string, ifaces []generated.Iface) error
+	SetPublicConfig(ctx context.Context, nodeID string, cfg generated.PublicIface) error
+	SetWGPorts(ctx context.Context, nodeID string, ports []uint) error
+	// SetRetired marks the node as decommissioned
+	SetRetired(ctx context.Context, nodeID string) error
+	SetHardwareChanged(ctx context.Context, nodeID string, changed bool) error
+	// SetApproval records the approval and sets the node approval state
+	SetApproval(ctx context.Context, nodeID string, approval generated.NodeApproval) error
+	// PushProof adds proof to the proofs of the node if it is not there yet
+	PushProof(ctx context.Context, nodeID string, proof generated.Proof) error
+}
+
+// GatewayRepository stores the gateways and the domains claimed on them
+type GatewayRepository interface {
+	// Get returns the gateway with gwID
+	Get(ctx context.Context, gwID string) (Gateway, error)
+	// Exists tests if the gateway with gwID exists
+	Exists(ctx context.Context, gwID string) (bool, error)
+	// List returns the page of the gateways matching filter, the count of the
+	// matching gateways is only returned if the page is selected by its number
+	List(ctx context.Context, filter GatewayFilter, pager models.Pager, opts ...*options.FindOptions) ([]Gateway, int64, error)
+	// Count returns the number of gateways matching filter
+	Count(ctx context.Context, filter GatewayFilter) (int64, error)
+	// Create registers a gateway, or updates it if it is already registered
+	Create(ctx context.Context, gw Gateway) (schema.ID, error)
+	SetTotalResources(ctx context.Context, gwID string, capacity generated.ResourceAmount) error
+	SetReservedResources(ctx context.Context, gwID string, capacity generated.ResourceAmount) error
+	SetWorkloadsAmount(ctx context.Context, gwID string, workloads generated.WorkloadAmount) error
+	SetUptime(ctx context.Context, gwID string, uptime int64) error
+	SetFreeToUse(ctx context.Context, gwID string, freeToUse bool) error
+	SetInterfaces(ctx context.Context, gwID string, ifaces []generated.Iface) error
+	SetPublicConfig(ctx context.Context, gwID string, cfg generated.PublicIface) error
+	// SetRetired marks the gateway as decommissioned
+	SetRetired(ctx context.Context, gwID string) error
+	// PushProof adds proof to the proofs of the gateway if it is not there yet
+	PushProof(ctx context.Context, gwID string, proof generated.Proof) error
+	// Domains lists the domains claimed on the gateway, sorted by name
+	Domains(ctx context.Context, gwID string) ([]DomainClaim, error)
+	// GetDomain returns the claim on domain, mongo.ErrNoDocuments if it is free
+	GetDomain(ctx context.Context, domain string) (DomainClaim, error)
+	// ClaimDomain records a claim on a domain, ErrDomainClaimed if it is already held
+	ClaimDomain(ctx context.Context, claim DomainClaim) error
+	// ReleaseDomains frees all the domains held by reservation
+	ReleaseDomains(ctx context.Context, reservation schema.ID) error
+}
+
+// CapacityRepository stores the capacity history of the nodes
+type CapacityRepository interface {
+	// Record accounts the capacity of node at the given time in all
+	// the resolutions of the history
+	Record(ctx context.Context, node Node, at time.Time) error
+	// History returns the average capacity of the buckets matching
+	// filter for each step
+	History(ctx context.Context, filter CapacityFilter, step time.Duration) ([]CapacityPoint, error)
+}
+
+// WalletChallengeRepository stores the pending challenges of the farm wallet addresses
+type WalletChallengeRepository interface {
+	// Create generates a new challenge for the address of the farm, replacing
+	// any challenge that was pending for it
+	Create(ctx context.Context, farmID schema.ID, address string) (WalletChallenge, error)
+	// Get returns the pending challenge of the address of the farm,
+	// ErrChallengeNotFound if there is none or it expired
+	Get(ctx context.Context, farmID schema.ID, address string) (WalletChallenge, error)
+	// Delete removes the challenge of the address of the farm
+	Delete(ctx context.Context, farmID schema.ID, address string) error
+}
+
+// StatsRepository aggregates the capacity and the reservations of the grid.
+// The nodes that reported after onlineSince are counted as online
+type StatsRepository interface {
+	Grid(ctx context.Context, onlineSince time.Time) (Stats, error)
+	PerFarm(ctx context.Context, onlineSince time.Time) ([]FarmStats, error)
+	PerCountry(ctx context.Context, onlineSince time.Time) ([]CountryStats, error)
+	// CountReservations counts the deployed reservations per farm, per country and grid wide
+	CountReservations(ctx context.Context) (ReservationCounts, error)
+}
+
+// NewFarmRepository returns a FarmRepository backed by db
+func NewFarmRepository(db *mongo.Database) FarmRepository {
+	return &farmRepository{db: db}
+}
+
+type farmRepository struct {
+	db *mongo.Database
+}
+
+func (f *farmRepository) Get(ctx context.Context, id schema.ID) (Farm, error) {
+	return FarmFilter{}.WithID(id).Get(ctx, f.db)
+}
+
+func (f *farmRepository) GetByName(ctx context.Context, name string) (Farm, error) {
+	return FarmFilter{}.WithName(name).Get(ctx, f.db)
+}
+
+func (f *farmRepository) List(ctx context.Context, filter FarmFilter, pager models.Pager, opts ...*options.FindOptions) ([]Farm, int64, error) {
+	farms := []Farm{}
+	total, err := models.List(ctx, f.db.Collection(FarmCollection), bson.D(filter), pager, &farms, opts...)
+	return farms, total, err
+}
+
+func (f *farmRepository) Create(ctx context.Context, farm Farm) (schema.ID, error) {
+	return FarmCreate(ctx, f.db, farm)
+}
+
+func (f *farmRepository) Update(ctx context.Context, id schema.ID, farm Farm) error {
+	return FarmUpdate(ctx, f.db, id, farm)
+}
+
+func (f *farmRepository) SetAdmins(ctx context.Context, id schema.ID, current, admins []generated.FarmAdmin) error {
+	return FarmSetAdmins(ctx, f.db, id, current, admins)
+}
+
+func (f *farmRepository) SetPendingOwner(ctx context.Context, id schema.ID, tid int64) error {
+	return FarmSetPendingOwner(ctx, f.db, id, tid)
+}
+
+func (f *farmRepository) TransferOwnership(ctx context.Context, id schema.ID, tid int64) error {
+	return FarmTransferOwnership(ctx, f.db, id, tid)
+}
+
+func (f *farmRepository) AddIP(ctx context.Context, id schema.ID, ip generated.PublicIP) error {
+	return FarmIPAdd(ctx, f.db, id, ip)
+}
+
+func (f *farmRepository) RemoveIP(ctx context.Context, id schema.ID, address net.IP) error {
+	return FarmIPRemove(ctx, f.db, id, address)
+}
+
+func (f *farmRepository) ReserveIP(ctx context.Context, id schema.ID, address net.IP, reservation schema.ID) error {
+	return FarmIPReserve(ctx, f.db, id, address, reservation)
+}
+
+func (f *farmRepository) ReleaseIPs(ctx context.Context, reservation schema.ID) error {
+	return FarmIPRelease(ctx, f.db, reservation)
+}
+
+func (f *farmRepository) SetWalletVerified(ctx context.Context, id schema.ID, address string) error {
+	return FarmWalletSetVerified(ctx, f.db, id, address)
+}
+
+func (f *farmRepository) Delete(ctx context.Context, id schema.ID) error {
+	return FarmFilter{}.WithID(id).Delete(ctx, f.db)
+}
+
+// NewNodeRepository returns a NodeRepository backed by db
+func NewNodeRepository(db *mongo.Database) NodeRepository {
+	return &nodeRepository{db: db}
+}
+
+type nodeRepository struct {
+	db *mongo.Database
+}
+
+func (n *nodeRepository) Get(ctx context.Context, nodeID string, includeProofs bool) (Node, error) {
+	return NodeFilter{}.WithNodeID(nodeID).Get(ctx, n.db, includeProofs)
+}
+
+func (n *nodeRepository) Exists(ctx context.Context, nodeID string) (bool, error) {
+	count, err := NodeFilter{}.WithNodeID(nodeID).Count(ctx, n.db)
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+func (n *nodeRepository) List(ctx context.Context, filter NodeFilter, pager models.Pager, opts ...*options.FindOptions) ([]Node, int64, error) {
+	nodes := []Node{}
+	total, err := models.List(ctx, n.db.Collection(NodeCollection), bson.D(filter), pager, &nodes, opts...)
+	return nodes, total, err
+}
+
+func (n *nodeRepository) Count(ctx context.Context, filter NodeFilter) (int64, error) {
+	return filter.Count(ctx, n.db)
+}
+
+func (n *nodeRepository) Create(ctx context.Context, node Node) (schema.ID, error) {
+	return NodeCreate(ctx, n.db, node)
+}
+
+func (n *nodeRepository) SetTotalResources(ctx context.Context, nodeID string, capacity generated.ResourceAmount) error {
+	return NodeUpdateTotalResources(ctx, n.db, nodeID, capacity)
+}
+
+func (n *nodeRepository) SetReservedResources(ctx context.Context, nodeID string, capacity generated.ResourceAmount) error {
+	return NodeUpdateReservedResources(ctx, n.db, nodeID, capacity)
+}
+
+func (n *nodeRepository) SetWorkloadsAmount(ctx context.Context, nodeID string, workloads generated.WorkloadAmount) error {
+	return NodeUpdateWorkloadsAmount(ctx, n.db, nodeID, workloads)
+}
+
+func (n *nodeRepository) SetUptime(ctx context.Context, nodeID string, uptime int64) error {
+	return NodeUpdateUptime(ctx, n.db, nodeID, uptime)
+}
+
+func (n *nodeRepository) SetFreeToUse(ctx context.Context, nodeID string, freeToUse bool) error {
+	return NodeUpdateFreeToUse(ctx, n.db, nodeID, freeToUse)
+}
+
+func (n *nodeRepository) SetInterfaces(ctx context.Context, nodeID string, ifaces []generated.Iface) error {
+	return NodeSetInterfaces(ctx, n.db, nodeID, ifaces)
+}
+
+func (n *nodeRepository) SetPublicConfig(ctx context.Context, nodeID string, cfg generated.PublicIface) error {
+	return NodeSetPublicConfig(ctx, n.db, nodeID, cfg)
+}
+
+func (n *nodeRepository) SetWGPorts(ctx context.Context, nodeID string, ports []uint) error {
+	return NodeSetWGPorts(ctx, n.db, nodeID, ports)
+}
+
+func (n *nodeRepository) SetRetired(ctx context.Context, nodeID string) error {
+	return NodeSetRetired(ctx, n.db, nodeID)
+}
+
+func (n *nodeRepository) SetHardwareChanged(ctx context.Context, nodeID string, changed bool) error {
+	return NodeSetHardwareChanged(ctx, n.db, nodeID, changed)
+}
+
+func (n *nodeRepository) SetApproval(ctx context.Context, nodeID string, approval generated.NodeApproval) error {
+	return NodeSetApproval(ctx, n.db, nodeID, approval)
+}
+
+func (n *nodeRepository) PushProof(ctx context.Context, nodeID string, proof generated.Proof) error {
+	return NodePushProof(ctx, n.db, nodeID, proof)
+}
+
+// NewGatewayRepository returns a GatewayRepository backed by db
+func NewGatewayRepository(db *mongo.Database) GatewayRepository {
+	return &gatewayRepository{db: db}
+}
+
+type gatewayRepository struct {
+	db *mongo.Database
+}
+
+func (g *gatewayRepository) Get(ctx context.Context, gwID string) (Gateway, error) {
+	return GatewayFilter{}.WithGWID(gwID).Get(ctx, g.db)
+}
+
+func (g *gatewayRepository) Exists(ctx context.Context, gwID string) (bool, error) {
+	count, err := GatewayFilter{}.WithGWID(gwID).Count(ctx, g.db)
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+func (g *gatewayRepository) List(ctx context.Context, filter GatewayFilter, pager models.Pager, opts ...*options.FindOptions) ([]Gateway, int64, error) {
+	gateways := []Gateway{}
+	total, err := models.List(ctx, g.db.Collection(GatewayCollection), bson.D(filter), pager, &gateways, opts...)
+	return gateways, total, err
+}
+
+func (g *gatewayRepository) Count(ctx context.Context, filter GatewayFilter) (int64, error) {
+	return filter.Count(ctx, g.db)
+}
+
+func (g *gatewayRepository) Create(ctx context.Context, gw Gateway) (schema.ID, error) {
+	return GatewayCreate(ctx, g.db, gw)
+}
+
+func (g *gatewayRepository) SetTotalResources(ctx context.Context, gwID string, capacity generated.ResourceAmount) error {
+	return GatewayUpdateTotalResources(ctx, g.db, gwID, capacity)
+}
+
+func (g *gatewayRepository) SetReservedResources(ctx context.Context, gwID string, capacity generated.ResourceAmount) error {
+	return GatewayUpdateReservedResources(ctx, g.db, gwID, capacity)
+}
+
+func (g *gatewayRepository) SetWorkloadsAmount(ctx context.Context, gwID string, workloads generated.WorkloadAmount) error {
+	return GatewayUpdateWorkloadsAmount(ctx, g.db, gwID, workloads)
+}
+
+func (g *gatewayRepository) SetUptime(ctx context.Context, gwID string, uptime int64) error {
+	return GatewayUpdateUptime(ctx, g.db, gwID, uptime)
+}
+
+func (g *gatewayRepository) SetFreeToUse(ctx context.Context, gwID string, freeToUse bool) error {
+	return GatewayUpdateFreeToUse(ctx, g.db, gwID, freeToUse)
+}
+
+func (g *gatewayRepository) SetInterfaces(ctx context.Context, gwID string, ifaces []generated.Iface) error {
+	return GatewaySetInterfaces(ctx, g.db, gwID, ifaces)
+}
+
+func (g *gatewayRepository) SetPublicConfig(ctx context.Context, gwID string, cfg generated.PublicIface) error {
+	return GatewaySetPublicConfig(ctx, g.db, gwID, cfg)
+}
+
+func (g *gatewayRepository) SetRetired(ctx context.Context, gwID string) error {
+	return GatewaySetRetired(ctx, g.db, gwID)
+}
+
+func (g *gatewayRepository) PushProof(ctx context.Context, gwID string, proof generated.Proof) error {
+	return GatewayPushProof(ctx, g.db, gwID, proof)
+}
+
+func (g *gatewayRepository) Domains(ctx context.Context, gwID string) ([]DomainClaim, error) {
+	var filter DomainFilter
+	filter = filter.WithGatewayID(gwID)
+
+	cur, err := filter.Find(ctx, g.db, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list domains")
+	}
+
+	defer cur.Close(ctx)
+	out := []DomainClaim{}
+	if err := cur.All(ctx, &out); err != nil {
+		return nil, errors.Wrap(err, "failed to load domain list")
+	}
+
+	return out, nil
+}
+
+func (g *gatewayRepository) GetDomain(ctx context.Context, domain string) (DomainClaim, error) {
+	return DomainFilter{}.WithDomain(domain).Get(ctx, g.db)
+}
+
+func (g *gatewayRepository) ClaimDomain(ctx context.Context, claim DomainClaim) error {
+	return DomainClaimCreate(ctx, g.db, claim)
+}
+
+func (g *gatewayRepository) ReleaseDomains(ctx context.Context, reservation schema.ID) error {
+	return DomainClaimRelease(ctx, g.db, reservation)
+}
+
+// NewCapacityRepository returns a CapacityRepository backed by db
+func NewCapacityRepository(db *mongo.Database) CapacityRepository {
+	return &capacityRepository{db: db}
+}
+
+type capacityRepository struct {
+	db *mongo.Database
+}
+
+func (c *capacityRepository) Record(ctx context.Context, node Node, at time.Time) error {
+	return CapacityRecord(ctx, c.db, node, at)
+}
+
+func (c *capacityRepository) History(ctx context.Context, filter CapacityFilter, step time.Duration) ([]CapacityPoint, error) {
+	return CapacityHistory(ctx, c.db, filter, step)
+}
+
+// NewWalletChallengeRepository returns a WalletChallengeRepository backed by db
+func NewWalletChallengeRepository(db *mongo.Database) WalletChallengeRepository {
+	return &walletChallengeRepository{db: db}
+}
+
+type walletChallengeRepository struct {
+	db *mongo.Database
+}
+
+func (w *walletChallengeRepository) Create(ctx context.Context, farmID schema.ID, address string) (WalletChallenge, error) {
+	return WalletChallengeCreate(ctx, w.db, farmID, address)
+}
+
+func (w *walletChallengeRepository) Get(ctx context.Context, farmID schema.ID, address string) (WalletChallenge, error) {
+	return WalletChallengeGet(ctx, w.db, farmID, address)
+}
+
+func (w *walletChallengeRepository) Delete(ctx context.Context, farmID schema.ID, address string) error {
+	return WalletChallengeDelete(ctx, w.db, farmID, address)
+}
+
+// NewStatsRepository returns a StatsRepository that aggregates the collections of db
+func NewStatsRepository(db *mongo.Database) StatsRepository {
+	return &statsRepository{db: db}
+}
+
+type statsRepository struct {
+	db *mongo.Database
+}
+
+func (s *statsRepository) Grid(ctx context.Context, onlineSince time.Time) (Stats, error) {
+	return StatsGrid(ctx, s.db, onlineSince)
+}
+
+func (s *statsRepository) PerFarm(ctx context.Context, onlineSince time.Time) ([]FarmStats, error) {
+	return StatsPerFarm(ctx, s.db, onlineSince)
+}
+
+func (s *statsRepository) PerCountry(ctx context.Context, onlineSince time.Time) ([]CountryStats, error) {
+	return StatsPerCountry(ctx, s.db, onlineSince)
+}
+
+func (s *statsRepository) CountReservations(ctx context.Context) (ReservationCounts, error) {
+	return CountReservations(ctx, s.db)
+}
@@ -0,0 +1,1045 @@
+package types
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/tfexplorer/models"
+	generated "github.com/threefoldtech/tfexplorer/models/generated/directory"
+	workloads "github.com/threefoldtech/tfexplorer/pkg/workloads/types"
+	"github.com/threefoldtech/tfexplorer/schema"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// NewMemoryFarmRepository returns a FarmRepository that keeps the farms in memory
+func NewMemoryFarmRepository() FarmRepository {
+	return &memoryFarmRepository{farms: models.NewMemoryCollection()}
+}
+
+type memoryFarmRepository struct {
+	mu    sync.Mutex
+	farms *models.MemoryCollection
+}
+
+func (m *memoryFarmRepository) get(id schema.ID) (farm Farm, err error) {
+	err = m.farms.Get(id, &farm)
+	return
+}
+
+// update applies fn to the farm with id and stores it
+func (m *memoryFarmRepository) update(id schema.ID, fn func(farm *Farm) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	farm, err := m.get(id)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(&farm); err != nil {
+		return err
+	}
+
+	return m.farms.Put(id, farm)
+}
+
+func (m *memoryFarmRepository) Get(ctx context.Context, id schema.ID) (Farm, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.get(id)
+}
+
+func (m *memoryFarmRepository) GetByName(ctx context.Context, name string) (Farm, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range m.farms.Keys() {
+		farm, err := m.get(key.(schema.ID))
+		if err != nil {
+			return farm, err
+		}
+
+		if farm.Name == name {
+			return farm, nil
+		}
+	}
+
+	return Farm{}, mongo.ErrNoDocuments
+}
+
+func (m *memoryFarmRepository) List(ctx context.Context, filter FarmFilter, pager models.Pager, opts ...*options.FindOptions) ([]Farm, int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	farms := []Farm{}
+	total, err := m.farms.List(bson.D(filter), pager, &farms, opts...)
+	return farms, total, err
+}
+
+func (m *memoryFarmRepository) Create(ctx context.Context, farm Farm) (schema.ID, error) {
+	if err := farm.register(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	farm.ID = m.farms.NextID()
+	return farm.ID, m.farms.Put(farm.ID, farm)
+}
+
+func (m *memoryFarmRepository) Update(ctx context.Context, id schema.ID, farm Farm) error {
+	err := m.update(id, func(current *Farm) error {
+		farm.ID = id
+		farm.ThreebotId = current.ThreebotId
+		if err := farm.Validate(); err != nil {
+			return err
+		}
+
+		farm.KeepVerification(current.WalletAddresses)
+
+		current.Name = farm.Name
+		current.IyoOrganization = farm.IyoOrganization
+		current.Email = farm.Email
+		current.WalletAddresses = farm.WalletAddresses
+		current.ResourcePrices = farm.ResourcePrices
+		current.Location = farm.Location
+		current.PrefixZero = farm.PrefixZero
+		return nil
+	})
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// like an update that matches no farm
+		return nil
+	}
+
+	return err
+}
+
+func (m *memoryFarmRepository) SetAdmins(ctx context.Context, id schema.ID, current, admins []generated.FarmAdmin) error {
+	if admins == nil {
+		admins = make([]generated.FarmAdmin, 0)
+	}
+
+	err := m.update(id, func(farm *Farm) error {
+		if len(farm.Admins) != len(current) {
+			return ErrAdminsChanged
+		}
+
+		for i := range current {
+			if farm.Admins[i] != current[i] {
+				return ErrAdminsChanged
+			}
+		}
+
+		farm.Admins = admins
+		return nil
+	})
+
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrAdminsChanged
+	}
+
+	return err
+}
+
+func (m *memoryFarmRepository) SetPendingOwner(ctx context.Context, id schema.ID, tid int64) error {
+	return m.update(id, func(farm *Farm) error {
+		farm.PendingOwner = tid
+		return nil
+	})
+}
+
+func (m *memoryFarmRepository) TransferOwnership(ctx context.Context, id schema.ID, tid int64) error {
+	return m.update(id, func(farm *Farm) error {
+		admins := make([]generated.FarmAdmin, 0, len(farm.Admins))
+		for _, admin := range farm.Admins {
+			if admin.ThreebotId == tid {
+				continue
+			}
+			admins = append(admins, admin)
+		}
+
+		farm.ThreebotId = tid
+		farm.PendingOwner = 0
+		farm.Admins = admins
+		return nil
+	})
+}
+
+func (m *memoryFarmRepository) AddIP(ctx context.Context, id schema.ID, ip generated.PublicIP) error {
+	ip.ReservationId = 0
+
+	return m.update(id, func(farm *Farm) error {
+		farm.IPAddresses = append(farm.IPAddresses, ip)
+		return nil
+	})
+}
+
+func (m *memoryFarmRepository) RemoveIP(ctx context.Context, id schema.ID, address net.IP) error {
+	return m.update(id, func(farm *Farm) error {
+		i := farm.IPIndex(address)
+		if i < 0 || farm.IPAddresses[i].ReservationId != 0 {
+			return ErrIPNotAvailable
+		}
+
+		farm.IPAddresses = append(farm.IPAddresses[:i], farm.IPAddresses[i+1:]...)
+		return nil
+	})
+}
+
+func (m *memoryFarmRepository) ReserveIP(ctx context.Context, id schema.ID, address net.IP, reservation schema.ID) error {
+	return m.update(id, func(farm *Farm) error {
+		i := farm.IPIndex(address)
+		if i < 0 || farm.IPAddresses[i].ReservationId != 0 {
+			return ErrIPNotAvailable
+		}
+
+		farm.IPAddresses[i].ReservationId = int64(reservation)
+		return nil
+	})
+}
+
+func (m *memoryFarmRepository) ReleaseIPs(ctx context.Context, reservation schema.ID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range m.farms.Keys() {
+		farm, err := m.get(key.(schema.ID))
+		if err != nil {
+			return err
+		}
+
+		released := false
+		for i := range farm.IPAddresses {
+			if farm.IPAddresses[i].ReservationId == int64(reservation) {
+				farm.IPAddresses[i].ReservationId = 0
+				released = true
+			}
+		}
+
+		if !released {
+			continue
+		}
+
+		if err := m.farms.Put(farm.ID, farm); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *memoryFarmRepository) SetWalletVerified(ctx context.Context, id schema.ID, address string) error {
+	return m.update(id, func(farm *Farm) error {
+		found := false
+		for i := range farm.WalletAddresses {
+			if farm.WalletAddresses[i].Address != address {
+				continue
+			}
+
+			farm.WalletAddresses[i].Verified = true
+			farm.WalletAddresses[i].VerifiedAt = schema.Date{Time: time.Now()}
+			found = true
+		}
+
+		if !found {
+			return mongo.ErrNoDocuments
+		}
+
+		return nil
+	})
+}
+
+func (m *memoryFarmRepository) Delete(ctx context.Context, id schema.ID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.farms.Delete(id)
+	return nil
+}
+
+// hasProof tests if proof is already in proofs, comparing the stored
+// documents like $addToSet does
+func hasProof(proofs []generated.Proof, proof generated.Proof) (bool, error) {
+	data, err := bson.Marshal(proof)
+	if err != nil {
+		return false, err
+	}
+
+	for _, p := range proofs {
+		existing, err := bson.Marshal(p)
+		if err != nil {
+			return false, err
+		}
+
+		if bytes.Equal(existing, data) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// NewMemoryNodeRepository returns a NodeRepository that keeps the nodes in
+// memory. Nodes can only be registered on the farms known to farms
+func NewMemoryNodeRepository(farms FarmRepository) NodeRepository {
+	return &memoryNodeRepository{farms: farms, nodes: models.NewMemoryCollection()}
+}
+
+type memoryNodeRepository struct {
+	mu    sync.Mutex
+	farms FarmRepository
+	nodes *models.MemoryCollection
+}
+
+// update applies fn to the node with nodeID and stores it. Like nodeUpdate
+// the update of an unknown node is ignored
+func (m *memoryNodeRepository) update(nodeID string, fn func(node *Node) error) error {
+	if nodeID == "" {
+		return fmt.Errorf("invalid node id")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var node Node
+	err := m.nodes.Get(nodeID, &node)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if err := fn(&node); err != nil {
+		return err
+	}
+
+	return m.nodes.Put(nodeID, node)
+}
+
+func (m *memoryNodeRepository) Get(ctx context.Context, nodeID string, includeProofs bool) (node Node, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err = m.nodes.Get(nodeID, &node); err != nil {
+		return
+	}
+
+	if !includeProofs {
+		node.Proofs = nil
+	}
+
+	return
+}
+
+func (m *memoryNodeRepository) Exists(ctx context.Context, nodeID string) (bool, error) {
+	_, err := m.Get(ctx, nodeID, false)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (m *memoryNodeRepository) List(ctx context.Context, filter NodeFilter, pager models.Pager, opts ...*options.FindOptions) ([]Node, int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	nodes := []Node{}
+	total, err := m.nodes.List(bson.D(filter), pager, &nodes, opts...)
+	return nodes, total, err
+}
+
+func (m *memoryNodeRepository) Count(ctx context.Context, filter NodeFilter) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.nodes.Count(bson.D(filter))
+}
+
+func (m *memoryNodeRepository) Create(ctx context.Context, node Node) (schema.ID, error) {
+	if err := node.Validate(); err != nil {
+		return 0, err
+	}
+
+	if _, err := m.farms.Get(ctx, schema.ID(node.FarmId)); err != nil {
+		return 0, errors.Wrap(err, "unknown farm id")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var current Node
+	err := m.nodes.Get(node.NodeId, &current)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		node.ID = m.nodes.NextID()
+		node.register(nil)
+	} else if err != nil {
+		return 0, err
+	} else {
+		node.ID = current.ID
+		node.register(&current)
+	}
+
+	return node.ID, m.nodes.Put(node.NodeId, node)
+}
+
+func (m *memoryNodeRepository) SetTotalResources(ctx context.Context, nodeID string, capacity generated.ResourceAmount) error {
+	return m.update(nodeID, func(node *Node) error {
+		node.TotalResources = capacity
+		return nil
+	})
+}
+
+func (m *memoryNodeRepository) SetReservedResources(ctx context.Context, nodeID string, capacity generated.ResourceAmount) error {
+	return m.update(nodeID, func(node *Node) error {
+		node.ReservedResources = capacity
+		return nil
+	})
+}
+
+func (m *memoryNodeRepository) SetWorkloadsAmount(ctx context.Context, nodeID string, workloads generated.WorkloadAmount) error {
+	return m.update(nodeID, func(node *Node) error {
+		node.Workloads = workloads
+		return nil
+	})
+}
+
+func (m *memoryNodeRepository) SetUptime(ctx context.Context, nodeID string, uptime int64) error {
+	return m.update(nodeID, func(node *Node) error {
+		node.Uptime = uptime
+		node.Updated = schema.Date{Time: time.Now()}
+		return nil
+	})
+}
+
+func (m *memoryNodeRepository) SetFreeToUse(ctx context.Context, nodeID string, freeToUse bool) error {
+	return m.update(nodeID, func(node *Node) error {
+		node.FreeToUse = freeToUse
+		return nil
+	})
+}
+
+func (m *memoryNodeRepository) SetInterfaces(ctx context.Context, nodeID string, ifaces []generated.Iface) error {
+	return m.update(nodeID, func(node *Node) error {
+		node.Ifaces = ifaces
+		return nil
+	})
+}
+
+func (m *memoryNodeRepository) SetPublicConfig(ctx context.Context, nodeID string, cfg generated.PublicIface) error {
+	return m.update(nodeID, func(node *Node) error {
+		node.PublicConfig = &cfg
+		return nil
+	})
+}
+
+func (m *memoryNodeRepository) SetWGPorts(ctx context.Context, nodeID string, ports []uint) error {
+	return m.update(nodeID, func(node *Node) error {
+		node.WgPorts = make([]int64, 0, len(ports))
+		for _, port := range ports {
+			node.WgPorts = append(node.WgPorts, int64(port))
+		}
+		return nil
+	})
+}
+
+func (m *memoryNodeRepository) SetRetired(ctx context.Context, nodeID string) error {
+	return m.update(nodeID, func(node *Node) error {
+		node.Retired = true
+		node.RetiredAt = schema.Date{Time: time.Now()}
+		return nil
+	})
+}
+
+func (m *memoryNodeRepository) SetHardwareChanged(ctx context.Context, nodeID string, changed bool) error {
+	return m.update(nodeID, func(node *Node) error {
+		node.HardwareChanged = changed
+		if changed {
+			node.HardwareChangedAt = schema.Date{Time: time.Now()}
+		}
+		return nil
+	})
+}
+
+func (m *memoryNodeRepository) SetApproval(ctx context.Context, nodeID string, approval generated.NodeApproval) error {
+	return m.update(nodeID, func(node *Node) error {
+		node.Approved = approval.Approved
+		node.Approvals = append(node.Approvals, approval)
+		return nil
+	})
+}
+
+func (m *memoryNodeRepository) PushProof(ctx context.Context, nodeID string, proof generated.Proof) error {
+	return m.update(nodeID, func(node *Node) error {
+		found, err := hasProof(node.Proofs, proof)
+		if err != nil || found {
+			return err
+		}
+
+		node.Proofs = append(node.Proofs, proof)
+		return nil
+	})
+}
+
+// NewMemoryGatewayRepository returns a GatewayRepository that keeps the
+// gateways and the domain claims in memory
+func NewMemoryGatewayRepository() GatewayRepository {
+	return &memoryGatewayRepository{
+		gateways: models.NewMemoryCollection(),
+		domains:  models.NewMemoryCollection(),
+	}
+}
+
+type memoryGatewayRepository struct {
+	mu       sync.Mutex
+	gateways *models.MemoryCollection
+	domains  *models.MemoryCollection
+}
+
+// update applies fn to the gateway with gwID and stores it. Like gwUpdate
+// the update of an unknown gateway is ignored
+func (m *memoryGatewayRepository) update(gwID string, fn func(gw *Gateway) error) error {
+	if gwID == "" {
+		return fmt.Errorf("invalid node id")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var gw Gateway
+	err := m.gateways.Get(gwID, &gw)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if err := fn(&gw); err != nil {
+		return err
+	}
+
+	return m.gateways.Put(gwID, gw)
+}
+
+func (m *memoryGatewayRepository) Get(ctx context.Context, gwID string) (gw Gateway, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	err = m.gateways.Get(gwID, &gw)
+	return
+}
+
+func (m *memoryGatewayRepository) Exists(ctx context.Context, gwID string) (bool, error) {
+	_, err := m.Get(ctx, gwID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (m *memoryGatewayRepository) List(ctx context.Context, filter GatewayFilter, pager models.Pager, opts ...*options.FindOptions) ([]Gateway, int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	gateways := []Gateway{}
+	total, err := m.gateways.List(bson.D(filter), pager, &gateways, opts...)
+	return gateways, total, err
+}
+
+func (m *memoryGatewayRepository) Count(ctx context.Context, filter GatewayFilter) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.gateways.Count(bson.D(filter))
+}
+
+func (m *memoryGatewayRepository) Create(ctx context.Context, gw Gateway) (schema.ID, error) {
+	if err := gw.Validate(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var current Gateway
+	err := m.gateways.Get(gw.NodeId, &current)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		gw.ID = m.gateways.NextID()
+		gw.register(nil)
+	} else if err != nil {
+		return 0, err
+	} else {
+		gw.ID = current.ID
+		gw.register(&current)
+	}
+
+	return gw.ID, m.gateways.Put(gw.NodeId, gw)
+}
+
+func (m *memoryGatewayRepository) SetTotalResources(ctx context.Context, gwID string, capacity generated.ResourceAmount) error {
+	return m.update(gwID, func(gw *Gateway) error {
+		gw.TotalResources = capacity
+		return nil
+	})
+}
+
+func (m *memoryGatewayRepository) SetReservedResources(ctx context.Context, gwID string, capacity generated.ResourceAmount) error {
+	return m.update(gwID, func(gw *Gateway) error {
+		gw.ReservedResources = capacity
+		return nil
+	})
+}
+
+func (m *memoryGatewayRepository) SetWorkloadsAmount(ctx context.Context, gwID string, workloads generated.WorkloadAmount) error {
+	return m.update(gwID, func(gw *Gateway) error {
+		gw.Workloads = workloads
+		return nil
+	})
+}
+
+func (m *memoryGatewayRepository) SetUptime(ctx context.Context, gwID string, uptime int64) error {
+	return m.update(gwID, func(gw *Gateway) error {
+		gw.Uptime = uptime
+		gw.Updated = schema.Date{Time: time.Now()}
+		return nil
+	})
+}
+
+func (m *memoryGatewayRepository) SetFreeToUse(ctx context.Context, gwID string, freeToUse bool) error {
+	return m.update(gwID, func(gw *Gateway) error {
+		gw.FreeToUse = freeToUse
+		return nil
+	})
+}
+
+func (m *memoryGatewayRepository) SetInterfaces(ctx context.Context, gwID string, ifaces []generated.Iface) error {
+	return m.update(gwID, func(gw *Gateway) error {
+		gw.Ifaces = ifaces
+		return nil
+	})
+}
+
+func (m *memoryGatewayRepository) SetPublicConfig(ctx context.Context, gwID string, cfg generated.PublicIface) error {
+	return m.update(gwID, func(gw *Gateway) error {
+		gw.PublicConfig = &cfg
+		return nil
+	})
+}
+
+func (m *memoryGatewayRepository) SetRetired(ctx context.Context, gwID string) error {
+	return m.update(gwID, func(gw *Gateway) error {
+		gw.Retired = true
+		gw.RetiredAt = schema.Date{Time: time.Now()}
+		return nil
+	})
+}
+
+func (m *memoryGatewayRepository) PushProof(ctx context.Context, gwID string, proof generated.Proof) error {
+	return m.update(gwID, func(gw *Gateway) error {
+		found, err := hasProof(gw.Proofs, proof)
+		if err != nil || found {
+			return err
+		}
+
+		gw.Proofs = append(gw.Proofs, proof)
+		return nil
+	})
+}
+
+// claims returns the domain claims that match fn, sorted by domain
+func (m *memoryGatewayRepository) claims(fn func(claim DomainClaim) bool) ([]DomainClaim, error) {
+	out := []DomainClaim{}
+	for _, key := range m.domains.Keys() {
+		var claim DomainClaim
+		if err := m.domains.Get(key, &claim); err != nil {
+			return nil, err
+		}
+
+		if fn(claim) {
+			out = append(out, claim)
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Domain < out[j].Domain
+	})
+
+	return out, nil
+}
+
+func (m *memoryGatewayRepository) Domains(ctx context.Context, gwID string) ([]DomainClaim, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.claims(func(claim DomainClaim) bool {
+		return claim.GatewayID == gwID
+	})
+}
+
+func (m *memoryGatewayRepository) GetDomain(ctx context.Context, domain string) (claim DomainClaim, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	err = m.domains.Get(domain, &claim)
+	return
+}
+
+func (m *memoryGatewayRepository) ClaimDomain(ctx context.Context, claim DomainClaim) error {
+	claim.Domain = NormalizeDomain(claim.Domain)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var existing DomainClaim
+	if err := m.domains.Get(claim.Domain, &existing); err == nil {
+		return ErrDomainClaimed
+	} else if !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+
+	return m.domains.Put(claim.Domain, claim)
+}
+
+func (m *memoryGatewayRepository) ReleaseDomains(ctx context.Context, reservation schema.ID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	held, err := m.claims(func(claim DomainClaim) bool {
+		return claim.ReservationID == reservation
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, claim := range held {
+		m.domains.Delete(claim.Domain)
+	}
+
+	return nil
+}
+
+// NewMemoryCapacityRepository returns a CapacityRepository that keeps the
+// capacity history in memory
+func NewMemoryCapacityRepository() CapacityRepository {
+	return &memoryCapacityRepository{buckets: models.NewMemoryCollection()}
+}
+
+type memoryCapacityRepository struct {
+	mu      sync.Mutex
+	buckets *models.MemoryCollection
+}
+
+type capacityKey struct {
+	node      string
+	step      int64
+	timestamp int64
+}
+
+func capacityOf(amount generated.ResourceAmount) CapacityAmount {
+	return CapacityAmount{
+		Cru: float64(amount.Cru),
+		Mru: amount.Mru,
+		Hru: amount.Hru,
+		Sru: amount.Sru,
+	}
+}
+
+func (m *memoryCapacityRepository) Record(ctx context.Context, node Node, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	at = at.UTC()
+	for _, resolution := range Resolutions {
+		timestamp := at.Truncate(resolution.Step)
+		key := capacityKey{
+			node:      node.NodeId,
+			step:      int64(resolution.Step / time.Second),
+			timestamp: timestamp.Unix(),
+		}
+
+		var bucket CapacityBucket
+		err := m.buckets.Get(key, &bucket)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			bucket = CapacityBucket{NodeID: key.node, Step: key.step, Timestamp: timestamp}
+		} else if err != nil {
+			return err
+		}
+
+		bucket.FarmID = node.FarmId
+		bucket.ExpireAt = timestamp.Add(resolution.Step + resolution.Retention)
+		bucket.Samples++
+		bucket.Total = bucket.Total.add(capacityOf(node.TotalResources))
+		bucket.Reserved = bucket.Reserved.add(capacityOf(node.ReservedResources))
+		bucket.Used = bucket.Used.add(capacityOf(node.UsedResources))
+
+		if err := m.buckets.Put(key, bucket); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *memoryCapacityRepository) History(ctx context.Context, filter CapacityFilter, step time.Duration) ([]CapacityPoint, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// the expired buckets are removed by a ttl index in the database
+	filter = append(filter, bson.E{Key: "expire_at", Value: bson.M{"$gt": time.Now().UTC()}})
+	docs, err := m.buckets.Find(bson.D(filter))
+	if err != nil {
+		return nil, err
+	}
+
+	buckets := make([]CapacityBucket, 0, len(docs))
+	for _, doc := range docs {
+		var bucket CapacityBucket
+		if err := bson.Unmarshal(doc, &bucket); err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, bucket)
+	}
+
+	return downsample(buckets, step), nil
+}
+
+// NewMemoryWalletChallengeRepository returns a WalletChallengeRepository
+// that keeps the challenges in memory
+func NewMemoryWalletChallengeRepository() WalletChallengeRepository {
+	return &memoryWalletChallengeRepository{challenges: models.NewMemoryCollection()}
+}
+
+type memoryWalletChallengeRepository struct {
+	mu         sync.Mutex
+	challenges *models.MemoryCollection
+}
+
+type challengeKey struct {
+	farm    schema.ID
+	address string
+}
+
+func (m *memoryWalletChallengeRepository) Create(ctx context.Context, farmID schema.ID, address string) (WalletChallenge, error) {
+	challenge, err := newWalletChallenge(farmID, address)
+	if err != nil {
+		return challenge, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return challenge, m.challenges.Put(challengeKey{farmID, address}, challenge)
+}
+
+func (m *memoryWalletChallengeRepository) Get(ctx context.Context, farmID schema.ID, address string) (WalletChallenge, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var challenge WalletChallenge
+	err := m.challenges.Get(challengeKey{farmID, address}, &challenge)
+	if errors.Is(err, mongo.ErrNoDocuments) || (err == nil && !challenge.Expiration.After(time.Now())) {
+		return WalletChallenge{}, ErrChallengeNotFound
+	}
+
+	return challenge, err
+}
+
+func (m *memoryWalletChallengeRepository) Delete(ctx context.Context, farmID schema.ID, address string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.challenges.Delete(challengeKey{farmID, address})
+	return nil
+}
+
+// NewMemoryStatsRepository returns a StatsRepository that aggregates the
+// nodes, gateways and reservations of the given repositories
+func NewMemoryStatsRepository(nodes NodeRepository, gateways GatewayRepository, reservations workloads.ReservationRepository) StatsRepository {
+	return &memoryStatsRepository{nodes: nodes, gateways: gateways, reservations: reservations}
+}
+
+type memoryStatsRepository struct {
+	nodes        NodeRepository
+	gateways     GatewayRepository
+	reservations workloads.ReservationRepository
+}
+
+func addResources(a, b generated.ResourceAmount) generated.ResourceAmount {
+	return generated.ResourceAmount{
+		Cru: a.Cru + b.Cru,
+		Mru: a.Mru + b.Mru,
+		Hru: a.Hru + b.Hru,
+		Sru: a.Sru + b.Sru,
+	}
+}
+
+// account adds node to the stats like nodeStatsPipeline does
+func (s *Stats) account(node Node, onlineSince time.Time) {
+	s.Nodes++
+	if !node.Updated.Before(onlineSince) {
+		s.OnlineNodes++
+	}
+	if node.FreeToUse {
+		s.FreeToUseNodes++
+	}
+
+	s.TotalResources = addResources(s.TotalResources, node.TotalResources)
+	s.ReservedResources = addResources(s.ReservedResources, node.ReservedResources)
+	s.UsedResources = addResources(s.UsedResources, node.UsedResources)
+
+	s.Workloads.Network += uint64(node.Workloads.Network)
+	s.Workloads.Volume += uint64(node.Workloads.Volume)
+	s.Workloads.ZDBNamespace += uint64(node.Workloads.ZDBNamespace)
+	s.Workloads.Container += uint64(node.Workloads.Container)
+	s.Workloads.K8sVM += uint64(node.Workloads.K8sVM)
+	s.Workloads.Proxy += uint64(node.Workloads.Proxy)
+	s.Workloads.ReverseProxy += uint64(node.Workloads.ReverseProxy)
+	s.Workloads.Subdomain += uint64(node.Workloads.Subdomain)
+	s.Workloads.DelegateDomain += uint64(node.Workloads.DelegateDomain)
+}
+
+// aggregate groups the active nodes by key and sums their capacity
+func (m *memoryStatsRepository) aggregate(ctx context.Context, onlineSince time.Time, key func(node Node) interface{}) (map[interface{}]*Stats, error) {
+	nodes, _, err := m.nodes.List(ctx, NodeFilter{}.WithRetired(false), models.Pager{})
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[interface{}]*Stats)
+	for _, node := range nodes {
+		k := key(node)
+		stats, ok := groups[k]
+		if !ok {
+			stats = &Stats{}
+			groups[k] = stats
+		}
+
+		stats.account(node, onlineSince)
+	}
+
+	return groups, nil
+}
+
+func (m *memoryStatsRepository) Grid(ctx context.Context, onlineSince time.Time) (Stats, error) {
+	groups, err := m.aggregate(ctx, onlineSince, func(Node) interface{} { return nil })
+	if err != nil || len(groups) == 0 {
+		return Stats{}, err
+	}
+
+	return *groups[nil], nil
+}
+
+func (m *memoryStatsRepository) PerFarm(ctx context.Context, onlineSince time.Time) ([]FarmStats, error) {
+	groups, err := m.aggregate(ctx, onlineSince, func(node Node) interface{} { return schema.ID(node.FarmId) })
+	if err != nil {
+		return nil, err
+	}
+
+	stats := []FarmStats{}
+	for k, group := range groups {
+		stats = append(stats, FarmStats{FarmID: k.(schema.ID), Stats: *group})
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].FarmID < stats[j].FarmID
+	})
+
+	return stats, nil
+}
+
+func (m *memoryStatsRepository) PerCountry(ctx context.Context, onlineSince time.Time) ([]CountryStats, error) {
+	groups, err := m.aggregate(ctx, onlineSince, func(node Node) interface{} { return node.Location.Country })
+	if err != nil {
+		return nil, err
+	}
+
+	stats := []CountryStats{}
+	for k, group := range groups {
+		stats = append(stats, CountryStats{Country: k.(string), Stats: *group})
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Country < stats[j].Country
+	})
+
+	return stats, nil
+}
+
+// locate returns the farm and the country of the node or gateway with id
+func (m *memoryStatsRepository) locate(ctx context.Context, id string) (schema.ID, string, bool, error) {
+	node, err := m.nodes.Get(ctx, id, false)
+	if err == nil {
+		return schema.ID(node.FarmId), node.Location.Country, true, nil
+	} else if !errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, "", false, err
+	}
+
+	gw, err := m.gateways.Get(ctx, id)
+	if err == nil {
+		return schema.ID(gw.FarmId), gw.Location.Country, true, nil
+	} else if !errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, "", false, err
+	}
+
+	return 0, "", false, nil
+}
+
+func (m *memoryStatsRepository) CountReservations(ctx context.Context) (ReservationCounts, error) {
+	counts := ReservationCounts{
+		Farms:     make(map[schema.ID]int64),
+		Countries: make(map[string]int64),
+	}
+
+	reservations, err := m.reservations.Find(ctx, workloads.ReservationFilter{}.WithNextAction(workloads.Deploy))
+	if err != nil {
+		return counts, err
+	}
+
+	for _, reservation := range reservations {
+		ids := append(reservation.NodeIDs(), reservation.GatewayIDs()...)
+		if len(ids) == 0 {
+			continue
+		}
+		counts.Grid++
+
+		farms := make(map[schema.ID]struct{})
+		countries := make(map[string]struct{})
+		for _, id := range ids {
+			farm, country, ok, err := m.locate(ctx, id)
+			if err != nil {
+				return counts, err
+			} else if !ok {
+				// workloads on unknown nodes have no farm or country
+				continue
+			}
+
+			farms[farm] = struct{}{}
+			countries[country] = struct{}{}
+		}
+
+		for farm := range farms {
+			counts.Farms[farm]++
+		}
+		for country := range countries {
+			counts.Countries[country]++
+		}
+	}
+
+	return counts, nil
+}
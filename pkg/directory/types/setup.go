@@ -19,11 +19,74 @@ func Setup(ctx context.Context, db *mongo.Database) error {
 			Keys:    bson.M{"name": 1},
 			Options: options.Index().SetUnique(true),
 		},
+		{
+			Keys: bson.M{"admins.threebot_id": 1},
+		},
+		{
+			Keys: bson.M{"ip_addresses.reservation_id": 1},
+		},
+		{
+			// used by the search endpoint
+			Keys: bson.D{
+				{Key: "name", Value: "text"},
+				{Key: "email", Value: "text"},
+				{Key: "location.city", Value: "text"},
+				{Key: "location.country", Value: "text"},
+			},
+			Options: options.Index().SetWeights(bson.M{"name": 10, "email": 5}),
+		},
 	})
 	if err != nil {
 		log.Error().Err(err).Msg("failed to initialize farm index")
 	}
 
+	domain := db.Collection(DomainCollection)
+	_, err = domain.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.M{"gateway_id": 1},
+		},
+		{
+			Keys: bson.M{"reservation_id": 1},
+		},
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to initialize domain index")
+	}
+
+	challenge := db.Collection(WalletChallengeCollection)
+	_, err = challenge.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "farm_id", Value: 1}, {Key: "address", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.M{"expiration": 1},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to initialize wallet challenge index")
+	}
+
+	capacity := db.Collection(CapacityCollection)
+	_, err = capacity.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "node_id", Value: 1}, {Key: "step", Value: 1}, {Key: "timestamp", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "farm_id", Value: 1}, {Key: "step", Value: 1}, {Key: "timestamp", Value: 1}},
+		},
+		{
+			// buckets are removed by mongo once their retention is over
+			Keys:    bson.M{"expire_at": 1},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to initialize capacity index")
+	}
+
 	node := db.Collection(NodeCollection)
 
 	nodeIdexes := []mongo.IndexModel{
@@ -34,6 +97,15 @@ func Setup(ctx context.Context, db *mongo.Database) error {
 		{
 			Keys: bson.M{"farm_id": 1},
 		},
+		{
+			// used by the search endpoint
+			Keys: bson.D{
+				{Key: "node_id", Value: "text"},
+				{Key: "location.city", Value: "text"},
+				{Key: "location.country", Value: "text"},
+			},
+			Options: options.Index().SetWeights(bson.M{"node_id": 10}),
+		},
 	}
 
 	for _, x := range []string{"total_resources", "user_resources", "reserved_resources"} {
@@ -50,5 +122,25 @@ func Setup(ctx context.Context, db *mongo.Database) error {
 		log.Error().Err(err).Msg("failed to initialize node index")
 	}
 
+	gateway := db.Collection(GatewayCollection)
+	_, err = gateway.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.M{"node_id": 1},
+		},
+		{
+			// used by the search endpoint
+			Keys: bson.D{
+				{Key: "node_id", Value: "text"},
+				{Key: "location.city", Value: "text"},
+				{Key: "location.country", Value: "text"},
+				{Key: "managed_domains", Value: "text"},
+			},
+			Options: options.Index().SetWeights(bson.M{"node_id": 10}),
+		},
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to initialize gateway index")
+	}
+
 	return err
 }
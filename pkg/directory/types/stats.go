@@ -0,0 +1,275 @@
+package types
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	generated "github.com/threefoldtech/tfexplorer/models/generated/directory"
+	workloads "github.com/threefoldtech/tfexplorer/pkg/workloads/types"
+	"github.com/threefoldtech/tfexplorer/schema"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Stats is the aggregated capacity of a group of nodes
+type Stats struct {
+	Nodes             int64                    `bson:"nodes" json:"nodes"`
+	OnlineNodes       int64                    `bson:"online_nodes" json:"online_nodes"`
+	FreeToUseNodes    int64                    `bson:"free_to_use_nodes" json:"free_to_use_nodes"`
+	TotalResources    generated.ResourceAmount `bson:"total_resources" json:"total_resources"`
+	ReservedResources generated.ResourceAmount `bson:"reserved_resources" json:"reserved_resources"`
+	UsedResources     generated.ResourceAmount `bson:"used_resources" json:"used_resources"`
+	Workloads         WorkloadCounts           `bson:"workloads" json:"workloads"`
+	Reservations      int64                    `bson:"reservations" json:"reservations"`
+}
+
+// WorkloadCounts is the number of workloads of each type summed over a group
+// of nodes. The per node WorkloadAmount counters are too small to hold the
+// sum over the whole grid
+type WorkloadCounts struct {
+	Network        uint64 `bson:"network" json:"network"`
+	Volume         uint64 `bson:"volume" json:"volume"`
+	ZDBNamespace   uint64 `bson:"zdb_namespace" json:"zdb_namespace"`
+	Container      uint64 `bson:"container" json:"container"`
+	K8sVM          uint64 `bson:"k8s_vm" json:"k8s_vm"`
+	Proxy          uint64 `bson:"proxy" json:"proxy"`
+	ReverseProxy   uint64 `bson:"reverse_proxy" json:"reverse_proxy"`
+	Subdomain      uint64 `bson:"subdomain" json:"subdomain"`
+	DelegateDomain uint64 `bson:"delegate_domain" json:"delegate_domain"`
+}
+
+// FarmStats is the aggregated capacity of the nodes of a farm
+type FarmStats struct {
+	FarmID schema.ID `bson:"_id" json:"farm_id"`
+	Stats  `bson:",inline"`
+}
+
+// CountryStats is the aggregated capacity of the nodes located in a country
+type CountryStats struct {
+	Country string `bson:"_id" json:"country"`
+	Stats   `bson:",inline"`
+}
+
+// ReservationCounts holds the number of deployed reservations
+// per farm, per country and on the whole grid
+type ReservationCounts struct {
+	Farms     map[schema.ID]int64
+	Countries map[string]int64
+	Grid      int64
+}
+
+var (
+	resourceUnits = []string{"cru", "mru", "hru", "sru"}
+	workloadTypes = []string{
+		"network", "volume", "zdb_namespace", "container", "k8s_vm",
+		"proxy", "reverse_proxy", "subdomain", "delegate_domain",
+	}
+)
+
+// nodeStatsPipeline groups the active nodes by key and sums their capacity.
+// nodes that reported after onlineSince are counted as online
+func nodeStatsPipeline(key interface{}, onlineSince time.Time) mongo.Pipeline {
+	group := bson.M{
+		"_id":   key,
+		"nodes": bson.M{"$sum": 1},
+		"online_nodes": bson.M{"$sum": bson.M{
+			"$cond": bson.A{bson.M{"$gte": bson.A{"$updated", schema.Date{Time: onlineSince}}}, 1, 0},
+		}},
+		"free_to_use_nodes": bson.M{"$sum": bson.M{
+			"$cond": bson.A{"$free_to_use", 1, 0},
+		}},
+	}
+	// $group can not output embedded documents, so the sums are
+	// stored flat first then nested back by the $project stage
+	project := bson.M{
+		"nodes":             1,
+		"online_nodes":      1,
+		"free_to_use_nodes": 1,
+	}
+
+	for _, resources := range []string{"total_resources", "reserved_resources", "used_resources"} {
+		nested := bson.M{}
+		for _, unit := range resourceUnits {
+			flat := fmt.Sprintf("%s_%s", resources, unit)
+			group[flat] = bson.M{"$sum": fmt.Sprintf("$%s.%s", resources, unit)}
+			nested[unit] = "$" + flat
+		}
+		project[resources] = nested
+	}
+
+	nested := bson.M{}
+	for _, typ := range workloadTypes {
+		flat := "workloads_" + typ
+		group[flat] = bson.M{"$sum": "$workloads." + typ}
+		nested[typ] = "$" + flat
+	}
+	project["workloads"] = nested
+
+	return mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"retired": bson.M{"$ne": true}}}},
+		{{Key: "$group", Value: group}},
+		{{Key: "$project", Value: project}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	}
+}
+
+// StatsPerFarm aggregates the capacity of the nodes of each farm
+func StatsPerFarm(ctx context.Context, db *mongo.Database, onlineSince time.Time) ([]FarmStats, error) {
+	cur, err := db.Collection(NodeCollection).Aggregate(ctx, nodeStatsPipeline("$farm_id", onlineSince))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	stats := []FarmStats{}
+	err = cur.All(ctx, &stats)
+	return stats, err
+}
+
+// StatsPerCountry aggregates the capacity of the nodes of each country
+func StatsPerCountry(ctx context.Context, db *mongo.Database, onlineSince time.Time) ([]CountryStats, error) {
+	cur, err := db.Collection(NodeCollection).Aggregate(ctx, nodeStatsPipeline("$location.country", onlineSince))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	stats := []CountryStats{}
+	err = cur.All(ctx, &stats)
+	return stats, err
+}
+
+// StatsGrid aggregates the capacity of all the nodes of the grid
+func StatsGrid(ctx context.Context, db *mongo.Database, onlineSince time.Time) (Stats, error) {
+	var stats Stats
+	cur, err := db.Collection(NodeCollection).Aggregate(ctx, nodeStatsPipeline(nil, onlineSince))
+	if err != nil {
+		return stats, err
+	}
+	defer cur.Close(ctx)
+
+	if cur.Next(ctx) {
+		err = cur.Decode(&stats)
+		return stats, err
+	}
+
+	return stats, cur.Err()
+}
+
+// reservationNodes is the expression that lists all the node ids
+// used by the workloads of a reservation
+func reservationNodes() bson.M {
+	sets := bson.A{}
+	for _, typ := range []string{"containers", "volumes", "zdbs", "kubernetes", "proxies", "reserve_proxies", "subdomains", "domain_delegates", "gateway4to6", "public_ips"} {
+		sets = append(sets, bson.M{"$ifNull": bson.A{fmt.Sprintf("$data_reservation.%s.node_id", typ), bson.A{}}})
+	}
+
+	// network workload is special because node id is set on the network_resources,
+	// which gives a list of lists that needs to be flattened
+	sets = append(sets, bson.M{"$reduce": bson.M{
+		"input":        bson.M{"$ifNull": bson.A{"$data_reservation.networks.network_resources.node_id", bson.A{}}},
+		"initialValue": bson.A{},
+		"in":           bson.M{"$concatArrays": bson.A{"$$value", "$$this"}},
+	}})
+
+	return bson.M{"$setUnion": sets}
+}
+
+// CountReservations counts the deployed reservations per farm, per country
+// and grid wide. A reservation is counted once per farm or country
+// even if it uses multiple nodes in it
+func CountReservations(ctx context.Context, db *mongo.Database) (ReservationCounts, error) {
+	counts := ReservationCounts{
+		Farms:     make(map[schema.ID]int64),
+		Countries: make(map[string]int64),
+	}
+
+	first := func(field string) bson.M {
+		return bson.M{"$arrayElemAt": bson.A{
+			bson.M{"$concatArrays": bson.A{"$node." + field, "$gateway." + field}}, 0,
+		}}
+	}
+
+	countBy := func(key string) bson.A {
+		return bson.A{
+			bson.M{"$group": bson.M{"_id": bson.M{"reservation": "$_id", "key": key}}},
+			bson.M{"$group": bson.M{"_id": "$_id.key", "count": bson.M{"$sum": 1}}},
+		}
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"next_action": workloads.Deploy}}},
+		{{Key: "$project", Value: bson.M{"nodes": reservationNodes()}}},
+		{{Key: "$unwind", Value: "$nodes"}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         NodeCollection,
+			"localField":   "nodes",
+			"foreignField": "node_id",
+			"as":           "node",
+		}}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         GatewayCollection,
+			"localField":   "nodes",
+			"foreignField": "node_id",
+			"as":           "gateway",
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"farm_id": first("farm_id"),
+			"country": first("location.country"),
+		}}},
+		{{Key: "$facet", Value: bson.M{
+			"farms":     countBy("$farm_id"),
+			"countries": countBy("$country"),
+			"grid": bson.A{
+				bson.M{"$group": bson.M{"_id": "$_id"}},
+				bson.M{"$count": "count"},
+			},
+		}}},
+	}
+
+	cur, err := db.Collection(workloads.ReservationCollection).Aggregate(ctx, pipeline)
+	if err != nil {
+		return counts, err
+	}
+	defer cur.Close(ctx)
+
+	var result struct {
+		Farms []struct {
+			ID    *schema.ID `bson:"_id"`
+			Count int64      `bson:"count"`
+		} `bson:"farms"`
+		Countries []struct {
+			ID    *string `bson:"_id"`
+			Count int64   `bson:"count"`
+		} `bson:"countries"`
+		Grid []struct {
+			Count int64 `bson:"count"`
+		} `bson:"grid"`
+	}
+
+	if !cur.Next(ctx) {
+		return counts, cur.Err()
+	}
+
+	if err := cur.Decode(&result); err != nil {
+		return counts, err
+	}
+
+	// workloads on unknown nodes have no farm or country
+	for _, farm := range result.Farms {
+		if farm.ID != nil {
+			counts.Farms[*farm.ID] = farm.Count
+		}
+	}
+	for _, country := range result.Countries {
+		if country.ID != nil {
+			counts.Countries[*country.ID] = country.Count
+		}
+	}
+	if len(result.Grid) > 0 {
+		counts.Grid = result.Grid[0].Count
+	}
+
+	return counts, nil
+}
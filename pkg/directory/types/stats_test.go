@@ -0,0 +1,47 @@
+package types
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestNodeStatsPipeline(t *testing.T) {
+	pipeline := nodeStatsPipeline("$farm_id", time.Now())
+	require.Len(t, pipeline, 4)
+
+	group, ok := pipeline[1][0].Value.(bson.M)
+	require.True(t, ok)
+	assert.Equal(t, "$farm_id", group["_id"])
+	assert.Equal(t, bson.M{"$sum": "$total_resources.cru"}, group["total_resources_cru"])
+	assert.Equal(t, bson.M{"$sum": "$workloads.k8s_vm"}, group["workloads_k8s_vm"])
+
+	project, ok := pipeline[2][0].Value.(bson.M)
+	require.True(t, ok)
+	// every flat sum of the group stage must be nested back
+	for _, resources := range []string{"total_resources", "reserved_resources", "used_resources"} {
+		nested, ok := project[resources].(bson.M)
+		require.True(t, ok, resources)
+		assert.Len(t, nested, len(resourceUnits))
+		assert.Equal(t, "$"+resources+"_mru", nested["mru"])
+	}
+
+	workloads, ok := project["workloads"].(bson.M)
+	require.True(t, ok)
+	assert.Len(t, workloads, len(workloadTypes))
+	assert.Equal(t, "$workloads_zdb_namespace", workloads["zdb_namespace"])
+}
+
+func TestStatsWorkloadsDoNotOverflow(t *testing.T) {
+	// sums over the grid go way past the per node counters
+	data, err := bson.Marshal(bson.M{"workloads": bson.M{"container": int64(100000), "volume": int32(70000)}})
+	require.NoError(t, err)
+
+	var stats Stats
+	require.NoError(t, bson.Unmarshal(data, &stats))
+	assert.Equal(t, uint64(100000), stats.Workloads.Container)
+	assert.Equal(t, uint64(70000), stats.Workloads.Volume)
+}
@@ -0,0 +1,98 @@
+package types
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/tfexplorer/schema"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// WalletChallengeCollection db collection name
+	WalletChallengeCollection = "wallet_challenge"
+
+	// challengeTimeout is how long a farmer has to sign a challenge
+	challengeTimeout = 10 * time.Minute
+)
+
+var (
+	// ErrChallengeNotFound is returned when no valid challenge exists for an address
+	ErrChallengeNotFound = errors.New("no pending challenge for this address, or challenge expired")
+)
+
+// WalletChallenge is a random message the farmer needs to sign with the
+// key of a wallet address to prove ownership of the address
+type WalletChallenge struct {
+	FarmID     schema.ID `bson:"farm_id" json:"farm_id"`
+	Address    string    `bson:"address" json:"address"`
+	Challenge  string    `bson:"challenge" json:"challenge"`
+	Expiration time.Time `bson:"expiration" json:"expiration"`
+}
+
+// WalletChallengeCreate generates a new challenge for the address of farm
+// replacing any challenge that was pending for it
+func WalletChallengeCreate(ctx context.Context, db *mongo.Database, farmID schema.ID, address string) (WalletChallenge, error) {
+	challenge, err := newWalletChallenge(farmID, address)
+	if err != nil {
+		return challenge, err
+	}
+
+	col := db.Collection(WalletChallengeCollection)
+	_, err = col.ReplaceOne(ctx,
+		bson.M{"farm_id": farmID, "address": address},
+		challenge,
+		options.Replace().SetUpsert(true),
+	)
+
+	return challenge, err
+}
+
+// newWalletChallenge generates a random challenge for the address of farm
+func newWalletChallenge(farmID schema.ID, address string) (WalletChallenge, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return WalletChallenge{}, errors.Wrap(err, "failed to generate challenge")
+	}
+
+	return WalletChallenge{
+		FarmID:     farmID,
+		Address:    address,
+		Challenge:  hex.EncodeToString(buf),
+		Expiration: time.Now().Add(challengeTimeout).UTC(),
+	}, nil
+}
+
+// WalletChallengeGet loads the pending challenge of the address of farm
+func WalletChallengeGet(ctx context.Context, db *mongo.Database, farmID schema.ID, address string) (WalletChallenge, error) {
+	var challenge WalletChallenge
+
+	col := db.Collection(WalletChallengeCollection)
+	result := col.FindOne(ctx, bson.M{
+		"farm_id":    farmID,
+		"address":    address,
+		"expiration": bson.M{"$gt": time.Now().UTC()},
+	})
+
+	if err := result.Err(); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return challenge, ErrChallengeNotFound
+		}
+		return challenge, err
+	}
+
+	err := result.Decode(&challenge)
+	return challenge, err
+}
+
+// WalletChallengeDelete removes the challenge of the address of farm
+func WalletChallengeDelete(ctx context.Context, db *mongo.Database, farmID schema.ID, address string) error {
+	col := db.Collection(WalletChallengeCollection)
+	_, err := col.DeleteOne(ctx, bson.M{"farm_id": farmID, "address": address})
+	return err
+}
@@ -15,17 +15,20 @@ import (
 type Escrow interface {
 	Run(ctx context.Context) error
 	RegisterReservation(reservation workloads.Reservation, supportedCurrencies []string) (types.CustomerEscrowInformation, error)
+	Quote(reservation workloads.Reservation, supportedCurrencies []string) (types.Quote, error)
 	ReservationDeployed(reservationID schema.ID)
 	ReservationCanceled(reservationID schema.ID)
 }
 
 // Free implements the Escrow interface in a way that makes all reservation free
 type Free struct {
-	db *mongo.Database
+	reservations workloadstypes.ReservationRepository
 }
 
 // NewFree creates a new EscrowFree object
-func NewFree(db *mongo.Database) *Free { return &Free{db: db} }
+func NewFree(db *mongo.Database) *Free {
+	return &Free{reservations: workloadstypes.NewReservationRepository(db)}
+}
 
 // Run implements the escrow interface
 func (e *Free) Run(ctx context.Context) error {
@@ -36,7 +39,7 @@ func (e *Free) Run(ctx context.Context) error {
 func (e *Free) RegisterReservation(reservation workloads.Reservation, _ []string) (detail types.CustomerEscrowInformation, err error) {
 
 	if reservation.NextAction == workloads.NextActionPay {
-		if err = workloadstypes.ReservationSetNextAction(context.Background(), e.db, reservation.ID, workloads.NextActionDeploy); err != nil {
+		if err = e.reservations.SetNextAction(context.Background(), reservation.ID, workloads.NextActionDeploy); err != nil {
 			err = errors.Wrapf(err, "failed to change state of reservation %d to DEPLOY", reservation.ID)
 			return
 		}
@@ -45,6 +48,11 @@ func (e *Free) RegisterReservation(reservation workloads.Reservation, _ []string
 	return detail, nil
 }
 
+// Quote implements the escrow interface, reservations are free but still use cloud units
+func (e *Free) Quote(reservation workloads.Reservation, _ []string) (types.Quote, error) {
+	return types.NewQuote(ReservationCloudUnits(reservation.DataReservation)), nil
+}
+
 // ReservationDeployed implements the escrow interface
 func (e *Free) ReservationDeployed(reservationID schema.ID) {}
 
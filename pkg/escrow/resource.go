@@ -106,6 +106,27 @@ func (e Stellar) calculateReservationCost(rsuPerFarmerMap rsuPerFarmer, duration
 	return costPerFarmerMap, nil
 }
 
+// ReservationCloudUnits returns the compute and storage units used by all
+// the workloads of the reservation
+func ReservationCloudUnits(resData workloads.ReservationData) (cu float64, su float64) {
+	var total rsu
+	for _, cont := range resData.Containers {
+		total = total.add(processContainer(cont))
+	}
+	for _, vol := range resData.Volumes {
+		total = total.add(processVolume(vol))
+	}
+	for _, zdb := range resData.Zdbs {
+		total = total.add(processZdb(zdb))
+	}
+	for _, k8s := range resData.Kubernetes {
+		total = total.add(processKubernetes(k8s))
+	}
+
+	units := rsuToCu(total)
+	return units.cu, units.su
+}
+
 func (e Stellar) processReservationResources(resData workloads.ReservationData) (rsuPerFarmer, error) {
 	rsuPerNodeMap := make(rsuPerNode)
 	for _, cont := range resData.Containers {
@@ -122,7 +143,7 @@ func (e Stellar) processReservationResources(resData workloads.ReservationData)
 	}
 	rsuPerFarmerMap := make(rsuPerFarmer)
 	for nodeID, rsu := range rsuPerNodeMap {
-		node, err := e.nodeAPI.Get(e.ctx, e.db, nodeID, false)
+		node, err := e.nodeAPI.Get(e.ctx, nodeID, false)
 		if err != nil {
 			return nil, errors.Wrap(err, "could not get node")
 		}
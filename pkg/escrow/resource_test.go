@@ -13,7 +13,6 @@ import (
 	directorytypes "github.com/threefoldtech/tfexplorer/pkg/directory/types"
 	"github.com/threefoldtech/tfexplorer/pkg/stellar"
 	"github.com/threefoldtech/tfexplorer/schema"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type (
@@ -142,7 +141,6 @@ func TestProcessReservation(t *testing.T) {
 
 	escrow := Stellar{
 		wallet:             &stellar.Wallet{},
-		db:                 nil,
 		reservationChannel: nil,
 		nodeAPI:            &nodeAPIMock{},
 	}
@@ -507,7 +505,6 @@ func TestCalculateReservationCost(t *testing.T) {
 
 	escrow := Stellar{
 		wallet:             &stellar.Wallet{},
-		db:                 nil,
 		reservationChannel: nil,
 		nodeAPI:            &nodeAPIMock{},
 	}
@@ -731,7 +728,7 @@ func TestResourceUnitsToCloudUnits(t *testing.T) {
 	}
 }
 
-func (napim *nodeAPIMock) Get(_ context.Context, _ *mongo.Database, nodeID string, _ bool) (directorytypes.Node, error) {
+func (napim *nodeAPIMock) Get(_ context.Context, nodeID string, _ bool) (directorytypes.Node, error) {
 	idInt, err := strconv.Atoi(nodeID)
 	if err != nil {
 		return directorytypes.Node{}, errors.New("node not found")
@@ -9,11 +9,13 @@ import (
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 	"github.com/stellar/go/xdr"
+	"github.com/threefoldtech/tfexplorer/config"
+	"github.com/threefoldtech/tfexplorer/models"
 	gdirectory "github.com/threefoldtech/tfexplorer/models/generated/directory"
 	"github.com/threefoldtech/tfexplorer/models/generated/workloads"
-	"github.com/threefoldtech/tfexplorer/pkg/directory"
 	directorytypes "github.com/threefoldtech/tfexplorer/pkg/directory/types"
 	"github.com/threefoldtech/tfexplorer/pkg/escrow/types"
+	phonebook "github.com/threefoldtech/tfexplorer/pkg/phonebook/types"
 	"github.com/threefoldtech/tfexplorer/pkg/stellar"
 	workloadtypes "github.com/threefoldtech/tfexplorer/pkg/workloads/types"
 	"github.com/threefoldtech/tfexplorer/schema"
@@ -25,14 +27,21 @@ type (
 	Stellar struct {
 		foundationAddress string
 		wallet            *stellar.Wallet
-		db                *mongo.Database
 
 		reservationChannel chan reservationRegisterJob
 		deployedChannel    chan schema.ID
 		cancelledChannel   chan schema.ID
 
-		nodeAPI NodeAPI
-		farmAPI FarmAPI
+		nodeAPI    NodeAPI
+		farmAPI    FarmAPI
+		gatewayAPI GatewayAPI
+		orgAPI     phonebook.OrganizationRepository
+
+		payments     types.PaymentRepository
+		addresses    types.AddressRepository
+		reservations workloadtypes.ReservationRepository
+		queue        workloadtypes.WorkloadQueue
+		tx           models.Transactor
 
 		ctx context.Context
 	}
@@ -40,13 +49,21 @@ type (
 	// NodeAPI operations on node database
 	NodeAPI interface {
 		// Get a node from the database using its ID
-		Get(ctx context.Context, db *mongo.Database, id string, proofs bool) (directorytypes.Node, error)
+		Get(ctx context.Context, id string, proofs bool) (directorytypes.Node, error)
 	}
 
 	// FarmAPI operations on farm database
 	FarmAPI interface {
-		// GetByID get a farm from the database using its ID
-		GetByID(ctx context.Context, db *mongo.Database, id int64) (directorytypes.Farm, error)
+		// Get a farm from the database using its ID
+		Get(ctx context.Context, id schema.ID) (directorytypes.Farm, error)
+		// ReleaseIPs frees the public ips reserved by a reservation
+		ReleaseIPs(ctx context.Context, reservation schema.ID) error
+	}
+
+	// GatewayAPI operations on gateway database
+	GatewayAPI interface {
+		// ReleaseDomains frees the domains claimed by a reservation
+		ReleaseDomains(ctx context.Context, reservation schema.ID) error
 	}
 
 	reservationRegisterJob struct {
@@ -64,6 +81,10 @@ type (
 const (
 	// interval between every check of active escrow accounts
 	balanceCheckInterval = time.Minute * 1
+	// time a new reservation has to get its escrow registered, the reservations
+	// still without escrow after it were abandoned by a crash or a failure of
+	// the explorer between storing and registering them
+	registrationGracePeriod = time.Minute * 10
 )
 
 const (
@@ -80,8 +101,9 @@ var (
 	ErrNoCurrencyShared = errors.New("none of the provided currencies is supported by all farmers")
 )
 
-// NewStellar creates a new escrow object and fetches all addresses for the escrow wallet
-func NewStellar(wallet *stellar.Wallet, db *mongo.Database, foundationAddress string) *Stellar {
+// NewStellar creates a new escrow object and fetches all addresses for the escrow wallet.
+// tx makes the reservations move to deploy atomically
+func NewStellar(wallet *stellar.Wallet, db *mongo.Database, tx models.Transactor, foundationAddress string) *Stellar {
 	jobChannel := make(chan reservationRegisterJob)
 	deployChannel := make(chan schema.ID)
 	cancelChannel := make(chan schema.ID)
@@ -93,10 +115,16 @@ func NewStellar(wallet *stellar.Wallet, db *mongo.Database, foundationAddress st
 
 	return &Stellar{
 		wallet:             wallet,
-		db:                 db,
 		foundationAddress:  addr,
-		nodeAPI:            &directory.NodeAPI{},
-		farmAPI:            &directory.FarmAPI{},
+		nodeAPI:            directorytypes.NewNodeRepository(db),
+		farmAPI:            directorytypes.NewFarmRepository(db),
+		gatewayAPI:         directorytypes.NewGatewayRepository(db),
+		orgAPI:             phonebook.NewOrganizationRepository(db),
+		payments:           types.NewPaymentRepository(db),
+		addresses:          types.NewAddressRepository(db),
+		reservations:       workloadtypes.NewReservationRepository(db),
+		queue:              workloadtypes.NewWorkloadQueue(db),
+		tx:                 tx,
 		reservationChannel: jobChannel,
 		deployedChannel:    deployChannel,
 		cancelledChannel:   cancelChannel,
@@ -127,6 +155,16 @@ func (e *Stellar) Run(ctx context.Context) error {
 				log.Error().Err(err).Msgf("failed to refund expired reservations")
 			}
 
+			log.Info().Msg("retrying held payouts")
+			if err := e.payoutHeldReservations(); err != nil {
+				log.Error().Err(err).Msgf("failed to payout held reservations")
+			}
+
+			log.Info().Msg("scanning for reservations without escrow")
+			if err := e.invalidateUnregisteredReservations(); err != nil {
+				log.Error().Err(err).Msgf("failed to invalidate reservations without escrow")
+			}
+
 		case job := <-e.reservationChannel:
 			log.Info().Int64("reservation_id", int64(job.reservation.ID)).Msg("processing new reservation escrow for reservation")
 			details, err := e.processReservation(job.reservation, job.supportedCurrencyCodes)
@@ -164,7 +202,7 @@ func (e *Stellar) Run(ctx context.Context) error {
 
 func (e *Stellar) refundExpiredReservations() error {
 	// load expired escrows
-	reservationEscrows, err := types.GetAllExpiredReservationPaymentInfos(e.ctx, e.db)
+	reservationEscrows, err := e.payments.ListExpired(e.ctx)
 	if err != nil {
 		return errors.Wrap(err, "failed to load active reservations from escrow")
 	}
@@ -177,9 +215,97 @@ func (e *Stellar) refundExpiredReservations() error {
 		}
 
 		escrowInfo.Canceled = true
-		if err = types.ReservationPaymentInfoUpdate(e.ctx, e.db, escrowInfo); err != nil {
+		if err = e.payments.Update(e.ctx, escrowInfo); err != nil {
 			log.Error().Err(err).Msgf("failed to mark expired reservation escrow info as cancelled")
 		}
+
+		// a reservation that was never paid is never deployed, the resources
+		// it claimed can be given back. Paid ones are released by the
+		// explorer once the nodes deleted their workloads
+		if escrowInfo.Paid {
+			continue
+		}
+
+		if err := e.releaseResources(escrowInfo.ReservationID); err != nil {
+			log.Error().Err(err).Int64("id", int64(escrowInfo.ReservationID)).Msg("failed to release expired reservation resources")
+		}
+	}
+	return nil
+}
+
+// releaseResources frees the public ips and domains held by a reservation
+func (e *Stellar) releaseResources(id schema.ID) error {
+	if err := e.farmAPI.ReleaseIPs(e.ctx, id); err != nil {
+		return errors.Wrap(err, "failed to release ip addresses")
+	}
+
+	if err := e.gatewayAPI.ReleaseDomains(e.ctx, id); err != nil {
+		return errors.Wrap(err, "failed to release domains")
+	}
+
+	return nil
+}
+
+// invalidateUnregisteredReservations gives back the resources claimed by the
+// reservations that were stored but never got an escrow, and makes sure they
+// are never processed. It runs in the escrow loop, so it can not race with the
+// registration of a reservation
+func (e *Stellar) invalidateUnregisteredReservations() error {
+	filter := workloadtypes.ReservationFilter{}.
+		WithNextActions(workloads.NextActionCreate, workloads.NextActionSign, workloads.NextActionPay).
+		WithEpochLT(time.Now().Add(-registrationGracePeriod))
+
+	reservations, err := e.reservations.Find(e.ctx, filter)
+	if err != nil {
+		return errors.Wrap(err, "failed to load the reservations waiting for payment")
+	}
+
+	for _, reservation := range reservations {
+		_, err := e.payments.Get(e.ctx, reservation.ID)
+		if err == nil {
+			continue
+		} else if !errors.Is(err, types.ErrEscrowNotFound) {
+			log.Error().Err(err).Int64("reservation_id", int64(reservation.ID)).Msg("failed to load reservation escrow")
+			continue
+		}
+
+		log.Warn().Int64("reservation_id", int64(reservation.ID)).Msg("invalidating reservation without escrow")
+
+		err = e.tx.WithTransaction(e.ctx, func(ctx context.Context) error {
+			if err := e.farmAPI.ReleaseIPs(ctx, reservation.ID); err != nil {
+				return err
+			}
+
+			if err := e.gatewayAPI.ReleaseDomains(ctx, reservation.ID); err != nil {
+				return err
+			}
+
+			return e.reservations.SetNextAction(ctx, reservation.ID, workloads.NextActionInvalid)
+		})
+
+		if err != nil {
+			log.Error().Err(err).Int64("reservation_id", int64(reservation.ID)).Msg("failed to invalidate reservation without escrow")
+		}
+	}
+
+	return nil
+}
+
+// payoutHeldReservations tries again to pay the farmers of the reservations
+// that were held because of unverified wallet addresses
+func (e *Stellar) payoutHeldReservations() error {
+	reservationEscrows, err := e.payments.ListHeld(e.ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to load held reservations from escrow")
+	}
+
+	for _, escrowInfo := range reservationEscrows {
+		if err := e.payoutFarmers(escrowInfo.ReservationID); err != nil {
+			log.Error().
+				Err(err).
+				Int64("reservation_id", int64(escrowInfo.ReservationID)).
+				Msg("failed to payout held reservation")
+		}
 	}
 	return nil
 }
@@ -189,7 +315,7 @@ func (e *Stellar) refundExpiredReservations() error {
 // if its underfunded it will throw an error.
 func (e *Stellar) checkReservations() error {
 	// load active escrows
-	reservationEscrows, err := types.GetAllActiveReservationPaymentInfos(e.ctx, e.db)
+	reservationEscrows, err := e.payments.ListActive(e.ctx)
 	if err != nil {
 		return errors.Wrap(err, "failed to load active reservations from escrow")
 	}
@@ -236,7 +362,7 @@ func (e *Stellar) checkReservationPaid(escrowInfo types.ReservationPaymentInform
 
 	slog.Debug().Msgf("required balance %d funded (%d), continue reservation", requiredValue, balance)
 
-	reservation, err := workloadtypes.ReservationFilter{}.WithID(escrowInfo.ReservationID).Get(e.ctx, e.db)
+	reservation, err := e.reservations.Get(e.ctx, escrowInfo.ReservationID)
 	if err != nil {
 		return errors.Wrap(err, "failed to load reservation")
 	}
@@ -246,7 +372,12 @@ func (e *Stellar) checkReservationPaid(escrowInfo types.ReservationPaymentInform
 		return errors.Wrap(err, "failed to process reservation pipeline")
 	}
 
-	reservation, _ = pl.Next()
+	signers, err := reservation.OrgSigners(e.ctx, e.orgAPI)
+	if err != nil {
+		return errors.Wrap(err, "failed to load organization signers")
+	}
+
+	reservation, _ = pl.WithOrgSigners(signers).Next()
 	if !reservation.IsAny(workloadtypes.Pay) {
 		// Do not continue, but also take no action to drive the reservation
 		// as much as possible from the main explorer part.
@@ -266,12 +397,12 @@ func (e *Stellar) checkReservationPaid(escrowInfo types.ReservationPaymentInform
 
 	slog.Info().Msg("all farmer are paid, trying to move to deploy state")
 
-	if err := workloadtypes.ReservationToDeploy(e.ctx, e.db, &reservation); err != nil {
+	if err := workloadtypes.ReservationToDeploy(e.ctx, e.tx, e.reservations, e.queue, &reservation); err != nil {
 		return errors.Wrap(err, "failed to schedule the reservation to deploy")
 	}
 
 	escrowInfo.Paid = true
-	if err = types.ReservationPaymentInfoUpdate(e.ctx, e.db, escrowInfo); err != nil {
+	if err = e.payments.Update(e.ctx, escrowInfo); err != nil {
 		return errors.Wrap(err, "failed to mark reservation escrow info as paid")
 	}
 
@@ -282,9 +413,8 @@ func (e *Stellar) checkReservationPaid(escrowInfo types.ReservationPaymentInform
 
 // processReservation processes a single reservation
 // calculates resources and their costs
-func (e *Stellar) processReservation(reservation workloads.Reservation, offeredCurrencyCodes []string) (types.CustomerEscrowInformation, error) {
-	var customerInfo types.CustomerEscrowInformation
-
+// quote selects the asset the reservation is paid with and computes the cost for each farmer
+func (e *Stellar) quote(reservation workloads.Reservation, offeredCurrencyCodes []string) (stellar.Asset, map[int64]xdr.Int64, error) {
 	// filter out unsupported currencies
 	currencies := []stellar.Asset{}
 	for _, offeredCurrency := range offeredCurrencyCodes {
@@ -293,7 +423,7 @@ func (e *Stellar) processReservation(reservation workloads.Reservation, offeredC
 			if err == stellar.ErrAssetCodeNotSupported {
 				continue
 			}
-			return customerInfo, err
+			return "", nil, err
 		}
 		// Sanity check
 		if _, exists := assetDistributions[asset]; !exists {
@@ -306,12 +436,12 @@ func (e *Stellar) processReservation(reservation workloads.Reservation, offeredC
 	}
 
 	if len(currencies) == 0 {
-		return customerInfo, ErrNoCurrencySupported
+		return "", nil, ErrNoCurrencySupported
 	}
 
 	rsuPerFarmer, err := e.processReservationResources(reservation.DataReservation)
 	if err != nil {
-		return customerInfo, errors.Wrap(err, "failed to process reservation resources")
+		return "", nil, errors.Wrap(err, "failed to process reservation resources")
 	}
 
 	// check which currencies are accepted by all farmers
@@ -328,7 +458,7 @@ func (e *Stellar) processReservation(reservation workloads.Reservation, offeredC
 		// check if all used farms have an address for this asset set up
 		supported, err := e.checkAssetSupport(farmIDs, asset)
 		if err != nil {
-			return customerInfo, errors.Wrap(err, "could not verify asset support")
+			return "", nil, errors.Wrap(err, "could not verify asset support")
 		}
 		if supported {
 			asset = currency
@@ -337,13 +467,24 @@ func (e *Stellar) processReservation(reservation workloads.Reservation, offeredC
 	}
 
 	if asset == "" {
-		return customerInfo, ErrNoCurrencyShared
+		return "", nil, ErrNoCurrencyShared
 	}
 
 	duration := time.Until(reservation.DataReservation.ExpirationReservation.Time)
 	res, err := e.calculateReservationCost(rsuPerFarmer, duration)
 	if err != nil {
-		return customerInfo, errors.Wrap(err, "failed to process reservation resources costs")
+		return "", nil, errors.Wrap(err, "failed to process reservation resources costs")
+	}
+
+	return asset, res, nil
+}
+
+func (e *Stellar) processReservation(reservation workloads.Reservation, offeredCurrencyCodes []string) (types.CustomerEscrowInformation, error) {
+	var customerInfo types.CustomerEscrowInformation
+
+	asset, res, err := e.quote(reservation, offeredCurrencyCodes)
+	if err != nil {
+		return customerInfo, err
 	}
 
 	address, err := e.createOrLoadAccount(reservation.CustomerTid)
@@ -368,7 +509,7 @@ func (e *Stellar) processReservation(reservation workloads.Reservation, offeredC
 		Canceled:      false,
 		Released:      false,
 	}
-	err = types.ReservationPaymentInfoCreate(e.ctx, e.db, reservationPaymentInfo)
+	err = e.payments.Create(e.ctx, reservationPaymentInfo)
 	if err != nil {
 		return customerInfo, errors.Wrap(err, "failed to create reservation payment information")
 	}
@@ -381,7 +522,7 @@ func (e *Stellar) processReservation(reservation workloads.Reservation, offeredC
 
 // refundClients refunds clients if the reservation is cancelled
 func (e *Stellar) refundClients(id schema.ID) error {
-	rpi, err := types.ReservationPaymentInfoGet(e.ctx, e.db, id)
+	rpi, err := e.payments.Get(e.ctx, id)
 	if err != nil {
 		return errors.Wrap(err, "failed to get reservation escrow info")
 	}
@@ -394,7 +535,7 @@ func (e *Stellar) refundClients(id schema.ID) error {
 		return errors.Wrap(err, "could not refund escrow")
 	}
 	rpi.Canceled = true
-	if err = types.ReservationPaymentInfoUpdate(e.ctx, e.db, rpi); err != nil {
+	if err = e.payments.Update(e.ctx, rpi); err != nil {
 		return errors.Wrapf(err, "could not mark escrows for %d as canceled", rpi.ReservationID)
 	}
 	log.Debug().Int64("id", int64(rpi.ReservationID)).Msg("refunded clients for reservation")
@@ -403,7 +544,7 @@ func (e *Stellar) refundClients(id schema.ID) error {
 
 // payoutFarmers pays out the farmer for a processed reservation
 func (e *Stellar) payoutFarmers(id schema.ID) error {
-	rpi, err := types.ReservationPaymentInfoGet(e.ctx, e.db, id)
+	rpi, err := e.payments.Get(e.ctx, id)
 	if err != nil {
 		return errors.Wrap(err, "failed to get reservation escrow info")
 	}
@@ -423,6 +564,8 @@ func (e *Stellar) payoutFarmers(id schema.ID) error {
 	// collect the farmer addresses and amount they should receive, we already
 	// have sufficient balance on the escrow to cover this
 	paymentInfo := make([]stellar.PayoutInfo, 0, len(rpi.Infos))
+	// set if the payout must be held until all farmers verified their address
+	unverified := false
 
 	for _, escrowDetails := range rpi.Infos {
 		farmerAmount, burnAmount, foundationAmount := e.splitPayout(escrowDetails.TotalAmount, paymentDistribution)
@@ -432,7 +575,7 @@ func (e *Stellar) payoutFarmers(id schema.ID) error {
 		if farmerAmount > 0 {
 			// in case of an error in this flow we continue, so we try to pay as much
 			// farmers as possible even if one fails
-			farm, err := e.farmAPI.GetByID(e.ctx, e.db, int64(escrowDetails.FarmerID))
+			farm, err := e.farmAPI.Get(e.ctx, schema.ID(escrowDetails.FarmerID))
 			if err != nil {
 				log.Error().Msgf("failed to load farm info: %s", err)
 				continue
@@ -445,16 +588,40 @@ func (e *Stellar) payoutFarmers(id schema.ID) error {
 				continue
 			}
 
+			if !destination.Verified {
+				switch config.Config.UnverifiedPayout {
+				case config.PayoutRefuse:
+					// the farmer share stays on the escrow and is refunded to the customer
+					log.Warn().Msgf("refusing to pay unverified address %s of farmer %d", destination.Address, farm.ID)
+					continue
+				case config.PayoutHold:
+					log.Warn().Msgf("holding payout of reservation %d until address %s of farmer %d is verified", id, destination.Address, farm.ID)
+					unverified = true
+				}
+			}
+
 			// farmerAmount can't be pooled so add an info immediately
 			paymentInfo = append(paymentInfo,
 				stellar.PayoutInfo{
-					Address: destination,
+					Address: destination.Address,
 					Amount:  farmerAmount,
 				},
 			)
 		}
 	}
 
+	if unverified {
+		if rpi.Held {
+			return nil
+		}
+
+		rpi.Held = true
+		if err = e.payments.Update(e.ctx, rpi); err != nil {
+			return errors.Wrapf(err, "could not mark escrows for %d as held", rpi.ReservationID)
+		}
+		return nil
+	}
+
 	// a burn is a transfer of tokens back to the issuer
 	if toBurn > 0 {
 		paymentInfo = append(paymentInfo,
@@ -473,7 +640,7 @@ func (e *Stellar) payoutFarmers(id schema.ID) error {
 			})
 	}
 
-	addressInfo, err := types.CustomerAddressByAddress(e.ctx, e.db, rpi.Address)
+	addressInfo, err := e.addresses.GetByAddress(e.ctx, rpi.Address)
 	if err != nil {
 		log.Error().Msgf("failed to load escrow address info: %s", err)
 		return errors.Wrap(err, "could not load escrow address info")
@@ -493,7 +660,8 @@ func (e *Stellar) payoutFarmers(id schema.ID) error {
 		Msgf("paid farmer")
 
 	rpi.Released = true
-	if err = types.ReservationPaymentInfoUpdate(e.ctx, e.db, rpi); err != nil {
+	rpi.Held = false
+	if err = e.payments.Update(e.ctx, rpi); err != nil {
 		return errors.Wrapf(err, "could not mark escrows for %d as released", rpi.ReservationID)
 	}
 	return nil
@@ -507,7 +675,7 @@ func (e *Stellar) refundEscrow(escrowInfo types.ReservationPaymentInformation) e
 
 	slog.Info().Msgf("try to refund client for escrow")
 
-	addressInfo, err := types.CustomerAddressByAddress(e.ctx, e.db, escrowInfo.Address)
+	addressInfo, err := e.addresses.GetByAddress(e.ctx, escrowInfo.Address)
 	if err != nil {
 		return errors.Wrap(err, "failed to load escrow info")
 	}
@@ -534,6 +702,23 @@ func (e *Stellar) RegisterReservation(reservation workloads.Reservation, support
 	return response.data, response.err
 }
 
+// Quote computes the price of a reservation without registering it
+func (e *Stellar) Quote(reservation workloads.Reservation, supportedCurrencies []string) (types.Quote, error) {
+	quote := types.NewQuote(ReservationCloudUnits(reservation.DataReservation))
+
+	asset, costs, err := e.quote(reservation, supportedCurrencies)
+	if err != nil {
+		return quote, err
+	}
+
+	quote.Asset = asset
+	for _, cost := range costs {
+		quote.Amount += cost
+	}
+
+	return quote, nil
+}
+
 // ReservationDeployed informs the escrow that a reservation has been successfully
 // deployed, so the escrow can release the funds to the farmer (and refund any excess)
 func (e *Stellar) ReservationDeployed(reservationID schema.ID) {
@@ -548,14 +733,14 @@ func (e *Stellar) ReservationCanceled(reservationID schema.ID) {
 
 // createOrLoadAccount creates or loads account based on  customer id
 func (e *Stellar) createOrLoadAccount(customerTID int64) (string, error) {
-	res, err := types.CustomerAddressGet(context.Background(), e.db, customerTID)
+	res, err := e.addresses.Get(context.Background(), customerTID)
 	if err != nil {
 		if err == types.ErrAddressNotFound {
 			seed, address, err := e.wallet.CreateAccount()
 			if err != nil {
 				return "", errors.Wrapf(err, "failed to create a new account for customer %d", customerTID)
 			}
-			err = types.CustomerAddressCreate(context.Background(), e.db, types.CustomerAddress{
+			err = e.addresses.Create(context.Background(), types.CustomerAddress{
 				CustomerTID: customerTID,
 				Address:     address,
 				Secret:      seed,
@@ -634,7 +819,7 @@ func (e *Stellar) splitPayout(totalAmount xdr.Int64, distribution payoutDistribu
 // checkAssetSupport for all unique farms in the reservation
 func (e *Stellar) checkAssetSupport(farmIDs []int64, asset stellar.Asset) (bool, error) {
 	for _, id := range farmIDs {
-		farm, err := e.farmAPI.GetByID(e.ctx, e.db, id)
+		farm, err := e.farmAPI.Get(e.ctx, schema.ID(id))
 		if err != nil {
 			return false, errors.Wrap(err, "could not load farm")
 		}
@@ -646,11 +831,11 @@ func (e *Stellar) checkAssetSupport(farmIDs []int64, asset stellar.Asset) (bool,
 	return true, nil
 }
 
-func addressByAsset(addrs []gdirectory.WalletAddress, asset stellar.Asset) (string, error) {
+func addressByAsset(addrs []gdirectory.WalletAddress, asset stellar.Asset) (gdirectory.WalletAddress, error) {
 	for _, a := range addrs {
 		if a.Asset == asset.Code() && a.Address != "" {
-			return a.Address, nil
+			return a, nil
 		}
 	}
-	return "", fmt.Errorf("not address found for asset %s", asset)
+	return gdirectory.WalletAddress{}, fmt.Errorf("not address found for asset %s", asset)
 }
@@ -1,11 +1,20 @@
 package escrow
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/stellar/go/xdr"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/tfexplorer/models"
+	"github.com/threefoldtech/tfexplorer/models/generated/workloads"
+	directorytypes "github.com/threefoldtech/tfexplorer/pkg/directory/types"
+	"github.com/threefoldtech/tfexplorer/pkg/escrow/types"
 	"github.com/threefoldtech/tfexplorer/pkg/stellar"
+	workloadtypes "github.com/threefoldtech/tfexplorer/pkg/workloads/types"
+	"github.com/threefoldtech/tfexplorer/schema"
 )
 
 func TestPayoutDistribution(t *testing.T) {
@@ -46,7 +55,7 @@ func TestPayoutDistribution(t *testing.T) {
 	w, err := stellar.New("", stellar.NetworkTest, nil)
 	assert.NoError(t, err)
 
-	e := NewStellar(w, nil, "")
+	e := NewStellar(w, nil, models.NoTransaction, "")
 
 	// check rounding in some trivial cases
 	farmer, burn, fd := e.splitPayout(10, pds[0])
@@ -89,3 +98,42 @@ func TestPayoutDistribution(t *testing.T) {
 	assert.Equal(t, xdr.Int64(241), burn)
 	assert.Equal(t, xdr.Int64(89), fd)
 }
+
+func TestInvalidateUnregisteredReservations(t *testing.T) {
+	ctx := context.Background()
+	e := &Stellar{
+		farmAPI:      directorytypes.NewMemoryFarmRepository(),
+		gatewayAPI:   directorytypes.NewMemoryGatewayRepository(),
+		payments:     types.NewMemoryPaymentRepository(),
+		reservations: workloadtypes.NewMemoryReservationRepository(),
+		tx:           models.NoTransaction,
+		ctx:          ctx,
+	}
+
+	create := func(age time.Duration) schema.ID {
+		id, err := e.reservations.Create(ctx, workloadtypes.Reservation{
+			NextAction: workloads.NextActionPay,
+			Epoch:      schema.Date{Time: time.Now().Add(-age)},
+		})
+		require.NoError(t, err)
+		return id
+	}
+
+	abandoned := create(time.Hour)
+	registered := create(time.Hour)
+	recent := create(time.Minute)
+	require.NoError(t, e.payments.Create(ctx, types.ReservationPaymentInformation{ReservationID: registered}))
+
+	require.NoError(t, e.invalidateUnregisteredReservations())
+
+	expected := map[schema.ID]workloads.NextActionEnum{
+		abandoned:  workloads.NextActionInvalid,
+		registered: workloads.NextActionPay,
+		recent:     workloads.NextActionPay,
+	}
+	for id, action := range expected {
+		reservation, err := e.reservations.Get(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, action, reservation.NextAction, "reservation %d", id)
+	}
+}
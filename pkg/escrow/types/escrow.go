@@ -50,6 +50,10 @@ type (
 		// entire reservation being canceled. As a result, an attempt was made
 		// to refund the client. It is possible for this to have failed.
 		Canceled bool `bson:"canceled"`
+		// Held indicates the reservation has been deployed but the payout
+		// is waiting for the farmers to verify their wallet address. Held
+		// escrows are not refunded when they expire
+		Held bool `bson:"held"`
 	}
 
 	// EscrowDetail hold the details of an escrow address
@@ -58,6 +62,14 @@ type (
 		TotalAmount xdr.Int64 `bson:"total_amount" json:"total_amount"`
 	}
 
+	// Quote is the price of a reservation
+	Quote struct {
+		Asset  stellar.Asset `json:"asset"`
+		Amount xdr.Int64     `json:"amount"`
+		Cu     float64       `json:"cu"`
+		Su     float64       `json:"su"`
+	}
+
 	// CustomerEscrowInformation is the escrow information which will get exposed
 	// to the customer once he creates a reservation
 	CustomerEscrowInformation struct {
@@ -67,6 +79,11 @@ type (
 	}
 )
 
+// NewQuote creates a quote for cu and su, without any cost
+func NewQuote(cu, su float64) Quote {
+	return Quote{Cu: cu, Su: su}
+}
+
 // ReservationPaymentInfoCreate creates the reservation payment information
 func ReservationPaymentInfoCreate(ctx context.Context, db *mongo.Database, reservationPaymentInfo ReservationPaymentInformation) error {
 	col := db.Collection(EscrowCollection)
@@ -110,10 +127,24 @@ func ReservationPaymentInfoGet(ctx context.Context, db *mongo.Database, id schem
 
 }
 
+// activeFilter matches the escrows still waiting to be funded
+func activeFilter() bson.M {
+	return bson.M{"paid": false, "expiration": bson.M{"$gt": schema.Date{Time: time.Now()}}}
+}
+
+// expiredFilter matches the escrows that expired before being released
+func expiredFilter() bson.M {
+	return bson.M{"released": false, "canceled": false, "held": bson.M{"$ne": true}, "expiration": bson.M{"$lte": schema.Date{Time: time.Now()}}}
+}
+
+// heldFilter matches the escrows whose payout is held
+func heldFilter() bson.M {
+	return bson.M{"held": true, "released": false, "canceled": false}
+}
+
 // GetAllActiveReservationPaymentInfos get all active reservation payment information
 func GetAllActiveReservationPaymentInfos(ctx context.Context, db *mongo.Database) ([]ReservationPaymentInformation, error) {
-	filter := bson.M{"paid": false, "expiration": bson.M{"$gt": schema.Date{Time: time.Now()}}}
-	cursor, err := db.Collection(EscrowCollection).Find(ctx, filter)
+	cursor, err := db.Collection(EscrowCollection).Find(ctx, activeFilter())
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get cursor over active payment infos")
 	}
@@ -127,8 +158,7 @@ func GetAllActiveReservationPaymentInfos(ctx context.Context, db *mongo.Database
 
 // GetAllExpiredReservationPaymentInfos get all active reservation payment information
 func GetAllExpiredReservationPaymentInfos(ctx context.Context, db *mongo.Database) ([]ReservationPaymentInformation, error) {
-	filter := bson.M{"released": false, "canceled": false, "expiration": bson.M{"$lte": schema.Date{Time: time.Now()}}}
-	cursor, err := db.Collection(EscrowCollection).Find(ctx, filter)
+	cursor, err := db.Collection(EscrowCollection).Find(ctx, expiredFilter())
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get cursor over expired payment infos")
 	}
@@ -139,3 +169,51 @@ func GetAllExpiredReservationPaymentInfos(ctx context.Context, db *mongo.Databas
 	}
 	return paymentInfos, err
 }
+
+// GetAllHeldReservationPaymentInfos get all the reservation payment information
+// which payout is waiting for wallet addresses to be verified
+func GetAllHeldReservationPaymentInfos(ctx context.Context, db *mongo.Database) ([]ReservationPaymentInformation, error) {
+	cursor, err := db.Collection(EscrowCollection).Find(ctx, heldFilter())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get cursor over held payment infos")
+	}
+	paymentInfos := make([]ReservationPaymentInformation, 0)
+	err = cursor.All(ctx, &paymentInfos)
+	if err != nil {
+		err = errors.Wrap(err, "failed to decode held payment information")
+	}
+	return paymentInfos, err
+}
+
+// ReservationPaymentInfoSpent returns the total amount of the asset with the given
+// code due in the escrows of the reservations. Canceled escrows are ignored
+func ReservationPaymentInfoSpent(ctx context.Context, db *mongo.Database, ids []schema.ID, code string) (xdr.Int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	cursor, err := db.Collection(EscrowCollection).Find(ctx, bson.M{
+		"_id":      bson.M{"$in": ids},
+		"canceled": false,
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get reservation payment infos")
+	}
+
+	var infos []ReservationPaymentInformation
+	if err := cursor.All(ctx, &infos); err != nil {
+		return 0, errors.Wrap(err, "failed to decode reservation payment infos")
+	}
+
+	var total xdr.Int64
+	for _, info := range infos {
+		if info.Asset.Code() != code {
+			continue
+		}
+		for _, detail := range info.Infos {
+			total += detail.TotalAmount
+		}
+	}
+
+	return total, nil
+}
@@ -0,0 +1,56 @@
+package types
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/tfexplorer/schema"
+)
+
+func TestMemoryPaymentLists(t *testing.T) {
+	ctx := context.Background()
+	payments := NewMemoryPaymentRepository()
+
+	future := schema.Date{Time: time.Now().Add(time.Hour)}
+	past := schema.Date{Time: time.Now().Add(-time.Hour)}
+	for _, info := range []ReservationPaymentInformation{
+		{ReservationID: 1, Expiration: future},
+		{ReservationID: 2, Expiration: past},
+		{ReservationID: 3, Expiration: past, Held: true},
+		{ReservationID: 4, Expiration: past, Canceled: true},
+	} {
+		require.NoError(t, payments.Create(ctx, info))
+	}
+
+	ids := func(infos []ReservationPaymentInformation, err error) []schema.ID {
+		require.NoError(t, err)
+		var ids []schema.ID
+		for _, info := range infos {
+			ids = append(ids, info.ReservationID)
+		}
+		return ids
+	}
+
+	assert.Equal(t, []schema.ID{1}, ids(payments.ListActive(ctx)))
+	assert.Equal(t, []schema.ID{2}, ids(payments.ListExpired(ctx)))
+	assert.Equal(t, []schema.ID{3}, ids(payments.ListHeld(ctx)))
+}
+
+func TestMemoryAddresses(t *testing.T) {
+	ctx := context.Background()
+	addresses := NewMemoryAddressRepository()
+
+	require.NoError(t, addresses.Create(ctx, CustomerAddress{CustomerTID: 1, Address: "a"}))
+	assert.Equal(t, ErrAddressExists, addresses.Create(ctx, CustomerAddress{CustomerTID: 1, Address: "b"}))
+	assert.Equal(t, ErrAddressExists, addresses.Create(ctx, CustomerAddress{CustomerTID: 2, Address: "a"}))
+
+	address, err := addresses.GetByAddress(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), address.CustomerTID)
+
+	_, err = addresses.Get(ctx, 2)
+	assert.Equal(t, ErrAddressNotFound, err)
+}
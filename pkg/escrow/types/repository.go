@@ -0,0 +1,100 @@
+package types
+
+import (
+	"context"
+
+	"github.com/stellar/go/xdr"
+	"github.com/threefoldtech/tfexplorer/schema"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// PaymentRepository stores the payment information of the reservations
+type PaymentRepository interface {
+	// Get returns the payment information of reservation id, ErrEscrowNotFound if it does not exist
+	Get(ctx context.Context, id schema.ID) (ReservationPaymentInformation, error)
+	// Create stores the payment information of a reservation, ErrEscrowExists
+	// if the reservation already has one
+	Create(ctx context.Context, info ReservationPaymentInformation) error
+	// Update replaces the payment information of the reservation
+	Update(ctx context.Context, info ReservationPaymentInformation) error
+	// Spent returns the total amount of the asset with code due in the
+	// escrows of the reservations ids. Canceled escrows are ignored
+	Spent(ctx context.Context, ids []schema.ID, code string) (xdr.Int64, error)
+	// ListActive returns the escrows still waiting to be funded
+	ListActive(ctx context.Context) ([]ReservationPaymentInformation, error)
+	// ListExpired returns the escrows that expired before being released
+	ListExpired(ctx context.Context) ([]ReservationPaymentInformation, error)
+	// ListHeld returns the escrows whose payout waits for the farmers to
+	// verify their wallet addresses
+	ListHeld(ctx context.Context) ([]ReservationPaymentInformation, error)
+}
+
+// AddressRepository stores the escrow addresses of the customers
+type AddressRepository interface {
+	// Get returns the address of the customer, ErrAddressNotFound if it has none
+	Get(ctx context.Context, customerTID int64) (CustomerAddress, error)
+	// GetByAddress returns the customer address with address, ErrAddressNotFound
+	// if it does not exist
+	GetByAddress(ctx context.Context, address string) (CustomerAddress, error)
+	// Create stores the address of a customer, ErrAddressExists if the
+	// customer or the address is already known
+	Create(ctx context.Context, address CustomerAddress) error
+}
+
+// NewPaymentRepository returns a PaymentRepository backed by db
+func NewPaymentRepository(db *mongo.Database) PaymentRepository {
+	return &paymentRepository{db: db}
+}
+
+type paymentRepository struct {
+	db *mongo.Database
+}
+
+func (p *paymentRepository) Get(ctx context.Context, id schema.ID) (ReservationPaymentInformation, error) {
+	return ReservationPaymentInfoGet(ctx, p.db, id)
+}
+
+func (p *paymentRepository) Create(ctx context.Context, info ReservationPaymentInformation) error {
+	return ReservationPaymentInfoCreate(ctx, p.db, info)
+}
+
+func (p *paymentRepository) Update(ctx context.Context, info ReservationPaymentInformation) error {
+	return ReservationPaymentInfoUpdate(ctx, p.db, info)
+}
+
+func (p *paymentRepository) Spent(ctx context.Context, ids []schema.ID, code string) (xdr.Int64, error) {
+	return ReservationPaymentInfoSpent(ctx, p.db, ids, code)
+}
+
+func (p *paymentRepository) ListActive(ctx context.Context) ([]ReservationPaymentInformation, error) {
+	return GetAllActiveReservationPaymentInfos(ctx, p.db)
+}
+
+func (p *paymentRepository) ListExpired(ctx context.Context) ([]ReservationPaymentInformation, error) {
+	return GetAllExpiredReservationPaymentInfos(ctx, p.db)
+}
+
+func (p *paymentRepository) ListHeld(ctx context.Context) ([]ReservationPaymentInformation, error) {
+	return GetAllHeldReservationPaymentInfos(ctx, p.db)
+}
+
+// NewAddressRepository returns an AddressRepository backed by db
+func NewAddressRepository(db *mongo.Database) AddressRepository {
+	return &addressRepository{db: db}
+}
+
+type addressRepository struct {
+	db *mongo.Database
+}
+
+func (a *addressRepository) Get(ctx context.Context, customerTID int64) (CustomerAddress, error) {
+	return CustomerAddressGet(ctx, a.db, customerTID)
+}
+
+func (a *addressRepository) GetByAddress(ctx context.Context, address string) (CustomerAddress, error) {
+	return CustomerAddressByAddress(ctx, a.db, address)
+}
+
+func (a *addressRepository) Create(ctx context.Context, address CustomerAddress) error {
+	return CustomerAddressCreate(ctx, a.db, address)
+}
@@ -0,0 +1,176 @@
+package types
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/stellar/go/xdr"
+	"github.com/threefoldtech/tfexplorer/models"
+	"github.com/threefoldtech/tfexplorer/schema"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// NewMemoryPaymentRepository returns a PaymentRepository that keeps the
+// payment information in memory
+func NewMemoryPaymentRepository() PaymentRepository {
+	return &memoryPaymentRepository{payments: models.NewMemoryCollection()}
+}
+
+type memoryPaymentRepository struct {
+	mu       sync.Mutex
+	payments *models.MemoryCollection
+}
+
+func (m *memoryPaymentRepository) get(id schema.ID) (info ReservationPaymentInformation, err error) {
+	err = m.payments.Get(id, &info)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return info, ErrEscrowNotFound
+	}
+
+	return
+}
+
+func (m *memoryPaymentRepository) Get(ctx context.Context, id schema.ID) (ReservationPaymentInformation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.get(id)
+}
+
+func (m *memoryPaymentRepository) Create(ctx context.Context, info ReservationPaymentInformation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, err := m.get(info.ReservationID); err == nil {
+		return ErrEscrowExists
+	} else if !errors.Is(err, ErrEscrowNotFound) {
+		return err
+	}
+
+	return m.payments.Put(info.ReservationID, info)
+}
+
+func (m *memoryPaymentRepository) Update(ctx context.Context, info ReservationPaymentInformation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, err := m.get(info.ReservationID); err != nil {
+		// like an update that matches no payment
+		return nil
+	}
+
+	return m.payments.Put(info.ReservationID, info)
+}
+
+func (m *memoryPaymentRepository) Spent(ctx context.Context, ids []schema.ID, code string) (xdr.Int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var total xdr.Int64
+	for _, id := range ids {
+		info, err := m.get(id)
+		if errors.Is(err, ErrEscrowNotFound) {
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+
+		if info.Canceled || info.Asset.Code() != code {
+			continue
+		}
+
+		for _, detail := range info.Infos {
+			total += detail.TotalAmount
+		}
+	}
+
+	return total, nil
+}
+
+func (m *memoryPaymentRepository) list(filter bson.M) ([]ReservationPaymentInformation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	infos := []ReservationPaymentInformation{}
+	docs, err := m.payments.Find(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, doc := range docs {
+		var info ReservationPaymentInformation
+		if err := bson.Unmarshal(doc, &info); err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
+
+func (m *memoryPaymentRepository) ListActive(ctx context.Context) ([]ReservationPaymentInformation, error) {
+	return m.list(activeFilter())
+}
+
+func (m *memoryPaymentRepository) ListExpired(ctx context.Context) ([]ReservationPaymentInformation, error) {
+	return m.list(expiredFilter())
+}
+
+func (m *memoryPaymentRepository) ListHeld(ctx context.Context) ([]ReservationPaymentInformation, error) {
+	return m.list(heldFilter())
+}
+
+// NewMemoryAddressRepository returns an AddressRepository that keeps the
+// customer addresses in memory
+func NewMemoryAddressRepository() AddressRepository {
+	return &memoryAddressRepository{addresses: models.NewMemoryCollection()}
+}
+
+type memoryAddressRepository struct {
+	mu        sync.Mutex
+	addresses *models.MemoryCollection
+}
+
+func (m *memoryAddressRepository) find(filter bson.M) (address CustomerAddress, err error) {
+	docs, err := m.addresses.Find(filter)
+	if err != nil {
+		return address, err
+	} else if len(docs) == 0 {
+		return address, ErrAddressNotFound
+	}
+
+	err = bson.Unmarshal(docs[0], &address)
+	return
+}
+
+func (m *memoryAddressRepository) Get(ctx context.Context, customerTID int64) (CustomerAddress, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.find(bson.M{"customer_tid": customerTID})
+}
+
+func (m *memoryAddressRepository) GetByAddress(ctx context.Context, address string) (CustomerAddress, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.find(bson.M{"address": address})
+}
+
+func (m *memoryAddressRepository) Create(ctx context.Context, address CustomerAddress) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// both the customer and the address are unique
+	for _, filter := range []bson.M{{"customer_tid": address.CustomerTID}, {"address": address.Address}} {
+		if _, err := m.find(filter); err == nil {
+			return ErrAddressExists
+		} else if !errors.Is(err, ErrAddressNotFound) {
+			return err
+		}
+	}
+
+	return m.addresses.Put(address.CustomerTID, address)
+}
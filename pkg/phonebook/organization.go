@@ -0,0 +1,327 @@
+package phonebook
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/tfexplorer/models"
+	generated "github.com/threefoldtech/tfexplorer/models/generated/phonebook"
+	"github.com/threefoldtech/tfexplorer/mw"
+	"github.com/threefoldtech/tfexplorer/pkg/phonebook/types"
+	"github.com/threefoldtech/tfexplorer/schema"
+	"github.com/zaibon/httpsig"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// OrganizationAPI struct
+type OrganizationAPI struct {
+	orgs  types.OrganizationRepository
+	users types.UserRepository
+	// admins are the threebot ids of the explorer admins, they can raise budgets
+	admins []int64
+}
+
+// requesterID returns the threebot id of the user that signed the request
+func requesterID(r *http.Request) (int64, mw.Response) {
+	sid := httpsig.KeyIDFromContext(r.Context())
+	id, err := strconv.ParseInt(sid, 10, 64)
+	if err != nil {
+		return 0, mw.BadRequest(err)
+	}
+
+	return id, nil
+}
+
+// roles implements mw.RoleResolver for the organizations
+func (o *OrganizationAPI) roles(ctx context.Context, orgID string, tid int64) ([]mw.Role, error) {
+	id, err := strconv.ParseInt(orgID, 10, 64)
+	if err != nil {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	org, err := o.orgs.Get(ctx, schema.ID(id))
+	if errors.Is(err, types.ErrOrganizationNotFound) {
+		return nil, mongo.ErrNoDocuments
+	} else if err != nil {
+		return nil, err
+	}
+
+	var roles []mw.Role
+	if org.IsOwner(tid) {
+		roles = append(roles, mw.RoleOrgOwner)
+	}
+	if org.CanManage(tid) {
+		roles = append(roles, mw.RoleOrgAdmin)
+	}
+	if org.IsMember(tid) {
+		roles = append(roles, mw.RoleOrgMember)
+	}
+
+	return roles, nil
+}
+
+func (o *OrganizationAPI) loadOrganization(r *http.Request) (types.Organization, mw.Response) {
+	id, err := strconv.ParseInt(mux.Vars(r)["org_id"], 10, 64)
+	if err != nil {
+		return types.Organization{}, mw.BadRequest(errors.Wrap(err, "invalid organization id"))
+	}
+
+	org, err := o.orgs.Get(r.Context(), schema.ID(id))
+	if errors.Is(err, types.ErrOrganizationNotFound) {
+		return types.Organization{}, mw.NotFound(err)
+	} else if err != nil {
+		return types.Organization{}, mw.Error(err)
+	}
+
+	return org, nil
+}
+
+// create an organization, the user signing the request becomes its owner
+func (o *OrganizationAPI) create(r *http.Request) (interface{}, mw.Response) {
+	tid, merr := requesterID(r)
+	if merr != nil {
+		return nil, merr
+	}
+
+	var info struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	}
+
+	defer r.Body.Close()
+
+	if err := json.NewDecoder(r.Body).Decode(&info); err != nil {
+		return nil, mw.BadRequest(err)
+	}
+
+	org, err := types.OrganizationCreate(r.Context(), o.orgs, info.Name, info.Description, tid)
+	if errors.Is(err, types.ErrOrganizationExists) {
+		return nil, mw.Conflict(err)
+	} else if err != nil {
+		return nil, mw.BadRequest(err)
+	}
+
+	return org, mw.Created()
+}
+
+func (o *OrganizationAPI) list(r *http.Request) (interface{}, mw.Response) {
+	member, err := models.QueryInt(r, "member")
+	if err != nil {
+		return nil, mw.BadRequest(errors.Wrap(err, "member should be an integer"))
+	}
+
+	var filter types.OrganizationFilter
+	filter = filter.WithName(r.FormValue("name"))
+	filter = filter.WithMember(member)
+
+	query, err := models.ParseQuery(r, types.OrganizationQueryFields)
+	if err != nil {
+		return nil, mw.BadRequest(err)
+	}
+	filter = filter.WithQuery(query)
+
+	pager, err := models.PageFromRequest(r)
+	if err != nil {
+		return nil, mw.BadRequest(err)
+	}
+
+	pager, err = query.Page(pager)
+	if err != nil {
+		return nil, mw.BadRequest(err)
+	}
+
+	orgs, total, err := o.orgs.List(r.Context(), filter, pager, query.Projection())
+	if err != nil {
+		return nil, mw.Error(err)
+	}
+
+	next, err := pager.Next(orgs)
+	if err != nil {
+		return nil, mw.Error(err)
+	}
+
+	out, err := query.Project(orgs)
+	if err != nil {
+		return nil, mw.Error(err)
+	}
+
+	response := mw.Paginated(r, next)
+	if pager.Paged() {
+		response = response.WithHeader("Pages", fmt.Sprint(models.Pages(pager, total)))
+	}
+
+	return out, response
+}
+
+func (o *OrganizationAPI) get(r *http.Request) (interface{}, mw.Response) {
+	return o.loadOrganization(r)
+}
+
+func (o *OrganizationAPI) update(r *http.Request) (interface{}, mw.Response) {
+	org, merr := o.loadOrganization(r)
+	if merr != nil {
+		return nil, merr
+	}
+
+	var info struct {
+		Description string `json:"description"`
+	}
+
+	defer r.Body.Close()
+
+	if err := json.NewDecoder(r.Body).Decode(&info); err != nil {
+		return nil, mw.BadRequest(err)
+	}
+
+	if err := o.orgs.SetDescription(r.Context(), org.ID, info.Description); err != nil {
+		return nil, mw.Error(err)
+	}
+
+	return nil, nil
+}
+
+// setMember adds a member to the organization or changes its role. Admins can
+// manage members and admins, only owners can manage owners
+func (o *OrganizationAPI) setMember(r *http.Request) (interface{}, mw.Response) {
+	org, merr := o.loadOrganization(r)
+	if merr != nil {
+		return nil, merr
+	}
+
+	tid, merr := requesterID(r)
+	if merr != nil {
+		return nil, merr
+	}
+
+	var member generated.OrganizationMember
+
+	defer r.Body.Close()
+
+	if err := json.NewDecoder(r.Body).Decode(&member); err != nil {
+		return nil, mw.BadRequest(err)
+	}
+
+	if member.Tid == 0 {
+		return nil, mw.BadRequest(fmt.Errorf("tid is required"))
+	}
+
+	if !org.IsOwner(tid) && (member.Role == generated.OrganizationRoleOwner || org.IsOwner(member.Tid)) {
+		return nil, mw.Deny(r, mw.Forbidden(fmt.Errorf("only the owners can manage the owners of the organization")))
+	}
+
+	if _, err := o.users.Get(r.Context(), schema.ID(member.Tid)); err != nil {
+		return nil, mw.NotFound(fmt.Errorf("user with id %d not found", member.Tid))
+	}
+
+	updated := org
+	updated.Members = org.WithMember(member)
+	if err := updated.Validate(); err != nil {
+		return nil, mw.BadRequest(err)
+	}
+
+	// the checks above hold as long as the members did not change
+	err := o.orgs.SetMembers(r.Context(), org.ID, org.Members, updated.Members)
+	if errors.Is(err, types.ErrMembersChanged) {
+		return nil, mw.Conflict(err)
+	} else if err != nil {
+		return nil, mw.Error(err)
+	}
+
+	return nil, mw.Created()
+}
+
+// removeMember removes a member from the organization. Members can always
+// leave the organization, as long as an owner remains
+func (o *OrganizationAPI) removeMember(r *http.Request) (interface{}, mw.Response) {
+	org, merr := o.loadOrganization(r)
+	if merr != nil {
+		return nil, merr
+	}
+
+	tid, merr := requesterID(r)
+	if merr != nil {
+		return nil, merr
+	}
+
+	member, err := strconv.ParseInt(mux.Vars(r)["threebot_id"], 10, 64)
+	if err != nil {
+		return nil, mw.BadRequest(errors.Wrap(err, "invalid threebot id"))
+	}
+
+	if !org.IsMember(member) {
+		return nil, mw.NotFound(fmt.Errorf("threebot %d is not a member of the organization", member))
+	}
+
+	// the policy lets the admins and the member itself through, an owner
+	// leaving the organization passes since it is removing itself
+	if !org.IsOwner(tid) && org.IsOwner(member) {
+		return nil, mw.Deny(r, mw.Forbidden(fmt.Errorf("only the owners can manage the owners of the organization")))
+	}
+
+	updated := org
+	updated.Members = org.WithoutMember(member)
+	if err := updated.Validate(); err != nil {
+		return nil, mw.BadRequest(err)
+	}
+
+	// the checks above hold as long as the members did not change
+	err = o.orgs.SetMembers(r.Context(), org.ID, org.Members, updated.Members)
+	if errors.Is(err, types.ErrMembersChanged) {
+		return nil, mw.Conflict(err)
+	} else if err != nil {
+		return nil, mw.Error(err)
+	}
+
+	return nil, nil
+}
+
+// setBudget sets the budget of the organization. Like the budget of a user, the
+// payload must be signed over the message built by types.OrganizationBudgetMessage
+// with version, the current version of the budget of the organization. The current
+// key of an owner can only lower the budget, raising it must be signed with a
+// recovery key of an owner or the key of an explorer admin
+func (o *OrganizationAPI) setBudget(r *http.Request) (interface{}, mw.Response) {
+	org, merr := o.loadOrganization(r)
+	if merr != nil {
+		return nil, merr
+	}
+
+	var payload struct {
+		Budget    types.Budget `json:"budget"`
+		Version   int64        `json:"version"`
+		Signer    string       `json:"signer"`
+		Signature string       `json:"signature"`
+	}
+
+	defer r.Body.Close()
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		return nil, mw.BadRequest(err)
+	}
+
+	signature, err := hex.DecodeString(payload.Signature)
+	if err != nil {
+		return nil, mw.BadRequest(errors.Wrap(err, "invalid signature hex"))
+	}
+
+	err = types.OrganizationSetBudget(r.Context(), o.orgs, o.users, org.ID, payload.Budget, payload.Version, payload.Signer, signature, o.admins)
+	if errors.Is(err, types.ErrOrganizationNotFound) {
+		return nil, mw.NotFound(err)
+	} else if errors.Is(err, types.ErrBadBudget) {
+		return nil, mw.BadRequest(err)
+	} else if errors.Is(err, types.ErrAuthorization) {
+		return nil, mw.Deny(r, mw.UnAuthorized(err))
+	} else if errors.Is(err, types.ErrBudgetVersion) {
+		return nil, mw.Conflict(err)
+	} else if err != nil {
+		return nil, mw.Error(err)
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,169 @@
+package phonebook
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	generated "github.com/threefoldtech/tfexplorer/models/generated/phonebook"
+	"github.com/threefoldtech/tfexplorer/mw"
+	"github.com/threefoldtech/tfexplorer/pkg/phonebook/types"
+	"github.com/zaibon/httpsig"
+)
+
+// signedBy returns r as if it was signed by the threebot tid
+func signedBy(r *http.Request, tid int64) *http.Request {
+	return r.WithContext(httpsig.WithKeyID(r.Context(), fmt.Sprint(tid)))
+}
+
+func newOrganizationAPI() OrganizationAPI {
+	return OrganizationAPI{
+		orgs:  types.NewMemoryOrganizationRepository(),
+		users: types.NewMemoryUserRepository(),
+	}
+}
+
+func TestOrganizationCreateList(t *testing.T) {
+	api := newOrganizationAPI()
+
+	create := func(name string, tid int64) mw.Response {
+		body, err := json.Marshal(map[string]string{"name": name})
+		require.NoError(t, err)
+
+		r := httptest.NewRequest(http.MethodPost, "/organizations", bytes.NewReader(body))
+		_, resp := api.create(signedBy(r, tid))
+		return resp
+	}
+
+	require.Equal(t, http.StatusCreated, create("acme", 1).Status())
+	require.Equal(t, http.StatusCreated, create("umbrella", 2).Status())
+	assert.Equal(t, http.StatusConflict, create("acme", 2).Status())
+	assert.Equal(t, http.StatusBadRequest, create("a", 2).Status())
+
+	result, resp := api.list(httptest.NewRequest(http.MethodGet, "/organizations?member=2", nil))
+	require.NotNil(t, resp)
+	require.Equal(t, http.StatusOK, resp.Status())
+	orgs := result.([]types.Organization)
+	require.Len(t, orgs, 1)
+	assert.Equal(t, "umbrella", orgs[0].Name)
+
+	result, resp = api.list(httptest.NewRequest(http.MethodGet, "/organizations?page=1&size=1", nil))
+	require.NotNil(t, resp)
+	assert.Equal(t, "2", resp.Header().Get("Pages"))
+	assert.Len(t, result.([]types.Organization), 1)
+}
+
+func TestOrganizationSetMember(t *testing.T) {
+	api := newOrganizationAPI()
+
+	ctx := context.Background()
+	owner, err := api.users.Create(ctx, types.User{Name: "alice", Email: "alice@example.com"})
+	require.NoError(t, err)
+
+	member, err := api.users.Create(ctx, types.User{Name: "bob", Email: "bob@example.com"})
+	require.NoError(t, err)
+
+	org, err := types.OrganizationCreate(ctx, api.orgs, "acme", "", int64(owner.ID))
+	require.NoError(t, err)
+
+	policy := mw.NewPolicy(nil, nil).WithOrganizations(api.roles)
+	handler := policy.Require(mw.RoleOrgAdmin)(mw.AsHandlerFunc(api.setMember))
+
+	set := func(tid int64, role generated.OrganizationRoleEnum, signer int64) int {
+		body, err := json.Marshal(generated.OrganizationMember{Tid: tid, Role: role})
+		require.NoError(t, err)
+
+		id := fmt.Sprint(int64(org.ID))
+		r := httptest.NewRequest(http.MethodPost, "/organizations/"+id+"/members", bytes.NewReader(body))
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, signedBy(mux.SetURLVars(r, map[string]string{"org_id": id}), signer))
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusNotFound, set(42, generated.OrganizationRoleMember, int64(owner.ID)))
+	assert.Equal(t, http.StatusForbidden, set(int64(member.ID), generated.OrganizationRoleMember, int64(member.ID)))
+	require.Equal(t, http.StatusCreated, set(int64(member.ID), generated.OrganizationRoleAdmin, int64(owner.ID)))
+	// admins can not manage the owners
+	assert.Equal(t, http.StatusForbidden, set(int64(member.ID), generated.OrganizationRoleOwner, int64(member.ID)))
+
+	stored, err := api.orgs.Get(ctx, org.ID)
+	require.NoError(t, err)
+	assert.True(t, stored.CanManage(int64(member.ID)))
+
+	// the members read before the change are stale
+	err = api.orgs.SetMembers(ctx, org.ID, org.Members, org.WithoutMember(int64(owner.ID)))
+	assert.True(t, errors.Is(err, types.ErrMembersChanged))
+}
+
+func TestOrganizationSetBudget(t *testing.T) {
+	api := newOrganizationAPI()
+	ctx := context.Background()
+
+	pk, sk, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	rpk, rsk, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	_, other, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	owner, err := api.users.Create(ctx, types.User{Name: "alice", Email: "alice@example.com", Pubkey: hex.EncodeToString(pk)})
+	require.NoError(t, err)
+	require.NoError(t, api.users.SetRecoveryKeys(ctx, owner.ID, owner.Pubkey, []string{hex.EncodeToString(rpk)}))
+
+	org, err := types.OrganizationCreate(ctx, api.orgs, "acme", "", int64(owner.ID))
+	require.NoError(t, err)
+
+	set := func(budget types.Budget, version int64, signer ed25519.PublicKey, sk ed25519.PrivateKey) mw.Response {
+		message, err := types.OrganizationBudgetMessage(org.ID, version, budget)
+		require.NoError(t, err)
+
+		body, err := json.Marshal(map[string]interface{}{
+			"budget":    budget,
+			"version":   version,
+			"signer":    hex.EncodeToString(signer),
+			"signature": hex.EncodeToString(ed25519.Sign(sk, message)),
+		})
+		require.NoError(t, err)
+
+		id := fmt.Sprint(int64(org.ID))
+		r := httptest.NewRequest(http.MethodPut, "/organizations/"+id+"/budget", bytes.NewReader(body))
+		_, resp := api.setBudget(signedBy(mux.SetURLVars(r, map[string]string{"org_id": id}), int64(owner.ID)))
+		return resp
+	}
+
+	// the key of an owner can lower the budget
+	require.Nil(t, set(types.Budget{MaxReservations: 10}, 0, pk, sk))
+
+	// but not raise it
+	resp := set(types.Budget{MaxReservations: 100}, 1, pk, sk)
+	require.NotNil(t, resp)
+	assert.Equal(t, http.StatusUnauthorized, resp.Status())
+
+	// the signature must be made by the signer
+	resp = set(types.Budget{MaxReservations: 5}, 1, pk, other)
+	require.NotNil(t, resp)
+	assert.Equal(t, http.StatusBadRequest, resp.Status())
+
+	// a budget signed for an older version can not be replayed
+	resp = set(types.Budget{MaxReservations: 5}, 0, pk, sk)
+	require.NotNil(t, resp)
+	assert.Equal(t, http.StatusConflict, resp.Status())
+
+	require.Nil(t, set(types.Budget{MaxReservations: 100}, 1, rpk, rsk))
+
+	stored, err := api.orgs.Get(ctx, org.ID)
+	require.NoError(t, err)
+	assert.EqualValues(t, 100, stored.Budget.MaxReservations)
+	assert.EqualValues(t, 2, stored.BudgetVersion)
+}
@@ -5,25 +5,102 @@ import (
 	"net/http"
 
 	"github.com/gorilla/mux"
+	"github.com/threefoldtech/tfexplorer/config"
 	"github.com/threefoldtech/tfexplorer/mw"
+	"github.com/threefoldtech/tfexplorer/pkg/mailer"
 	phonebook "github.com/threefoldtech/tfexplorer/pkg/phonebook/types"
+	"github.com/zaibon/httpsig"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
-// Setup injects and initializes directory package
-func Setup(parent *mux.Router, db *mongo.Database) error {
+// Setup injects and initializes phonebook package, the handlers are built
+// once and mounted on all the parent routers
+func Setup(parents []*mux.Router, db *mongo.Database) error {
 	if err := phonebook.Setup(context.TODO(), db); err != nil {
 		return err
 	}
 
-	var userAPI UserAPI
-	users := parent.PathPrefix("/users").Subrouter()
+	userRepo := phonebook.NewUserRepository(db)
+	tokenRepo := phonebook.NewTokenRepository(db)
 
-	users.HandleFunc("", mw.AsHandlerFunc(userAPI.create)).Methods(http.MethodPost).Name("user-create")
-	users.HandleFunc("", mw.AsHandlerFunc(userAPI.list)).Methods(http.MethodGet).Name(("user-list"))
-	users.HandleFunc("/{user_id}", mw.AsHandlerFunc(userAPI.register)).Methods(http.MethodPut).Name("user-register")
-	users.HandleFunc("/{user_id}", mw.AsHandlerFunc(userAPI.get)).Methods(http.MethodGet).Name("user-get")
-	users.HandleFunc("/{user_id}/validate", mw.AsHandlerFunc(userAPI.validate)).Methods(http.MethodPost).Name("user-validate")
+	userAPI := UserAPI{users: userRepo, tokens: tokenRepo, admins: config.Config.Admins}
+	if len(config.Config.Mailer) != 0 {
+		m, err := mailer.New(config.Config.Mailer)
+		if err != nil {
+			return err
+		}
+		userAPI.mailer = m
+	}
+
+	orgAPI := OrganizationAPI{orgs: phonebook.NewOrganizationRepository(db), users: userRepo, admins: config.Config.Admins}
 
+	mount(parents, &userAPI, &orgAPI)
 	return nil
 }
+
+// mount registers the routes of the apis on all the parent routers
+func mount(parents []*mux.Router, userAPI *UserAPI, orgAPI *OrganizationAPI) {
+	// verification emails are requested, and tokens are minted and revoked
+	// with the key of the user, the integrations can list the tokens with a token
+	userAuthMW := mw.NewAuthMiddleware(httpsig.NewVerifier(mw.NewUserKeyGetter(userAPI.users)))
+	userPolicy := mw.NewPolicy(config.Config.Admins, nil).WithSigners(userAPI.signer).WithUsers(userAPI.registered)
+	userTokensMW := userAuthMW.Tokens(userAPI.tokens, userAPI.users, phonebook.ScopeTokensRead)
+
+	orgPolicy := mw.NewPolicy(config.Config.Admins, nil).WithOrganizations(orgAPI.roles)
+
+	for _, parent := range parents {
+		users := parent.PathPrefix("/users").Subrouter()
+
+		users.HandleFunc("", mw.AsHandlerFunc(userAPI.create)).Methods(http.MethodPost).Name("user-create")
+		users.HandleFunc("", mw.AsHandlerFunc(userAPI.list)).Methods(http.MethodGet).Name(("user-list"))
+		users.HandleFunc("/{user_id}", mw.AsHandlerFunc(userAPI.get)).Methods(http.MethodGet).Name("user-get")
+		users.HandleFunc("/{user_id}/validate", mw.AsHandlerFunc(userAPI.validate)).Methods(http.MethodPost).Name("user-validate")
+		users.HandleFunc("/{user_id}/email/verify", mw.AsHandlerFunc(userAPI.verifyEmail)).Methods(http.MethodPost).Name("user-email-verify")
+
+		usersAuthenticated := parent.PathPrefix("/users").Subrouter()
+		usersAuthenticated.Use(userAuthMW.Middleware, userPolicy.Require(mw.RoleSelf))
+		usersTokens := parent.PathPrefix("/users").Subrouter()
+		usersTokens.Use(userTokensMW, userPolicy.Require(mw.RoleSelf))
+
+		// the keys and the budget are set with a payload signed by a key of the
+		// user, which may not be its current one after a compromise
+		usersKeys := parent.PathPrefix("/users").Subrouter()
+		usersKeys.Use(userPolicy.RequireSigner(mw.RoleSelf, mw.RoleRecovery))
+		usersBudget := parent.PathPrefix("/users").Subrouter()
+		usersBudget.Use(userPolicy.RequireSigner(mw.RoleSelf, mw.RoleRecovery, mw.RoleAdmin))
+		// the user info is a payload signed by the current key of the user
+		usersSigned := parent.PathPrefix("/users").Subrouter()
+		usersSigned.Use(userPolicy.RequireSignedBy("", mw.RoleSelf))
+
+		usersSigned.HandleFunc("/{user_id}", mw.AsHandlerFunc(userAPI.register)).Methods(http.MethodPut).Name("user-register")
+
+		usersKeys.HandleFunc("/{user_id}/keys/rotate", mw.AsHandlerFunc(userAPI.rotate)).Methods(http.MethodPost).Name("user-key-rotate")
+		usersKeys.HandleFunc("/{user_id}/keys/recovery", mw.AsHandlerFunc(userAPI.setRecoveryKeys)).Methods(http.MethodPut).Name("user-key-recovery")
+		usersBudget.HandleFunc("/{user_id}/budget", mw.AsHandlerFunc(userAPI.setBudget)).Methods(http.MethodPut).Name("user-budget")
+
+		usersAuthenticated.HandleFunc("/{user_id}/email/verification", mw.AsHandlerFunc(userAPI.requestVerification)).Methods(http.MethodPost).Name("user-email-verification")
+		usersAuthenticated.HandleFunc("/{user_id}/tokens", mw.AsHandlerFunc(userAPI.createToken)).Methods(http.MethodPost).Name("user-token-create")
+		usersTokens.HandleFunc("/{user_id}/tokens", mw.AsHandlerFunc(userAPI.listTokens)).Methods(http.MethodGet).Name("user-token-list")
+		usersAuthenticated.HandleFunc("/{user_id}/tokens/{token_id}", mw.AsHandlerFunc(userAPI.revokeToken)).Methods(http.MethodDelete).Name("user-token-revoke")
+
+		orgs := parent.PathPrefix("/organizations").Subrouter()
+		orgsUsers := parent.PathPrefix("/organizations").Subrouter()
+		orgsBudget := parent.PathPrefix("/organizations").Subrouter()
+		orgsAdmins := parent.PathPrefix("/organizations").Subrouter()
+		orgsAdminsOrSelf := parent.PathPrefix("/organizations").Subrouter()
+		orgsUsers.Use(userAuthMW.Middleware, orgPolicy.Require(mw.RoleUser))
+		// the explorer admins can raise the budget of the organizations
+		orgsBudget.Use(userAuthMW.Middleware, orgPolicy.Require(mw.RoleOrgOwner, mw.RoleAdmin))
+		orgsAdmins.Use(userAuthMW.Middleware, orgPolicy.Require(mw.RoleOrgAdmin))
+		// members can always leave the organization
+		orgsAdminsOrSelf.Use(userAuthMW.Middleware, orgPolicy.Require(mw.RoleOrgAdmin, mw.RoleSelf))
+
+		orgs.HandleFunc("", mw.AsHandlerFunc(orgAPI.list)).Methods(http.MethodGet).Name("organization-list")
+		orgs.HandleFunc("/{org_id}", mw.AsHandlerFunc(orgAPI.get)).Methods(http.MethodGet).Name("organization-get")
+		orgsUsers.HandleFunc("", mw.AsHandlerFunc(orgAPI.create)).Methods(http.MethodPost).Name("organization-create")
+		orgsAdmins.HandleFunc("/{org_id}", mw.AsHandlerFunc(orgAPI.update)).Methods(http.MethodPut).Name("organization-update")
+		orgsAdmins.HandleFunc("/{org_id}/members", mw.AsHandlerFunc(orgAPI.setMember)).Methods(http.MethodPost).Name("organization-member-set")
+		orgsAdminsOrSelf.HandleFunc("/{org_id}/members/{threebot_id}", mw.AsHandlerFunc(orgAPI.removeMember)).Methods(http.MethodDelete).Name("organization-member-remove")
+		orgsBudget.HandleFunc("/{org_id}/budget", mw.AsHandlerFunc(orgAPI.setBudget)).Methods(http.MethodPut).Name("organization-budget")
+	}
+}
@@ -0,0 +1,25 @@
+package phonebook
+
+import (
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/tfexplorer/mw"
+	"github.com/threefoldtech/tfexplorer/pkg/phonebook/types"
+)
+
+func TestRoutesDeclarePrincipals(t *testing.T) {
+	userAPI := UserAPI{users: types.NewMemoryUserRepository(), tokens: types.NewMemoryTokenRepository()}
+	orgAPI := newOrganizationAPI()
+
+	router := mux.NewRouter()
+	mount([]*mux.Router{router}, &userAPI, &orgAPI)
+
+	// anyone can sign up, check a signature or verify an email with the
+	// token sent to it
+	unguarded, err := mw.UnguardedRoutes(router, "user-create", "user-validate", "user-email-verify")
+	require.NoError(t, err)
+	assert.Empty(t, unguarded)
+}
@@ -0,0 +1,99 @@
+package phonebook
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/tfexplorer/mw"
+	"github.com/threefoldtech/tfexplorer/pkg/phonebook/types"
+	"github.com/threefoldtech/tfexplorer/schema"
+)
+
+// TokenCreateResponse is returned when a token is created, it is the only
+// time the secret of the token is given
+type TokenCreateResponse struct {
+	types.Token
+	Secret string `json:"secret"`
+}
+
+// owner returns the id of the user of the request, the routes require the
+// user to be the principal of the request
+func (u *UserAPI) owner(r *http.Request) (schema.ID, mw.Response) {
+	id, err := u.parseID(mux.Vars(r)["user_id"])
+	if err != nil {
+		return 0, mw.BadRequest(err)
+	}
+
+	return id, nil
+}
+
+// createToken mints a new token for the user
+func (u *UserAPI) createToken(r *http.Request) (interface{}, mw.Response) {
+	id, merr := u.owner(r)
+	if merr != nil {
+		return nil, merr
+	}
+
+	var payload struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+		// ExpiresIn is the lifetime of the token in seconds
+		ExpiresIn int64 `json:"expires_in"`
+	}
+
+	defer r.Body.Close()
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		return nil, mw.BadRequest(err)
+	}
+
+	lifetime := time.Duration(payload.ExpiresIn) * time.Second
+	token, secret, err := types.TokenCreate(r.Context(), u.tokens, id, payload.Name, payload.Scopes, lifetime)
+	if errors.Is(err, types.ErrBadToken) {
+		return nil, mw.BadRequest(err)
+	} else if err != nil {
+		return nil, mw.Error(err)
+	}
+
+	return TokenCreateResponse{Token: token, Secret: secret}, mw.Created()
+}
+
+// listTokens lists the valid tokens of the user, without their secret
+func (u *UserAPI) listTokens(r *http.Request) (interface{}, mw.Response) {
+	id, merr := u.owner(r)
+	if merr != nil {
+		return nil, merr
+	}
+
+	tokens, err := u.tokens.List(r.Context(), id)
+	if err != nil {
+		return nil, mw.Error(err)
+	}
+
+	return tokens, nil
+}
+
+// revokeToken deletes a token of the user
+func (u *UserAPI) revokeToken(r *http.Request) (interface{}, mw.Response) {
+	id, merr := u.owner(r)
+	if merr != nil {
+		return nil, merr
+	}
+
+	tokenID, err := u.parseID(mux.Vars(r)["token_id"])
+	if err != nil {
+		return nil, mw.BadRequest(errors.Wrap(err, "invalid token id"))
+	}
+
+	err = u.tokens.Revoke(r.Context(), id, tokenID)
+	if errors.Is(err, types.ErrTokenNotFound) {
+		return nil, mw.NotFound(err)
+	} else if err != nil {
+		return nil, mw.Error(err)
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,347 @@
+package types
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	generated "github.com/threefoldtech/tfexplorer/models/generated/phonebook"
+	"github.com/threefoldtech/tfexplorer/pkg/apierror"
+	"github.com/threefoldtech/tfexplorer/schema"
+	"github.com/threefoldtech/zos/pkg/crypto"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrBudgetVersion is returned when the budget of a user or an organization
+// changed since the version the new budget was signed for
+var ErrBudgetVersion = apierror.New(apierror.CodeConflict, "budget was changed")
+
+// ErrBadBudget is returned when a new budget of an organization or its
+// signature is not valid
+var ErrBadBudget = errors.New("bad budget")
+
+// ErrBudgetLocked is returned when the budget is locked by the creation of
+// another reservation
+var ErrBudgetLocked = apierror.New(apierror.CodeConflict, "budget is used by a concurrent reservation")
+
+// Budget limits the reservations of a user or an organization
+type Budget generated.Budget
+
+// Validate makes the sanity check requires for the budget type
+func (b Budget) Validate() error {
+	if b.MaxReservations < 0 || b.MaxCu < 0 || b.MaxSu < 0 {
+		return fmt.Errorf("budget limits can not be negative")
+	}
+
+	type key struct {
+		asset  string
+		period int64
+	}
+
+	seen := make(map[key]struct{})
+	for _, limit := range b.Spending {
+		if len(limit.Asset) == 0 {
+			return fmt.Errorf("spending limit asset is required")
+		}
+
+		if limit.Amount < 0 {
+			return fmt.Errorf("spending limit of %s can not be negative", limit.Asset)
+		}
+
+		if limit.Period <= 0 {
+			return fmt.Errorf("spending limit period of %s must be positive", limit.Asset)
+		}
+
+		k := key{asset: limit.Asset, period: limit.Period}
+		if _, ok := seen[k]; ok {
+			return fmt.Errorf("duplicate spending limit for %s over %d seconds", limit.Asset, limit.Period)
+		}
+		seen[k] = struct{}{}
+	}
+
+	return nil
+}
+
+// IsLimited checks if any limit is set on the budget
+func (b Budget) IsLimited() bool {
+	return b.MaxReservations > 0 || b.MaxCu > 0 || b.MaxSu > 0 || len(b.Spending) > 0
+}
+
+// Raises checks if b allows anything that current does not. Lowering the
+// limits of a budget, or adding new ones, does not raise it
+func (b Budget) Raises(current Budget) bool {
+	raises := func(limit, current float64) bool {
+		// 0 is unlimited
+		return current > 0 && (limit == 0 || limit > current)
+	}
+
+	if raises(float64(b.MaxReservations), float64(current.MaxReservations)) ||
+		raises(b.MaxCu, current.MaxCu) ||
+		raises(b.MaxSu, current.MaxSu) {
+		return true
+	}
+
+	for _, c := range current.Spending {
+		limit := 0.0
+		for _, l := range b.Spending {
+			if l.Asset == c.Asset && l.Period == c.Period {
+				limit = l.Amount
+				break
+			}
+		}
+
+		if raises(limit, c.Amount) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// BudgetMessage is the message that needs to be signed to set the budget of
+// a user. It includes the version of the budget it replaces so it can not be
+// replayed once the budget changed
+func BudgetMessage(id schema.ID, version int64, budget Budget) ([]byte, error) {
+	encoded, err := json.Marshal(budget)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprint(int64(id)))
+	buf.WriteString(fmt.Sprint(version))
+	buf.Write(encoded)
+
+	return buf.Bytes(), nil
+}
+
+// UserSetBudget replaces the budget of the user at version. The current key of
+// the user can only lower its budget, so a leaked key can not raise it: raising
+// it must be signed by a recovery key of the user or by the key of one of the
+// explorer admins. signer is the hex public key used to sign the message, if
+// empty the current key is assumed.
+func UserSetBudget(ctx context.Context, users UserRepository, id schema.ID, budget Budget, version int64, signer string, signature []byte, admins []int64) error {
+	current, err := users.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if err := budget.Validate(); err != nil {
+		return errors.Wrap(ErrBadUserUpdate, err.Error())
+	}
+
+	if version != current.BudgetVersion {
+		return errors.Wrapf(ErrBudgetVersion, "budget is at version %d", current.BudgetVersion)
+	}
+
+	if len(signer) == 0 {
+		signer = current.Pubkey
+	}
+
+	if !current.IsRecoveryKey(signer) {
+		admin, err := IsAdminKey(ctx, users, admins, signer)
+		if err != nil {
+			return err
+		}
+
+		if !admin {
+			if signer != current.Pubkey {
+				return errors.Wrap(ErrAuthorization, "budget must be signed by the key of the user, one of its recovery keys or an explorer admin")
+			}
+
+			if budget.Raises(Budget(current.Budget)) {
+				return errors.Wrap(ErrAuthorization, "raising the budget must be signed by a recovery key of the user or an explorer admin")
+			}
+		}
+	}
+
+	key, err := crypto.KeyFromHex(signer)
+	if err != nil {
+		return errors.Wrap(ErrBadUserUpdate, "invalid signer key")
+	}
+
+	message, err := BudgetMessage(id, version, budget)
+	if err != nil {
+		return err
+	}
+
+	if err := crypto.Verify(key, message, signature); err != nil {
+		return errors.Wrap(ErrBadUserUpdate, "payload verification failed")
+	}
+
+	return users.SetBudget(ctx, id, version, budget)
+}
+
+// IsAdminKey checks if key is the current key of one of the explorer admins
+func IsAdminKey(ctx context.Context, users UserRepository, admins []int64, key string) (bool, error) {
+	for _, tid := range admins {
+		admin, err := users.Get(ctx, schema.ID(tid))
+		if errors.Is(err, ErrUserNotFound) {
+			continue
+		} else if err != nil {
+			return false, err
+		}
+
+		if admin.Pubkey == key {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// OrganizationBudgetMessage is the message that needs to be signed to set the
+// budget of an organization. It differs from the BudgetMessage of the user with
+// the same id, so the signature of one can not be used for the other
+func OrganizationBudgetMessage(id schema.ID, version int64, budget Budget) ([]byte, error) {
+	message, err := BudgetMessage(id, version, budget)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte("organization"), message...), nil
+}
+
+// OrganizationSetBudget replaces the budget of the organization at version, like
+// UserSetBudget. The current key of an owner can only lower the budget, so a
+// leaked key can not raise it: raising it must be signed by a recovery key of
+// an owner or by the key of one of the explorer admins. signer is the hex public
+// key used to sign the message
+func OrganizationSetBudget(ctx context.Context, orgs OrganizationRepository, users UserRepository, id schema.ID, budget Budget, version int64, signer string, signature []byte, admins []int64) error {
+	current, err := orgs.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if err := budget.Validate(); err != nil {
+		return errors.Wrap(ErrBadBudget, err.Error())
+	}
+
+	if version != current.BudgetVersion {
+		return errors.Wrapf(ErrBudgetVersion, "budget is at version %d", current.BudgetVersion)
+	}
+
+	if len(signer) == 0 {
+		return errors.Wrap(ErrBadBudget, "signer is required")
+	}
+
+	var owner, recovery bool
+	for _, member := range current.Members {
+		if member.Role != generated.OrganizationRoleOwner {
+			continue
+		}
+
+		user, err := users.Get(ctx, schema.ID(member.Tid))
+		if errors.Is(err, ErrUserNotFound) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		owner = owner || user.Pubkey == signer
+		recovery = recovery || user.IsRecoveryKey(signer)
+	}
+
+	if !recovery {
+		admin, err := IsAdminKey(ctx, users, admins, signer)
+		if err != nil {
+			return err
+		}
+
+		if !admin {
+			if !owner {
+				return errors.Wrap(ErrAuthorization, "budget must be signed by the key of an owner, one of its recovery keys or an explorer admin")
+			}
+
+			if budget.Raises(Budget(current.Budget)) {
+				return errors.Wrap(ErrAuthorization, "raising the budget must be signed by a recovery key of an owner or an explorer admin")
+			}
+		}
+	}
+
+	key, err := crypto.KeyFromHex(signer)
+	if err != nil {
+		return errors.Wrap(ErrBadBudget, "invalid signer key")
+	}
+
+	message, err := OrganizationBudgetMessage(id, version, budget)
+	if err != nil {
+		return err
+	}
+
+	if err := crypto.Verify(key, message, signature); err != nil {
+		return errors.Wrap(ErrBadBudget, "payload verification failed")
+	}
+
+	return orgs.SetBudget(ctx, id, version, budget)
+}
+
+// budgetLock is the lease taken on the budget of a user or an organization
+// while a reservation is checked against it and stored. It is kept in the
+// budget_lock field of their document
+type budgetLock struct {
+	ID    string    `bson:"id"`
+	Until time.Time `bson:"until"`
+}
+
+func newBudgetLock(lease time.Duration) (budgetLock, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return budgetLock{}, errors.Wrap(err, "failed to generate lock id")
+	}
+
+	return budgetLock{ID: hex.EncodeToString(buf), Until: time.Now().UTC().Add(lease)}, nil
+}
+
+// lockBudget takes the budget lock of the document id of col if it is not
+// held or its lease expired. Inside a transaction the write makes concurrent
+// transactions locking the same budget conflict
+func lockBudget(ctx context.Context, col *mongo.Collection, id schema.ID, lease time.Duration, notFound error) (string, error) {
+	lock, err := newBudgetLock(lease)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := col.UpdateOne(ctx,
+		bson.M{
+			"_id": id,
+			"$or": []bson.M{
+				{"budget_lock": bson.M{"$exists": false}},
+				{"budget_lock.until": bson.M{"$lte": time.Now().UTC()}},
+			},
+		},
+		bson.M{"$set": bson.M{"budget_lock": lock}},
+	)
+	if err != nil {
+		return "", err
+	}
+
+	if result.MatchedCount > 0 {
+		return lock.ID, nil
+	}
+
+	count, err := col.CountDocuments(ctx, bson.M{"_id": id})
+	if err != nil {
+		return "", err
+	} else if count == 0 {
+		return "", notFound
+	}
+
+	return "", ErrBudgetLocked
+}
+
+// unlockBudget releases the budget lock of the document id of col if it is
+// still held with lock
+func unlockBudget(ctx context.Context, col *mongo.Collection, id schema.ID, lock string) error {
+	_, err := col.UpdateOne(ctx,
+		bson.M{"_id": id, "budget_lock.id": lock},
+		bson.M{"$unset": bson.M{"budget_lock": ""}},
+	)
+	return err
+}
@@ -0,0 +1,81 @@
+package types
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	generated "github.com/threefoldtech/tfexplorer/models/generated/phonebook"
+	"gotest.tools/assert"
+)
+
+func TestBudget_Validate(t *testing.T) {
+	var budget Budget
+	assert.NilError(t, budget.Validate())
+	assert.Assert(t, !budget.IsLimited())
+
+	budget = Budget{
+		MaxReservations: 10,
+		Spending: []generated.SpendingLimit{
+			{Asset: "TFT", Amount: 100, Period: 3600},
+			{Asset: "TFT", Amount: 1000, Period: 86400},
+		},
+	}
+	assert.NilError(t, budget.Validate())
+	assert.Assert(t, budget.IsLimited())
+
+	budget.Spending = append(budget.Spending, generated.SpendingLimit{Asset: "TFT", Amount: 10, Period: 3600})
+	assert.Error(t, budget.Validate(), "duplicate spending limit for TFT over 3600 seconds")
+
+	budget.Spending = []generated.SpendingLimit{{Asset: "TFT", Amount: 100}}
+	assert.Error(t, budget.Validate(), "spending limit period of TFT must be positive")
+
+	budget.Spending = nil
+	budget.MaxCu = -1
+	assert.Error(t, budget.Validate(), "budget limits can not be negative")
+}
+
+func TestBudget_Raises(t *testing.T) {
+	current := Budget{
+		MaxReservations: 10,
+		Spending:        []generated.SpendingLimit{{Asset: "TFT", Amount: 100, Period: 3600}},
+	}
+
+	assert.Assert(t, !current.Raises(current))
+	assert.Assert(t, !Budget{MaxReservations: 5, MaxCu: 1, Spending: current.Spending}.Raises(current))
+	assert.Assert(t, Budget{MaxReservations: 11, Spending: current.Spending}.Raises(current))
+	// 0 is unlimited
+	assert.Assert(t, Budget{Spending: current.Spending}.Raises(current))
+	assert.Assert(t, Budget{MaxReservations: 10}.Raises(current))
+	assert.Assert(t, !Budget{MaxReservations: 1}.Raises(Budget{}))
+}
+
+func TestBudgetLock(t *testing.T) {
+	ctx := context.Background()
+	users := NewMemoryUserRepository()
+	user, err := users.Create(ctx, User{Name: "user.3bot", Email: "user@example.com"})
+	assert.NilError(t, err)
+
+	lock, err := users.LockBudget(ctx, user.ID, time.Minute)
+	assert.NilError(t, err)
+
+	_, err = users.LockBudget(ctx, user.ID, time.Minute)
+	assert.Assert(t, errors.Is(err, ErrBudgetLocked))
+
+	// only the holder can unlock
+	assert.NilError(t, users.UnlockBudget(ctx, user.ID, "other"))
+	_, err = users.LockBudget(ctx, user.ID, time.Minute)
+	assert.Assert(t, errors.Is(err, ErrBudgetLocked))
+
+	assert.NilError(t, users.UnlockBudget(ctx, user.ID, lock))
+	_, err = users.LockBudget(ctx, user.ID, -time.Second)
+	assert.NilError(t, err)
+
+	// an expired lock can be taken again
+	_, err = users.LockBudget(ctx, user.ID, time.Minute)
+	assert.NilError(t, err)
+
+	_, err = users.LockBudget(ctx, user.ID+1, time.Minute)
+	assert.Assert(t, errors.Is(err, ErrUserNotFound))
+}
@@ -0,0 +1,61 @@
+package types
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/tfexplorer/pkg/apierror"
+	"github.com/threefoldtech/tfexplorer/schema"
+)
+
+const (
+	// EmailVerificationCollection db collection name
+	EmailVerificationCollection = "email_verification"
+
+	// verificationTimeout is how long a verification token is valid
+	verificationTimeout = 24 * time.Hour
+
+	// VerificationCooldown is how long a user has to wait before a new
+	// verification email is sent to the same address
+	VerificationCooldown = 5 * time.Minute
+)
+
+var (
+	// ErrInvalidToken is returned when the verification token is wrong or expired
+	ErrInvalidToken = apierror.New(apierror.CodeInvalidVerificationToken, "invalid or expired verification token")
+	// ErrVerificationCooldown is returned when a verification email was sent
+	// to the address less than VerificationCooldown ago
+	ErrVerificationCooldown = apierror.New(apierror.CodeVerificationCooldown, "a verification email was sent recently")
+)
+
+// EmailVerification is a token sent to the email of a user to
+// prove the user owns the email
+type EmailVerification struct {
+	UserID     schema.ID `bson:"user_id" json:"user_id"`
+	Email      string    `bson:"email" json:"email"`
+	Token      string    `bson:"token" json:"-"`
+	Expiration time.Time `bson:"expiration" json:"expiration"`
+}
+
+// EmailVerificationCreate generates a new verification token for the current email
+// of the user, replacing any token that was pending for it. ErrVerificationCooldown
+// is returned if the pending token was sent to the same email less than
+// VerificationCooldown ago
+func EmailVerificationCreate(ctx context.Context, users UserRepository, user User) (EmailVerification, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return EmailVerification{}, errors.Wrap(err, "failed to generate token")
+	}
+
+	verification := EmailVerification{
+		UserID:     user.ID,
+		Email:      user.Email,
+		Token:      hex.EncodeToString(buf),
+		Expiration: time.Now().Add(verificationTimeout).UTC(),
+	}
+
+	return verification, users.SetVerification(ctx, verification)
+}
@@ -0,0 +1,225 @@
+package types
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/tfexplorer/models"
+	generated "github.com/threefoldtech/tfexplorer/models/generated/phonebook"
+	"github.com/threefoldtech/tfexplorer/pkg/apierror"
+	"github.com/threefoldtech/tfexplorer/schema"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// OrganizationCollection db collection name
+	OrganizationCollection = "organization"
+)
+
+var (
+	// ErrOrganizationExists returned if organization with same name exists
+	ErrOrganizationExists = apierror.New(apierror.CodeOrganizationExists, "organization with same name exists")
+	// ErrOrganizationNotFound is returned if organization is not found
+	ErrOrganizationNotFound = errors.New("organization not found")
+	// ErrMembersChanged is returned if the members of the organization changed
+	// since they were read
+	ErrMembersChanged = apierror.New(apierror.CodeConflict, "members of the organization changed concurrently")
+)
+
+// Organization is a group of users that can act as a single customer
+type Organization generated.Organization
+
+// Validate makes the sanity check requires for the organization type
+func (o Organization) Validate() error {
+	if len(o.Name) < 3 {
+		return fmt.Errorf("name should be at least 3 character")
+	}
+
+	owners := 0
+	seen := make(map[int64]struct{})
+	for _, member := range o.Members {
+		if _, ok := seen[member.Tid]; ok {
+			return fmt.Errorf("threebot %d is a member multiple times", member.Tid)
+		}
+		seen[member.Tid] = struct{}{}
+
+		switch member.Role {
+		case generated.OrganizationRoleOwner:
+			owners++
+		case generated.OrganizationRoleAdmin, generated.OrganizationRoleMember:
+		default:
+			return fmt.Errorf("unsupported role '%d'", member.Role)
+		}
+	}
+
+	if owners == 0 {
+		return fmt.Errorf("organization must have at least one owner")
+	}
+
+	return nil
+}
+
+// RoleOf returns the role of tid in the organization, false if tid is not a member
+func (o *Organization) RoleOf(tid int64) (generated.OrganizationRoleEnum, bool) {
+	for _, member := range o.Members {
+		if member.Tid == tid {
+			return member.Role, true
+		}
+	}
+
+	return 0, false
+}
+
+// IsMember checks if tid is a member of the organization with any role
+func (o *Organization) IsMember(tid int64) bool {
+	_, ok := o.RoleOf(tid)
+	return ok
+}
+
+// IsOwner checks if tid is an owner of the organization
+func (o *Organization) IsOwner(tid int64) bool {
+	role, ok := o.RoleOf(tid)
+	return ok && role == generated.OrganizationRoleOwner
+}
+
+// CanManage checks if tid is allowed to manage the members of the organization
+func (o *Organization) CanManage(tid int64) bool {
+	role, ok := o.RoleOf(tid)
+	return ok && (role == generated.OrganizationRoleOwner || role == generated.OrganizationRoleAdmin)
+}
+
+// Signers returns the threebot ids allowed to sign on behalf of the organization
+func (o *Organization) Signers() []int64 {
+	signers := make([]int64, 0, len(o.Members))
+	for _, member := range o.Members {
+		signers = append(signers, member.Tid)
+	}
+
+	return signers
+}
+
+// WithMember returns the members of the organization where the role of
+// member.Tid is set to member.Role, the member is added if needed
+func (o *Organization) WithMember(member generated.OrganizationMember) []generated.OrganizationMember {
+	members := make([]generated.OrganizationMember, 0, len(o.Members)+1)
+	for _, m := range o.Members {
+		if m.Tid == member.Tid {
+			continue
+		}
+		members = append(members, m)
+	}
+
+	return append(members, member)
+}
+
+// WithoutMember returns the members of the organization without tid
+func (o *Organization) WithoutMember(tid int64) []generated.OrganizationMember {
+	members := make([]generated.OrganizationMember, 0, len(o.Members))
+	for _, m := range o.Members {
+		if m.Tid == tid {
+			continue
+		}
+		members = append(members, m)
+	}
+
+	return members
+}
+
+// OrganizationQueryFields are the fields of the organizations that can be used
+// in list queries
+var OrganizationQueryFields = models.Fields{
+	"id":          {Key: "_id", Type: models.FieldInt, Sortable: true},
+	"name":        {Type: models.FieldString, Sortable: true},
+	"description": {Type: models.FieldString},
+	"member":      {Key: "members.tid", Type: models.FieldInt},
+	"members":     {Type: models.FieldObject},
+}
+
+// OrganizationFilter type
+type OrganizationFilter bson.D
+
+// WithID filters organization with ID
+func (f OrganizationFilter) WithID(id schema.ID) OrganizationFilter {
+	return append(f, bson.E{Key: "_id", Value: id})
+}
+
+// WithIDs filters organizations with any of the ids
+func (f OrganizationFilter) WithIDs(ids ...schema.ID) OrganizationFilter {
+	return append(f, bson.E{Key: "_id", Value: bson.M{"$in": ids}})
+}
+
+// WithName filters organization with name
+func (f OrganizationFilter) WithName(name string) OrganizationFilter {
+	if name == "" {
+		return f
+	}
+	return append(f, bson.E{Key: "name", Value: name})
+}
+
+// WithMember filters organizations where tid is a member
+func (f OrganizationFilter) WithMember(tid int64) OrganizationFilter {
+	if tid == 0 {
+		return f
+	}
+	return append(f, bson.E{Key: "members.tid", Value: tid})
+}
+
+// WithQuery filter organizations with the conditions of a list query
+func (f OrganizationFilter) WithQuery(q models.Query) OrganizationFilter {
+	return OrganizationFilter(q.Apply(bson.D(f)))
+}
+
+// Find all organizations that matches filter
+func (f OrganizationFilter) Find(ctx context.Context, db *mongo.Database, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	if f == nil {
+		f = OrganizationFilter{}
+	}
+	return db.Collection(OrganizationCollection).Find(ctx, f, opts...)
+}
+
+// Count number of documents matching
+func (f OrganizationFilter) Count(ctx context.Context, db *mongo.Database) (int64, error) {
+	if f == nil {
+		f = OrganizationFilter{}
+	}
+
+	return db.Collection(OrganizationCollection).CountDocuments(ctx, f)
+}
+
+// Get single organization
+func (f OrganizationFilter) Get(ctx context.Context, db *mongo.Database) (org Organization, err error) {
+	if f == nil {
+		f = OrganizationFilter{}
+	}
+
+	result := db.Collection(OrganizationCollection).FindOne(ctx, f, options.FindOne())
+	if err = result.Err(); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			err = ErrOrganizationNotFound
+		}
+		return
+	}
+
+	err = result.Decode(&org)
+	return
+}
+
+// OrganizationCreate creates the organization with owner as its only member
+func OrganizationCreate(ctx context.Context, orgs OrganizationRepository, name, description string, owner int64) (Organization, error) {
+	org := Organization{
+		Name:        name,
+		Description: description,
+		Members: []generated.OrganizationMember{
+			{Tid: owner, Role: generated.OrganizationRoleOwner},
+		},
+	}
+
+	if err := org.Validate(); err != nil {
+		return org, err
+	}
+
+	return orgs.Create(ctx, org)
+}
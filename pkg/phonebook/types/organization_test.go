@@ -0,0 +1,48 @@
+package types
+
+import (
+	"testing"
+
+	generated "github.com/threefoldtech/tfexplorer/models/generated/phonebook"
+	"gotest.tools/assert"
+)
+
+func TestOrganization_Validate(t *testing.T) {
+	org := Organization{
+		Name: "acme",
+		Members: []generated.OrganizationMember{
+			{Tid: 1, Role: generated.OrganizationRoleOwner},
+			{Tid: 2, Role: generated.OrganizationRoleMember},
+		},
+	}
+	assert.NilError(t, org.Validate())
+
+	// the last owner can not leave
+	org.Members = org.WithoutMember(1)
+	assert.Error(t, org.Validate(), "organization must have at least one owner")
+
+	org.Members = org.WithMember(generated.OrganizationMember{Tid: 2, Role: generated.OrganizationRoleOwner})
+	assert.NilError(t, org.Validate())
+	assert.Equal(t, len(org.Members), 1)
+	assert.Assert(t, org.IsOwner(2))
+
+	org.Members = append(org.Members, generated.OrganizationMember{Tid: 2, Role: generated.OrganizationRoleAdmin})
+	assert.Error(t, org.Validate(), "threebot 2 is a member multiple times")
+}
+
+func TestOrganization_Roles(t *testing.T) {
+	org := Organization{
+		Members: []generated.OrganizationMember{
+			{Tid: 1, Role: generated.OrganizationRoleOwner},
+			{Tid: 2, Role: generated.OrganizationRoleAdmin},
+			{Tid: 3, Role: generated.OrganizationRoleMember},
+		},
+	}
+
+	assert.Assert(t, org.CanManage(1))
+	assert.Assert(t, org.CanManage(2))
+	assert.Assert(t, !org.CanManage(3))
+	assert.Assert(t, org.IsMember(3))
+	assert.Assert(t, !org.IsMember(4))
+	assert.DeepEqual(t, org.Signers(), []int64{1, 2, 3})
+}
@@ -0,0 +1,466 @@
+package types
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/tfexplorer/models"
+	generated "github.com/threefoldtech/tfexplorer/models/generated/phonebook"
+	"github.com/threefoldtech/tfexplorer/schema"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// UserRepository stores the users
+type UserRepository interface {
+	// Get returns the user with id, ErrUserNotFound if it does not exist
+	Get(ctx context.Context, id schema.ID) (User, error)
+	// List returns the page of the users matching filter, and their count
+	// if the page is selected by its number
+	List(ctx context.Context, filter UserFilter, pager models.Pager, opts ...*options.FindOptions) ([]User, int64, error)
+	// Create assigns a new id to the user and stores it, ErrUserExists
+	// if its name or email is already taken
+	Create(ctx context.Context, user User) (User, error)
+	// Update sets the profile of the user, only if current is still its
+	// key. ErrConcurrentRotation otherwise
+	Update(ctx context.Context, id schema.ID, current string, profile Profile) error
+	// SetKeys sets the key and the key history of the user, only if
+	// current is still its key. ErrConcurrentRotation otherwise
+	SetKeys(ctx context.Context, id schema.ID, current, pubkey string, history []generated.UserKey) error
+	// SetRecoveryKeys sets the recovery keys of the user, only if
+	// current is still its key. ErrConcurrentRotation otherwise
+	SetRecoveryKeys(ctx context.Context, id schema.ID, current string, keys []string) error
+	// SetBudget sets the budget of the user and increments its version, only
+	// if its budget is still at version. ErrBudgetVersion otherwise
+	SetBudget(ctx context.Context, id schema.ID, version int64, budget Budget) error
+	// LockBudget locks the budget of the user for lease, until it is
+	// unlocked. It returns the id of the lock, ErrBudgetLocked if it is held
+	LockBudget(ctx context.Context, id schema.ID, lease time.Duration) (string, error)
+	// UnlockBudget releases the budget lock of the user if it is still lock
+	UnlockBudget(ctx context.Context, id schema.ID, lock string) error
+	// SetVerification replaces the pending email verification of the user.
+	// ErrVerificationCooldown is returned if the pending one was sent to the
+	// same email less than VerificationCooldown before verification
+	SetVerification(ctx context.Context, verification EmailVerification) error
+	// VerifyEmail marks the email of the user as verified if token is its pending
+	// token and the email did not change since it was sent. ErrInvalidToken otherwise
+	VerifyEmail(ctx context.Context, id schema.ID, token string) error
+}
+
+// OrganizationRepository stores the organizations
+type OrganizationRepository interface {
+	// Get returns the organization with id, ErrOrganizationNotFound if it does not exist
+	Get(ctx context.Context, id schema.ID) (Organization, error)
+	// List returns the page of the organizations matching filter, and their
+	// count if the page is selected by its number
+	List(ctx context.Context, filter OrganizationFilter, pager models.Pager, opts ...*options.FindOptions) ([]Organization, int64, error)
+	// Create assigns a new id to the organization and stores it,
+	// ErrOrganizationExists if its name is already taken
+	Create(ctx context.Context, org Organization) (Organization, error)
+	SetDescription(ctx context.Context, id schema.ID, description string) error
+	// SetMembers replaces the members of the organization, only if they are
+	// still current. ErrMembersChanged otherwise
+	SetMembers(ctx context.Context, id schema.ID, current, members []generated.OrganizationMember) error
+	// SetBudget replaces the budget of the organization and increments its version,
+	// if its budget is still at version. ErrBudgetVersion otherwise
+	SetBudget(ctx context.Context, id schema.ID, version int64, budget Budget) error
+	// LockBudget locks the budget of the organization for lease, until it
+	// is unlocked. It returns the id of the lock, ErrBudgetLocked if it is held
+	LockBudget(ctx context.Context, id schema.ID, lease time.Duration) (string, error)
+	// UnlockBudget releases the budget lock of the organization if it is still lock
+	UnlockBudget(ctx context.Context, id schema.ID, lock string) error
+}
+
+// TokenRepository stores the API tokens
+type TokenRepository interface {
+	// Create assigns a new id to the token and stores it
+	Create(ctx context.Context, token Token) (Token, error)
+	// List lists the tokens of the user that are not expired, sorted by id
+	List(ctx context.Context, userID schema.ID) ([]Token, error)
+	// GetByHash returns the token with the hash of a secret, ErrTokenNotFound
+	// if it does not exist or is expired
+	GetByHash(ctx context.Context, hash string) (Token, error)
+	// Revoke deletes the token id of the user, ErrTokenNotFound if the user
+	// has no such token
+	Revoke(ctx context.Context, userID, id schema.ID) error
+}
+
+// NewUserRepository returns a UserRepository backed by db
+func NewUserRepository(db *mongo.Database) UserRepository {
+	return &userRepository{db: db, ids: models.NewIDGenerator(db, UserCollection)}
+}
+
+type userRepository struct {
+	db  *mongo.Database
+	ids models.IDGenerator
+}
+
+func (u *userRepository) Get(ctx context.Context, id schema.ID) (User, error) {
+	var filter UserFilter
+	filter = filter.WithID(id)
+
+	user, err := filter.Get(ctx, u.db)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return user, ErrUserNotFound
+	}
+
+	return user, err
+}
+
+func (u *userRepository) List(ctx context.Context, filter UserFilter, pager models.Pager, opts ...*options.FindOptions) ([]User, int64, error) {
+	users := []User{}
+	total, err := models.List(ctx, u.db.Collection(UserCollection), bson.D(filter), pager, &users, opts...)
+	return users, total, err
+}
+
+func (u *userRepository) Create(ctx context.Context, user User) (User, error) {
+	var filter UserFilter
+	filter = filter.WithName(user.Name)
+	_, err := filter.Get(ctx, u.db)
+
+	if err == nil {
+		return user, ErrUserExists
+	} else if !errors.Is(err, mongo.ErrNoDocuments) {
+		return user, err
+	}
+	// else ErrNoDocuments
+
+	user.ID = models.MustID(ctx, u.ids)
+
+	col := u.db.Collection(UserCollection)
+	_, err = col.InsertOne(ctx, user)
+	if err != nil {
+		if merr, ok := err.(mongo.WriteException); ok {
+			errCode := merr.WriteErrors[0].Code
+			if errCode == 11000 {
+				return user, ErrUserExists
+			}
+		}
+		return user, err
+	}
+
+	return user, nil
+}
+
+func (u *userRepository) Update(ctx context.Context, id schema.ID, current string, profile Profile) error {
+	return u.setIfKey(ctx, id, current, bson.M{
+		"email":          profile.Email,
+		"email_verified": profile.EmailVerified,
+		"description":    profile.Description,
+		"host":           profile.Host,
+	})
+}
+
+// setIfKey sets the fields of the user, only if current is still its key
+func (u *userRepository) setIfKey(ctx context.Context, id schema.ID, current string, fields bson.M) error {
+	var filter UserFilter
+	filter = filter.WithID(id)
+	filter = append(filter, bson.E{Key: "pubkey", Value: current})
+
+	result, err := u.db.Collection(UserCollection).UpdateOne(ctx, filter, bson.M{"$set": fields})
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrConcurrentRotation
+	}
+
+	return nil
+}
+
+func (u *userRepository) SetKeys(ctx context.Context, id schema.ID, current, pubkey string, history []generated.UserKey) error {
+	return u.setIfKey(ctx, id, current, bson.M{
+		"pubkey":      pubkey,
+		"key_history": history,
+	})
+}
+
+func (u *userRepository) SetRecoveryKeys(ctx context.Context, id schema.ID, current string, keys []string) error {
+	return u.setIfKey(ctx, id, current, bson.M{"recovery_keys": keys})
+}
+
+func (u *userRepository) SetBudget(ctx context.Context, id schema.ID, version int64, budget Budget) error {
+	var filter UserFilter
+	filter = filter.WithID(id)
+	if version == 0 {
+		// the users registered before the budgets were versioned have no version
+		filter = append(filter, bson.E{Key: "budget_version", Value: bson.M{"$in": bson.A{0, nil}}})
+	} else {
+		filter = append(filter, bson.E{Key: "budget_version", Value: version})
+	}
+
+	result, err := u.db.Collection(UserCollection).UpdateOne(ctx, filter, bson.M{
+		"$set": bson.M{
+			"budget":         generated.Budget(budget),
+			"budget_version": version + 1,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrBudgetVersion
+	}
+
+	return nil
+}
+
+func (u *userRepository) LockBudget(ctx context.Context, id schema.ID, lease time.Duration) (string, error) {
+	return lockBudget(ctx, u.db.Collection(UserCollection), id, lease, ErrUserNotFound)
+}
+
+func (u *userRepository) UnlockBudget(ctx context.Context, id schema.ID, lock string) error {
+	return unlockBudget(ctx, u.db.Collection(UserCollection), id, lock)
+}
+
+func (u *userRepository) SetVerification(ctx context.Context, verification EmailVerification) error {
+	// a pending token that is too recent is not matched, so the upsert
+	// conflicts with it on the unique user_id index
+	col := u.db.Collection(EmailVerificationCollection)
+	_, err := col.ReplaceOne(ctx,
+		bson.M{
+			"user_id": verification.UserID,
+			"$or": []bson.M{
+				{"email": bson.M{"$ne": verification.Email}},
+				{"expiration": bson.M{"$lte": verification.Expiration.Add(-VerificationCooldown)}},
+			},
+		},
+		verification,
+		options.Replace().SetUpsert(true),
+	)
+	if err != nil {
+		if merr, ok := err.(mongo.WriteException); ok {
+			errCode := merr.WriteErrors[0].Code
+			if errCode == 11000 {
+				return ErrVerificationCooldown
+			}
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (u *userRepository) VerifyEmail(ctx context.Context, id schema.ID, token string) error {
+	col := u.db.Collection(EmailVerificationCollection)
+	result := col.FindOneAndDelete(ctx, bson.M{
+		"user_id":    id,
+		"token":      token,
+		"expiration": bson.M{"$gt": time.Now().UTC()},
+	})
+
+	if err := result.Err(); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrInvalidToken
+		}
+		return err
+	}
+
+	var verification EmailVerification
+	if err := result.Decode(&verification); err != nil {
+		return err
+	}
+
+	var filter UserFilter
+	filter = filter.WithID(id).WithEmail(verification.Email)
+	updated, err := u.db.Collection(UserCollection).UpdateOne(ctx, filter, bson.M{
+		"$set": bson.M{"email_verified": true},
+	})
+	if err != nil {
+		return err
+	}
+
+	if updated.MatchedCount == 0 {
+		// user changed the email since the token was sent
+		return ErrInvalidToken
+	}
+
+	return nil
+}
+
+// NewOrganizationRepository returns an OrganizationRepository backed by db
+func NewOrganizationRepository(db *mongo.Database) OrganizationRepository {
+	return &organizationRepository{db: db, ids: models.NewIDGenerator(db, OrganizationCollection)}
+}
+
+type organizationRepository struct {
+	db  *mongo.Database
+	ids models.IDGenerator
+}
+
+func (o *organizationRepository) Get(ctx context.Context, id schema.ID) (Organization, error) {
+	var filter OrganizationFilter
+	filter = filter.WithID(id)
+
+	return filter.Get(ctx, o.db)
+}
+
+func (o *organizationRepository) List(ctx context.Context, filter OrganizationFilter, pager models.Pager, opts ...*options.FindOptions) ([]Organization, int64, error) {
+	orgs := []Organization{}
+	total, err := models.List(ctx, o.db.Collection(OrganizationCollection), bson.D(filter), pager, &orgs, opts...)
+	return orgs, total, err
+}
+
+func (o *organizationRepository) Create(ctx context.Context, org Organization) (Organization, error) {
+	org.ID = models.MustID(ctx, o.ids)
+
+	_, err := o.db.Collection(OrganizationCollection).InsertOne(ctx, org)
+	if err != nil {
+		if merr, ok := err.(mongo.WriteException); ok {
+			errCode := merr.WriteErrors[0].Code
+			if errCode == 11000 {
+				return org, ErrOrganizationExists
+			}
+		}
+		return org, err
+	}
+
+	return org, nil
+}
+
+// set sets the fields of the organization
+func (o *organizationRepository) set(ctx context.Context, id schema.ID, fields bson.M) error {
+	var filter OrganizationFilter
+	filter = filter.WithID(id)
+
+	result, err := o.db.Collection(OrganizationCollection).UpdateOne(ctx, filter, bson.M{"$set": fields})
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrOrganizationNotFound
+	}
+
+	return nil
+}
+
+func (o *organizationRepository) SetDescription(ctx context.Context, id schema.ID, description string) error {
+	return o.set(ctx, id, bson.M{"description": description})
+}
+
+func (o *organizationRepository) SetMembers(ctx context.Context, id schema.ID, current, members []generated.OrganizationMember) error {
+	var filter OrganizationFilter
+	filter = filter.WithID(id)
+	filter = append(filter, bson.E{Key: "members", Value: current})
+
+	result, err := o.db.Collection(OrganizationCollection).UpdateOne(ctx, filter, bson.M{
+		"$set": bson.M{"members": members},
+	})
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrMembersChanged
+	}
+
+	return nil
+}
+
+func (o *organizationRepository) SetBudget(ctx context.Context, id schema.ID, version int64, budget Budget) error {
+	var filter OrganizationFilter
+	filter = filter.WithID(id)
+	if version == 0 {
+		// the organizations created before the budgets were versioned have no version
+		filter = append(filter, bson.E{Key: "budget_version", Value: bson.M{"$in": bson.A{0, nil}}})
+	} else {
+		filter = append(filter, bson.E{Key: "budget_version", Value: version})
+	}
+
+	result, err := o.db.Collection(OrganizationCollection).UpdateOne(ctx, filter, bson.M{
+		"$set": bson.M{
+			"budget":         generated.Budget(budget),
+			"budget_version": version + 1,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrBudgetVersion
+	}
+
+	return nil
+}
+
+func (o *organizationRepository) LockBudget(ctx context.Context, id schema.ID, lease time.Duration) (string, error) {
+	return lockBudget(ctx, o.db.Collection(OrganizationCollection), id, lease, ErrOrganizationNotFound)
+}
+
+func (o *organizationRepository) UnlockBudget(ctx context.Context, id schema.ID, lock string) error {
+	return unlockBudget(ctx, o.db.Collection(OrganizationCollection), id, lock)
+}
+
+// NewTokenRepository returns a TokenRepository backed by db
+func NewTokenRepository(db *mongo.Database) TokenRepository {
+	return &tokenRepository{db: db, ids: models.NewIDGenerator(db, TokenCollection)}
+}
+
+type tokenRepository struct {
+	db  *mongo.Database
+	ids models.IDGenerator
+}
+
+func (t *tokenRepository) Create(ctx context.Context, token Token) (Token, error) {
+	token.ID = models.MustID(ctx, t.ids)
+
+	_, err := t.db.Collection(TokenCollection).InsertOne(ctx, token)
+	return token, err
+}
+
+func (t *tokenRepository) List(ctx context.Context, userID schema.ID) ([]Token, error) {
+	cur, err := t.db.Collection(TokenCollection).Find(ctx, bson.M{
+		"user_id":    userID,
+		"expiration": bson.M{"$gt": time.Now().UTC()},
+	}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	tokens := []Token{}
+	if err := cur.All(ctx, &tokens); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+func (t *tokenRepository) GetByHash(ctx context.Context, hash string) (token Token, err error) {
+	result := t.db.Collection(TokenCollection).FindOne(ctx, bson.M{
+		"hash":       hash,
+		"expiration": bson.M{"$gt": time.Now().UTC()},
+	})
+
+	if err = result.Err(); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			err = ErrTokenNotFound
+		}
+		return
+	}
+
+	err = result.Decode(&token)
+	return
+}
+
+func (t *tokenRepository) Revoke(ctx context.Context, userID, id schema.ID) error {
+	result, err := t.db.Collection(TokenCollection).DeleteOne(ctx, bson.M{
+		"_id":     id,
+		"user_id": userID,
+	})
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return ErrTokenNotFound
+	}
+
+	return nil
+}
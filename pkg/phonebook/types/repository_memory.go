@@ -0,0 +1,417 @@
+package types
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/tfexplorer/models"
+	generated "github.com/threefoldtech/tfexplorer/models/generated/phonebook"
+	"github.com/threefoldtech/tfexplorer/schema"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// NewMemoryUserRepository returns a UserRepository that keeps the users in memory
+func NewMemoryUserRepository() UserRepository {
+	return &memoryUserRepository{
+		users:         models.NewMemoryCollection(),
+		verifications: make(map[schema.ID]EmailVerification),
+		locks:         make(budgetLocks),
+	}
+}
+
+type memoryUserRepository struct {
+	mu            sync.Mutex
+	users         *models.MemoryCollection
+	verifications map[schema.ID]EmailVerification
+	locks         budgetLocks
+}
+
+func (m *memoryUserRepository) get(id schema.ID) (user User, err error) {
+	err = m.users.Get(id, &user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return user, ErrUserNotFound
+	}
+
+	return
+}
+
+func (m *memoryUserRepository) Get(ctx context.Context, id schema.ID) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.get(id)
+}
+
+func (m *memoryUserRepository) List(ctx context.Context, filter UserFilter, pager models.Pager, opts ...*options.FindOptions) ([]User, int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	users := []User{}
+	total, err := m.users.List(bson.D(filter), pager, &users, opts...)
+	return users, total, err
+}
+
+func (m *memoryUserRepository) Create(ctx context.Context, user User) (User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range m.users.Keys() {
+		existing, err := m.get(key.(schema.ID))
+		if err != nil {
+			return user, err
+		}
+
+		// like the unique indexes of the collection
+		if existing.Name == user.Name || existing.Email == user.Email {
+			return user, ErrUserExists
+		}
+	}
+
+	user.ID = m.users.NextID()
+	return user, m.users.Put(user.ID, user)
+}
+
+func (m *memoryUserRepository) Update(ctx context.Context, id schema.ID, current string, profile Profile) error {
+	return m.setIfKey(id, current, func(user *User) {
+		user.Email = profile.Email
+		user.EmailVerified = profile.EmailVerified
+		user.Description = profile.Description
+		user.Host = profile.Host
+	})
+}
+
+// setIfKey applies set to the user, only if current is still its key
+func (m *memoryUserRepository) setIfKey(id schema.ID, current string, set func(user *User)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, err := m.get(id)
+	if errors.Is(err, ErrUserNotFound) {
+		return ErrConcurrentRotation
+	} else if err != nil {
+		return err
+	}
+
+	if user.Pubkey != current {
+		return ErrConcurrentRotation
+	}
+
+	set(&user)
+	return m.users.Put(id, user)
+}
+
+func (m *memoryUserRepository) SetKeys(ctx context.Context, id schema.ID, current, pubkey string, history []generated.UserKey) error {
+	return m.setIfKey(id, current, func(user *User) {
+		user.Pubkey = pubkey
+		user.KeyHistory = history
+	})
+}
+
+func (m *memoryUserRepository) SetRecoveryKeys(ctx context.Context, id schema.ID, current string, keys []string) error {
+	return m.setIfKey(id, current, func(user *User) {
+		user.RecoveryKeys = keys
+	})
+}
+
+func (m *memoryUserRepository) SetBudget(ctx context.Context, id schema.ID, version int64, budget Budget) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	user, err := m.get(id)
+	if errors.Is(err, ErrUserNotFound) {
+		return ErrBudgetVersion
+	} else if err != nil {
+		return err
+	}
+
+	if user.BudgetVersion != version {
+		return ErrBudgetVersion
+	}
+
+	user.Budget = generated.Budget(budget)
+	user.BudgetVersion = version + 1
+	return m.users.Put(id, user)
+}
+
+func (m *memoryUserRepository) LockBudget(ctx context.Context, id schema.ID, lease time.Duration) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, err := m.get(id); err != nil {
+		return "", err
+	}
+
+	return m.locks.lock(id, lease)
+}
+
+func (m *memoryUserRepository) UnlockBudget(ctx context.Context, id schema.ID, lock string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.locks.unlock(id, lock)
+	return nil
+}
+
+func (m *memoryUserRepository) SetVerification(ctx context.Context, verification EmailVerification) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pending, ok := m.verifications[verification.UserID]
+	if ok && pending.Email == verification.Email &&
+		pending.Expiration.After(verification.Expiration.Add(-VerificationCooldown)) {
+		return ErrVerificationCooldown
+	}
+
+	m.verifications[verification.UserID] = verification
+	return nil
+}
+
+func (m *memoryUserRepository) VerifyEmail(ctx context.Context, id schema.ID, token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pending, ok := m.verifications[id]
+	if !ok || pending.Token != token || !pending.Expiration.After(time.Now()) {
+		return ErrInvalidToken
+	}
+	delete(m.verifications, id)
+
+	user, err := m.get(id)
+	if errors.Is(err, ErrUserNotFound) || user.Email != pending.Email {
+		// user changed the email since the token was sent
+		return ErrInvalidToken
+	} else if err != nil {
+		return err
+	}
+
+	user.EmailVerified = true
+	return m.users.Put(id, user)
+}
+
+// NewMemoryOrganizationRepository returns an OrganizationRepository that keeps
+// the organizations in memory
+func NewMemoryOrganizationRepository() OrganizationRepository {
+	return &memoryOrganizationRepository{
+		orgs:  models.NewMemoryCollection(),
+		locks: make(budgetLocks),
+	}
+}
+
+type memoryOrganizationRepository struct {
+	mu    sync.Mutex
+	orgs  *models.MemoryCollection
+	locks budgetLocks
+}
+
+func (m *memoryOrganizationRepository) get(id schema.ID) (org Organization, err error) {
+	err = m.orgs.Get(id, &org)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return org, ErrOrganizationNotFound
+	}
+
+	return
+}
+
+func (m *memoryOrganizationRepository) Get(ctx context.Context, id schema.ID) (Organization, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.get(id)
+}
+
+func (m *memoryOrganizationRepository) List(ctx context.Context, filter OrganizationFilter, pager models.Pager, opts ...*options.FindOptions) ([]Organization, int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	orgs := []Organization{}
+	total, err := m.orgs.List(bson.D(filter), pager, &orgs, opts...)
+	return orgs, total, err
+}
+
+func (m *memoryOrganizationRepository) Create(ctx context.Context, org Organization) (Organization, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// like the unique index of the collection
+	count, err := m.orgs.Count(bson.M{"name": org.Name})
+	if err != nil {
+		return org, err
+	} else if count > 0 {
+		return org, ErrOrganizationExists
+	}
+
+	org.ID = m.orgs.NextID()
+	return org, m.orgs.Put(org.ID, org)
+}
+
+// set applies set to the organization
+func (m *memoryOrganizationRepository) set(id schema.ID, set func(org *Organization)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	org, err := m.get(id)
+	if err != nil {
+		return err
+	}
+
+	set(&org)
+	return m.orgs.Put(id, org)
+}
+
+func (m *memoryOrganizationRepository) SetDescription(ctx context.Context, id schema.ID, description string) error {
+	return m.set(id, func(org *Organization) {
+		org.Description = description
+	})
+}
+
+func (m *memoryOrganizationRepository) SetMembers(ctx context.Context, id schema.ID, current, members []generated.OrganizationMember) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	org, err := m.get(id)
+	if errors.Is(err, ErrOrganizationNotFound) {
+		return ErrMembersChanged
+	} else if err != nil {
+		return err
+	}
+
+	if len(org.Members) != len(current) {
+		return ErrMembersChanged
+	}
+
+	for i := range current {
+		if org.Members[i] != current[i] {
+			return ErrMembersChanged
+		}
+	}
+
+	org.Members = members
+	return m.orgs.Put(id, org)
+}
+
+func (m *memoryOrganizationRepository) SetBudget(ctx context.Context, id schema.ID, version int64, budget Budget) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	org, err := m.get(id)
+	if errors.Is(err, ErrOrganizationNotFound) {
+		return ErrBudgetVersion
+	} else if err != nil {
+		return err
+	}
+
+	if org.BudgetVersion != version {
+		return ErrBudgetVersion
+	}
+
+	org.Budget = generated.Budget(budget)
+	org.BudgetVersion = version + 1
+	return m.orgs.Put(id, org)
+}
+
+func (m *memoryOrganizationRepository) LockBudget(ctx context.Context, id schema.ID, lease time.Duration) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, err := m.get(id); err != nil {
+		return "", err
+	}
+
+	return m.locks.lock(id, lease)
+}
+
+func (m *memoryOrganizationRepository) UnlockBudget(ctx context.Context, id schema.ID, lock string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.locks.unlock(id, lock)
+	return nil
+}
+
+// budgetLocks are the budget locks of the users or the organizations kept in memory
+type budgetLocks map[schema.ID]budgetLock
+
+func (l budgetLocks) lock(id schema.ID, lease time.Duration) (string, error) {
+	if held, ok := l[id]; ok && held.Until.After(time.Now()) {
+		return "", ErrBudgetLocked
+	}
+
+	lock, err := newBudgetLock(lease)
+	if err != nil {
+		return "", err
+	}
+
+	l[id] = lock
+	return lock.ID, nil
+}
+
+func (l budgetLocks) unlock(id schema.ID, lock string) {
+	if held, ok := l[id]; ok && held.ID == lock {
+		delete(l, id)
+	}
+}
+
+// NewMemoryTokenRepository returns a TokenRepository that keeps the tokens in memory
+func NewMemoryTokenRepository() TokenRepository {
+	return &memoryTokenRepository{tokens: models.NewMemoryCollection()}
+}
+
+type memoryTokenRepository struct {
+	mu     sync.Mutex
+	tokens *models.MemoryCollection
+}
+
+func (m *memoryTokenRepository) Create(ctx context.Context, token Token) (Token, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	token.ID = m.tokens.NextID()
+	return token, m.tokens.Put(token.ID, token)
+}
+
+// find returns the valid tokens matching filter
+func (m *memoryTokenRepository) find(filter bson.D) ([]Token, error) {
+	filter = append(filter, bson.E{Key: "expiration", Value: bson.M{"$gt": time.Now().UTC()}})
+
+	tokens := []Token{}
+	_, err := m.tokens.List(filter, models.Pager{}, &tokens)
+	return tokens, err
+}
+
+func (m *memoryTokenRepository) List(ctx context.Context, userID schema.ID) ([]Token, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.find(bson.D{{Key: "user_id", Value: userID}})
+}
+
+func (m *memoryTokenRepository) GetByHash(ctx context.Context, hash string) (Token, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tokens, err := m.find(bson.D{{Key: "hash", Value: hash}})
+	if err != nil {
+		return Token{}, err
+	} else if len(tokens) == 0 {
+		return Token{}, ErrTokenNotFound
+	}
+
+	return tokens[0], nil
+}
+
+func (m *memoryTokenRepository) Revoke(ctx context.Context, userID, id schema.ID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var token Token
+	if err := m.tokens.Get(id, &token); err != nil || token.UserID != userID {
+		return ErrTokenNotFound
+	}
+
+	m.tokens.Delete(id)
+	return nil
+}
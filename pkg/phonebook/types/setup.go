@@ -22,9 +22,69 @@ func Setup(ctx context.Context, db *mongo.Database) error {
 			Keys:    bson.M{"email": 1},
 			Options: options.Index().SetUnique(true),
 		},
+		{
+			// used by the search endpoint
+			Keys: bson.D{
+				{Key: "name", Value: "text"},
+				{Key: "email", Value: "text"},
+				{Key: "description", Value: "text"},
+			},
+			Options: options.Index().SetWeights(bson.M{"name": 10, "email": 5, "description": 1}),
+		},
 	})
 	if err != nil {
 		log.Error().Err(err).Msg("failed to initialize user index")
+		return err
+	}
+
+	organization := db.Collection(OrganizationCollection)
+	_, err = organization.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.M{"name": 1},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.M{"members.tid": 1},
+		},
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to initialize organization index")
+		return err
+	}
+
+	token := db.Collection(TokenCollection)
+	_, err = token.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.M{"hash": 1},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.M{"user_id": 1},
+		},
+		{
+			// expired tokens are dropped
+			Keys:    bson.M{"expiration": 1},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to initialize token index")
+		return err
+	}
+
+	verification := db.Collection(EmailVerificationCollection)
+	_, err = verification.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.M{"user_id": 1},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.M{"expiration": 1},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to initialize email verification index")
 	}
 
 	return err
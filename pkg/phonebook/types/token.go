@@ -0,0 +1,133 @@
+package types
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/tfexplorer/schema"
+)
+
+const (
+	// TokenCollection db collection name
+	TokenCollection = "token"
+
+	// MaxTokenLifetime is the longest a token can be valid
+	MaxTokenLifetime = 365 * 24 * time.Hour
+
+	// tokenPrefix makes tokens easy to recognize, e.g. in leaked secrets scans
+	tokenPrefix = "tfx_"
+)
+
+// Scopes of the API tokens, tokens can only be used to read
+const (
+	// ScopeEscrowRead allows reading the escrow details of the reservations of the user
+	ScopeEscrowRead = "escrow:read"
+	// ScopeTokensRead allows listing the tokens of the user
+	ScopeTokensRead = "tokens:read"
+)
+
+var (
+	// ErrTokenNotFound is returned if the token does not exist, is expired or revoked
+	ErrTokenNotFound = errors.New("token not found or expired")
+	// ErrBadToken is returned when a token is not valid
+	ErrBadToken = errors.New("bad token")
+
+	knownScopes = []string{ScopeEscrowRead, ScopeTokensRead}
+)
+
+// Token is an API token a user mints so integrations can read its private data
+// without its key. Only the hash of the secret is stored, the secret is given
+// once when the token is created
+type Token struct {
+	ID         schema.ID `bson:"_id" json:"id"`
+	UserID     schema.ID `bson:"user_id" json:"user_id"`
+	Name       string    `bson:"name" json:"name"`
+	Scopes     []string  `bson:"scopes" json:"scopes"`
+	Hash       string    `bson:"hash" json:"-"`
+	Created    time.Time `bson:"created" json:"created"`
+	Expiration time.Time `bson:"expiration" json:"expiration"`
+}
+
+// Has checks if the token has scope
+func (t *Token) Has(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate makes the sanity check requires for the token type
+func (t Token) Validate() error {
+	if len(t.Name) == 0 {
+		return fmt.Errorf("name is required")
+	}
+
+	if len(t.Scopes) == 0 {
+		return fmt.Errorf("at least one scope is required")
+	}
+
+	for _, scope := range t.Scopes {
+		known := false
+		for _, s := range knownScopes {
+			known = known || s == scope
+		}
+		if !known {
+			return fmt.Errorf("unknown scope '%s'", scope)
+		}
+	}
+
+	lifetime := t.Expiration.Sub(t.Created)
+	if lifetime <= 0 || lifetime > MaxTokenLifetime {
+		return fmt.Errorf("lifetime must be between 1s and %s", MaxTokenLifetime)
+	}
+
+	return nil
+}
+
+func tokenHash(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+// TokenCreate mints a new token for the user, it returns the token and its secret
+func TokenCreate(ctx context.Context, tokens TokenRepository, userID schema.ID, name string, scopes []string, lifetime time.Duration) (Token, string, error) {
+	now := time.Now().UTC()
+	token := Token{
+		UserID:     userID,
+		Name:       name,
+		Scopes:     scopes,
+		Created:    now,
+		Expiration: now.Add(lifetime),
+	}
+
+	if err := token.Validate(); err != nil {
+		return token, "", errors.Wrap(ErrBadToken, err.Error())
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return token, "", errors.Wrap(err, "failed to generate token")
+	}
+
+	secret := tokenPrefix + hex.EncodeToString(buf)
+	token.Hash = tokenHash(secret)
+
+	token, err := tokens.Create(ctx, token)
+	if err != nil {
+		return token, "", err
+	}
+
+	return token, secret, nil
+}
+
+// TokenGet returns the valid token with secret
+func TokenGet(ctx context.Context, tokens TokenRepository, secret string) (Token, error) {
+	return tokens.GetByHash(ctx, tokenHash(secret))
+}
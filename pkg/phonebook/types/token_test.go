@@ -0,0 +1,36 @@
+package types
+
+import (
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+func TestToken_Validate(t *testing.T) {
+	now := time.Now()
+	token := Token{
+		Name:       "dashboard",
+		Scopes:     []string{ScopeEscrowRead},
+		Created:    now,
+		Expiration: now.Add(time.Hour),
+	}
+	assert.NilError(t, token.Validate())
+	assert.Assert(t, token.Has(ScopeEscrowRead))
+	assert.Assert(t, !token.Has(ScopeTokensRead))
+
+	token.Scopes = []string{ScopeEscrowRead, "reservations:write"}
+	assert.Error(t, token.Validate(), "unknown scope 'reservations:write'")
+
+	token.Scopes = nil
+	assert.Error(t, token.Validate(), "at least one scope is required")
+
+	token.Scopes = []string{ScopeTokensRead}
+	token.Expiration = now.Add(MaxTokenLifetime + time.Hour)
+	assert.ErrorContains(t, token.Validate(), "lifetime must be between")
+}
+
+func TestTokenHash(t *testing.T) {
+	assert.Equal(t, tokenHash("tfx_a"), tokenHash("tfx_a"))
+	assert.Assert(t, tokenHash("tfx_a") != tokenHash("tfx_b"))
+}
@@ -5,12 +5,15 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 
+	"github.com/threefoldtech/tfexplorer/config"
 	"github.com/threefoldtech/tfexplorer/models"
 	generated "github.com/threefoldtech/tfexplorer/models/generated/phonebook"
+	"github.com/threefoldtech/tfexplorer/pkg/apierror"
 	"github.com/threefoldtech/tfexplorer/schema"
 	"github.com/threefoldtech/zos/pkg/crypto"
 	"go.mongodb.org/mongo-driver/bson"
@@ -25,13 +28,17 @@ const (
 
 var (
 	// ErrUserExists returned if user with same name exists
-	ErrUserExists = errors.New("user with same name or email exists")
+	ErrUserExists = apierror.New(apierror.CodeUserExists, "user with same name or email exists")
 	// ErrUserNotFound is returned if user is not found
 	ErrUserNotFound = errors.New("user not found")
 	// ErrBadUserUpdate is returned when invalid data is passed to update
 	ErrBadUserUpdate = errors.New("bad data during user update")
 	// ErrAuthorization returned if user is not allowed to do an operation
 	ErrAuthorization = errors.New("operation not allowed")
+	// ErrBadRotation is returned when a key rotation is not valid
+	ErrBadRotation = errors.New("bad key rotation")
+	// ErrConcurrentRotation is returned if the key changed while being rotated
+	ErrConcurrentRotation = apierror.New(apierror.CodeKeyChanged, "user key changed during rotation")
 )
 
 // User type
@@ -50,7 +57,7 @@ func (u User) Validate() error {
 		return fmt.Errorf("name should be at least 3 character")
 	}
 
-	return nil
+	return config.Config.Names.Check(u.Name)
 }
 
 // Encode user data for signing
@@ -70,6 +77,95 @@ func (u *User) Encode() []byte {
 	return buf.Bytes()
 }
 
+// KeyAt returns the public key of the user that was valid at time t
+func (u *User) KeyAt(t time.Time) string {
+	// history is ordered from the oldest to the newest key
+	for _, key := range u.KeyHistory {
+		if t.Before(key.ValidUntil.Time) {
+			return key.Pubkey
+		}
+	}
+
+	return u.Pubkey
+}
+
+// RotatedAt returns the last time the key of the user was rotated, or the
+// zero time if it never was
+func (u *User) RotatedAt() time.Time {
+	if len(u.KeyHistory) == 0 {
+		return time.Time{}
+	}
+
+	return u.KeyHistory[len(u.KeyHistory)-1].ValidUntil.Time
+}
+
+// HasKey checks if pubkey is the current key or any of the previous keys of the user
+func (u *User) HasKey(pubkey string) bool {
+	if u.Pubkey == pubkey {
+		return true
+	}
+
+	for _, key := range u.KeyHistory {
+		if key.Pubkey == pubkey {
+			return true
+		}
+	}
+
+	return false
+}
+
+// rotate replaces the current key of the user with pubkey and keeps
+// the current one in the key history
+func (u *User) rotate(pubkey string, at time.Time) {
+	var from schema.Date
+	if len(u.KeyHistory) > 0 {
+		from = u.KeyHistory[len(u.KeyHistory)-1].ValidUntil
+	}
+
+	u.KeyHistory = append(u.KeyHistory, generated.UserKey{
+		Pubkey:     u.Pubkey,
+		ValidFrom:  from,
+		ValidUntil: schema.Date{Time: at},
+	})
+	u.Pubkey = pubkey
+}
+
+// RotationMessage is the message that needs to be signed to rotate the key
+// of a user from current to pubkey. It includes the current key so a rotation
+// can not be replayed once the key has changed
+func RotationMessage(id schema.ID, current, pubkey string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprint(int64(id)))
+	buf.WriteString(current)
+	buf.WriteString(pubkey)
+
+	return buf.Bytes()
+}
+
+// RecoveryKeysMessage is the message that needs to be signed with the current
+// key of the user to set its recovery keys
+func RecoveryKeysMessage(id schema.ID, current string, keys []string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprint(int64(id)))
+	buf.WriteString(current)
+	for _, key := range keys {
+		buf.WriteString(key)
+	}
+
+	return buf.Bytes()
+}
+
+// UserQueryFields are the fields of the users that can be used in list queries
+var UserQueryFields = models.Fields{
+	"id":             {Key: "_id", Type: models.FieldInt, Sortable: true},
+	"name":           {Type: models.FieldString, Sortable: true},
+	"email":          {Type: models.FieldString, Sortable: true},
+	"email_verified": {Type: models.FieldBool},
+	"pubkey":         {Type: models.FieldString},
+	"host":           {Type: models.FieldString},
+	"description":    {Type: models.FieldString},
+}
+
 // UserFilter type
 type UserFilter bson.D
 
@@ -97,6 +193,11 @@ func (f UserFilter) WithEmail(email string) UserFilter {
 	return append(f, bson.E{Key: "email", Value: email})
 }
 
+// WithQuery filter users with the conditions of a list query
+func (f UserFilter) WithQuery(q models.Query) UserFilter {
+	return UserFilter(q.Apply(bson.D(f)))
+}
+
 // Find all users that matches filter
 func (f UserFilter) Find(ctx context.Context, db *mongo.Database, opts ...*options.FindOptions) (*mongo.Cursor, error) {
 	if f == nil {
@@ -130,8 +231,16 @@ func (f UserFilter) Get(ctx context.Context, db *mongo.Database) (user User, err
 	return
 }
 
+// Profile are the fields of a user it can update itself
+type Profile struct {
+	Email         string `bson:"email"`
+	EmailVerified bool   `bson:"email_verified"`
+	Description   string `bson:"description"`
+	Host          string `bson:"host"`
+}
+
 // UserCreate creates the user
-func UserCreate(ctx context.Context, db *mongo.Database, name, email, pubkey string) (user User, err error) {
+func UserCreate(ctx context.Context, users UserRepository, name, email, pubkey string) (user User, err error) {
 	if len(name) == 0 {
 		return user, fmt.Errorf("invalid name, can't be empty")
 	}
@@ -140,50 +249,31 @@ func UserCreate(ctx context.Context, db *mongo.Database, name, email, pubkey str
 		return user, errors.Wrapf(err, "invalid public key %s", pubkey)
 	}
 
-	var filter UserFilter
-	filter = filter.WithName(name)
-	_, err = filter.Get(ctx, db)
-
-	if err == nil {
-		return user, ErrUserExists
-	} else if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
-		return user, err
-	}
-	// else ErrNoDocuments
-
-	id := models.MustID(ctx, db, UserCollection)
-	user = User{
-		ID:     id,
+	return users.Create(ctx, User{
 		Name:   name,
 		Email:  email,
 		Pubkey: pubkey,
-	}
+	})
+}
 
-	col := db.Collection(UserCollection)
-	_, err = col.InsertOne(ctx, user)
-	if err != nil {
-		if merr, ok := err.(mongo.WriteException); ok {
-			errCode := merr.WriteErrors[0].Code
-			if errCode == 11000 {
-				return user, ErrUserExists
-			}
-		}
-		return user, err
+// UserExists checks if the user with id is registered
+func UserExists(ctx context.Context, users UserRepository, id schema.ID) (bool, error) {
+	_, err := users.Get(ctx, id)
+	if errors.Is(err, ErrUserNotFound) {
+		return false, nil
 	}
-	return
+
+	return err == nil, err
 }
 
 // UserUpdate update user info
-func UserUpdate(ctx context.Context, db *mongo.Database, id schema.ID, signature []byte, update User) error {
+func UserUpdate(ctx context.Context, users UserRepository, id schema.ID, signature []byte, update User) error {
 	update.ID = id
 
 	// then we find the user that matches this given ID
-	var filter UserFilter
-	filter = filter.WithID(id)
-
-	current, err := filter.Get(ctx, db)
-	if err != nil && errors.Is(err, mongo.ErrNoDocuments) {
-		return ErrUserNotFound
+	current, err := users.Get(ctx, id)
+	if err != nil {
+		return err
 	}
 
 	// user need to always sign with current stored public key
@@ -202,15 +292,10 @@ func UserUpdate(ctx context.Context, db *mongo.Database, id schema.ID, signature
 		return errors.Wrap(ErrBadUserUpdate, "payload verification failed")
 	}
 
-	// if public key update is required, we make sure
-	// that is valid key.
-	if len(update.Pubkey) != 0 {
-		_, err := crypto.KeyFromHex(update.Pubkey)
-		if err != nil {
-			return errors.Wrap(ErrBadUserUpdate, "invalid public key")
-		}
-
-		current.Pubkey = update.Pubkey
+	// the key is only changed by UserRotateKey, which keeps the previous
+	// key in the history and guards against concurrent rotations
+	if len(update.Pubkey) != 0 && update.Pubkey != current.Pubkey {
+		return errors.Wrap(ErrBadUserUpdate, "public key can only be changed with a key rotation")
 	}
 
 	// sanity check make sure user is not trying to update his name
@@ -219,8 +304,10 @@ func UserUpdate(ctx context.Context, db *mongo.Database, id schema.ID, signature
 	}
 
 	// copy all modified fields.
-	if len(update.Email) != 0 {
+	if len(update.Email) != 0 && update.Email != current.Email {
 		current.Email = update.Email
+		// the new email needs to be verified again
+		current.EmailVerified = false
 	}
 
 	if len(update.Description) != 0 {
@@ -231,10 +318,115 @@ func UserUpdate(ctx context.Context, db *mongo.Database, id schema.ID, signature
 		current.Host = update.Host
 	}
 
-	// actually update the user with final data
-	if _, err := db.Collection(UserCollection).UpdateOne(ctx, filter, bson.M{"$set": current}); err != nil {
+	// only the profile is written, and only if the key that signed the
+	// update is still the key of the user
+	return users.Update(ctx, id, current.Pubkey, Profile{
+		Email:         current.Email,
+		EmailVerified: current.EmailVerified,
+		Description:   current.Description,
+		Host:          current.Host,
+	})
+}
+
+// UserRotateKey replaces the public key of the user with pubkey. The API tokens
+// of the user minted before the rotation stop being accepted. The rotation
+// message must be signed either by the current key of the user or by one of its
+// recovery keys. signer is the hex public key used to sign the message, if empty
+// the current key is assumed.
+func UserRotateKey(ctx context.Context, users UserRepository, id schema.ID, pubkey, signer string, signature []byte) error {
+	current, err := users.Get(ctx, id)
+	if err != nil {
 		return err
 	}
 
-	return nil
+	if _, err := crypto.KeyFromHex(pubkey); err != nil {
+		return errors.Wrap(ErrBadRotation, "invalid public key")
+	}
+
+	if current.HasKey(pubkey) {
+		return errors.Wrap(ErrBadRotation, "public key has already been used")
+	}
+
+	if len(signer) == 0 {
+		signer = current.Pubkey
+	}
+
+	if signer != current.Pubkey && !current.IsRecoveryKey(signer) {
+		return errors.Wrap(ErrAuthorization, "signer is not the current key or a recovery key of the user")
+	}
+
+	key, err := crypto.KeyFromHex(signer)
+	if err != nil {
+		return errors.Wrap(ErrBadRotation, "invalid signer key")
+	}
+
+	if err := crypto.Verify(key, RotationMessage(id, current.Pubkey, pubkey), signature); err != nil {
+		return errors.Wrap(ErrBadRotation, "payload verification failed")
+	}
+
+	previous := current.Pubkey
+	current.rotate(pubkey, time.Now())
+
+	// only rotate if the key was not changed in the meantime
+	return users.SetKeys(ctx, id, previous, current.Pubkey, current.KeyHistory)
+}
+
+// UserSetRecoveryKeys sets the keys allowed to rotate the key of the user.
+// The first recovery keys are signed by the current key of the user, once the
+// user has recovery keys they can only be changed with one of them, otherwise
+// a leaked user key could replace them and set the budget. signer is the hex
+// public key used to sign the message, if empty the current key is assumed.
+func UserSetRecoveryKeys(ctx context.Context, users UserRepository, id schema.ID, keys []string, signer string, signature []byte) error {
+	current, err := users.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if len(signer) == 0 {
+		signer = current.Pubkey
+	}
+
+	if len(current.RecoveryKeys) > 0 {
+		if !current.IsRecoveryKey(signer) {
+			return errors.Wrap(ErrAuthorization, "recovery keys must be signed by a recovery key of the user")
+		}
+	} else if signer != current.Pubkey {
+		return errors.Wrap(ErrAuthorization, "recovery keys must be signed by the key of the user")
+	}
+
+	for _, k := range keys {
+		if _, err := crypto.KeyFromHex(k); err != nil {
+			return errors.Wrapf(ErrBadUserUpdate, "invalid recovery key %s", k)
+		}
+
+		if k == current.Pubkey {
+			return errors.Wrap(ErrBadUserUpdate, "the current key can not be a recovery key")
+		}
+	}
+
+	key, err := crypto.KeyFromHex(signer)
+	if err != nil {
+		return errors.Wrap(ErrBadUserUpdate, "invalid signer key")
+	}
+
+	if err := crypto.Verify(key, RecoveryKeysMessage(id, current.Pubkey, keys), signature); err != nil {
+		return errors.Wrap(ErrBadUserUpdate, "payload verification failed")
+	}
+
+	if keys == nil {
+		keys = []string{}
+	}
+
+	return users.SetRecoveryKeys(ctx, id, current.Pubkey, keys)
+}
+
+// IsRecoveryKey checks if key is one of the recovery keys of the user
+func (u *User) IsRecoveryKey(key string) bool {
+	for _, k := range u.RecoveryKeys {
+		if k == key {
+			return true
+		}
+	}
+
+	return false
 }
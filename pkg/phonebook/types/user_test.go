@@ -2,7 +2,9 @@ package types
 
 import (
 	"testing"
+	"time"
 
+	"github.com/threefoldtech/tfexplorer/config"
 	"gotest.tools/assert"
 )
 
@@ -61,3 +63,54 @@ func TestUser_Validate(t *testing.T) {
 		})
 	}
 }
+
+func TestUser_ValidateNamePolicy(t *testing.T) {
+	policy := config.Config.Names
+	defer func() {
+		config.Config.Names = policy
+	}()
+
+	config.Config.Names = config.NamePolicy{
+		Pattern:  `^[a-z0-9][a-z0-9._-]*$`,
+		Reserved: config.ReservedNames{"admin", "threefold.3bot"},
+	}
+
+	assert.NilError(t, User{Name: "user.3bot"}.Validate())
+	assert.Error(t, User{Name: "admin"}.Validate(), "name 'admin' is reserved")
+	assert.Error(t, User{Name: "threefold.3bot"}.Validate(), "name 'threefold.3bot' is reserved")
+	assert.ErrorContains(t, User{Name: "user 3bot"}.Validate(), "name must match")
+	assert.ErrorContains(t, User{Name: ".user"}.Validate(), "name must match")
+}
+
+func TestUser_KeyAt(t *testing.T) {
+	start := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	u := User{Pubkey: "key1"}
+	assert.Equal(t, u.KeyAt(start), "key1")
+
+	u.rotate("key2", start.Add(time.Hour))
+	u.rotate("key3", start.Add(2*time.Hour))
+
+	assert.Equal(t, u.Pubkey, "key3")
+	assert.Equal(t, len(u.KeyHistory), 2)
+	assert.Assert(t, u.KeyHistory[1].ValidFrom.Equal(start.Add(time.Hour)))
+
+	assert.Equal(t, u.KeyAt(start), "key1")
+	assert.Equal(t, u.KeyAt(start.Add(90*time.Minute)), "key2")
+	assert.Equal(t, u.KeyAt(start.Add(2*time.Hour)), "key3")
+	assert.Equal(t, u.KeyAt(start.Add(24*time.Hour)), "key3")
+
+	assert.Assert(t, u.HasKey("key1"))
+	assert.Assert(t, !u.HasKey("key4"))
+}
+
+func TestUser_RotatedAt(t *testing.T) {
+	u := User{Pubkey: "key1"}
+	assert.Assert(t, u.RotatedAt().IsZero())
+
+	rotation := time.Now().Add(-time.Minute)
+	u.rotate("key2", rotation)
+	assert.Assert(t, u.RotatedAt().Equal(rotation))
+	assert.Equal(t, u.KeyAt(rotation.Add(-time.Minute)), "key1")
+	assert.Equal(t, u.KeyAt(time.Now()), "key2")
+}
@@ -1,25 +1,39 @@
 package phonebook
 
 import (
+	"context"
 	"crypto/ed25519"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"math"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
 	"github.com/threefoldtech/tfexplorer/models"
 	"github.com/threefoldtech/tfexplorer/mw"
+	"github.com/threefoldtech/tfexplorer/pkg/mailer"
 	"github.com/threefoldtech/tfexplorer/pkg/phonebook/types"
 	"github.com/threefoldtech/tfexplorer/schema"
 	"github.com/threefoldtech/zos/pkg/crypto"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// ErrVerificationDisabled is returned when no mailer is configured
+var ErrVerificationDisabled = errors.New("email verification is not enabled on this explorer")
+
 // UserAPI struct
-type UserAPI struct{}
+type UserAPI struct {
+	users  types.UserRepository
+	tokens types.TokenRepository
+	// mailer sends the verification emails, nil if verification is disabled
+	mailer mailer.Mailer
+	// admins are the threebot ids of the explorer admins, they can raise budgets
+	admins []int64
+}
 
 // create user entry point, makes sure name is free for reservation
 func (u *UserAPI) create(r *http.Request) (interface{}, mw.Response) {
@@ -36,17 +50,101 @@ func (u *UserAPI) create(r *http.Request) (interface{}, mw.Response) {
 		return nil, mw.BadRequest(err)
 	}
 
-	db := mw.Database(r)
-	user, err := types.UserCreate(r.Context(), db, user.Name, user.Email, user.Pubkey)
+	user, err := types.UserCreate(r.Context(), u.users, user.Name, user.Email, user.Pubkey)
 	if err != nil && errors.Is(err, types.ErrUserExists) {
 		return nil, mw.Conflict(err)
 	} else if err != nil {
 		return nil, mw.Error(err)
 	}
 
+	if u.mailer != nil && len(user.Email) != 0 {
+		// the user can still ask for a new verification email
+		// so this does not fail the registration
+		if err := u.sendVerification(r.Context(), user); err != nil {
+			log.Error().Err(err).Int64("user", int64(user.ID)).Msg("failed to send verification email")
+		}
+	}
+
 	return user, mw.Created()
 }
 
+func (u *UserAPI) sendVerification(ctx context.Context, user types.User) error {
+	verification, err := types.EmailVerificationCreate(ctx, u.users, user)
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf(
+		"Hello %s,\n\nuse the following token to verify the email of your threebot %d:\n\n%s\n\nThe token expires at %s.\n",
+		user.Name, user.ID, verification.Token, verification.Expiration.Format(time.RFC1123),
+	)
+
+	return u.mailer.Send(ctx, user.Email, "Verify your email address", body)
+}
+
+// requestVerification sends a new verification token to the email of the user.
+// The request must be signed by the user
+func (u *UserAPI) requestVerification(r *http.Request) (interface{}, mw.Response) {
+	if u.mailer == nil {
+		return nil, mw.Error(ErrVerificationDisabled, http.StatusNotImplemented)
+	}
+
+	userID, merr := u.owner(r)
+	if merr != nil {
+		return nil, merr
+	}
+
+	user, err := u.users.Get(r.Context(), userID)
+	if err != nil {
+		return nil, mw.NotFound(err)
+	}
+
+	if len(user.Email) == 0 {
+		return nil, mw.BadRequest(fmt.Errorf("user has no email"))
+	}
+
+	if user.EmailVerified {
+		return nil, mw.Conflict(fmt.Errorf("email is already verified"))
+	}
+
+	err = u.sendVerification(r.Context(), user)
+	if errors.Is(err, types.ErrVerificationCooldown) {
+		return nil, mw.Error(err, http.StatusTooManyRequests).
+			WithHeader("Retry-After", fmt.Sprint(int64(types.VerificationCooldown.Seconds())))
+	} else if err != nil {
+		return nil, mw.Error(err)
+	}
+
+	return nil, mw.Created()
+}
+
+// verifyEmail checks the token sent to the user and marks the email as verified
+func (u *UserAPI) verifyEmail(r *http.Request) (interface{}, mw.Response) {
+	userID, err := u.parseID(mux.Vars(r)["user_id"])
+	if err != nil {
+		return nil, mw.BadRequest(err)
+	}
+
+	var payload struct {
+		Token string `json:"token"`
+	}
+
+	defer r.Body.Close()
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		return nil, mw.BadRequest(err)
+	}
+
+	err = u.users.VerifyEmail(r.Context(), userID, payload.Token)
+	if errors.Is(err, types.ErrInvalidToken) {
+		return nil, mw.BadRequest(err)
+	} else if err != nil {
+		return nil, mw.Error(err)
+	}
+
+	return nil, nil
+}
+
 /*
 register
 As implemented in threebot. It works as a USER update function. To update
@@ -80,11 +178,14 @@ func (u *UserAPI) register(r *http.Request) (interface{}, mw.Response) {
 	if err != nil {
 		return nil, mw.BadRequest(errors.Wrap(err, "invalid signature hex"))
 	}
-	db := mw.Database(r)
 
-	if err := types.UserUpdate(r.Context(), db, schema.ID(id), signature, payload.User); err != nil {
+	if err := types.UserUpdate(r.Context(), u.users, schema.ID(id), signature, payload.User); err != nil {
 		if errors.Is(err, types.ErrBadUserUpdate) {
 			return nil, mw.BadRequest(err)
+		} else if errors.Is(err, types.ErrUserNotFound) {
+			return nil, mw.NotFound(err)
+		} else if errors.Is(err, types.ErrConcurrentRotation) {
+			return nil, mw.Conflict(err)
 		}
 		return nil, mw.Error(err)
 	}
@@ -92,32 +193,190 @@ func (u *UserAPI) register(r *http.Request) (interface{}, mw.Response) {
 	return nil, nil
 }
 
+/*
+rotate
+replaces the public key of the user. The payload holds the new public key and
+the signature of the message built by types.RotationMessage. The message is signed
+either with the current key of the user or with one of its recovery keys, in which
+case signer must be set to the hex encoded recovery key.
+
+The previous key is kept in the key history of the user.
+*/
+func (u *UserAPI) rotate(r *http.Request) (interface{}, mw.Response) {
+	id, err := u.parseID(mux.Vars(r)["user_id"])
+	if err != nil {
+		return nil, mw.BadRequest(errors.Wrap(err, "invalid user id"))
+	}
+
+	var payload struct {
+		Pubkey    string `json:"pubkey"`
+		Signer    string `json:"signer"`
+		Signature string `json:"signature"`
+	}
+
+	defer r.Body.Close()
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		return nil, mw.BadRequest(err)
+	}
+
+	signature, err := hex.DecodeString(payload.Signature)
+	if err != nil {
+		return nil, mw.BadRequest(errors.Wrap(err, "invalid signature hex"))
+	}
+
+	err = types.UserRotateKey(r.Context(), u.users, id, payload.Pubkey, payload.Signer, signature)
+	if errors.Is(err, types.ErrUserNotFound) {
+		return nil, mw.NotFound(err)
+	} else if errors.Is(err, types.ErrBadRotation) {
+		return nil, mw.BadRequest(err)
+	} else if errors.Is(err, types.ErrAuthorization) {
+		return nil, mw.Deny(r, mw.UnAuthorized(err))
+	} else if errors.Is(err, types.ErrConcurrentRotation) {
+		return nil, mw.Conflict(err)
+	} else if err != nil {
+		return nil, mw.Error(err)
+	}
+
+	return nil, nil
+}
+
+/*
+setRecoveryKeys
+sets the keys allowed to rotate the key of the user. The payload must be signed
+over the message built by types.RecoveryKeysMessage, with a recovery key of the
+user if any is registered, or with the current key otherwise. signer is the hex
+encoded key used to sign.
+*/
+func (u *UserAPI) setRecoveryKeys(r *http.Request) (interface{}, mw.Response) {
+	id, err := u.parseID(mux.Vars(r)["user_id"])
+	if err != nil {
+		return nil, mw.BadRequest(errors.Wrap(err, "invalid user id"))
+	}
+
+	var payload struct {
+		RecoveryKeys []string `json:"recovery_keys"`
+		Signer       string   `json:"signer"`
+		Signature    string   `json:"signature"`
+	}
+
+	defer r.Body.Close()
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		return nil, mw.BadRequest(err)
+	}
+
+	signature, err := hex.DecodeString(payload.Signature)
+	if err != nil {
+		return nil, mw.BadRequest(errors.Wrap(err, "invalid signature hex"))
+	}
+
+	err = types.UserSetRecoveryKeys(r.Context(), u.users, id, payload.RecoveryKeys, payload.Signer, signature)
+	if errors.Is(err, types.ErrUserNotFound) {
+		return nil, mw.NotFound(err)
+	} else if errors.Is(err, types.ErrBadUserUpdate) {
+		return nil, mw.BadRequest(err)
+	} else if errors.Is(err, types.ErrAuthorization) {
+		return nil, mw.Deny(r, mw.UnAuthorized(err))
+	} else if errors.Is(err, types.ErrConcurrentRotation) {
+		return nil, mw.Conflict(err)
+	} else if err != nil {
+		return nil, mw.Error(err)
+	}
+
+	return nil, nil
+}
+
+/*
+setBudget
+sets the budget of the user. The payload must be signed over the message built by
+types.BudgetMessage with version, the current version of the budget of the user.
+The current key of the user can only lower the budget, raising it must be signed
+with a recovery key of the user or the key of an explorer admin. signer is the
+hex encoded key used to sign.
+*/
+func (u *UserAPI) setBudget(r *http.Request) (interface{}, mw.Response) {
+	id, err := u.parseID(mux.Vars(r)["user_id"])
+	if err != nil {
+		return nil, mw.BadRequest(errors.Wrap(err, "invalid user id"))
+	}
+
+	var payload struct {
+		Budget    types.Budget `json:"budget"`
+		Version   int64        `json:"version"`
+		Signer    string       `json:"signer"`
+		Signature string       `json:"signature"`
+	}
+
+	defer r.Body.Close()
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		return nil, mw.BadRequest(err)
+	}
+
+	signature, err := hex.DecodeString(payload.Signature)
+	if err != nil {
+		return nil, mw.BadRequest(errors.Wrap(err, "invalid signature hex"))
+	}
+
+	err = types.UserSetBudget(r.Context(), u.users, id, payload.Budget, payload.Version, payload.Signer, signature, u.admins)
+	if errors.Is(err, types.ErrUserNotFound) {
+		return nil, mw.NotFound(err)
+	} else if errors.Is(err, types.ErrBadUserUpdate) {
+		return nil, mw.BadRequest(err)
+	} else if errors.Is(err, types.ErrAuthorization) {
+		return nil, mw.Deny(r, mw.UnAuthorized(err))
+	} else if errors.Is(err, types.ErrBudgetVersion) {
+		return nil, mw.Conflict(err)
+	} else if err != nil {
+		return nil, mw.Error(err)
+	}
+
+	return nil, nil
+}
+
 func (u *UserAPI) list(r *http.Request) (interface{}, mw.Response) {
 	var filter types.UserFilter
 	filter = filter.WithName(r.FormValue("name"))
 	filter = filter.WithEmail(r.FormValue("email"))
 
-	db := mw.Database(r)
-	pager := models.PageFromRequest(r)
-	cur, err := filter.Find(r.Context(), db, pager)
+	query, err := models.ParseQuery(r, types.UserQueryFields)
+	if err != nil {
+		return nil, mw.BadRequest(err)
+	}
+	filter = filter.WithQuery(query)
+
+	pager, err := models.PageFromRequest(r)
+	if err != nil {
+		return nil, mw.BadRequest(err)
+	}
+
+	pager, err = query.Page(pager)
+	if err != nil {
+		return nil, mw.BadRequest(err)
+	}
+
+	users, total, err := u.users.List(r.Context(), filter, pager, query.Projection())
 	if err != nil {
 		return nil, mw.Error(err)
 	}
 
-	users := []types.User{}
-	if err := cur.All(r.Context(), &users); err != nil {
+	next, err := pager.Next(users)
+	if err != nil {
 		return nil, mw.Error(err)
 	}
 
-	total, err := filter.Count(r.Context(), db)
+	out, err := query.Project(users)
 	if err != nil {
-		return nil, mw.Error(err, http.StatusInternalServerError)
+		return nil, mw.Error(err)
 	}
 
-	nrPages := math.Ceil(float64(total) / float64(*pager.Limit))
-	pages := fmt.Sprintf("%d", int64(nrPages))
+	response := mw.Paginated(r, next)
+	if pager.Paged() {
+		response = response.WithHeader("Pages", fmt.Sprint(models.Pages(pager, total)))
+	}
 
-	return users, mw.Ok().WithHeader("Pages", pages)
+	return out, response
 }
 
 func (u *UserAPI) parseID(id string) (schema.ID, error) {
@@ -129,17 +388,51 @@ func (u *UserAPI) parseID(id string) (schema.ID, error) {
 	return schema.ID(v), nil
 }
 
+// registered implements mw.UserResolver for the users
+func (u *UserAPI) registered(ctx context.Context, tid int64) (bool, error) {
+	return types.UserExists(ctx, u.users, schema.ID(tid))
+}
+
+// signer implements mw.SignerResolver for the users
+func (u *UserAPI) signer(ctx context.Context, userID string, signer string) ([]mw.Role, error) {
+	id, err := u.parseID(userID)
+	if err != nil {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	user, err := u.users.Get(ctx, id)
+	if errors.Is(err, types.ErrUserNotFound) {
+		return nil, mongo.ErrNoDocuments
+	} else if err != nil {
+		return nil, err
+	}
+
+	var roles []mw.Role
+	if user.Pubkey == signer {
+		roles = append(roles, mw.RoleSelf)
+	}
+	if user.IsRecoveryKey(signer) {
+		roles = append(roles, mw.RoleRecovery)
+	}
+
+	admin, err := types.IsAdminKey(ctx, u.users, u.admins, signer)
+	if err != nil {
+		return nil, err
+	}
+	if admin {
+		roles = append(roles, mw.RoleAdmin)
+	}
+
+	return roles, nil
+}
+
 func (u *UserAPI) get(r *http.Request) (interface{}, mw.Response) {
 
 	userID, err := u.parseID(mux.Vars(r)["user_id"])
 	if err != nil {
 		return nil, mw.BadRequest(err)
 	}
-	var filter types.UserFilter
-	filter = filter.WithID(userID)
-
-	db := mw.Database(r)
-	user, err := filter.Get(r.Context(), db)
+	user, err := u.users.Get(r.Context(), userID)
 	if err != nil {
 		return nil, mw.NotFound(err)
 	}
@@ -174,15 +467,13 @@ func (u *UserAPI) validate(r *http.Request) (interface{}, mw.Response) {
 		return nil, mw.BadRequest(errors.Wrap(err, "signature must be hex encoded string of original data"))
 	}
 
-	var filter types.UserFilter
-	filter = filter.WithID(userID)
-
-	db := mw.Database(r)
-	user, err := filter.Get(r.Context(), db)
+	user, err := u.users.Get(r.Context(), userID)
 	if err != nil {
 		return nil, mw.NotFound(err)
 	}
 
+	// the signature is only checked against the current key, a rotated key
+	// may be compromised and the caller must not be able to pick it
 	key, err := crypto.KeyFromHex(user.Pubkey)
 	if err != nil {
 		return nil, mw.Error(err)
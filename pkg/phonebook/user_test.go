@@ -0,0 +1,296 @@
+package phonebook
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/tfexplorer/mw"
+	"github.com/threefoldtech/tfexplorer/pkg/phonebook/types"
+)
+
+func newUserRequest(t *testing.T, name, email string) *http.Request {
+	pk, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	body, err := json.Marshal(types.User{Name: name, Email: email, Pubkey: hex.EncodeToString(pk)})
+	require.NoError(t, err)
+
+	return httptest.NewRequest(http.MethodPost, "/users", bytes.NewReader(body))
+}
+
+func TestUserCreateGet(t *testing.T) {
+	api := UserAPI{users: types.NewMemoryUserRepository()}
+
+	result, resp := api.create(newUserRequest(t, "alice", "alice@example.com"))
+	require.NotNil(t, resp)
+	require.Equal(t, http.StatusCreated, resp.Status())
+
+	user := result.(types.User)
+	assert.NotZero(t, user.ID)
+
+	_, resp = api.create(newUserRequest(t, "alice", "other@example.com"))
+	require.NotNil(t, resp)
+	assert.Equal(t, http.StatusConflict, resp.Status())
+
+	r := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/users/1", nil), map[string]string{"user_id": "1"})
+	result, resp = api.get(r)
+	require.Nil(t, resp)
+	assert.Equal(t, "alice", result.(types.User).Name)
+
+	r = mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/users/2", nil), map[string]string{"user_id": "2"})
+	_, resp = api.get(r)
+	require.NotNil(t, resp)
+	assert.Equal(t, http.StatusNotFound, resp.Status())
+}
+
+func TestSetRecoveryKeys(t *testing.T) {
+	api := UserAPI{users: types.NewMemoryUserRepository()}
+
+	pk, sk, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	rpk, rsk, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	user, err := api.users.Create(context.Background(), types.User{Name: "alice", Email: "alice@example.com", Pubkey: hex.EncodeToString(pk)})
+	require.NoError(t, err)
+
+	set := func(keys []string, signer string, sk ed25519.PrivateKey) mw.Response {
+		message := types.RecoveryKeysMessage(user.ID, user.Pubkey, keys)
+		body, err := json.Marshal(map[string]interface{}{
+			"recovery_keys": keys,
+			"signer":        signer,
+			"signature":     hex.EncodeToString(ed25519.Sign(sk, message)),
+		})
+		require.NoError(t, err)
+
+		r := httptest.NewRequest(http.MethodPut, "/users/1/keys/recovery", bytes.NewReader(body))
+		_, resp := api.setRecoveryKeys(mux.SetURLVars(r, map[string]string{"user_id": fmt.Sprint(int64(user.ID))}))
+		return resp
+	}
+
+	recovery := []string{hex.EncodeToString(rpk)}
+	require.Nil(t, set(recovery, "", sk))
+
+	// once set, the user key can not replace the recovery keys
+	resp := set(nil, "", sk)
+	require.NotNil(t, resp)
+	assert.Equal(t, http.StatusUnauthorized, resp.Status())
+
+	require.Nil(t, set(nil, recovery[0], rsk))
+
+	stored, err := api.users.Get(context.Background(), user.ID)
+	require.NoError(t, err)
+	assert.Empty(t, stored.RecoveryKeys)
+}
+
+func TestUserRegister(t *testing.T) {
+	api := UserAPI{users: types.NewMemoryUserRepository()}
+	ctx := context.Background()
+
+	pk, sk, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	npk, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	user, err := api.users.Create(ctx, types.User{Name: "alice", Email: "alice@example.com", Pubkey: hex.EncodeToString(pk)})
+	require.NoError(t, err)
+
+	register := func(update types.User) mw.Response {
+		update.ID = user.ID
+		body, err := json.Marshal(map[string]interface{}{
+			"email":                update.Email,
+			"host":                 update.Host,
+			"pubkey":               update.Pubkey,
+			"sender_signature_hex": hex.EncodeToString(ed25519.Sign(sk, update.Encode())),
+		})
+		require.NoError(t, err)
+
+		r := httptest.NewRequest(http.MethodPut, "/users/1", bytes.NewReader(body))
+		_, resp := api.register(mux.SetURLVars(r, map[string]string{"user_id": fmt.Sprint(int64(user.ID))}))
+		return resp
+	}
+
+	// the key can only be changed with a rotation
+	resp := register(types.User{Pubkey: hex.EncodeToString(npk)})
+	require.NotNil(t, resp)
+	assert.Equal(t, http.StatusBadRequest, resp.Status())
+
+	require.Nil(t, register(types.User{Email: "alice@example.org", Host: "alice.example.org"}))
+
+	stored, err := api.users.Get(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, user.Pubkey, stored.Pubkey)
+	assert.Equal(t, "alice@example.org", stored.Email)
+	assert.Equal(t, "alice.example.org", stored.Host)
+}
+
+func TestSetBudget(t *testing.T) {
+	api := UserAPI{users: types.NewMemoryUserRepository()}
+	ctx := context.Background()
+
+	pk, sk, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	apk, ask, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	user, err := api.users.Create(ctx, types.User{Name: "alice", Email: "alice@example.com", Pubkey: hex.EncodeToString(pk)})
+	require.NoError(t, err)
+	admin, err := api.users.Create(ctx, types.User{Name: "admin", Email: "admin@example.com", Pubkey: hex.EncodeToString(apk)})
+	require.NoError(t, err)
+	api.admins = []int64{int64(admin.ID)}
+
+	set := func(budget types.Budget, version int64, signer string, sk ed25519.PrivateKey) mw.Response {
+		message, err := types.BudgetMessage(user.ID, version, budget)
+		require.NoError(t, err)
+
+		body, err := json.Marshal(map[string]interface{}{
+			"budget":    budget,
+			"version":   version,
+			"signer":    signer,
+			"signature": hex.EncodeToString(ed25519.Sign(sk, message)),
+		})
+		require.NoError(t, err)
+
+		r := httptest.NewRequest(http.MethodPut, "/users/1/budget", bytes.NewReader(body))
+		_, resp := api.setBudget(mux.SetURLVars(r, map[string]string{"user_id": fmt.Sprint(int64(user.ID))}))
+		return resp
+	}
+
+	// the user key can lower its budget
+	require.Nil(t, set(types.Budget{MaxReservations: 10}, 0, "", sk))
+	require.Nil(t, set(types.Budget{MaxReservations: 5}, 1, "", sk))
+
+	// but not raise it
+	resp := set(types.Budget{MaxReservations: 100}, 2, "", sk)
+	require.NotNil(t, resp)
+	assert.Equal(t, http.StatusUnauthorized, resp.Status())
+
+	// a budget signed for an older version can not be replayed
+	resp = set(types.Budget{MaxReservations: 10}, 0, "", sk)
+	require.NotNil(t, resp)
+	assert.Equal(t, http.StatusConflict, resp.Status())
+
+	require.Nil(t, set(types.Budget{}, 2, hex.EncodeToString(apk), ask))
+
+	stored, err := api.users.Get(ctx, user.ID)
+	require.NoError(t, err)
+	assert.False(t, types.Budget(stored.Budget).IsLimited())
+	assert.EqualValues(t, 3, stored.BudgetVersion)
+}
+
+func TestUserList(t *testing.T) {
+	api := UserAPI{users: types.NewMemoryUserRepository()}
+
+	for _, name := range []string{"alice", "bob", "carol"} {
+		_, resp := api.create(newUserRequest(t, name, name+"@example.com"))
+		require.Equal(t, http.StatusCreated, resp.Status())
+	}
+
+	result, resp := api.list(httptest.NewRequest(http.MethodGet, "/users?name=bob", nil))
+	require.NotNil(t, resp)
+	users := result.([]types.User)
+	require.Len(t, users, 1)
+	assert.Equal(t, "bob@example.com", users[0].Email)
+
+	result, resp = api.list(httptest.NewRequest(http.MethodGet, "/users?page=2&size=2", nil))
+	require.NotNil(t, resp)
+	assert.Equal(t, "2", resp.Header().Get("Pages"))
+	users = result.([]types.User)
+	require.Len(t, users, 1)
+	assert.Equal(t, "carol", users[0].Name)
+}
+
+func TestVerifyEmail(t *testing.T) {
+	api := UserAPI{users: types.NewMemoryUserRepository()}
+
+	ctx := context.Background()
+	user, err := api.users.Create(ctx, types.User{Name: "alice", Email: "alice@example.com"})
+	require.NoError(t, err)
+
+	verification, err := types.EmailVerificationCreate(ctx, api.users, user)
+	require.NoError(t, err)
+
+	_, err = types.EmailVerificationCreate(ctx, api.users, user)
+	assert.True(t, errors.Is(err, types.ErrVerificationCooldown))
+
+	verify := func(token string) mw.Response {
+		body, err := json.Marshal(map[string]string{"token": token})
+		require.NoError(t, err)
+
+		r := httptest.NewRequest(http.MethodPost, "/users/1/email/verify", bytes.NewReader(body))
+		_, resp := api.verifyEmail(mux.SetURLVars(r, map[string]string{"user_id": fmt.Sprint(int64(user.ID))}))
+		return resp
+	}
+
+	resp := verify("wrong")
+	require.NotNil(t, resp)
+	assert.Equal(t, http.StatusBadRequest, resp.Status())
+
+	require.Nil(t, verify(verification.Token))
+
+	stored, err := api.users.Get(ctx, user.ID)
+	require.NoError(t, err)
+	assert.True(t, stored.EmailVerified)
+
+	// the token is consumed
+	resp = verify(verification.Token)
+	require.NotNil(t, resp)
+	assert.Equal(t, http.StatusBadRequest, resp.Status())
+}
+
+func TestValidateRotatedKey(t *testing.T) {
+	api := UserAPI{users: types.NewMemoryUserRepository()}
+	ctx := context.Background()
+
+	opk, osk, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	npk, nsk, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	user, err := api.users.Create(ctx, types.User{Name: "alice", Email: "alice@example.com", Pubkey: hex.EncodeToString(opk)})
+	require.NoError(t, err)
+	before := time.Now().Add(-time.Hour)
+
+	message := types.RotationMessage(user.ID, user.Pubkey, hex.EncodeToString(npk))
+	err = types.UserRotateKey(ctx, api.users, user.ID, hex.EncodeToString(npk), user.Pubkey, ed25519.Sign(osk, message))
+	require.NoError(t, err)
+
+	validate := func(sk ed25519.PrivateKey, epoch int64) bool {
+		data := []byte("hello")
+		body, err := json.Marshal(map[string]interface{}{
+			"payload":   hex.EncodeToString(data),
+			"signature": hex.EncodeToString(ed25519.Sign(sk, data)),
+			"epoch":     epoch,
+		})
+		require.NoError(t, err)
+
+		r := httptest.NewRequest(http.MethodPost, "/users/1/validate", bytes.NewReader(body))
+		result, resp := api.validate(mux.SetURLVars(r, map[string]string{"user_id": fmt.Sprint(int64(user.ID))}))
+		require.Nil(t, resp)
+
+		out, err := json.Marshal(result)
+		require.NoError(t, err)
+		var valid struct {
+			IsValid bool `json:"is_valid"`
+		}
+		require.NoError(t, json.Unmarshal(out, &valid))
+		return valid.IsValid
+	}
+
+	assert.True(t, validate(nsk, 0))
+	// the rotated key is rejected, even with an epoch from before the rotation
+	assert.False(t, validate(osk, before.Unix()))
+	assert.False(t, validate(osk, 0))
+}
@@ -0,0 +1,300 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	directory "github.com/threefoldtech/tfexplorer/pkg/directory/types"
+	phonebook "github.com/threefoldtech/tfexplorer/pkg/phonebook/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// HitType is the type of object a search hit refers to
+type HitType string
+
+const (
+	// HitUser is a phonebook user
+	HitUser HitType = "user"
+	// HitFarm is a farm
+	HitFarm HitType = "farm"
+	// HitNode is a node
+	HitNode HitType = "node"
+	// HitGateway is a gateway
+	HitGateway HitType = "gateway"
+)
+
+const (
+	scoreExact    = 100
+	scorePrefix   = 50
+	scoreContains = 20
+	// the mongo text score is usually between 0.5 and 2, weighted so that
+	// a text match alone ranks below a name match
+	textWeight = 5
+)
+
+// Hit is a single search result
+type Hit struct {
+	Type   HitType     `json:"type"`
+	ID     string      `json:"id"`
+	Name   string      `json:"name"`
+	Score  float64     `json:"score"`
+	Object interface{} `json:"object"`
+}
+
+// source describes how to search a collection
+type source struct {
+	kind       HitType
+	collection string
+	// idField is matched by prefix, or exactly if numeric is set
+	idField string
+	numeric bool
+	// nameField is matched case insensitively anywhere in the name
+	nameField string
+	decode    func(raw bson.Raw) (Hit, error)
+}
+
+var sources = []source{
+	{
+		kind:       HitUser,
+		collection: phonebook.UserCollection,
+		idField:    "_id",
+		numeric:    true,
+		nameField:  "name",
+		decode: func(raw bson.Raw) (Hit, error) {
+			var user phonebook.User
+			err := bson.Unmarshal(raw, &user)
+			return Hit{ID: fmt.Sprint(user.ID), Name: user.Name, Object: user}, err
+		},
+	},
+	{
+		kind:       HitFarm,
+		collection: directory.FarmCollection,
+		idField:    "_id",
+		numeric:    true,
+		nameField:  "name",
+		decode: func(raw bson.Raw) (Hit, error) {
+			var farm directory.Farm
+			err := bson.Unmarshal(raw, &farm)
+			return Hit{ID: fmt.Sprint(farm.ID), Name: farm.Name, Object: farm}, err
+		},
+	},
+	{
+		kind:       HitNode,
+		collection: directory.NodeCollection,
+		idField:    "node_id",
+		decode: func(raw bson.Raw) (Hit, error) {
+			var node directory.Node
+			err := bson.Unmarshal(raw, &node)
+			return Hit{ID: node.NodeId, Name: node.NodeId, Object: node}, err
+		},
+	},
+	{
+		kind:       HitGateway,
+		collection: directory.GatewayCollection,
+		idField:    "node_id",
+		decode: func(raw bson.Raw) (Hit, error) {
+			var gateway directory.Gateway
+			err := bson.Unmarshal(raw, &gateway)
+			return Hit{ID: gateway.NodeId, Name: gateway.NodeId, Object: gateway}, err
+		},
+	},
+}
+
+// ParseHitTypes parses a comma separated list of hit types, empty
+// input means all types
+func ParseHitTypes(s string) ([]HitType, error) {
+	if len(s) == 0 {
+		return nil, nil
+	}
+
+	var kinds []HitType
+	for _, name := range strings.Split(s, ",") {
+		kind := HitType(strings.TrimSpace(name))
+		found := false
+		for _, src := range sources {
+			if src.kind == kind {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("unknown type '%s'", kind)
+		}
+		kinds = append(kinds, kind)
+	}
+
+	return kinds, nil
+}
+
+// match builds the query for the id and name matches of q
+func (s *source) match(q string) bson.D {
+	var or bson.A
+	if s.numeric {
+		if id, err := strconv.ParseInt(q, 10, 64); err == nil {
+			or = append(or, bson.M{s.idField: id})
+		}
+	} else {
+		or = append(or, bson.M{s.idField: bson.M{"$regex": "^" + regexp.QuoteMeta(q)}})
+	}
+
+	if len(s.nameField) != 0 {
+		or = append(or, bson.M{s.nameField: bson.M{"$regex": regexp.QuoteMeta(q), "$options": "i"}})
+	}
+
+	if len(or) == 0 {
+		return nil
+	}
+
+	return bson.D{{Key: "$or", Value: or}}
+}
+
+// find runs query on the source collection and decodes the hits, the text
+// score is read from the score field if projected
+func (s *source) find(ctx context.Context, db *mongo.Database, query bson.D, opts *options.FindOptions) ([]Hit, error) {
+	cur, err := db.Collection(s.collection).Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var hits []Hit
+	for cur.Next(ctx) {
+		hit, err := s.decode(cur.Current)
+		if err != nil {
+			return nil, err
+		}
+
+		hit.Type = s.kind
+		if score, ok := cur.Current.Lookup("score").DoubleOK(); ok {
+			hit.Score = score * textWeight
+		}
+		hits = append(hits, hit)
+	}
+
+	return hits, cur.Err()
+}
+
+// search finds the hits of q in the source, both by text index and by id and name
+func (s *source) search(ctx context.Context, db *mongo.Database, q string, limit int64) ([]Hit, error) {
+	textScore := bson.M{"score": bson.M{"$meta": "textScore"}}
+	hits, err := s.find(ctx, db,
+		bson.D{{Key: "$text", Value: bson.M{"$search": q}}},
+		options.Find().SetProjection(textScore).SetSort(textScore).SetLimit(limit),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if query := s.match(q); query != nil {
+		matches, err := s.find(ctx, db, query, options.Find().SetLimit(limit))
+		if err != nil {
+			return nil, err
+		}
+		hits = append(hits, matches...)
+	}
+
+	return hits, nil
+}
+
+// rank returns the score of the id and name match of q against hit
+func rank(hit Hit, q string) float64 {
+	name := strings.ToLower(hit.Name)
+	lower := strings.ToLower(q)
+
+	switch {
+	case hit.ID == q || name == lower:
+		return scoreExact
+	case strings.HasPrefix(hit.ID, q) || strings.HasPrefix(name, lower):
+		return scorePrefix
+	case strings.Contains(name, lower):
+		return scoreContains
+	}
+
+	return 0
+}
+
+// merge deduplicates the hits, adds the match rank to the text score and
+// sorts them by descending score
+func merge(hits []Hit, q string) []Hit {
+	type key struct {
+		kind HitType
+		id   string
+	}
+
+	seen := make(map[key]int)
+	merged := make([]Hit, 0, len(hits))
+	for _, hit := range hits {
+		k := key{kind: hit.Type, id: hit.ID}
+		if i, ok := seen[k]; ok {
+			if hit.Score > merged[i].Score {
+				merged[i].Score = hit.Score
+			}
+			continue
+		}
+
+		seen[k] = len(merged)
+		merged = append(merged, hit)
+	}
+
+	for i := range merged {
+		merged[i].Score += rank(merged[i], q)
+	}
+
+	sort.SliceStable(merged, func(i, j int) bool {
+		a, b := merged[i], merged[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.ID < b.ID
+	})
+
+	return merged
+}
+
+// Search finds the users, farms, nodes and gateways matching q, ranked by
+// relevance. Only the given kinds are searched, or all if none is given
+func Search(ctx context.Context, db *mongo.Database, q string, kinds []HitType, limit int64) ([]Hit, error) {
+	var hits []Hit
+	for _, src := range sources {
+		if !wanted(kinds, src.kind) {
+			continue
+		}
+
+		found, err := src.search(ctx, db, q, limit)
+		if err != nil {
+			return nil, fmt.Errorf("failed to search %s: %w", src.collection, err)
+		}
+		hits = append(hits, found...)
+	}
+
+	hits = merge(hits, q)
+	if int64(len(hits)) > limit {
+		hits = hits[:limit]
+	}
+
+	return hits, nil
+}
+
+func wanted(kinds []HitType, kind HitType) bool {
+	if len(kinds) == 0 {
+		return true
+	}
+
+	for _, k := range kinds {
+		if k == kind {
+			return true
+		}
+	}
+
+	return false
+}
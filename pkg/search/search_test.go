@@ -0,0 +1,93 @@
+package search
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/tfexplorer/mw"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestRank(t *testing.T) {
+	assert.EqualValues(t, scoreExact, rank(Hit{ID: "12", Name: "belgium"}, "12"))
+	assert.EqualValues(t, scoreExact, rank(Hit{ID: "1", Name: "Belgium"}, "belgium"))
+	assert.EqualValues(t, scorePrefix, rank(Hit{ID: "3Fk8sdf", Name: "3Fk8sdf"}, "3Fk"))
+	assert.EqualValues(t, scoreContains, rank(Hit{ID: "1", Name: "farm-belgium"}, "bel"))
+	// node ids are case sensitive
+	assert.EqualValues(t, 0, rank(Hit{ID: "3Fk8sdf"}, "3fk"))
+}
+
+func TestMerge(t *testing.T) {
+	hits := merge([]Hit{
+		{Type: HitFarm, ID: "2", Name: "freefarm", Score: 7},
+		{Type: HitFarm, ID: "1", Name: "belfarm", Score: 3},
+		{Type: HitUser, ID: "5", Name: "bel"},
+		{Type: HitFarm, ID: "1", Name: "belfarm"},
+	}, "bel")
+
+	require.Len(t, hits, 3)
+	assert.Equal(t, Hit{Type: HitUser, ID: "5", Name: "bel", Score: scoreExact}, hits[0])
+	assert.Equal(t, Hit{Type: HitFarm, ID: "1", Name: "belfarm", Score: scorePrefix + 3}, hits[1])
+	assert.Equal(t, Hit{Type: HitFarm, ID: "2", Name: "freefarm", Score: 7}, hits[2])
+}
+
+func TestSourceMatch(t *testing.T) {
+	users := source{idField: "_id", numeric: true, nameField: "name"}
+	assert.Equal(t, bson.D{{Key: "$or", Value: bson.A{
+		bson.M{"_id": int64(12)},
+		bson.M{"name": bson.M{"$regex": "12", "$options": "i"}},
+	}}}, users.match("12"))
+
+	nodes := source{idField: "node_id"}
+	assert.Equal(t, bson.D{{Key: "$or", Value: bson.A{
+		bson.M{"node_id": bson.M{"$regex": `^a\.b`}},
+	}}}, nodes.match("a.b"))
+}
+
+func TestParseHitTypes(t *testing.T) {
+	kinds, err := ParseHitTypes("")
+	require.NoError(t, err)
+	assert.Nil(t, kinds)
+
+	kinds, err = ParseHitTypes("farm, node")
+	require.NoError(t, err)
+	assert.Equal(t, []HitType{HitFarm, HitNode}, kinds)
+
+	_, err = ParseHitTypes("farm,reservation")
+	assert.Error(t, err)
+}
+
+func TestSearchHandler(t *testing.T) {
+	var (
+		kinds []HitType
+		limit int64
+	)
+	api := API{find: func(ctx context.Context, q string, k []HitType, l int64) ([]Hit, error) {
+		kinds, limit = k, l
+		return []Hit{{Type: HitFarm, ID: "1", Name: q}}, nil
+	}}
+
+	search := func(query string) (interface{}, mw.Response) {
+		return api.search(httptest.NewRequest(http.MethodGet, "/search?"+query, nil))
+	}
+
+	result, resp := search("q=bel&type=farm&limit=1000")
+	require.Nil(t, resp)
+	assert.Equal(t, []Hit{{Type: HitFarm, ID: "1", Name: "bel"}}, result)
+	assert.Equal(t, []HitType{HitFarm}, kinds)
+	assert.Equal(t, int64(maxLimit), limit)
+
+	_, resp = search("q=bel")
+	require.Nil(t, resp)
+	assert.Equal(t, int64(defaultLimit), limit)
+
+	for _, query := range []string{"q=", "q=bel&type=reservation", "q=bel&limit=many"} {
+		_, resp = search(query)
+		require.NotNil(t, resp, query)
+		assert.Equal(t, http.StatusBadRequest, resp.Status(), query)
+	}
+}
@@ -0,0 +1,81 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/tfexplorer/models"
+	"github.com/threefoldtech/tfexplorer/mw"
+	"github.com/threefoldtech/tfexplorer/pkg/apierror"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	defaultLimit   = 20
+	maxLimit       = 100
+	maxQueryLength = 128
+)
+
+// Searcher finds the objects of kinds matching q, like Search
+type Searcher func(ctx context.Context, q string, kinds []HitType, limit int64) ([]Hit, error)
+
+// NewSearcher returns a Searcher using the text indexes of db
+func NewSearcher(db *mongo.Database) Searcher {
+	return func(ctx context.Context, q string, kinds []HitType, limit int64) ([]Hit, error) {
+		return Search(ctx, db, q, kinds, limit)
+	}
+}
+
+// API is the search handler
+type API struct {
+	find Searcher
+}
+
+// Setup injects and initializes search package. The text indexes it relies on
+// are created by the phonebook and directory packages
+func Setup(parents []*mux.Router, db *mongo.Database) error {
+	api := API{find: NewSearcher(db)}
+	for _, parent := range parents {
+		parent.HandleFunc("/search", mw.AsHandlerFunc(api.search)).Methods(http.MethodGet).Name("search")
+	}
+	return nil
+}
+
+// search handles /search?q=<query>&type=<user,farm,node,gateway>&limit=<n>
+func (a *API) search(r *http.Request) (interface{}, mw.Response) {
+	q := strings.TrimSpace(r.FormValue("q"))
+	if len(q) == 0 {
+		return nil, mw.BadRequest(apierror.FieldError("q", fmt.Errorf("q is required")))
+	}
+
+	if len(q) > maxQueryLength {
+		return nil, mw.BadRequest(apierror.FieldError("q", fmt.Errorf("q can not be longer than %d characters", maxQueryLength)))
+	}
+
+	kinds, err := ParseHitTypes(r.FormValue("type"))
+	if err != nil {
+		return nil, mw.BadRequest(apierror.FieldError("type", err))
+	}
+
+	limit, err := models.QueryInt(r, "limit")
+	if err != nil {
+		return nil, mw.BadRequest(apierror.FieldError("limit", errors.Wrap(err, "limit should be an integer")))
+	}
+
+	if limit <= 0 {
+		limit = defaultLimit
+	} else if limit > maxLimit {
+		limit = maxLimit
+	}
+
+	hits, err := a.find(r.Context(), q, kinds, limit)
+	if err != nil {
+		return nil, mw.Error(err)
+	}
+
+	return hits, nil
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/stellar/go/support/errors"
 	"github.com/stellar/go/txnbuild"
 	"github.com/stellar/go/xdr"
+	"github.com/threefoldtech/tfexplorer/pkg/apierror"
 	"github.com/threefoldtech/tfexplorer/schema"
 )
 
@@ -58,7 +59,7 @@ var (
 	// ErrInsufficientBalance is an error that is used when there is insufficient balance
 	ErrInsufficientBalance = errors.New("insufficient balance")
 	// ErrAssetCodeNotSupported indicated the given asset code is not supported by this wallet
-	ErrAssetCodeNotSupported = errors.New("asset code not supported")
+	ErrAssetCodeNotSupported = apierror.New(apierror.CodeAssetNotSupported, "asset code not supported")
 )
 
 // New stellar wallet from an optional seed. If no seed is given (i.e. empty string),
@@ -6,6 +6,7 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/stellar/go/clients/horizonclient"
+	"github.com/stellar/go/keypair"
 	hProtocol "github.com/stellar/go/protocols/horizon"
 )
 
@@ -79,3 +80,14 @@ func (a *AddressValidator) getHorizonClient() (*horizonclient.Client, error) {
 		return nil, errors.New("network is not supported")
 	}
 }
+
+// VerifySignature checks that signature is the signature of data made
+// with the private key of the stellar address
+func VerifySignature(address string, data, signature []byte) error {
+	kp, err := keypair.ParseAddress(address)
+	if err != nil {
+		return errors.Wrap(err, "invalid stellar address")
+	}
+
+	return kp.Verify(data, signature)
+}
@@ -0,0 +1,26 @@
+package stellar
+
+import (
+	"testing"
+
+	"github.com/stellar/go/keypair"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifySignature(t *testing.T) {
+	kp, err := keypair.Random()
+	require.NoError(t, err)
+
+	other, err := keypair.Random()
+	require.NoError(t, err)
+
+	challenge := []byte("0123456789abcdef")
+	signature, err := kp.Sign(challenge)
+	require.NoError(t, err)
+
+	assert.NoError(t, VerifySignature(kp.Address(), challenge, signature))
+	assert.Error(t, VerifySignature(other.Address(), challenge, signature))
+	assert.Error(t, VerifySignature(kp.Address(), []byte("another challenge"), signature))
+	assert.Error(t, VerifySignature("not an address", challenge, signature))
+}
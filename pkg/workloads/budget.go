@@ -0,0 +1,369 @@
+package workloads
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/stellar/go/amount"
+	"github.com/stellar/go/xdr"
+	generated "github.com/threefoldtech/tfexplorer/models/generated/workloads"
+	"github.com/threefoldtech/tfexplorer/mw"
+	"github.com/threefoldtech/tfexplorer/pkg/apierror"
+	"github.com/threefoldtech/tfexplorer/pkg/escrow"
+	escrowtypes "github.com/threefoldtech/tfexplorer/pkg/escrow/types"
+	phonebook "github.com/threefoldtech/tfexplorer/pkg/phonebook/types"
+	"github.com/threefoldtech/tfexplorer/pkg/workloads/types"
+	"github.com/threefoldtech/tfexplorer/schema"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrBudgetExceeded is returned when a reservation does not fit in the budget
+var ErrBudgetExceeded = apierror.New(apierror.CodeBudgetExceeded, "budget exceeded")
+
+// activeActions are the states of the reservations accounted in a budget
+var activeActions = []generated.NextActionEnum{
+	generated.NextActionCreate,
+	generated.NextActionSign,
+	generated.NextActionPay,
+	generated.NextActionDeploy,
+}
+
+// Usage is how much of a budget is used
+type Usage struct {
+	// Reservations is the number of reservations that are not deleted
+	Reservations int64 `json:"reservations"`
+	// Cu and Su are the cloud units used by these reservations
+	Cu float64 `json:"cu"`
+	Su float64 `json:"su"`
+	// Spending is the amount spent for each spending limit of the budget
+	Spending []SpendingUsage `json:"spending"`
+}
+
+// SpendingUsage is the amount spent on the reservations created during period
+type SpendingUsage struct {
+	Asset  string  `json:"asset"`
+	Period int64   `json:"period"`
+	Spent  float64 `json:"spent"`
+	Limit  float64 `json:"limit"`
+}
+
+// isActive checks if the reservation is accounted in a budget. The stored state
+// of a reservation is only updated when it is processed, so the reservations
+// that expired or were not deployed in time are run through the pipeline to
+// find out if they are about to be deleted
+func isActive(reservation types.Reservation) bool {
+	// the organization signers are not needed, a reservation deleted by
+	// their signatures is already in the delete state
+	pl, err := types.NewPipeline(reservation)
+	if err != nil {
+		return true
+	}
+
+	reservation, _ = pl.Next()
+	return reservation.IsAny(activeActions...)
+}
+
+// usageBatchSize is the number of reservations read at once to compute a usage
+const usageBatchSize = 500
+
+// usageProjection leaves out the fields of the reservations that are not
+// needed to compute a usage, the results are still needed by the pipeline
+var usageProjection = bson.M{
+	"json":               0,
+	"customer_signature": 0,
+	"metadata":           0,
+	"signatures_farmer":  0,
+	"results.data_json":  0,
+	"results.signature":  0,
+	"results.message":    0,
+}
+
+// eachReservation calls fn with the reservations matching filter. They are read
+// in batches sorted by id so the reservations of a large customer are never
+// all loaded at once
+func (a *API) eachReservation(ctx context.Context, filter types.ReservationFilter, opts *options.FindOptions, fn func(reservations []types.Reservation) error) error {
+	var from schema.ID
+	for {
+		batch := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(usageBatchSize)
+		reservations, err := a.reservations.Find(ctx, append(types.ReservationFilter{}, filter...).WithIDGE(from), batch, opts)
+		if err != nil {
+			return err
+		}
+
+		if len(reservations) > 0 {
+			from = reservations[len(reservations)-1].ID + 1
+		}
+
+		if err := fn(reservations); err != nil {
+			return err
+		}
+
+		if len(reservations) < usageBatchSize {
+			return nil
+		}
+	}
+}
+
+// budgetUsage computes the usage of budget by the reservations matching filter
+func (a *API) budgetUsage(ctx context.Context, filter types.ReservationFilter, budget phonebook.Budget) (Usage, error) {
+	usage := Usage{Spending: []SpendingUsage{}}
+
+	active := append(types.ReservationFilter{}, filter...).WithNextActions(activeActions...)
+	err := a.eachReservation(ctx, active, options.Find().SetProjection(usageProjection), func(reservations []types.Reservation) error {
+		for _, reservation := range reservations {
+			if !isActive(reservation) {
+				continue
+			}
+
+			cu, su := escrow.ReservationCloudUnits(reservation.DataReservation)
+			usage.Reservations++
+			usage.Cu += cu
+			usage.Su += su
+		}
+		return nil
+	})
+	if err != nil {
+		return usage, err
+	}
+
+	for _, limit := range budget.Spending {
+		since := time.Now().Add(-time.Duration(limit.Period) * time.Second)
+		recent := append(types.ReservationFilter{}, filter...).WithEpochGE(since)
+
+		// only the ids are needed to find the escrows of the reservations
+		var spent xdr.Int64
+		err := a.eachReservation(ctx, recent, options.Find().SetProjection(bson.M{"_id": 1}), func(reservations []types.Reservation) error {
+			if len(reservations) == 0 {
+				return nil
+			}
+
+			ids := make([]schema.ID, 0, len(reservations))
+			for _, reservation := range reservations {
+				ids = append(ids, reservation.ID)
+			}
+
+			due, err := a.payments.Spent(ctx, ids, limit.Asset)
+			if err != nil {
+				return err
+			}
+
+			spent += due
+			return nil
+		})
+		if err != nil {
+			return usage, err
+		}
+
+		usage.Spending = append(usage.Spending, SpendingUsage{
+			Asset:  limit.Asset,
+			Period: limit.Period,
+			Spent:  float64(spent) / float64(amount.One),
+			Limit:  limit.Amount,
+		})
+	}
+
+	return usage, nil
+}
+
+// fits checks that a reservation with quote can be added to usage without exceeding budget
+func (u Usage) fits(budget phonebook.Budget, quote escrowtypes.Quote) error {
+	if budget.MaxReservations > 0 && u.Reservations+1 > budget.MaxReservations {
+		return errors.Wrapf(ErrBudgetExceeded, "maximum of %d active reservations reached", budget.MaxReservations)
+	}
+
+	if budget.MaxCu > 0 && u.Cu+quote.Cu > budget.MaxCu {
+		return errors.Wrapf(ErrBudgetExceeded, "reservation needs %.3f CU, %.3f of %.3f CU used", quote.Cu, u.Cu, budget.MaxCu)
+	}
+
+	if budget.MaxSu > 0 && u.Su+quote.Su > budget.MaxSu {
+		return errors.Wrapf(ErrBudgetExceeded, "reservation needs %.3f SU, %.3f of %.3f SU used", quote.Su, u.Su, budget.MaxSu)
+	}
+
+	if quote.Asset == "" {
+		return nil
+	}
+
+	cost := float64(quote.Amount) / float64(amount.One)
+	for _, spending := range u.Spending {
+		if spending.Asset != quote.Asset.Code() || spending.Limit == 0 {
+			continue
+		}
+
+		if spending.Spent+cost > spending.Limit {
+			return errors.Wrapf(ErrBudgetExceeded, "reservation costs %f %s, %f of %f spent over the last %s",
+				cost, spending.Asset, spending.Spent, spending.Limit, time.Duration(spending.Period)*time.Second)
+		}
+	}
+
+	return nil
+}
+
+// budgetLockLease is how long a budget stays locked if the explorer stops
+// before unlocking it
+const budgetLockLease = 30 * time.Second
+
+// maxBudgetLockRetries is how many times a budget held by a concurrent
+// creation is locked again before giving up
+const maxBudgetLockRetries = 10
+
+// budgetLocker locks the budgets of a kind of customer
+type budgetLocker interface {
+	LockBudget(ctx context.Context, id schema.ID, lease time.Duration) (string, error)
+	UnlockBudget(ctx context.Context, id schema.ID, lock string) error
+}
+
+// lockBudget locks the budget of customer id, waiting for a concurrent creation
+// to release it
+func lockBudget(ctx context.Context, locker budgetLocker, id schema.ID) (string, error) {
+	var err error
+	for i := 0; i < maxBudgetLockRetries; i++ {
+		var lock string
+		if lock, err = locker.LockBudget(ctx, id, budgetLockLease); !errors.Is(err, phonebook.ErrBudgetLocked) {
+			return lock, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(time.Duration(i+1) * 50 * time.Millisecond):
+		}
+	}
+
+	return "", err
+}
+
+// checkBudgets makes sure the reservation fits in the budget of the customer
+// and of the customer organization. Each limited budget is locked before its
+// usage is read, so the creations against the same budget are serialized: in a
+// transaction the lock writes conflict, without transactions the lock is held
+// until the returned unlock is called, once the reservation is stored. It must
+// run in the transaction creating the reservation
+func (a *API) checkBudgets(ctx context.Context, res *types.Reservation, user phonebook.User, currencies []string) (func(), mw.Response) {
+	type scope struct {
+		name   string
+		id     schema.ID
+		locker budgetLocker
+		budget phonebook.Budget
+		filter types.ReservationFilter
+	}
+
+	type held struct {
+		scope
+		lock string
+	}
+
+	// unlock is returned on every path, the caller defers it unconditionally
+	var locks []held
+	unlock := func() {
+		for _, l := range locks {
+			if err := l.locker.UnlockBudget(ctx, l.id, l.lock); err != nil {
+				log.Error().Err(err).Str("budget", l.name).Msg("failed to unlock budget")
+			}
+		}
+	}
+
+	var scopes []scope
+	if budget := phonebook.Budget(user.Budget); budget.IsLimited() {
+		scopes = append(scopes, scope{
+			name:   fmt.Sprintf("user %d", user.ID),
+			id:     user.ID,
+			locker: a.users,
+			budget: budget,
+			filter: types.ReservationFilter{}.WithCustomerID(int(user.ID)),
+		})
+	}
+
+	if res.DataReservation.CustomerOrg != 0 {
+		org, err := a.orgs.Get(ctx, schema.ID(res.DataReservation.CustomerOrg))
+		if errors.Is(err, phonebook.ErrOrganizationNotFound) {
+			return unlock, mw.NotFound(err)
+		} else if err != nil {
+			return unlock, mw.Error(err)
+		}
+
+		if budget := phonebook.Budget(org.Budget); budget.IsLimited() {
+			scopes = append(scopes, scope{
+				name:   fmt.Sprintf("organization %d", org.ID),
+				id:     org.ID,
+				locker: a.orgs,
+				budget: budget,
+				filter: types.ReservationFilter{}.WithCustomerOrg(res.DataReservation.CustomerOrg),
+			})
+		}
+	}
+
+	if len(scopes) == 0 {
+		return unlock, nil
+	}
+
+	quote, err := a.escrow.Quote(generated.Reservation(*res), currencies)
+	if err != nil {
+		return unlock, mw.BadRequest(errors.Wrap(err, "failed to quote reservation"))
+	}
+
+	for _, s := range scopes {
+		lock, err := lockBudget(ctx, s.locker, s.id)
+		if errors.Is(err, phonebook.ErrBudgetLocked) {
+			return unlock, mw.Conflict(errors.Wrapf(err, "budget of %s", s.name))
+		} else if err != nil {
+			return unlock, mw.Error(err)
+		}
+		locks = append(locks, held{scope: s, lock: lock})
+
+		usage, err := a.budgetUsage(ctx, s.filter, s.budget)
+		if err != nil {
+			return unlock, mw.Error(err)
+		}
+
+		if err := usage.fits(s.budget, quote); err != nil {
+			return unlock, mw.Forbidden(errors.Wrapf(err, "budget of %s", s.name))
+		}
+	}
+
+	return unlock, nil
+}
+
+func (a *API) userUsage(r *http.Request) (interface{}, mw.Response) {
+	id, err := strconv.ParseInt(mux.Vars(r)["user_id"], 10, 64)
+	if err != nil {
+		return nil, mw.BadRequest(errors.Wrap(err, "invalid user id"))
+	}
+
+	user, err := a.users.Get(r.Context(), schema.ID(id))
+	if err != nil {
+		return nil, mw.NotFound(err)
+	}
+
+	usage, err := a.budgetUsage(r.Context(), types.ReservationFilter{}.WithCustomerID(int(id)), phonebook.Budget(user.Budget))
+	if err != nil {
+		return nil, mw.Error(err)
+	}
+
+	return usage, nil
+}
+
+func (a *API) organizationUsage(r *http.Request) (interface{}, mw.Response) {
+	id, err := strconv.ParseInt(mux.Vars(r)["org_id"], 10, 64)
+	if err != nil {
+		return nil, mw.BadRequest(errors.Wrap(err, "invalid organization id"))
+	}
+
+	org, err := a.orgs.Get(r.Context(), schema.ID(id))
+	if err != nil {
+		return nil, mw.NotFound(err)
+	}
+
+	usage, err := a.budgetUsage(r.Context(), types.ReservationFilter{}.WithCustomerOrg(id), phonebook.Budget(org.Budget))
+	if err != nil {
+		return nil, mw.Error(err)
+	}
+
+	return usage, nil
+}
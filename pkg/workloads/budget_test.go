@@ -0,0 +1,78 @@
+package workloads
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	generated "github.com/threefoldtech/tfexplorer/models/generated/workloads"
+	phonebook "github.com/threefoldtech/tfexplorer/pkg/phonebook/types"
+	"github.com/threefoldtech/tfexplorer/pkg/workloads/types"
+	"github.com/threefoldtech/tfexplorer/schema"
+)
+
+func TestIsActive(t *testing.T) {
+	reservation := func(action generated.NextActionEnum, provisioning, expiration time.Duration) types.Reservation {
+		var r types.Reservation
+		r.NextAction = action
+		r.DataReservation.ExpirationProvisioning = schema.Date{Time: time.Now().Add(provisioning)}
+		r.DataReservation.ExpirationReservation = schema.Date{Time: time.Now().Add(expiration)}
+		r.DataReservation.Volumes = []generated.Volume{{WorkloadId: 1, NodeId: "node"}}
+		return r
+	}
+
+	assert.True(t, isActive(reservation(types.Sign, time.Hour, 24*time.Hour)))
+	assert.False(t, isActive(reservation(types.Delete, time.Hour, 24*time.Hour)))
+
+	// not paid or deployed before the provisioning expiration
+	assert.False(t, isActive(reservation(types.Pay, -time.Hour, 24*time.Hour)))
+	assert.False(t, isActive(reservation(types.Deploy, -time.Hour, 24*time.Hour)))
+
+	deployed := reservation(types.Deploy, -time.Hour, 24*time.Hour)
+	deployed.Results = []generated.Result{{WorkloadId: "1-1", State: generated.ResultStateOK}}
+	assert.True(t, isActive(deployed))
+
+	deployed.DataReservation.ExpirationReservation = schema.Date{Time: time.Now().Add(-time.Minute)}
+	assert.False(t, isActive(deployed))
+}
+
+func TestCheckBudgetsUnknownOrganization(t *testing.T) {
+	api, _, _ := newTestAPI(t)
+
+	var res types.Reservation
+	res.DataReservation.CustomerOrg = 42
+
+	unlock, merr := api.checkBudgets(context.Background(), &res, phonebook.User{}, nil)
+	// the caller defers unlock even if the check fails
+	require.NotNil(t, unlock)
+	unlock()
+
+	require.NotNil(t, merr)
+	assert.Equal(t, http.StatusNotFound, merr.Status())
+}
+
+func TestBudgetUsageBatches(t *testing.T) {
+	api, _, _ := newTestAPI(t)
+	ctx := context.Background()
+
+	// more than a batch of active reservations, and some deleted ones
+	for i := 0; i < usageBatchSize+20; i++ {
+		var reservation types.Reservation
+		reservation.CustomerTid = 7
+		reservation.NextAction = types.Deploy
+		if i%10 == 0 {
+			reservation.NextAction = types.Deleted
+		}
+		reservation.DataReservation.ExpirationProvisioning = schema.Date{Time: time.Now().Add(time.Hour)}
+		reservation.DataReservation.ExpirationReservation = schema.Date{Time: time.Now().Add(24 * time.Hour)}
+		_, err := api.reservations.Create(ctx, reservation)
+		require.NoError(t, err)
+	}
+
+	usage, err := api.budgetUsage(ctx, types.ReservationFilter{}.WithCustomerID(7), phonebook.Budget{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(usageBatchSize+20-(usageBatchSize+20)/10), usage.Reservations)
+}
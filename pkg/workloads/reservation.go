@@ -24,6 +24,7 @@ import (
 	"github.com/threefoldtech/tfexplorer/pkg/stellar"
 	"github.com/threefoldtech/tfexplorer/pkg/workloads/types"
 	"github.com/threefoldtech/tfexplorer/schema"
+	"github.com/zaibon/httpsig"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -32,7 +33,17 @@ import (
 type (
 	// API struct
 	API struct {
-		escrow escrow.Escrow
+		escrow       escrow.Escrow
+		reservations types.ReservationRepository
+		queue        types.WorkloadQueue
+		users        phonebook.UserRepository
+		orgs         phonebook.OrganizationRepository
+		nodes        directory.NodeRepository
+		farms        directory.FarmRepository
+		gateways     directory.GatewayRepository
+		payments     escrowtypes.PaymentRepository
+		// tx makes the writes of the handlers atomic
+		tx models.Transactor
 	}
 
 	// ReservationCreateResponse wraps reservation create response
@@ -45,20 +56,13 @@ type (
 // freeTFT currency code
 const freeTFT = "FreeTFT"
 
-func (a *API) validAddresses(ctx context.Context, db *mongo.Database, res *types.Reservation) error {
+func (a *API) validAddresses(ctx context.Context, res *types.Reservation) error {
 	if config.Config.Network == "" {
 		log.Info().Msg("escrow disabled, no validation of farmer wallet address needed")
 		return nil
 	}
 
-	workloads := res.Workloads("")
-	var nodes []string
-
-	for _, wl := range workloads {
-		nodes = append(nodes, wl.NodeID)
-	}
-
-	farms, err := directory.FarmsForNodes(ctx, db, nodes...)
+	farms, err := a.farmsOf(ctx, res)
 	if err != nil {
 		return err
 	}
@@ -82,6 +86,228 @@ func (a *API) validAddresses(ctx context.Context, db *mongo.Database, res *types
 	return nil
 }
 
+// farmsOf returns the farms of the nodes used by the workloads of the reservation
+func (a *API) farmsOf(ctx context.Context, res *types.Reservation) ([]directory.Farm, error) {
+	seen := make(map[string]struct{})
+	ids := make(map[int64]struct{})
+	var farms []directory.Farm
+	for _, wl := range res.Workloads("") {
+		if _, ok := seen[wl.NodeID]; ok {
+			continue
+		}
+		seen[wl.NodeID] = struct{}{}
+
+		node, err := a.nodes.Get(ctx, wl.NodeID, false)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			// gateways and unknown nodes have no farm to check
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		if _, ok := ids[node.FarmId]; ok {
+			continue
+		}
+		ids[node.FarmId] = struct{}{}
+
+		farm, err := a.farms.Get(ctx, schema.ID(node.FarmId))
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		farms = append(farms, farm)
+	}
+
+	return farms, nil
+}
+
+// checkRetired makes sure none of the nodes and gateways used by the reservation
+// have been decommissioned by their farmer
+func (a *API) checkRetired(ctx context.Context, res *types.Reservation) error {
+	nodes := res.NodeIDs()
+	if len(nodes) > 0 {
+		count, err := a.nodes.Count(ctx, directory.NodeFilter{}.
+			WithNodeIDs(nodes).
+			WithRetired(true))
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("reservation uses %d decommissioned node(s)", count)
+		}
+	}
+
+	gateways := res.GatewayIDs()
+	if len(gateways) > 0 {
+		count, err := a.gateways.Count(ctx, directory.GatewayFilter{}.
+			WithGWIDs(gateways).
+			WithRetired(true))
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("reservation uses %d decommissioned gateway(s)", count)
+		}
+	}
+
+	return nil
+}
+
+// checkCertified makes sure all the nodes used by the reservation are approved
+// by a certifier when the customer asked for certified nodes only
+func (a *API) checkCertified(ctx context.Context, res *types.Reservation) error {
+	if !res.DataReservation.CertifiedOnly {
+		return nil
+	}
+
+	nodes := res.NodeIDs()
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	count, err := a.nodes.Count(ctx, directory.NodeFilter{}.
+		WithNodeIDs(nodes).
+		WithApproved(true))
+	if err != nil {
+		return err
+	}
+
+	if count < int64(len(nodes)) {
+		return fmt.Errorf("reservation requires certified nodes but uses %d node(s) that are not certified", int64(len(nodes))-count)
+	}
+
+	return nil
+}
+
+// domainClaims returns all the domains claimed by the gateway workloads of the reservation
+func domainClaims(res *types.Reservation) []directory.DomainClaim {
+	var claims []directory.DomainClaim
+	for _, wl := range res.DataReservation.Subdomains {
+		claims = append(claims, directory.DomainClaim{
+			Domain:    directory.NormalizeDomain(wl.Domain),
+			Type:      directory.DomainTypeSubdomain,
+			GatewayID: wl.NodeId,
+		})
+	}
+	for _, wl := range res.DataReservation.DomainDelegates {
+		claims = append(claims, directory.DomainClaim{
+			Domain:    directory.NormalizeDomain(wl.Domain),
+			Type:      directory.DomainTypeDelegate,
+			GatewayID: wl.NodeId,
+		})
+	}
+
+	return claims
+}
+
+// checkDomains makes sure the domains used by the reservation are managed by
+// (or delegatable to) their gateway and not already held by another reservation
+func (a *API) checkDomains(ctx context.Context, res *types.Reservation) mw.Response {
+	seen := make(map[string]struct{})
+	for _, claim := range domainClaims(res) {
+		if _, ok := seen[claim.Domain]; ok {
+			return mw.BadRequest(fmt.Errorf("domain '%s' is used more than once in the reservation", claim.Domain))
+		}
+		seen[claim.Domain] = struct{}{}
+
+		gw, err := a.gateways.Get(ctx, claim.GatewayID)
+		if err != nil {
+			return mw.BadRequest(errors.Wrapf(err, "failed to load gateway '%s'", claim.GatewayID))
+		}
+
+		if err := directory.ValidateDomain(gw, claim.Domain, claim.Type); err != nil {
+			return mw.BadRequest(err)
+		}
+
+		current, err := a.gateways.GetDomain(ctx, claim.Domain)
+		if err == nil {
+			return mw.Conflict(fmt.Errorf("domain '%s' is already held by reservation %d", claim.Domain, current.ReservationID))
+		} else if !errors.Is(err, mongo.ErrNoDocuments) {
+			return mw.Error(err)
+		}
+	}
+
+	return nil
+}
+
+// checkOrganizations makes sure the customer is a member of the customer organization
+// and that the organizations referenced by the signing requests exist
+func (a *API) checkOrganizations(ctx context.Context, res *types.Reservation) mw.Response {
+	if res.DataReservation.CustomerOrg != 0 {
+		org, err := a.orgs.Get(ctx, schema.ID(res.DataReservation.CustomerOrg))
+		if err != nil {
+			return mw.BadRequest(errors.Wrapf(err, "customer organization %d", res.DataReservation.CustomerOrg))
+		}
+
+		if !org.IsMember(res.CustomerTid) {
+			return mw.Forbidden(fmt.Errorf("customer %d is not a member of organization %d", res.CustomerTid, res.DataReservation.CustomerOrg))
+		}
+	}
+
+	for _, request := range []generated.SigningRequest{
+		res.DataReservation.SigningRequestProvision,
+		res.DataReservation.SigningRequestDelete,
+	} {
+		if request.OrgId == 0 {
+			continue
+		}
+
+		if _, err := a.orgs.Get(ctx, schema.ID(request.OrgId)); err != nil {
+			return mw.BadRequest(errors.Wrapf(err, "signing request organization %d", request.OrgId))
+		}
+	}
+
+	return nil
+}
+
+// claimResources reserves the farm public ips and gateway domains used by
+// the reservation, those are given back with releaseResources
+func (a *API) claimResources(ctx context.Context, res *types.Reservation) error {
+	for _, wl := range res.DataReservation.PublicIPs {
+		node, err := a.nodes.Get(ctx, wl.NodeId, false)
+		if err != nil {
+			return errors.Wrapf(err, "failed to load node '%s'", wl.NodeId)
+		}
+
+		err = a.farms.ReserveIP(ctx, schema.ID(node.FarmId), wl.IPaddress.IP, res.ID)
+		if errors.Is(err, directory.ErrIPNotAvailable) {
+			return fmt.Errorf("ip address %s is not available on farm %d", wl.IPaddress.IP, node.FarmId)
+		} else if err != nil {
+			return err
+		}
+	}
+
+	for _, claim := range domainClaims(res) {
+		claim.ReservationID = res.ID
+		claim.CustomerTid = res.CustomerTid
+		claim.Created = schema.Date{Time: time.Now()}
+
+		err := a.gateways.ClaimDomain(ctx, claim)
+		if errors.Is(err, directory.ErrDomainClaimed) {
+			return fmt.Errorf("domain '%s' is already claimed", claim.Domain)
+		} else if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// releaseResources frees all the public ips and domains held by the reservation
+func (a *API) releaseResources(ctx context.Context, id schema.ID) error {
+	if err := a.farms.ReleaseIPs(ctx, id); err != nil {
+		return errors.Wrap(err, "failed to release ip addresses")
+	}
+
+	if err := a.gateways.ReleaseDomains(ctx, id); err != nil {
+		return errors.Wrap(err, "failed to release domains")
+	}
+
+	return nil
+}
+
 func (a *API) create(r *http.Request) (interface{}, mw.Response) {
 	defer r.Body.Close()
 	var reservation types.Reservation
@@ -105,12 +331,17 @@ func (a *API) create(r *http.Request) (interface{}, mw.Response) {
 	reservation.SignaturesDelete = make([]generated.SigningSignature, 0)
 	reservation.SignaturesFarmer = make([]generated.SigningSignature, 0)
 	reservation.Results = make([]generated.Result, 0)
+	reservation.RetiredNodes = make([]string, 0)
 
 	if err := reservation.Validate(); err != nil {
 		return nil, mw.BadRequest(err)
 	}
 
-	reservation, err := a.pipeline(reservation, nil)
+	if merr := a.checkOrganizations(r.Context(), &reservation); merr != nil {
+		return nil, merr
+	}
+
+	reservation, err := a.pipeline(r.Context(), reservation)
 	if err != nil {
 		// if failed to create pipeline, then
 		// this reservation has failed initial validation
@@ -121,9 +352,19 @@ func (a *API) create(r *http.Request) (interface{}, mw.Response) {
 		return nil, mw.BadRequest(fmt.Errorf("invalid request wrong status '%s'", reservation.NextAction.String()))
 	}
 
-	db := mw.Database(r)
+	if err := a.checkRetired(r.Context(), &reservation); err != nil {
+		return nil, mw.BadRequest(err)
+	}
+
+	if err := a.checkCertified(r.Context(), &reservation); err != nil {
+		return nil, mw.BadRequest(err)
+	}
+
+	if merr := a.checkDomains(r.Context(), &reservation); merr != nil {
+		return nil, merr
+	}
 
-	if err := a.validAddresses(r.Context(), db, &reservation); err != nil {
+	if err := a.validAddresses(r.Context(), &reservation); err != nil {
 		return nil, mw.Error(err, http.StatusFailedDependency) //FIXME: what is this strange status ?
 	}
 
@@ -134,20 +375,18 @@ func (a *API) create(r *http.Request) (interface{}, mw.Response) {
 	var freeNodes int
 
 	usedNodes := reservation.NodeIDs()
-	count, err := (directory.NodeFilter{}).
+	count, err := a.nodes.Count(r.Context(), directory.NodeFilter{}.
 		WithNodeIDs(usedNodes).
-		WithFreeToUse(true).
-		Count(r.Context(), db)
+		WithFreeToUse(true))
 	if err != nil {
 		return nil, mw.Error(err, http.StatusInternalServerError)
 	}
 	freeNodes += int(count)
 
 	usedGateways := reservation.GatewayIDs()
-	count, err = (directory.GatewayFilter{}).
+	count, err = a.gateways.Count(r.Context(), directory.GatewayFilter{}.
 		WithGWIDs(usedGateways).
-		WithFreeToUse(true).
-		Count(r.Context(), db)
+		WithFreeToUse(true))
 	if err != nil {
 		return nil, mw.Error(err, http.StatusInternalServerError)
 	}
@@ -173,9 +412,7 @@ func (a *API) create(r *http.Request) (interface{}, mw.Response) {
 		}
 	}
 
-	var filter phonebook.UserFilter
-	filter = filter.WithID(schema.ID(reservation.CustomerTid))
-	user, err := filter.Get(r.Context(), db)
+	user, err := a.users.Get(r.Context(), schema.ID(reservation.CustomerTid))
 	if err != nil {
 		return nil, mw.BadRequest(errors.Wrapf(err, "cannot find user with id '%d'", reservation.CustomerTid))
 	}
@@ -185,24 +422,68 @@ func (a *API) create(r *http.Request) (interface{}, mw.Response) {
 		return nil, mw.BadRequest(errors.Wrap(err, "invalid signature format, expecting hex encoded string"))
 	}
 
+	// the epoch is not signed, so new data is only verified with the
+	// current key, a rotated key can not be used by backdating it
 	if err := reservation.Verify(user.Pubkey, signature); err != nil {
 		return nil, mw.BadRequest(errors.Wrap(err, "failed to verify customer signature"))
 	}
 
+	if config.Config.RequireVerifiedEmail && !user.EmailVerified {
+		return nil, mw.Forbidden(fmt.Errorf("the email of user '%d' must be verified before creating reservations", user.ID))
+	}
+
 	reservation.Epoch = schema.Date{Time: time.Now()}
 
-	id, err := types.ReservationCreate(r.Context(), db, reservation)
-	if err != nil {
-		return nil, mw.Error(err)
-	}
+	// the reservation is only stored if it fits in the budgets and all
+	// its resources can be claimed
+	var (
+		budgetErr mw.Response
+		claimErr  error
+	)
+	err = a.tx.WithTransaction(r.Context(), func(ctx context.Context) error {
+		var unlock func()
+		unlock, budgetErr = a.checkBudgets(ctx, &reservation, user, currencies)
+		defer unlock()
+
+		claimErr = nil
+		if budgetErr != nil {
+			return budgetErr.Err()
+		}
 
-	reservation, err = types.ReservationFilter{}.WithID(id).Get(r.Context(), db)
-	if err != nil {
+		id, err := a.reservations.Create(ctx, reservation)
+		if err != nil {
+			return err
+		}
+
+		reservation.ID = id
+		if claimErr = a.claimResources(ctx, &reservation); claimErr != nil {
+			return claimErr
+		}
+
+		reservation, err = a.reservations.Get(ctx, id)
+		return err
+	})
+
+	if budgetErr != nil {
+		return nil, budgetErr
+	} else if claimErr != nil {
+		if a.tx == models.NoTransaction {
+			// the reservation is stored anyway, give back what we
+			// managed to claim and make sure it is never processed
+			a.invalidate(r.Context(), reservation.ID)
+		}
+		return nil, mw.Conflict(claimErr)
+	} else if err != nil {
 		return nil, mw.Error(err)
 	}
 
+	// the escrow does its own writes, it can only be called once the
+	// reservation is committed. If the explorer stops before the escrow is
+	// registered, or the invalidation below fails, the escrow invalidates
+	// the reservation once its registration grace period is over
 	escrowDetails, err := a.escrow.RegisterReservation(generated.Reservation(reservation), currencies)
 	if err != nil {
+		a.invalidate(r.Context(), reservation.ID)
 		return nil, mw.Error(err)
 	}
 
@@ -212,6 +493,22 @@ func (a *API) create(r *http.Request) (interface{}, mw.Response) {
 	}, mw.Created()
 }
 
+// invalidate gives back the resources claimed by the reservation and
+// makes sure it is never processed
+func (a *API) invalidate(ctx context.Context, id schema.ID) {
+	err := a.tx.WithTransaction(ctx, func(ctx context.Context) error {
+		if err := a.releaseResources(ctx, id); err != nil {
+			return err
+		}
+
+		return a.reservations.SetNextAction(ctx, id, generated.NextActionInvalid)
+	})
+
+	if err != nil {
+		log.Error().Err(err).Int64("id", int64(id)).Msg("failed to invalidate reservation")
+	}
+}
+
 func (a *API) parseID(id string) (schema.ID, error) {
 	v, err := strconv.ParseInt(id, 10, 64)
 	if err != nil {
@@ -221,30 +518,43 @@ func (a *API) parseID(id string) (schema.ID, error) {
 	return schema.ID(v), nil
 }
 
-func (a *API) pipeline(r types.Reservation, err error) (types.Reservation, error) {
-	if err != nil {
-		return r, err
-	}
+// user implements mw.UserResolver for the customers and the signers
+func (a *API) user(ctx context.Context, tid int64) (bool, error) {
+	return phonebook.UserExists(ctx, a.users, schema.ID(tid))
+}
+
+func (a *API) pipeline(ctx context.Context, r types.Reservation) (types.Reservation, error) {
 	pl, err := types.NewPipeline(r)
 	if err != nil {
 		return r, errors.Wrap(err, "failed to process reservation state pipeline")
 	}
 
-	r, _ = pl.Next()
+	signers, err := r.OrgSigners(ctx, a.orgs)
+	if err != nil {
+		return r, errors.Wrap(err, "failed to load organization signers")
+	}
+
+	r, _ = pl.WithOrgSigners(signers).Next()
 	return r, nil
 }
 
+// load gets the reservation with id and process it through the pipeline
+func (a *API) load(ctx context.Context, id schema.ID) (types.Reservation, error) {
+	r, err := a.reservations.Get(ctx, id)
+	if err != nil {
+		return r, err
+	}
+
+	return a.pipeline(ctx, r)
+}
+
 func (a *API) get(r *http.Request) (interface{}, mw.Response) {
 	id, err := a.parseID(mux.Vars(r)["res_id"])
 	if err != nil {
 		return nil, mw.BadRequest(fmt.Errorf("invalid reservation id"))
 	}
 
-	var filter types.ReservationFilter
-	filter = filter.WithID(id)
-
-	db := mw.Database(r)
-	reservation, err := a.pipeline(filter.Get(r.Context(), db))
+	reservation, err := a.load(r.Context(), id)
 	if err != nil {
 		return nil, mw.NotFound(err)
 	}
@@ -252,177 +562,104 @@ func (a *API) get(r *http.Request) (interface{}, mw.Response) {
 	return reservation, nil
 }
 
-func (a *API) list(r *http.Request) (interface{}, mw.Response) {
-	var filter types.ReservationFilter
-	filter, err := types.ApplyQueryFilter(r, filter)
+// escrowDetails returns the escrow details of a reservation, only to its
+// customer or the members of its customer organization
+func (a *API) escrowDetails(r *http.Request) (interface{}, mw.Response) {
+	id, err := a.parseID(mux.Vars(r)["res_id"])
 	if err != nil {
-		return nil, mw.BadRequest(err)
+		return nil, mw.BadRequest(fmt.Errorf("invalid reservation id"))
 	}
 
-	db := mw.Database(r)
-	pager := models.PageFromRequest(r)
-	cur, err := filter.Find(r.Context(), db, pager)
+	reservation, err := a.load(r.Context(), id)
 	if err != nil {
-		return nil, mw.Error(err)
+		return nil, mw.NotFound(err)
 	}
 
-	defer cur.Close(r.Context())
-
-	total, err := filter.Count(r.Context(), db)
+	principal, _ := mw.PrincipalFromContext(r.Context())
+	tid, err := strconv.ParseInt(principal.ID, 10, 64)
 	if err != nil {
-		return nil, mw.Error(err)
+		return nil, mw.Forbidden(fmt.Errorf("only users can read escrow details"))
 	}
 
-	reservations := []types.Reservation{}
-
-	for cur.Next(r.Context()) {
-		var reservation types.Reservation
-		if err := cur.Decode(&reservation); err != nil {
-			// skip reservations we can not load
-			// this is probably an old reservation
-			currentID := cur.Current.Lookup("_id").Int64()
-			log.Error().Err(err).Int64("id", currentID).Msg("failed to decode reservation")
-			continue
-		}
-
-		reservation, err := a.pipeline(reservation, nil)
+	allowed := reservation.CustomerTid == tid
+	if !allowed && reservation.DataReservation.CustomerOrg != 0 {
+		org, err := a.orgs.Get(r.Context(), schema.ID(reservation.DataReservation.CustomerOrg))
 		if err != nil {
-			log.Error().Err(err).Int64("id", int64(reservation.ID)).Msg("failed to process reservation")
-			continue
+			return nil, mw.Error(err)
 		}
+		allowed = org.IsMember(tid)
+	}
 
-		reservations = append(reservations, reservation)
+	if !allowed {
+		return nil, mw.Forbidden(fmt.Errorf("only the customer of reservation %d can read its escrow details", id))
 	}
 
-	pages := fmt.Sprintf("%d", models.NrPages(total, *pager.Limit))
-	return reservations, mw.Ok().WithHeader("Pages", pages)
-}
+	info, err := a.payments.Get(r.Context(), id)
+	if errors.Is(err, escrowtypes.ErrEscrowNotFound) {
+		return nil, mw.NotFound(err)
+	} else if err != nil {
+		return nil, mw.Error(err)
+	}
 
-func (a *API) queued(ctx context.Context, db *mongo.Database, nodeID string, limit int64) ([]types.Workload, error) {
+	return escrowtypes.CustomerEscrowInformation{
+		Address: info.Address,
+		Asset:   info.Asset,
+		Details: info.Infos,
+	}, nil
+}
 
-	type intermediate struct {
-		WorkloadID string                     `bson:"workload_id" json:"workload_id"`
-		User       string                     `bson:"user" json:"user"`
-		Type       generated.WorkloadTypeEnum `bson:"type" json:"type"`
-		Content    bson.Raw                   `bson:"content" json:"content"`
-		Created    schema.Date                `bson:"created" json:"created"`
-		Duration   int64                      `bson:"duration" json:"duration"`
-		Signature  string                     `bson:"signature" json:"signature"`
-		ToDelete   bool                       `bson:"to_delete" json:"to_delete"`
-		NodeID     string                     `json:"node_id" bson:"node_id"`
+func (a *API) list(r *http.Request) (interface{}, mw.Response) {
+	var filter types.ReservationFilter
+	filter, err := types.ApplyQueryFilter(r, filter)
+	if err != nil {
+		return nil, mw.BadRequest(err)
 	}
 
-	workloads := make([]types.Workload, 0)
+	query, err := models.ParseQuery(r, types.ReservationQueryFields)
+	if err != nil {
+		return nil, mw.BadRequest(err)
+	}
+	filter = filter.WithQuery(query)
 
-	var queue types.QueueFilter
-	queue = queue.WithNodeID(nodeID)
+	pager, err := models.PageFromRequest(r)
+	if err != nil {
+		return nil, mw.BadRequest(err)
+	}
 
-	cur, err := queue.Find(ctx, db, options.Find().SetLimit(limit))
+	pager, err = query.Page(pager)
 	if err != nil {
-		return nil, err
+		return nil, mw.BadRequest(err)
 	}
-	defer cur.Close(ctx)
-	for cur.Next(ctx) {
-		// why we have intermediate struct you say? I will tell you
-		// Content in the workload structure is definition as of type interface{}
-		// bson if found a nil interface, it initialize it with bson.D (list of elements)
-		// so data in Content will be something like [{key: k1, value: v1}, {key: k2, value: v2}]
-		// which is not the same structure expected in the node
-		// hence we use bson.M to force it to load data in a map like {k1: v1, k2: v2}
-		var wl intermediate
 
-		if err := cur.Decode(&wl); err != nil {
-			return workloads, err
-		}
+	loaded, next, total, err := a.reservations.List(r.Context(), filter, pager)
+	if err != nil {
+		return nil, mw.Error(err)
+	}
 
-		obj := generated.ReservationWorkload{
-			WorkloadId: wl.WorkloadID,
-			User:       wl.User,
-			Type:       wl.Type,
-			Created:    wl.Created,
-			Duration:   wl.Duration,
-			Signature:  wl.Signature,
-			ToDelete:   wl.ToDelete,
+	reservations := make([]types.Reservation, 0, len(loaded))
+	for _, reservation := range loaded {
+		reservation, err := a.pipeline(r.Context(), reservation)
+		if err != nil {
+			log.Error().Err(err).Int64("id", int64(reservation.ID)).Msg("failed to process reservation")
+			continue
 		}
 
-		switch wl.Type {
-		case generated.WorkloadTypeContainer:
-			var data generated.Container
-			if err := bson.Unmarshal(wl.Content, &data); err != nil {
-				return nil, err
-			}
-			obj.Content = data
-
-		case generated.WorkloadTypeVolume:
-			var data generated.Volume
-			if err := bson.Unmarshal(wl.Content, &data); err != nil {
-				return nil, err
-			}
-			obj.Content = data
-
-		case generated.WorkloadTypeZDB:
-			var data generated.ZDB
-			if err := bson.Unmarshal(wl.Content, &data); err != nil {
-				return nil, err
-			}
-			obj.Content = data
-
-		case generated.WorkloadTypeNetwork:
-			var data generated.Network
-			if err := bson.Unmarshal(wl.Content, &data); err != nil {
-				return nil, err
-			}
-			obj.Content = data
-
-		case generated.WorkloadTypeKubernetes:
-			var data generated.K8S
-			if err := bson.Unmarshal(wl.Content, &data); err != nil {
-				return nil, err
-			}
-			obj.Content = data
-
-		case generated.WorkloadTypeDomainDelegate:
-			var data generated.GatewayDelegate
-			if err := bson.Unmarshal(wl.Content, &data); err != nil {
-				return nil, err
-			}
-			obj.Content = data
-
-		case generated.WorkloadTypeSubDomain:
-			var data generated.GatewaySubdomain
-			if err := bson.Unmarshal(wl.Content, &data); err != nil {
-				return nil, err
-			}
-			obj.Content = data
-
-		case generated.WorkloadTypeProxy:
-			var data generated.GatewayProxy
-			if err := bson.Unmarshal(wl.Content, &data); err != nil {
-				return nil, err
-			}
-			obj.Content = data
+		reservations = append(reservations, reservation)
+	}
 
-		case generated.WorkloadTypeReverseProxy:
-			var data generated.GatewayReserveProxy
-			if err := bson.Unmarshal(wl.Content, &data); err != nil {
-				return nil, err
-			}
-			obj.Content = data
-		case generated.WorkloadTypeGateway4To6:
-			var data generated.Gateway4To6
-			if err := bson.Unmarshal(wl.Content, &data); err != nil {
-				return nil, err
-			}
-			obj.Content = data
-		}
+	// the fields are only selected once loaded, as the whole reservation
+	// is needed to process it
+	out, err := query.Project(reservations)
+	if err != nil {
+		return nil, mw.Error(err)
+	}
 
-		workloads = append(workloads, types.Workload{
-			NodeID:              wl.NodeID,
-			ReservationWorkload: obj,
-		})
+	response := mw.Paginated(r, next)
+	if pager.Paged() {
+		response = response.WithHeader("Pages", fmt.Sprint(models.Pages(pager, total)))
 	}
 
-	return workloads, nil
+	return out, response
 }
 
 func (a *API) workloads(r *http.Request) (interface{}, mw.Response) {
@@ -434,8 +671,7 @@ func (a *API) workloads(r *http.Request) (interface{}, mw.Response) {
 		nodeID = mux.Vars(r)["node_id"]
 	)
 
-	db := mw.Database(r)
-	workloads, err := a.queued(r.Context(), db, nodeID, maxPageSize)
+	workloads, err := a.queue.List(r.Context(), nodeID, maxPageSize)
 	if err != nil {
 		return nil, mw.Error(err)
 	}
@@ -451,47 +687,57 @@ func (a *API) workloads(r *http.Request) (interface{}, mw.Response) {
 	}
 
 	// store last reservation ID
-	lastID, err := types.ReservationLastID(r.Context(), db)
+	lastID, err := a.reservations.LastID(r.Context())
 	if err != nil {
 		return nil, mw.Error(err)
 	}
 
-	filter := types.ReservationFilter{}.WithIDGE(from)
-	filter = filter.WithNodeID(nodeID)
-
-	cur, err := filter.Find(r.Context(), db)
-	if err != nil {
-		return nil, mw.Error(err)
-	}
+	// the reservations are read in batches of maxPageSize so a node polling
+	// from an old id does not load the whole collection
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(maxPageSize)
 
-	defer cur.Close(r.Context())
+batches:
+	for len(workloads) < maxPageSize {
+		filter := types.ReservationFilter{}.WithIDGE(from)
+		filter = filter.WithNodeID(nodeID)
 
-	for cur.Next(r.Context()) {
-		var reservation types.Reservation
-		if err := cur.Decode(&reservation); err != nil {
+		reservations, err := a.reservations.Find(r.Context(), filter, opts)
+		if err != nil {
 			return nil, mw.Error(err)
 		}
 
-		reservation, err = a.pipeline(reservation, nil)
-		if err != nil {
-			log.Error().Err(err).Int64("id", int64(reservation.ID)).Msg("failed to process reservation")
-			continue
-		}
+		for _, reservation := range reservations {
+			from = reservation.ID + 1
 
-		if reservation.NextAction == types.Delete {
-			if err := a.setReservationDeleted(r.Context(), db, reservation.ID); err != nil {
-				return nil, mw.Error(err)
+			reservation, err = a.pipeline(r.Context(), reservation)
+			if err != nil {
+				log.Error().Err(err).Int64("id", int64(reservation.ID)).Msg("failed to process reservation")
+				continue
 			}
-		}
 
-		// only reservations that is in right status
-		if !reservation.IsAny(types.Deploy, types.Delete) {
-			continue
-		}
+			if reservation.NextAction == types.Delete {
+				err := a.tx.WithTransaction(r.Context(), func(ctx context.Context) error {
+					return a.setReservationDeleted(ctx, &reservation)
+				})
+				if err != nil {
+					return nil, mw.Error(err)
+				}
+				a.escrow.ReservationCanceled(reservation.ID)
+			}
+
+			// only reservations that is in right status
+			if !reservation.IsAny(types.Deploy, types.Delete) {
+				continue
+			}
 
-		workloads = append(workloads, reservation.Workloads(nodeID)...)
+			workloads = append(workloads, reservation.Workloads(nodeID)...)
+
+			if len(workloads) >= maxPageSize {
+				break batches
+			}
+		}
 
-		if len(workloads) >= maxPageSize {
+		if len(reservations) < maxPageSize {
 			break
 		}
 	}
@@ -507,11 +753,7 @@ func (a *API) workloadGet(r *http.Request) (interface{}, mw.Response) {
 		return nil, mw.BadRequest(errors.Wrap(err, "invalid reservation id part"))
 	}
 
-	var filter types.ReservationFilter
-	filter = filter.WithID(rid)
-
-	db := mw.Database(r)
-	reservation, err := a.pipeline(filter.Get(r.Context(), db))
+	reservation, err := a.load(r.Context(), rid)
 	if err != nil {
 		return nil, mw.NotFound(err)
 	}
@@ -562,11 +804,7 @@ func (a *API) workloadPutResult(r *http.Request) (interface{}, mw.Response) {
 		return nil, mw.BadRequest(err)
 	}
 
-	var filter types.ReservationFilter
-	filter = filter.WithID(rid)
-
-	db := mw.Database(r)
-	reservation, err := a.pipeline(filter.Get(r.Context(), db))
+	reservation, err := a.load(r.Context(), rid)
 	if err != nil {
 		return nil, mw.NotFound(err)
 	}
@@ -592,58 +830,75 @@ func (a *API) workloadPutResult(r *http.Request) (interface{}, mw.Response) {
 		return nil, mw.UnAuthorized(errors.Wrap(err, "invalid result signature"))
 	}
 
-	if err := types.ResultPush(r.Context(), db, rid, result); err != nil {
-		return nil, mw.Error(err)
-	}
+	var deployed bool
+	err = a.tx.WithTransaction(r.Context(), func(ctx context.Context) error {
+		deployed = false
+		if err := a.reservations.PushResult(ctx, rid, result); err != nil {
+			return err
+		}
 
-	if err := types.WorkloadPop(r.Context(), db, gwid, nodeID); err != nil {
-		return nil, mw.Error(err)
-	}
+		if err := a.queue.Pop(ctx, gwid, nodeID); err != nil {
+			return err
+		}
 
-	if result.State == generated.ResultStateError {
-		if err := a.setReservationDeleted(r.Context(), db, rid); err != nil {
-			return nil, mw.Error(err)
+		if result.State != generated.ResultStateOK && result.State != generated.ResultStateError {
+			return nil
 		}
-	} else if result.State == generated.ResultStateOK {
-		// check if entire reservation is deployed successfully
+
 		// fetch reservation from db again to have result appended in the model
-		reservation, err = a.pipeline(filter.Get(r.Context(), db))
+		reservation, err := a.load(ctx, rid)
 		if err != nil {
-			return nil, mw.NotFound(err)
+			return err
 		}
 
-		if reservation.IsSuccessfullyDeployed() {
-			a.escrow.ReservationDeployed(rid)
+		if result.State == generated.ResultStateError {
+			return a.setReservationDeleted(ctx, &reservation)
 		}
+
+		// check if entire reservation is deployed successfully
+
+		deployed = reservation.IsSuccessfullyDeployed()
+		return nil
+	})
+
+	if err != nil {
+		return nil, mw.Error(err)
+	}
+
+	// the escrow is only told once the result is committed
+	if result.State == generated.ResultStateError {
+		a.escrow.ReservationCanceled(rid)
+	} else if deployed {
+		a.escrow.ReservationDeployed(rid)
 	}
 
 	return nil, mw.Created()
 }
 
 func (a *API) workloadPutDeleted(r *http.Request) (interface{}, mw.Response) {
-	// WARNING: #TODO
-	// This method does not validate the signature of the caller
-	// because there is no payload in a delete call.
-	// may be a simple body that has "reservation id" and "signature"
-	// can be used, we use the reservation id to avoid using the same
-	// request body to delete other reservations
-
-	// HTTP Delete should not have a body though, so may be this should be
-	// changed to a PUT operation.
-
 	nodeID := mux.Vars(r)["node_id"]
 	gwid := mux.Vars(r)["gwid"]
 
+	// the node must sign the request, unsigned requests are only
+	// accepted until all nodes sign them
+	signer := httpsig.KeyIDFromContext(r.Context())
+	if len(signer) != 0 && signer != nodeID {
+		mw.SecurityLog(r).Str("key_id", signer).Str("node", nodeID).Msg("workload deletion signed by another node")
+		return nil, mw.Forbidden(fmt.Errorf("trying to delete a workload of node %s while you are %s", nodeID, signer))
+	} else if len(signer) == 0 {
+		if config.Config.RequireSignedDeletion {
+			mw.SecurityLog(r).Str("node", nodeID).Msg("unsigned workload deletion refused")
+			return nil, mw.UnAuthorized(fmt.Errorf("workload deletion must be signed by node %s", nodeID))
+		}
+		mw.SecurityLog(r).Str("node", nodeID).Msg("unsigned workload deletion accepted")
+	}
+
 	rid, err := a.parseID(strings.Split(gwid, "-")[0])
 	if err != nil {
 		return nil, mw.BadRequest(errors.Wrap(err, "invalid reservation id part"))
 	}
 
-	var filter types.ReservationFilter
-	filter = filter.WithID(rid)
-
-	db := mw.Database(r)
-	reservation, err := a.pipeline(filter.Get(r.Context(), db))
+	reservation, err := a.load(r.Context(), rid)
 	if err != nil {
 		return nil, mw.NotFound(err)
 	}
@@ -674,60 +929,68 @@ func (a *API) workloadPutDeleted(r *http.Request) (interface{}, mw.Response) {
 
 	result.State = generated.ResultStateDeleted
 
-	if err := types.ResultPush(r.Context(), db, rid, *result); err != nil {
-		return nil, mw.Error(err)
-	}
+	err = a.tx.WithTransaction(r.Context(), func(ctx context.Context) error {
+		if err := a.reservations.PushResult(ctx, rid, *result); err != nil {
+			return err
+		}
 
-	if err := types.WorkloadPop(r.Context(), db, gwid, nodeID); err != nil {
-		return nil, mw.Error(err)
-	}
+		if err := a.queue.Pop(ctx, gwid, nodeID); err != nil {
+			return err
+		}
 
-	// get it from store again (make sure we are up to date)
-	reservation, err = a.pipeline(filter.Get(r.Context(), db))
-	if err != nil {
-		return nil, mw.Error(err)
-	}
+		// get it from store again (make sure we are up to date)
+		reservation, err := a.load(ctx, rid)
+		if err != nil {
+			return err
+		}
 
-	if !reservation.AllDeleted() {
-		return nil, nil
-	}
+		if !reservation.AllDeleted() {
+			return nil
+		}
+
+		if err := a.reservations.SetNextAction(ctx, reservation.ID, generated.NextActionDeleted); err != nil {
+			return err
+		}
+
+		return a.releaseResources(ctx, reservation.ID)
+	})
 
-	if err := types.ReservationSetNextAction(r.Context(), db, reservation.ID, generated.NextActionDeleted); err != nil {
+	if err != nil {
 		return nil, mw.Error(err)
 	}
 
 	return nil, nil
 }
 
-func (a *API) signProvision(r *http.Request) (interface{}, mw.Response) {
-	defer r.Body.Close()
-	var signature generated.SigningSignature
-
-	if err := json.NewDecoder(r.Body).Decode(&signature); err != nil {
-		return nil, mw.BadRequest(err)
+// maxSignRetries is how many times a signature is checked and pushed again
+// when the reservation is updated concurrently, before giving up
+const maxSignRetries = 5
+
+// retryConflicts runs push until it does not fail with ErrReservationConflict,
+// at most maxSignRetries times. push must load and check the reservation again
+// since it changed in between
+func retryConflicts(push func() error) error {
+	var err error
+	for i := 0; i < maxSignRetries; i++ {
+		if err = push(); !errors.Is(err, types.ErrReservationConflict) {
+			return err
+		}
 	}
 
-	sig, err := hex.DecodeString(signature.Signature)
-	if err != nil {
-		return nil, mw.BadRequest(errors.Wrap(err, "invalid signature expecting hex encoded string"))
-	}
+	return err
+}
 
-	id, err := a.parseID(mux.Vars(r)["res_id"])
+// checkSigner makes sure signature is the valid signature of reservation
+// by one of the signers of request
+func (a *API) checkSigner(ctx context.Context, reservation types.Reservation, request generated.SigningRequest, signature generated.SigningSignature) mw.Response {
+	sig, err := hex.DecodeString(signature.Signature)
 	if err != nil {
-		return nil, mw.BadRequest(fmt.Errorf("invalid reservation id"))
+		return mw.BadRequest(errors.Wrap(err, "invalid signature expecting hex encoded string"))
 	}
 
-	var filter types.ReservationFilter
-	filter = filter.WithID(id)
-
-	db := mw.Database(r)
-	reservation, err := a.pipeline(filter.Get(r.Context(), db))
+	orgSigners, err := reservation.OrgSigners(ctx, a.orgs)
 	if err != nil {
-		return nil, mw.NotFound(err)
-	}
-
-	if reservation.NextAction != generated.NextActionSign {
-		return nil, mw.UnAuthorized(fmt.Errorf("reservation not expecting signatures"))
+		return mw.Error(err)
 	}
 
 	in := func(i int64, l []int64) bool {
@@ -739,31 +1002,85 @@ func (a *API) signProvision(r *http.Request) (interface{}, mw.Response) {
 		return false
 	}
 
-	if !in(signature.Tid, reservation.DataReservation.SigningRequestProvision.Signers) {
-		return nil, mw.UnAuthorized(fmt.Errorf("signature not required for '%d'", signature.Tid))
+	if !in(signature.Tid, types.Signers(request, orgSigners)) {
+		return mw.UnAuthorized(fmt.Errorf("signature not required for '%d'", signature.Tid))
 	}
 
-	user, err := phonebook.UserFilter{}.WithID(schema.ID(signature.Tid)).Get(r.Context(), db)
+	user, err := a.users.Get(ctx, schema.ID(signature.Tid))
 	if err != nil {
-		return nil, mw.NotFound(errors.Wrap(err, "customer id not found"))
+		return mw.NotFound(errors.Wrap(err, "customer id not found"))
 	}
 
+	// like the customer signature, new signatures are only verified with
+	// the current key of the signer
 	if err := reservation.SignatureVerify(user.Pubkey, sig); err != nil {
-		return nil, mw.UnAuthorized(errors.Wrap(err, "failed to verify signature"))
+		return mw.UnAuthorized(errors.Wrap(err, "failed to verify signature"))
 	}
 
-	signature.Epoch = schema.Date{Time: time.Now()}
-	if err := types.ReservationPushSignature(r.Context(), db, id, types.SignatureProvision, signature); err != nil {
-		return nil, mw.Error(err)
+	return nil
+}
+
+func (a *API) signProvision(r *http.Request) (interface{}, mw.Response) {
+	defer r.Body.Close()
+	var signature generated.SigningSignature
+
+	if err := json.NewDecoder(r.Body).Decode(&signature); err != nil {
+		return nil, mw.BadRequest(err)
 	}
 
-	reservation, err = a.pipeline(filter.Get(r.Context(), db))
+	id, err := a.parseID(mux.Vars(r)["res_id"])
 	if err != nil {
-		return nil, mw.Error(err)
+		return nil, mw.BadRequest(fmt.Errorf("invalid reservation id"))
 	}
 
-	if reservation.NextAction == generated.NextActionDeploy {
-		types.WorkloadPush(r.Context(), db, reservation.Workloads("")...)
+	// the signature is checked with the epoch it was signed at, it is
+	// stored with the time it was received
+	pushed := signature
+	pushed.Epoch = schema.Date{Time: time.Now()}
+
+	var merr mw.Response
+	err = retryConflicts(func() error {
+		reservation, err := a.load(r.Context(), id)
+		if err != nil {
+			merr = mw.NotFound(err)
+			return merr.Err()
+		}
+
+		if reservation.NextAction != generated.NextActionSign {
+			merr = mw.UnAuthorized(fmt.Errorf("reservation not expecting signatures"))
+			return merr.Err()
+		}
+
+		if merr = a.checkSigner(r.Context(), reservation, reservation.DataReservation.SigningRequestProvision, signature); merr != nil {
+			return merr.Err()
+		}
+
+		return a.tx.WithTransaction(r.Context(), func(ctx context.Context) error {
+			// the signature is only pushed if the reservation did not change since
+			// it was checked, so concurrent signers can not overwrite each other
+			if err := a.reservations.PushSignature(ctx, id, reservation.Version, types.SignatureProvision, pushed); err != nil {
+				return err
+			}
+
+			reservation, err := a.load(ctx, id)
+			if err != nil {
+				return err
+			}
+
+			if reservation.NextAction != generated.NextActionDeploy {
+				return nil
+			}
+
+			return a.queue.Push(ctx, reservation.Workloads("")...)
+		})
+	})
+
+	if merr != nil {
+		return nil, merr
+	} else if errors.Is(err, types.ErrReservationConflict) {
+		return nil, mw.Conflict(err)
+	} else if err != nil {
+		return nil, mw.Error(err)
 	}
 
 	return nil, mw.Created()
@@ -777,74 +1094,85 @@ func (a *API) signDelete(r *http.Request) (interface{}, mw.Response) {
 		return nil, mw.BadRequest(err)
 	}
 
-	sig, err := hex.DecodeString(signature.Signature)
-	if err != nil {
-		return nil, mw.BadRequest(errors.Wrap(err, "invalid signature expecting hex encoded string"))
-	}
-
 	id, err := a.parseID(mux.Vars(r)["res_id"])
 	if err != nil {
 		return nil, mw.BadRequest(fmt.Errorf("invalid reservation id"))
 	}
 
-	var filter types.ReservationFilter
-	filter = filter.WithID(id)
-
-	db := mw.Database(r)
-	reservation, err := a.pipeline(filter.Get(r.Context(), db))
-	if err != nil {
-		return nil, mw.NotFound(err)
-	}
+	// the signature is checked with the epoch it was signed at, it is
+	// stored with the time it was received
+	pushed := signature
+	pushed.Epoch = schema.Date{Time: time.Now()}
 
-	in := func(i int64, l []int64) bool {
-		for _, x := range l {
-			if x == i {
-				return true
-			}
+	var (
+		merr    mw.Response
+		deleted bool
+	)
+	err = retryConflicts(func() error {
+		reservation, err := a.load(r.Context(), id)
+		if err != nil {
+			merr = mw.NotFound(err)
+			return merr.Err()
 		}
-		return false
-	}
 
-	if !in(signature.Tid, reservation.DataReservation.SigningRequestDelete.Signers) {
-		return nil, mw.UnAuthorized(fmt.Errorf("signature not required for '%d'", signature.Tid))
-	}
+		if merr = a.checkSigner(r.Context(), reservation, reservation.DataReservation.SigningRequestDelete, signature); merr != nil {
+			return merr.Err()
+		}
 
-	user, err := phonebook.UserFilter{}.WithID(schema.ID(signature.Tid)).Get(r.Context(), db)
-	if err != nil {
-		return nil, mw.NotFound(errors.Wrap(err, "customer id not found"))
-	}
+		return a.tx.WithTransaction(r.Context(), func(ctx context.Context) error {
+			deleted = false
+			// the signature is only pushed if the reservation did not change since
+			// it was checked, so concurrent signers can not overwrite each other
+			if err := a.reservations.PushSignature(ctx, id, reservation.Version, types.SignatureDelete, pushed); err != nil {
+				return err
+			}
 
-	if err := reservation.SignatureVerify(user.Pubkey, sig); err != nil {
-		return nil, mw.UnAuthorized(errors.Wrap(err, "failed to verify signature"))
-	}
+			reservation, err := a.load(ctx, id)
+			if err != nil {
+				return err
+			}
 
-	signature.Epoch = schema.Date{Time: time.Now()}
-	if err := types.ReservationPushSignature(r.Context(), db, id, types.SignatureDelete, signature); err != nil {
-		return nil, mw.Error(err)
-	}
+			if reservation.NextAction != generated.NextActionDelete {
+				return nil
+			}
 
-	reservation, err = a.pipeline(filter.Get(r.Context(), db))
-	if err != nil {
-		return nil, mw.Error(err)
-	}
+			if err := a.setReservationDeleted(ctx, &reservation); err != nil {
+				return err
+			}
 
-	if reservation.NextAction != generated.NextActionDelete {
-		return nil, mw.Created()
-	}
+			deleted = true
+			return a.queue.Push(ctx, reservation.Workloads("")...)
+		})
+	})
 
-	if err := a.setReservationDeleted(r.Context(), db, reservation.ID); err != nil {
+	if merr != nil {
+		return nil, merr
+	} else if errors.Is(err, types.ErrReservationConflict) {
+		return nil, mw.Conflict(err)
+	} else if err != nil {
 		return nil, mw.Error(err)
 	}
 
-	if err := types.WorkloadPush(r.Context(), db, reservation.Workloads("")...); err != nil {
-		return nil, mw.Error(err)
+	if deleted {
+		// cancel reservation escrow in case the reservation has not yet been deployed
+		a.escrow.ReservationCanceled(id)
 	}
 
 	return nil, mw.Created()
 }
 
-func (a *API) setReservationDeleted(ctx context.Context, db *mongo.Database, id schema.ID) error {
-	// cancel reservation escrow in case the reservation has not yet been deployed
-	a.escrow.ReservationCanceled(id)
-	return types.ReservationSetNextAction(ctx, db, id, generated.NextActionDelete)
+// setReservationDeleted marks the reservation to be deleted. The resources
+// it claimed are given back right away if none of its workloads got
+// deployed, otherwise once the nodes deleted them all. The escrow must be
+// told once the change is committed
+func (a *API) setReservationDeleted(ctx context.Context, reservation *types.Reservation) error {
+	if err := a.reservations.SetNextAction(ctx, reservation.ID, generated.NextActionDelete); err != nil {
+		return err
+	}
+
+	if reservation.AnyDeployed() {
+		return nil
+	}
+
+	return a.releaseResources(ctx, reservation.ID)
 }
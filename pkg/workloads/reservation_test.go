@@ -0,0 +1,509 @@
+package workloads
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/tfexplorer/models"
+	dirgenerated "github.com/threefoldtech/tfexplorer/models/generated/directory"
+	phonebookgen "github.com/threefoldtech/tfexplorer/models/generated/phonebook"
+	generated "github.com/threefoldtech/tfexplorer/models/generated/workloads"
+	"github.com/threefoldtech/tfexplorer/mw"
+	directory "github.com/threefoldtech/tfexplorer/pkg/directory/types"
+	escrowtypes "github.com/threefoldtech/tfexplorer/pkg/escrow/types"
+	phonebook "github.com/threefoldtech/tfexplorer/pkg/phonebook/types"
+	"github.com/threefoldtech/tfexplorer/pkg/workloads/types"
+	"github.com/threefoldtech/tfexplorer/schema"
+)
+
+type testEscrow struct {
+	deployed []schema.ID
+	canceled []schema.ID
+}
+
+func (e *testEscrow) Run(ctx context.Context) error { return nil }
+
+func (e *testEscrow) RegisterReservation(reservation generated.Reservation, supportedCurrencies []string) (escrowtypes.CustomerEscrowInformation, error) {
+	return escrowtypes.CustomerEscrowInformation{}, nil
+}
+
+func (e *testEscrow) Quote(reservation generated.Reservation, supportedCurrencies []string) (escrowtypes.Quote, error) {
+	return escrowtypes.Quote{}, nil
+}
+
+func (e *testEscrow) ReservationDeployed(reservationID schema.ID) {
+	e.deployed = append(e.deployed, reservationID)
+}
+
+func (e *testEscrow) ReservationCanceled(reservationID schema.ID) {
+	e.canceled = append(e.canceled, reservationID)
+}
+
+func newTestAPI(t *testing.T, update ...func(r *types.Reservation)) (*API, *testEscrow, schema.ID) {
+	escrow := &testEscrow{}
+	api := &API{
+		escrow:       escrow,
+		reservations: types.NewMemoryReservationRepository(),
+		queue:        types.NewMemoryWorkloadQueue(),
+		users:        phonebook.NewMemoryUserRepository(),
+		orgs:         phonebook.NewMemoryOrganizationRepository(),
+		farms:        directory.NewMemoryFarmRepository(),
+		gateways:     directory.NewMemoryGatewayRepository(),
+		payments:     escrowtypes.NewMemoryPaymentRepository(),
+		tx:           models.NoTransaction,
+	}
+	api.nodes = directory.NewMemoryNodeRepository(api.farms)
+
+	var reservation types.Reservation
+	reservation.NextAction = types.Deploy
+	reservation.Epoch = schema.Date{Time: time.Now()}
+	reservation.DataReservation.ExpirationProvisioning = schema.Date{Time: time.Now().Add(time.Hour)}
+	reservation.DataReservation.ExpirationReservation = schema.Date{Time: time.Now().Add(24 * time.Hour)}
+	reservation.DataReservation.Volumes = []generated.Volume{
+		{WorkloadId: 1, NodeId: "node"},
+		{WorkloadId: 2, NodeId: "node"},
+	}
+
+	for _, fn := range update {
+		fn(&reservation)
+	}
+
+	id, err := api.reservations.Create(context.Background(), reservation)
+	require.NoError(t, err)
+
+	return api, escrow, id
+}
+
+// newTestUser creates a user with a new key, it returns its id and private key
+func newTestUser(t *testing.T, api *API, name string) (int64, ed25519.PrivateKey) {
+	pk, sk, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	user, err := api.users.Create(context.Background(), phonebook.User{Name: name, Email: name + "@example.com", Pubkey: hex.EncodeToString(pk)})
+	require.NoError(t, err)
+
+	return int64(user.ID), sk
+}
+
+// createRequest returns the request creating a reservation of customer signed
+// with sk, update can change the reservation data before it is signed
+func createRequest(t *testing.T, customer int64, sk ed25519.PrivateKey, update ...func(data *generated.ReservationData)) *http.Request {
+	var data generated.ReservationData
+	data.ExpirationProvisioning = schema.Date{Time: time.Now().Add(time.Hour)}
+	data.ExpirationReservation = schema.Date{Time: time.Now().Add(24 * time.Hour)}
+	data.Currencies = []string{"TFT"}
+	data.Volumes = []generated.Volume{{WorkloadId: 1, NodeId: "node", Size: 1}}
+
+	for _, fn := range update {
+		fn(&data)
+	}
+
+	encoded, err := json.Marshal(data)
+	require.NoError(t, err)
+
+	var reservation types.Reservation
+	// the data is decoded back so it matches its json exactly
+	require.NoError(t, json.Unmarshal(encoded, &reservation.DataReservation))
+	reservation.Json = string(encoded)
+	reservation.CustomerTid = customer
+	reservation.CustomerSignature = hex.EncodeToString(ed25519.Sign(sk, encoded))
+
+	var buf bytes.Buffer
+	require.NoError(t, json.NewEncoder(&buf).Encode(reservation))
+	return httptest.NewRequest(http.MethodPost, "/reservations", &buf)
+}
+
+func workloadRequest(t *testing.T, method, gwid string, body interface{}) *http.Request {
+	var buf bytes.Buffer
+	if body != nil {
+		require.NoError(t, json.NewEncoder(&buf).Encode(body))
+	}
+
+	r := httptest.NewRequest(method, "/reservations/workloads/"+gwid+"/node", &buf)
+	return mux.SetURLVars(r, map[string]string{"gwid": gwid, "node_id": "node"})
+}
+
+func TestReservationGet(t *testing.T) {
+	api, _, id := newTestAPI(t)
+
+	r := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/reservations/1", nil), map[string]string{"res_id": "1"})
+	result, resp := api.get(r)
+	require.Nil(t, resp)
+	assert.Equal(t, id, result.(types.Reservation).ID)
+
+	r = mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/reservations/2", nil), map[string]string{"res_id": "2"})
+	_, resp = api.get(r)
+	require.NotNil(t, resp)
+	assert.Equal(t, http.StatusNotFound, resp.Status())
+
+	_, resp = api.workloadGet(workloadRequest(t, http.MethodGet, "1-1", nil))
+	require.Nil(t, resp)
+
+	_, resp = api.workloadGet(workloadRequest(t, http.MethodGet, "1-3", nil))
+	require.NotNil(t, resp)
+	assert.Equal(t, http.StatusNotFound, resp.Status())
+}
+
+func TestReservationCreate(t *testing.T) {
+	api, _, _ := newTestAPI(t)
+	ctx := context.Background()
+	customer, sk := newTestUser(t, api, "alice")
+
+	result, resp := api.create(createRequest(t, customer, sk))
+	require.NotNil(t, resp)
+	require.Equal(t, http.StatusCreated, resp.Status())
+
+	id := result.(ReservationCreateResponse).ID
+	reservation, err := api.reservations.Get(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, customer, reservation.CustomerTid)
+	assert.Len(t, reservation.DataReservation.Volumes, 1)
+
+	// the signature must match the data of the reservation
+	_, other := newTestUser(t, api, "bob")
+	_, resp = api.create(createRequest(t, customer, other))
+	require.NotNil(t, resp)
+	assert.Equal(t, http.StatusBadRequest, resp.Status())
+
+	// the node is not known, so it can not be certified
+	_, resp = api.create(createRequest(t, customer, sk, func(data *generated.ReservationData) {
+		data.CertifiedOnly = true
+	}))
+	require.NotNil(t, resp)
+	assert.Equal(t, http.StatusBadRequest, resp.Status())
+
+	// organizations must exist and the customer must be a member
+	_, resp = api.create(createRequest(t, customer, sk, func(data *generated.ReservationData) {
+		data.SigningRequestProvision = generated.SigningRequest{OrgId: 10, QuorumMin: 1}
+	}))
+	require.NotNil(t, resp)
+	assert.NotEqual(t, http.StatusCreated, resp.Status())
+}
+
+func TestReservationList(t *testing.T) {
+	api, _, _ := newTestAPI(t)
+	ctx := context.Background()
+
+	for _, customer := range []int64{1, 2} {
+		var reservation types.Reservation
+		reservation.CustomerTid = customer
+		reservation.NextAction = types.Deploy
+		reservation.DataReservation.ExpirationReservation = schema.Date{Time: time.Now().Add(time.Hour)}
+		_, err := api.reservations.Create(ctx, reservation)
+		require.NoError(t, err)
+	}
+
+	list := func(query string) ([]types.Reservation, mw.Response) {
+		result, resp := api.list(httptest.NewRequest(http.MethodGet, "/reservations?"+query, nil))
+		require.NotNil(t, resp)
+		require.Equal(t, http.StatusOK, resp.Status(), resp.Err())
+		return result.([]types.Reservation), resp
+	}
+
+	reservations, _ := list("customer_tid=2")
+	require.Len(t, reservations, 1)
+	assert.Equal(t, int64(2), reservations[0].CustomerTid)
+
+	reservations, resp := list("page=1&size=2")
+	assert.Len(t, reservations, 2)
+	assert.Equal(t, "2", resp.Header().Get("Pages"))
+
+	reservations, resp = list("size=2")
+	assert.Len(t, reservations, 2)
+	next := resp.Header().Get("Next-Cursor")
+	require.NotEmpty(t, next)
+
+	reservations, _ = list("size=2&cursor=" + next)
+	require.Len(t, reservations, 1)
+	assert.Equal(t, int64(2), reservations[0].CustomerTid)
+}
+
+func TestSignProvision(t *testing.T) {
+	api, _, _ := newTestAPI(t)
+	ctx := context.Background()
+	owner, _ := newTestUser(t, api, "alice")
+	signer, sk := newTestUser(t, api, "bob")
+
+	org, err := api.orgs.Create(ctx, phonebook.Organization{
+		Name:    "acme",
+		Members: []phonebookgen.OrganizationMember{{Tid: owner, Role: phonebookgen.OrganizationRoleOwner}},
+	})
+	require.NoError(t, err)
+
+	var reservation types.Reservation
+	reservation.NextAction = types.Sign
+	reservation.DataReservation.ExpirationProvisioning = schema.Date{Time: time.Now().Add(time.Hour)}
+	reservation.DataReservation.ExpirationReservation = schema.Date{Time: time.Now().Add(24 * time.Hour)}
+	reservation.DataReservation.Volumes = []generated.Volume{{WorkloadId: 1, NodeId: "node"}}
+	reservation.DataReservation.SigningRequestProvision = generated.SigningRequest{OrgId: int64(org.ID), QuorumMin: 1}
+	id, err := api.reservations.Create(ctx, reservation)
+	require.NoError(t, err)
+
+	reservation, err = api.reservations.Get(ctx, id)
+	require.NoError(t, err)
+
+	sign := func(tid int64) mw.Response {
+		message := fmt.Sprint(int64(id)) + reservation.Json
+		signature := generated.SigningSignature{
+			Tid:       tid,
+			Signature: hex.EncodeToString(ed25519.Sign(sk, []byte(message))),
+		}
+
+		var buf bytes.Buffer
+		require.NoError(t, json.NewEncoder(&buf).Encode(signature))
+		r := httptest.NewRequest(http.MethodPost, "/reservations/1/sign/provision", &buf)
+		_, resp := api.signProvision(mux.SetURLVars(r, map[string]string{"res_id": fmt.Sprint(int64(id))}))
+		require.NotNil(t, resp)
+		return resp
+	}
+
+	// bob is not a member of the organization yet
+	assert.Equal(t, http.StatusUnauthorized, sign(signer).Status())
+
+	require.NoError(t, api.orgs.SetMembers(ctx, org.ID, org.Members, org.WithMember(phonebookgen.OrganizationMember{
+		Tid:  signer,
+		Role: phonebookgen.OrganizationRoleMember,
+	})))
+
+	require.Equal(t, http.StatusCreated, sign(signer).Status())
+
+	reservation, err = api.load(ctx, id)
+	require.NoError(t, err)
+	assert.Len(t, reservation.SignaturesProvision, 1)
+	// the quorum is reached, the reservation now waits for its payment
+	assert.Equal(t, generated.NextActionPay, reservation.NextAction)
+}
+
+func TestWorkloadResults(t *testing.T) {
+	api, escrow, id := newTestAPI(t)
+	ctx := context.Background()
+
+	ok := types.Result{State: generated.ResultStateOK}
+	for _, gwid := range []string{"1-1", "1-2"} {
+		_, resp := api.workloadPutResult(workloadRequest(t, http.MethodPut, gwid, ok))
+		require.NotNil(t, resp)
+		require.Equal(t, http.StatusCreated, resp.Status())
+	}
+
+	assert.Equal(t, []schema.ID{id}, escrow.deployed, "the reservation is deployed once all the workloads are")
+
+	_, resp := api.workloadPutDeleted(workloadRequest(t, http.MethodDelete, "1-1", nil))
+	require.Nil(t, resp)
+
+	reservation, err := api.reservations.Get(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, types.Deploy, reservation.NextAction)
+
+	_, resp = api.workloadPutDeleted(workloadRequest(t, http.MethodDelete, "1-2", nil))
+	require.Nil(t, resp)
+
+	reservation, err = api.reservations.Get(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, types.Deleted, reservation.NextAction)
+	assert.True(t, reservation.AllDeleted())
+}
+
+func TestWorkloadsPoll(t *testing.T) {
+	api, _, _ := newTestAPI(t)
+	ctx := context.Background()
+
+	create := func(action generated.NextActionEnum, volumes int) {
+		var reservation types.Reservation
+		reservation.NextAction = action
+		reservation.DataReservation.ExpirationReservation = schema.Date{Time: time.Now().Add(time.Hour)}
+		for i := 1; i <= volumes; i++ {
+			reservation.DataReservation.Volumes = append(reservation.DataReservation.Volumes, generated.Volume{WorkloadId: int64(i), NodeId: "node"})
+		}
+		_, err := api.reservations.Create(ctx, reservation)
+		require.NoError(t, err)
+	}
+
+	poll := func() []types.Workload {
+		r := httptest.NewRequest(http.MethodGet, "/reservations/workloads/node?from=0", nil)
+		result, resp := api.workloads(mux.SetURLVars(r, map[string]string{"node_id": "node"}))
+		require.NotNil(t, resp)
+		require.Equal(t, http.StatusOK, resp.Status(), resp.Err())
+		return result.([]types.Workload)
+	}
+
+	// the deployed reservation after more than a batch of deleted ones
+	// is still found
+	for i := 0; i < 250; i++ {
+		create(types.Deleted, 1)
+	}
+	create(types.Deploy, 1)
+	assert.Len(t, poll(), 3)
+
+	// the poll stops once the page is full
+	for i := 0; i < 120; i++ {
+		create(types.Deploy, 2)
+	}
+	assert.Len(t, poll(), 201)
+}
+
+func TestSignDelete(t *testing.T) {
+	api, escrow, id := newTestAPI(t, func(r *types.Reservation) {
+		r.DataReservation.SigningRequestDelete = generated.SigningRequest{Signers: []int64{1, 2}, QuorumMin: 2}
+	})
+	ctx := context.Background()
+
+	reservation, err := api.reservations.Get(ctx, id)
+	require.NoError(t, err)
+
+	keys := make(map[int64]ed25519.PrivateKey)
+	for _, name := range []string{"alice", "bob"} {
+		pk, sk, err := ed25519.GenerateKey(nil)
+		require.NoError(t, err)
+
+		user, err := api.users.Create(ctx, phonebook.User{Name: name, Email: name + "@example.com", Pubkey: hex.EncodeToString(pk)})
+		require.NoError(t, err)
+		keys[int64(user.ID)] = sk
+	}
+
+	sign := func(tid int64) *http.Request {
+		message := fmt.Sprint(int64(id)) + reservation.Json
+		signature := generated.SigningSignature{
+			Tid:       tid,
+			Signature: hex.EncodeToString(ed25519.Sign(keys[tid], []byte(message))),
+		}
+
+		var buf bytes.Buffer
+		require.NoError(t, json.NewEncoder(&buf).Encode(signature))
+		r := httptest.NewRequest(http.MethodPost, "/reservations/1/sign/delete", &buf)
+		return mux.SetURLVars(r, map[string]string{"res_id": fmt.Sprint(int64(id))})
+	}
+
+	_, resp := api.signDelete(sign(1))
+	require.NotNil(t, resp)
+	require.Equal(t, http.StatusCreated, resp.Status())
+	assert.Empty(t, escrow.canceled)
+
+	// a signer that read the reservation before the first signature
+	err = api.reservations.PushSignature(ctx, id, reservation.Version, types.SignatureDelete, generated.SigningSignature{Tid: 2})
+	assert.True(t, errors.Is(err, types.ErrReservationConflict))
+
+	_, resp = api.signDelete(sign(2))
+	require.NotNil(t, resp)
+	require.Equal(t, http.StatusCreated, resp.Status())
+
+	reservation, err = api.reservations.Get(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, types.Delete, reservation.NextAction)
+	assert.Len(t, reservation.SignaturesDelete, 2)
+	assert.Equal(t, []schema.ID{id}, escrow.canceled)
+}
+
+// racingRepository updates the reservation right before the signatures are
+// pushed, like a concurrent signer would
+type racingRepository struct {
+	types.ReservationRepository
+	races  int
+	pushes int
+}
+
+func (r *racingRepository) PushSignature(ctx context.Context, id schema.ID, version int64, mode types.SignatureMode, signature generated.SigningSignature) error {
+	r.pushes++
+	if r.races > 0 {
+		r.races--
+		racer := generated.SigningSignature{Tid: 100 + int64(r.pushes)}
+		if err := r.ReservationRepository.PushSignature(ctx, id, version, mode, racer); err != nil {
+			return err
+		}
+	}
+
+	return r.ReservationRepository.PushSignature(ctx, id, version, mode, signature)
+}
+
+func TestSignDeleteConflicts(t *testing.T) {
+	api, escrow, id := newTestAPI(t)
+	ctx := context.Background()
+	signer, sk := newTestUser(t, api, "alice")
+
+	reservation, err := api.reservations.Get(ctx, id)
+	require.NoError(t, err)
+	reservation.DataReservation.SigningRequestDelete = generated.SigningRequest{Signers: []int64{signer}, QuorumMin: 1}
+	id, err = api.reservations.Create(ctx, reservation)
+	require.NoError(t, err)
+
+	racing := &racingRepository{ReservationRepository: api.reservations}
+	api.reservations = racing
+
+	sign := func() mw.Response {
+		message := fmt.Sprint(int64(id)) + reservation.Json
+		signature := generated.SigningSignature{
+			Tid:       signer,
+			Signature: hex.EncodeToString(ed25519.Sign(sk, []byte(message))),
+		}
+
+		var buf bytes.Buffer
+		require.NoError(t, json.NewEncoder(&buf).Encode(signature))
+		r := httptest.NewRequest(http.MethodPost, "/reservations/1/sign/delete", &buf)
+		_, resp := api.signDelete(mux.SetURLVars(r, map[string]string{"res_id": fmt.Sprint(int64(id))}))
+		require.NotNil(t, resp)
+		return resp
+	}
+
+	// the conflict is only returned once the retries are exhausted
+	racing.races = maxSignRetries
+	assert.Equal(t, http.StatusConflict, sign().Status())
+	assert.Equal(t, maxSignRetries, racing.pushes)
+
+	// the signers that lost a race are retried on the reloaded reservation
+	racing.races = 2
+	require.Equal(t, http.StatusCreated, sign().Status())
+	assert.Equal(t, maxSignRetries+3, racing.pushes)
+
+	reservation, err = api.reservations.Get(ctx, id)
+	require.NoError(t, err)
+	assert.Len(t, reservation.SignaturesDelete, maxSignRetries+3, "no signature is lost")
+	assert.Equal(t, types.Delete, reservation.NextAction)
+	assert.Equal(t, []schema.ID{id}, escrow.canceled)
+}
+
+func TestFailedReservationReleasesResources(t *testing.T) {
+	api, escrow, id := newTestAPI(t)
+	ctx := context.Background()
+
+	farmID, err := api.farms.Create(ctx, directory.Farm{
+		Name:            "farm",
+		ThreebotId:      1,
+		WalletAddresses: []dirgenerated.WalletAddress{{Asset: "TFT", Address: "address"}},
+	})
+	require.NoError(t, err)
+
+	ip := dirgenerated.PublicIP{
+		Address: schema.MustParseIPRange("185.69.166.10/24"),
+		Gateway: net.ParseIP("185.69.166.1"),
+	}
+	require.NoError(t, api.farms.AddIP(ctx, farmID, ip))
+	require.NoError(t, api.farms.ReserveIP(ctx, farmID, ip.Address.IP, id))
+
+	failed := types.Result{State: generated.ResultStateError}
+	_, resp := api.workloadPutResult(workloadRequest(t, http.MethodPut, "1-1", failed))
+	require.NotNil(t, resp)
+	require.Equal(t, http.StatusCreated, resp.Status())
+
+	reservation, err := api.reservations.Get(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, types.Delete, reservation.NextAction)
+	assert.Equal(t, []schema.ID{id}, escrow.canceled)
+
+	// nothing got deployed, so the address is given back right away
+	farm, err := api.farms.Get(ctx, farmID)
+	require.NoError(t, err)
+	require.Len(t, farm.IPAddresses, 1)
+	assert.Zero(t, farm.IPAddresses[0].ReservationId)
+}
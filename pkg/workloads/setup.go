@@ -5,32 +5,96 @@ import (
 	"net/http"
 
 	"github.com/gorilla/mux"
+	"github.com/threefoldtech/tfexplorer/config"
+	"github.com/threefoldtech/tfexplorer/models"
 	"github.com/threefoldtech/tfexplorer/mw"
+	directory "github.com/threefoldtech/tfexplorer/pkg/directory/types"
 	"github.com/threefoldtech/tfexplorer/pkg/escrow"
+	escrowtypes "github.com/threefoldtech/tfexplorer/pkg/escrow/types"
+	phonebook "github.com/threefoldtech/tfexplorer/pkg/phonebook/types"
 	"github.com/threefoldtech/tfexplorer/pkg/workloads/types"
+	"github.com/zaibon/httpsig"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
-// Setup injects and initializes directory package
-func Setup(parent *mux.Router, db *mongo.Database, escrow escrow.Escrow) error {
+// Setup injects and initializes workloads package, the handlers are built
+// once and mounted on all the parent routers
+func Setup(parents []*mux.Router, db *mongo.Database, escrow escrow.Escrow) error {
 	if err := types.Setup(context.TODO(), db); err != nil {
 		return err
 	}
 
-	var api API
-	api.escrow = escrow
-	reservations := parent.PathPrefix("/reservations").Subrouter()
-
-	reservations.HandleFunc("", mw.AsHandlerFunc(api.create)).Methods(http.MethodPost).Name("reservation-create")
-	reservations.HandleFunc("", mw.AsHandlerFunc(api.list)).Methods(http.MethodGet).Name("reservation-list")
-	reservations.HandleFunc("/{res_id:\\d+}", mw.AsHandlerFunc(api.get)).Methods(http.MethodGet).Name("reservation-get")
-	reservations.HandleFunc("/{res_id:\\d+}/sign/provision", mw.AsHandlerFunc(api.signProvision)).Methods(http.MethodPost).Name("reservation-sign-provision")
-	reservations.HandleFunc("/{res_id:\\d+}/sign/delete", mw.AsHandlerFunc(api.signDelete)).Methods(http.MethodPost).Name("reservation-sign-delete")
+	tx, err := models.NewTransactor(context.TODO(), db)
+	if err != nil {
+		return err
+	}
 
-	reservations.HandleFunc("/workloads/{node_id}", mw.AsHandlerFunc(api.workloads)).Queries("from", "{from:\\d+}").Methods(http.MethodGet).Name("workloads-poll")
-	reservations.HandleFunc("/workloads/{gwid:\\d+-\\d+}", mw.AsHandlerFunc(api.workloadGet)).Methods(http.MethodGet).Name("workload-get")
-	reservations.HandleFunc("/workloads/{gwid:\\d+-\\d+}/{node_id}", mw.AsHandlerFunc(api.workloadPutResult)).Methods(http.MethodPut).Name("workloads-results")
-	reservations.HandleFunc("/workloads/{gwid:\\d+-\\d+}/{node_id}", mw.AsHandlerFunc(api.workloadPutDeleted)).Methods(http.MethodDelete).Name("workloads-deleted")
+	api := API{
+		escrow:       escrow,
+		reservations: types.NewReservationRepository(db),
+		queue:        types.NewWorkloadQueue(db),
+		users:        phonebook.NewUserRepository(db),
+		orgs:         phonebook.NewOrganizationRepository(db),
+		nodes:        directory.NewNodeRepository(db),
+		farms:        directory.NewFarmRepository(db),
+		gateways:     directory.NewGatewayRepository(db),
+		payments:     escrowtypes.NewPaymentRepository(db),
+		tx:           tx,
+	}
 
+	mount(parents, &api, phonebook.NewTokenRepository(db))
 	return nil
 }
+
+// mount registers the routes of api on all the parent routers
+func mount(parents []*mux.Router, api *API, tokens phonebook.TokenRepository) {
+	// escrow details are private, they can be read with the key of the
+	// customer or a token
+	userAuthMW := mw.NewAuthMiddleware(httpsig.NewVerifier(mw.NewUserKeyGetter(api.users)))
+	customersMW := userAuthMW.Tokens(tokens, api.users, phonebook.ScopeEscrowRead)
+	customersPolicy := mw.NewPolicy(config.Config.Admins, nil).WithUsers(api.user)
+	// deletions are checked by the handler since unsigned requests are
+	// still accepted unless signed deletions are required
+	nodeAuthMW := mw.NewAuthMiddleware(httpsig.NewVerifier(mw.NewNodeKeyGetter()))
+	// the results are only accepted from the node they are reported for
+	nodePolicy := mw.NewPolicy(config.Config.Admins, nil)
+
+	for _, parent := range parents {
+		reservations := parent.PathPrefix("/reservations").Subrouter()
+
+		reservations.HandleFunc("", mw.AsHandlerFunc(api.list)).Methods(http.MethodGet).Name("reservation-list")
+		reservations.HandleFunc("/{res_id:\\d+}", mw.AsHandlerFunc(api.get)).Methods(http.MethodGet).Name("reservation-get")
+		reservations.HandleFunc("/workloads/{node_id}", mw.AsHandlerFunc(api.workloads)).Queries("from", "{from:\\d+}").Methods(http.MethodGet).Name("workloads-poll")
+		reservations.HandleFunc("/workloads/{gwid:\\d+-\\d+}", mw.AsHandlerFunc(api.workloadGet)).Methods(http.MethodGet).Name("workload-get")
+
+		// the reservations and their signatures are payloads signed by the
+		// customer or the signer, which the handlers verify
+		customersSigned := parent.PathPrefix("/reservations").Subrouter()
+		customersSigned.Use(customersPolicy.RequireSignedBy("customer_tid", mw.RoleUser))
+		signersSigned := parent.PathPrefix("/reservations").Subrouter()
+		signersSigned.Use(customersPolicy.RequireSignedBy("tid", mw.RoleUser))
+
+		customersSigned.HandleFunc("", mw.AsHandlerFunc(api.create)).Methods(http.MethodPost).Name("reservation-create")
+		signersSigned.HandleFunc("/{res_id:\\d+}/sign/provision", mw.AsHandlerFunc(api.signProvision)).Methods(http.MethodPost).Name("reservation-sign-provision")
+		signersSigned.HandleFunc("/{res_id:\\d+}/sign/delete", mw.AsHandlerFunc(api.signDelete)).Methods(http.MethodPost).Name("reservation-sign-delete")
+
+		customers := parent.PathPrefix("/reservations").Subrouter()
+		customers.Use(customersMW, customersPolicy.Require(mw.RoleUser))
+		customers.HandleFunc("/{res_id:\\d+}/escrow", mw.AsHandlerFunc(api.escrowDetails)).Methods(http.MethodGet).Name("reservation-escrow")
+
+		nodes := parent.PathPrefix("/reservations").Subrouter()
+		nodes.Use(nodeAuthMW.Middleware, nodePolicy.Require(mw.RoleNode))
+		nodes.HandleFunc("/workloads/{gwid:\\d+-\\d+}/{node_id}", mw.AsHandlerFunc(api.workloadPutResult)).Methods(http.MethodPut).Name("workloads-results")
+
+		nodeSigned := parent.PathPrefix("/reservations").Subrouter()
+		nodeSigned.Use(nodeAuthMW.Optional)
+		nodeSigned.HandleFunc("/workloads/{gwid:\\d+-\\d+}/{node_id}", mw.AsHandlerFunc(api.workloadPutDeleted)).Methods(http.MethodDelete).Name("workloads-deleted")
+
+		// budgets are stored in the phonebook but their usage is
+		// computed from the reservations
+		users := parent.PathPrefix("/users").Subrouter()
+		users.HandleFunc("/{user_id:\\d+}/usage", mw.AsHandlerFunc(api.userUsage)).Methods(http.MethodGet).Name("user-usage")
+		orgs := parent.PathPrefix("/organizations").Subrouter()
+		orgs.HandleFunc("/{org_id:\\d+}/usage", mw.AsHandlerFunc(api.organizationUsage)).Methods(http.MethodGet).Name("organization-usage")
+	}
+}
@@ -0,0 +1,24 @@
+package workloads
+
+import (
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/tfexplorer/mw"
+	phonebook "github.com/threefoldtech/tfexplorer/pkg/phonebook/types"
+)
+
+func TestRoutesDeclarePrincipals(t *testing.T) {
+	api, _, _ := newTestAPI(t)
+
+	router := mux.NewRouter()
+	mount([]*mux.Router{router}, api, phonebook.NewMemoryTokenRepository())
+
+	// unsigned deletions are still accepted by the handler unless signed
+	// deletions are required
+	unguarded, err := mw.UnguardedRoutes(router, "workloads-deleted")
+	require.NoError(t, err)
+	assert.Empty(t, unguarded)
+}
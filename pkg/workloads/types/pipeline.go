@@ -11,13 +11,39 @@ import (
 // returns new reservation object, and true if the reservation has changed
 type Pipeline struct {
 	r Reservation
+	// orgSigners are the threebot ids allowed to sign for the
+	// organizations referenced by the signing requests
+	orgSigners map[int64][]int64
 }
 
 // NewPipeline creates a reservation pipeline, all reservation must be processes
 // through the pipeline before any action is taken. This will always make sure
 // that reservation is in the right state.
 func NewPipeline(R Reservation) (*Pipeline, error) {
-	return &Pipeline{R}, nil
+	return &Pipeline{r: R}, nil
+}
+
+// WithOrgSigners sets the current signers of the organizations referenced by
+// the signing requests of the reservation, as returned by OrgSigners
+func (p *Pipeline) WithOrgSigners(signers map[int64][]int64) *Pipeline {
+	p.orgSigners = signers
+	return p
+}
+
+func (p *Pipeline) signers(request generated.SigningRequest) []int64 {
+	return Signers(request, p.orgSigners)
+}
+
+// Signers returns all the threebot ids whose signature count for request, this is
+// the signers of the request and the current signers of its organization
+func Signers(request generated.SigningRequest, orgSigners map[int64][]int64) []int64 {
+	if request.OrgId == 0 {
+		return request.Signers
+	}
+
+	signers := make([]int64, 0, len(request.Signers)+len(orgSigners[request.OrgId]))
+	signers = append(signers, request.Signers...)
+	return append(signers, orgSigners[request.OrgId]...)
 }
 
 func (p *Pipeline) checkProvisionSignatures() bool {
@@ -41,10 +67,11 @@ func (p *Pipeline) checkProvisionSignatures() bool {
 		return false
 	}
 
+	signers := p.signers(request)
 	signatures := p.r.SignaturesProvision
 	var count int64
 	for _, signature := range signatures {
-		if !in(signature.Tid, request.Signers) {
+		if !in(signature.Tid, signers) {
 			continue
 		}
 		count++
@@ -74,10 +101,11 @@ func (p *Pipeline) checkDeleteSignatures() bool {
 		return false
 	}
 
+	signers := p.signers(request)
 	signatures := p.r.SignaturesDelete
 	var count int64
 	for _, signature := range signatures {
-		if !in(signature.Tid, request.Signers) {
+		if !in(signature.Tid, signers) {
 			continue
 		}
 		count++
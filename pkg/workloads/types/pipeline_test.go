@@ -0,0 +1,57 @@
+package types
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	generated "github.com/threefoldtech/tfexplorer/models/generated/workloads"
+	"github.com/threefoldtech/tfexplorer/schema"
+)
+
+func TestPipelineOrgSigners(t *testing.T) {
+	reservation := Reservation{
+		NextAction: generated.NextActionSign,
+		DataReservation: generated.ReservationData{
+			SigningRequestProvision: generated.SigningRequest{
+				Signers:   []int64{1},
+				OrgId:     10,
+				QuorumMin: 2,
+			},
+			ExpirationProvisioning: schema.Date{Time: time.Now().Add(time.Hour)},
+			ExpirationReservation:  schema.Date{Time: time.Now().Add(time.Hour)},
+		},
+		SignaturesProvision: []generated.SigningSignature{
+			{Tid: 1},
+			{Tid: 2},
+		},
+	}
+
+	// without the organization members, only the signature of 1 counts
+	pl, err := NewPipeline(reservation)
+	require.NoError(t, err)
+	result, _ := pl.Next()
+	assert.Equal(t, generated.NextActionSign, result.NextAction)
+
+	// 2 is a current member of the organization
+	pl, err = NewPipeline(reservation)
+	require.NoError(t, err)
+	result, _ = pl.WithOrgSigners(map[int64][]int64{10: {2, 3}}).Next()
+	assert.Equal(t, generated.NextActionPay, result.NextAction)
+
+	// 2 left the organization
+	pl, err = NewPipeline(reservation)
+	require.NoError(t, err)
+	result, _ = pl.WithOrgSigners(map[int64][]int64{10: {3}}).Next()
+	assert.Equal(t, generated.NextActionSign, result.NextAction)
+}
+
+func TestSigners(t *testing.T) {
+	request := generated.SigningRequest{Signers: []int64{1, 2}}
+	assert.Equal(t, []int64{1, 2}, Signers(request, map[int64][]int64{10: {3}}))
+
+	request.OrgId = 10
+	assert.Equal(t, []int64{1, 2, 3}, Signers(request, map[int64][]int64{10: {3}}))
+	assert.Equal(t, []int64{1, 2}, Signers(request, nil))
+}
@@ -0,0 +1,179 @@
+package types
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/threefoldtech/tfexplorer/models"
+	generated "github.com/threefoldtech/tfexplorer/models/generated/workloads"
+	"github.com/threefoldtech/tfexplorer/schema"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ReservationRepository stores the reservations
+type ReservationRepository interface {
+	// Get returns the reservation with id, mongo.ErrNoDocuments if it does not exist
+	Get(ctx context.Context, id schema.ID) (Reservation, error)
+	// List returns the page of the reservations matching filter. The reservations
+	// that can not be decoded, probably old ones, are skipped. next is the cursor
+	// of the following page, it points after the last reservation read even if
+	// it was skipped. The count of the matching reservations is only returned
+	// if the page is selected by its number
+	List(ctx context.Context, filter ReservationFilter, pager models.Pager, opts ...*options.FindOptions) (reservations []Reservation, next string, total int64, err error)
+	// Find returns the reservations matching filter, the options can be used
+	// to sort and limit them so the whole collection is never loaded at once
+	Find(ctx context.Context, filter ReservationFilter, opts ...*options.FindOptions) ([]Reservation, error)
+	// Create stores a new reservation, it returns its id
+	Create(ctx context.Context, r Reservation) (schema.ID, error)
+	// LastID returns the id of the last created reservation
+	LastID(ctx context.Context) (schema.ID, error)
+	// SetNextAction sets the next action of the reservation
+	SetNextAction(ctx context.Context, id schema.ID, action generated.NextActionEnum) error
+	// PushSignature adds the signature to the reservation, it replaces the
+	// signature of the same user if any. ErrReservationConflict is returned
+	// if the reservation is not at version anymore
+	PushSignature(ctx context.Context, id schema.ID, version int64, mode SignatureMode, signature generated.SigningSignature) error
+	// PushResult adds the result to the reservation, it replaces the result
+	// of the same workload if any
+	PushResult(ctx context.Context, id schema.ID, result Result) error
+	// FlagRetiredNode flags all the active reservations using nodeID, it
+	// returns the number of reservations flagged
+	FlagRetiredNode(ctx context.Context, nodeID string) (int64, error)
+}
+
+// WorkloadQueue holds the workloads waiting to be picked by the nodes
+type WorkloadQueue interface {
+	// Push queues the workloads
+	Push(ctx context.Context, workloads ...Workload) error
+	// List returns at most limit workloads from the queue of nodeID
+	List(ctx context.Context, nodeID string, limit int64) ([]Workload, error)
+	// Pop removes the workload with id from the queue of nodeID
+	Pop(ctx context.Context, id string, nodeID string) error
+}
+
+// NewReservationRepository returns a ReservationRepository backed by db
+func NewReservationRepository(db *mongo.Database) ReservationRepository {
+	return &reservationRepository{db: db}
+}
+
+type reservationRepository struct {
+	db *mongo.Database
+}
+
+func (r *reservationRepository) Get(ctx context.Context, id schema.ID) (Reservation, error) {
+	return ReservationFilter{}.WithID(id).Get(ctx, r.db)
+}
+
+func (r *reservationRepository) List(ctx context.Context, filter ReservationFilter, pager models.Pager, opts ...*options.FindOptions) ([]Reservation, string, int64, error) {
+	opts = append([]*options.FindOptions{pager.Options()}, opts...)
+	cur, err := ReservationFilter(pager.Filter(bson.D(filter))).Find(ctx, r.db, opts...)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	defer cur.Close(ctx)
+
+	var docs []bson.Raw
+	for cur.Next(ctx) {
+		docs = append(docs, append(bson.Raw(nil), cur.Current...))
+	}
+
+	if err := cur.Err(); err != nil {
+		return nil, "", 0, err
+	}
+
+	reservations, next, err := decodePage(pager, docs)
+	if err != nil || !pager.Paged() {
+		return reservations, next, 0, err
+	}
+
+	total, err := filter.Count(ctx, r.db)
+	return reservations, next, total, err
+}
+
+func (r *reservationRepository) Find(ctx context.Context, filter ReservationFilter, opts ...*options.FindOptions) ([]Reservation, error) {
+	cur, err := filter.Find(ctx, r.db, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	reservations := []Reservation{}
+	err = cur.All(ctx, &reservations)
+	return reservations, err
+}
+
+func (r *reservationRepository) Create(ctx context.Context, reservation Reservation) (schema.ID, error) {
+	return ReservationCreate(ctx, r.db, reservation)
+}
+
+func (r *reservationRepository) LastID(ctx context.Context) (schema.ID, error) {
+	return ReservationLastID(ctx, r.db)
+}
+
+func (r *reservationRepository) SetNextAction(ctx context.Context, id schema.ID, action generated.NextActionEnum) error {
+	return ReservationSetNextAction(ctx, r.db, id, action)
+}
+
+func (r *reservationRepository) PushSignature(ctx context.Context, id schema.ID, version int64, mode SignatureMode, signature generated.SigningSignature) error {
+	return ReservationPushSignature(ctx, r.db, id, version, mode, signature)
+}
+
+func (r *reservationRepository) PushResult(ctx context.Context, id schema.ID, result Result) error {
+	return ResultPush(ctx, r.db, id, result)
+}
+
+func (r *reservationRepository) FlagRetiredNode(ctx context.Context, nodeID string) (int64, error) {
+	return ReservationFlagRetiredNode(ctx, r.db, nodeID)
+}
+
+// NewWorkloadQueue returns a WorkloadQueue backed by db
+func NewWorkloadQueue(db *mongo.Database) WorkloadQueue {
+	return &workloadQueue{db: db}
+}
+
+type workloadQueue struct {
+	db *mongo.Database
+}
+
+func (q *workloadQueue) Push(ctx context.Context, workloads ...Workload) error {
+	return WorkloadPush(ctx, q.db, workloads...)
+}
+
+func (q *workloadQueue) List(ctx context.Context, nodeID string, limit int64) ([]Workload, error) {
+	return WorkloadQueued(ctx, q.db, nodeID, limit)
+}
+
+func (q *workloadQueue) Pop(ctx context.Context, id string, nodeID string) error {
+	return WorkloadPop(ctx, q.db, id, nodeID)
+}
+
+// decodePage decodes the documents of a page of reservations, skipping
+// the ones that can not be decoded as long as they have a valid id
+func decodePage(pager models.Pager, docs []bson.Raw) ([]Reservation, string, error) {
+	reservations := []Reservation{}
+	for _, doc := range docs {
+		var reservation Reservation
+		if err := bson.Unmarshal(doc, &reservation); err != nil {
+			id, ok := doc.Lookup("_id").Int64OK()
+			if !ok {
+				return nil, "", errors.Wrap(err, "failed to decode reservation without a valid id")
+			}
+
+			log.Error().Err(err).Int64("id", id).Msg("failed to decode reservation")
+			continue
+		}
+
+		reservations = append(reservations, reservation)
+	}
+
+	var last bson.Raw
+	if len(docs) > 0 {
+		last = docs[len(docs)-1]
+	}
+
+	next, err := pager.After(len(docs), last)
+	return reservations, next, err
+}
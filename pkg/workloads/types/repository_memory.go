@@ -0,0 +1,255 @@
+package types
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/threefoldtech/tfexplorer/models"
+	generated "github.com/threefoldtech/tfexplorer/models/generated/workloads"
+	"github.com/threefoldtech/tfexplorer/schema"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// NewMemoryReservationRepository returns a ReservationRepository that keeps
+// the reservations in memory
+func NewMemoryReservationRepository() ReservationRepository {
+	return &memoryReservationRepository{reservations: models.NewMemoryCollection()}
+}
+
+type memoryReservationRepository struct {
+	mu           sync.Mutex
+	reservations *models.MemoryCollection
+}
+
+func (m *memoryReservationRepository) get(id schema.ID) (reservation Reservation, err error) {
+	err = m.reservations.Get(id, &reservation)
+	return
+}
+
+// update applies fn to the reservation with id and stores it at its next
+// version. Like an update that matches no reservation, unknown ids are ignored
+func (m *memoryReservationRepository) update(id schema.ID, fn func(r *Reservation) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	reservation, err := m.get(id)
+	if err != nil {
+		return nil
+	}
+
+	if err := fn(&reservation); err != nil {
+		return err
+	}
+
+	reservation.Version++
+	return m.reservations.Put(id, reservation)
+}
+
+func (m *memoryReservationRepository) Get(ctx context.Context, id schema.ID) (Reservation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.get(id)
+}
+
+func (m *memoryReservationRepository) List(ctx context.Context, filter ReservationFilter, pager models.Pager, opts ...*options.FindOptions) ([]Reservation, string, int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	opts = append([]*options.FindOptions{pager.Options()}, opts...)
+	docs, err := m.reservations.Find(pager.Filter(bson.D(filter)), opts...)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	reservations, next, err := decodePage(pager, docs)
+	if err != nil || !pager.Paged() {
+		return reservations, next, 0, err
+	}
+
+	total, err := m.reservations.Count(bson.D(filter))
+	return reservations, next, total, err
+}
+
+func (m *memoryReservationRepository) Find(ctx context.Context, filter ReservationFilter, opts ...*options.FindOptions) ([]Reservation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	reservations := []Reservation{}
+	_, err := m.reservations.List(bson.D(filter), models.Pager{}, &reservations, opts...)
+	return reservations, err
+}
+
+func (m *memoryReservationRepository) Create(ctx context.Context, reservation Reservation) (schema.ID, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	reservation.ID = m.reservations.NextID()
+	reservation.Version = 0
+	if err := m.reservations.Put(reservation.ID, reservation); err != nil {
+		return 0, err
+	}
+
+	return reservation.ID, nil
+}
+
+func (m *memoryReservationRepository) LastID(ctx context.Context) (schema.ID, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.reservations.LastID(), nil
+}
+
+func (m *memoryReservationRepository) SetNextAction(ctx context.Context, id schema.ID, action generated.NextActionEnum) error {
+	return m.update(id, func(r *Reservation) error {
+		r.NextAction = action
+		return nil
+	})
+}
+
+func (m *memoryReservationRepository) PushSignature(ctx context.Context, id schema.ID, version int64, mode SignatureMode, signature generated.SigningSignature) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	reservation, err := m.get(id)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrReservationConflict
+	} else if err != nil {
+		return err
+	}
+
+	if reservation.Version != version {
+		return ErrReservationConflict
+	}
+
+	signatures, err := reservation.signatures(mode)
+	if err != nil {
+		return err
+	}
+
+	*signatures = pushSignature(*signatures, signature)
+	reservation.Version++
+	return m.reservations.Put(id, reservation)
+}
+
+func (m *memoryReservationRepository) PushResult(ctx context.Context, id schema.ID, result Result) error {
+	return m.update(id, func(r *Reservation) error {
+		results := make([]generated.Result, 0, len(r.Results)+1)
+		for _, res := range r.Results {
+			if res.WorkloadId == result.WorkloadId && res.NodeId == result.NodeId {
+				continue
+			}
+			results = append(results, res)
+		}
+
+		r.Results = append(results, generated.Result(result))
+		return nil
+	})
+}
+
+func (m *memoryReservationRepository) FlagRetiredNode(ctx context.Context, nodeID string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var flagged int64
+	for _, key := range m.reservations.Keys() {
+		reservation, err := m.get(key.(schema.ID))
+		if err != nil {
+			return flagged, err
+		}
+
+		switch reservation.NextAction {
+		case Delete, Deleted, Invalid:
+			continue
+		}
+
+		if !contains(reservation.NodeIDs(), nodeID) && !contains(reservation.GatewayIDs(), nodeID) {
+			continue
+		}
+
+		// like $addToSet, a reservation that is already flagged is not modified
+		if contains(reservation.RetiredNodes, nodeID) {
+			continue
+		}
+
+		reservation.RetiredNodes = append(reservation.RetiredNodes, nodeID)
+		reservation.Version++
+		if err := m.reservations.Put(reservation.ID, reservation); err != nil {
+			return flagged, err
+		}
+		flagged++
+	}
+
+	return flagged, nil
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NewMemoryWorkloadQueue returns a WorkloadQueue that keeps the workloads in memory
+func NewMemoryWorkloadQueue() WorkloadQueue {
+	return &memoryWorkloadQueue{workloads: models.NewMemoryCollection()}
+}
+
+type memoryWorkloadQueue struct {
+	mu        sync.Mutex
+	workloads *models.MemoryCollection
+}
+
+type queueKey struct {
+	workloadID string
+	nodeID     string
+}
+
+func (q *memoryWorkloadQueue) Push(ctx context.Context, workloads ...Workload) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, wl := range workloads {
+		if err := q.workloads.Put(queueKey{wl.WorkloadId, wl.NodeID}, wl); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (q *memoryWorkloadQueue) List(ctx context.Context, nodeID string, limit int64) ([]Workload, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	docs, err := q.workloads.Find(bson.D(QueueFilter{}.WithNodeID(nodeID)), options.Find().SetLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+
+	workloads := make([]Workload, 0, len(docs))
+	for _, doc := range docs {
+		wl, err := decodeQueued(doc)
+		if err != nil {
+			return workloads, err
+		}
+
+		workloads = append(workloads, wl)
+	}
+
+	return workloads, nil
+}
+
+func (q *memoryWorkloadQueue) Pop(ctx context.Context, id string, nodeID string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.workloads.Delete(queueKey{id, nodeID})
+	return nil
+}
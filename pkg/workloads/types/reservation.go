@@ -13,6 +13,8 @@ import (
 	"github.com/pkg/errors"
 	"github.com/threefoldtech/tfexplorer/models"
 	generated "github.com/threefoldtech/tfexplorer/models/generated/workloads"
+	"github.com/threefoldtech/tfexplorer/pkg/apierror"
+	phonebook "github.com/threefoldtech/tfexplorer/pkg/phonebook/types"
 	"github.com/threefoldtech/tfexplorer/schema"
 	"github.com/threefoldtech/zos/pkg/crypto"
 	"go.mongodb.org/mongo-driver/bson"
@@ -43,6 +45,9 @@ const (
 	Deleted = generated.NextActionDeleted
 )
 
+// ErrReservationConflict is returned when a reservation was updated since it was read
+var ErrReservationConflict = apierror.New(apierror.CodeReservationConflict, "reservation was updated concurrently")
+
 // ApplyQueryFilter parese the query string
 func ApplyQueryFilter(r *http.Request, filter ReservationFilter) (ReservationFilter, error) {
 	var err error
@@ -53,6 +58,13 @@ func ApplyQueryFilter(r *http.Request, filter ReservationFilter) (ReservationFil
 	if customerid != 0 {
 		filter = filter.WithCustomerID(int(customerid))
 	}
+	customerOrg, err := models.QueryInt(r, "customer_org")
+	if err != nil {
+		return nil, errors.Wrap(err, "customer_org should be an integer")
+	}
+	if customerOrg != 0 {
+		filter = filter.WithCustomerOrg(customerOrg)
+	}
 	sNextAction := r.FormValue("next_action")
 	if len(sNextAction) != 0 {
 		nextAction, err := strconv.ParseInt(sNextAction, 10, 0)
@@ -64,6 +76,25 @@ func ApplyQueryFilter(r *http.Request, filter ReservationFilter) (ReservationFil
 	return filter, nil
 }
 
+// ReservationQueryFields are the fields of the reservations that can be used
+// in list queries
+var ReservationQueryFields = models.Fields{
+	"id":                   {Key: "_id", Type: models.FieldInt, Sortable: true},
+	"customer_tid":         {Type: models.FieldInt},
+	"customer_org":         {Key: "data_reservation.customer_org", Type: models.FieldInt},
+	"next_action":          {Type: models.FieldInt},
+	"epoch":                {Type: models.FieldDate},
+	"metadata":             {Type: models.FieldString},
+	"json":                 {Type: models.FieldString},
+	"data_reservation":     {Type: models.FieldObject},
+	"customer_signature":   {Type: models.FieldString},
+	"signatures_provision": {Type: models.FieldObject},
+	"signatures_farmer":    {Type: models.FieldObject},
+	"signatures_delete":    {Type: models.FieldObject},
+	"results":              {Type: models.FieldObject},
+	"retired_nodes":        {Type: models.FieldObject},
+}
+
 // ReservationFilter type
 type ReservationFilter bson.D
 
@@ -72,6 +103,16 @@ func (f ReservationFilter) WithID(id schema.ID) ReservationFilter {
 	return append(f, bson.E{Key: "_id", Value: id})
 }
 
+// WithVersion filter reservation at version, reservations stored before
+// versioning are at version 0
+func (f ReservationFilter) WithVersion(version int64) ReservationFilter {
+	if version == 0 {
+		return append(f, bson.E{Key: "version", Value: bson.M{"$in": bson.A{0, nil}}})
+	}
+
+	return append(f, bson.E{Key: "version", Value: version})
+}
+
 // WithIDGE return find reservations with
 func (f ReservationFilter) WithIDGE(id schema.ID) ReservationFilter {
 	return append(f, bson.E{
@@ -86,6 +127,21 @@ func (f ReservationFilter) WithNextAction(action generated.NextActionEnum) Reser
 	})
 }
 
+// WithNextActions filter reservations in any of the given states
+func (f ReservationFilter) WithNextActions(actions ...generated.NextActionEnum) ReservationFilter {
+	return append(f, bson.E{Key: "next_action", Value: bson.M{"$in": actions}})
+}
+
+// WithEpochGE filter reservations created at or after since
+func (f ReservationFilter) WithEpochGE(since time.Time) ReservationFilter {
+	return append(f, bson.E{Key: "epoch", Value: bson.M{"$gte": schema.Date{Time: since}}})
+}
+
+// WithEpochLT filter reservations created before until
+func (f ReservationFilter) WithEpochLT(until time.Time) ReservationFilter {
+	return append(f, bson.E{Key: "epoch", Value: bson.M{"$lt": schema.Date{Time: until}}})
+}
+
 // WithCustomerID filter reservation on customer
 func (f ReservationFilter) WithCustomerID(customerID int) ReservationFilter {
 	return append(f, bson.E{
@@ -94,13 +150,18 @@ func (f ReservationFilter) WithCustomerID(customerID int) ReservationFilter {
 
 }
 
+// WithCustomerOrg filter reservation on the customer organization
+func (f ReservationFilter) WithCustomerOrg(orgID int64) ReservationFilter {
+	return append(f, bson.E{Key: "data_reservation.customer_org", Value: orgID})
+}
+
 // WithNodeID searsch reservations with NodeID
 func (f ReservationFilter) WithNodeID(id string) ReservationFilter {
 	//data_reservation.{containers, volumes, zdbs, networks, kubernetes}.node_id
 	// we need to search ALL types for any reservation that has the node ID
 	or := []bson.M{}
 
-	for _, typ := range []string{"containers", "volumes", "zdbs", "kubernetes", "proxies", "reserve_proxies", "subdomains", "domain_delegates", "gateway4to6"} {
+	for _, typ := range []string{"containers", "volumes", "zdbs", "kubernetes", "proxies", "reserve_proxies", "subdomains", "domain_delegates", "gateway4to6", "public_ips"} {
 		key := fmt.Sprintf("data_reservation.%s.node_id", typ)
 		or = append(or, bson.M{key: id})
 	}
@@ -137,6 +198,11 @@ func (f ReservationFilter) Get(ctx context.Context, db *mongo.Database) (reserva
 	return
 }
 
+// WithQuery filter reservations with the conditions of a list query
+func (f ReservationFilter) WithQuery(q models.Query) ReservationFilter {
+	return ReservationFilter(q.Apply(bson.D(f)))
+}
+
 // Find all users that matches filter
 func (f ReservationFilter) Find(ctx context.Context, db *mongo.Database, opts ...*options.FindOptions) (*mongo.Cursor, error) {
 	if f == nil {
@@ -190,7 +256,8 @@ func (r *Reservation) Validate() error {
 		len(r.DataReservation.ReserveProxy) +
 		len(r.DataReservation.Subdomains) +
 		len(r.DataReservation.DomainDelegates) +
-		len(r.DataReservation.Gateway4To6s)
+		len(r.DataReservation.Gateway4To6s) +
+		len(r.DataReservation.PublicIPs)
 
 	// all workloads are supposed to implement this interface
 	type workloader interface{ WorkloadID() int64 }
@@ -227,6 +294,12 @@ func (r *Reservation) Validate() error {
 	for _, w := range r.DataReservation.Gateway4To6s {
 		workloaders = append(workloaders, w)
 	}
+	for _, w := range r.DataReservation.PublicIPs {
+		if w.IPaddress.IP.To4() == nil {
+			return fmt.Errorf("public ip workload '%d' requires an ipv4 address", w.WorkloadId)
+		}
+		workloaders = append(workloaders, w)
+	}
 
 	for _, w := range workloaders {
 		if _, ok := ids[w.WorkloadID()]; ok {
@@ -270,6 +343,37 @@ func (r *Reservation) SignatureVerify(pk string, sig []byte) error {
 	return crypto.Verify(key, buf.Bytes(), sig)
 }
 
+// OrgSigners loads the current members of the organizations referenced by the
+// signing requests of the reservation. The returned map can be used with
+// Pipeline.WithOrgSigners
+func (r *Reservation) OrgSigners(ctx context.Context, orgs phonebook.OrganizationRepository) (map[int64][]int64, error) {
+	var ids []schema.ID
+	for _, request := range []generated.SigningRequest{
+		r.DataReservation.SigningRequestProvision,
+		r.DataReservation.SigningRequestDelete,
+	} {
+		if request.OrgId != 0 {
+			ids = append(ids, schema.ID(request.OrgId))
+		}
+	}
+
+	signers := make(map[int64][]int64)
+	if len(ids) == 0 {
+		return signers, nil
+	}
+
+	found, _, err := orgs.List(ctx, phonebook.OrganizationFilter{}.WithIDs(ids...), models.Pager{})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, org := range found {
+		signers[int64(org.ID)] = org.Signers()
+	}
+
+	return signers, nil
+}
+
 // Expired checks if this reservation has expired
 func (r *Reservation) Expired() bool {
 	return time.Until(r.DataReservation.ExpirationReservation.Time) <= 0
@@ -440,6 +544,17 @@ func (r *Reservation) Workloads(nodeID string) []Workload {
 		wrkl.Content = wl
 		workloads = append(workloads, wrkl)
 	}
+	for _, wl := range data.PublicIPs {
+		if len(nodeID) > 0 && wl.NodeId != nodeID {
+			continue
+		}
+		wrkl := newWrkl(
+			fmt.Sprintf("%d-%d", r.ID, wl.WorkloadId),
+			generated.WorkloadTypePublicIP,
+			wl.NodeId)
+		wrkl.Content = wl
+		workloads = append(workloads, wrkl)
+	}
 	for _, wl := range data.Networks {
 		for _, nr := range wl.NetworkResources {
 
@@ -475,6 +590,17 @@ func (r *Reservation) IsSuccessfullyDeployed() bool {
 	return succeeded
 }
 
+// AnyDeployed checks if at least one workload of the reservation was deployed
+func (r *Reservation) AnyDeployed() bool {
+	for _, result := range r.Results {
+		if result.State == generated.ResultStateOK {
+			return true
+		}
+	}
+
+	return false
+}
+
 // NodeIDs used by this reservation
 func (r *Reservation) NodeIDs() []string {
 	ids := make(map[string]struct{})
@@ -500,6 +626,10 @@ func (r *Reservation) NodeIDs() []string {
 		ids[w.NodeId] = struct{}{}
 	}
 
+	for _, w := range r.DataReservation.PublicIPs {
+		ids[w.NodeId] = struct{}{}
+	}
+
 	nodeIDs := make([]string, 0, len(ids))
 	for nid := range ids {
 		nodeIDs = append(nodeIDs, nid)
@@ -542,20 +672,25 @@ func (r *Reservation) GatewayIDs() []string {
 // NOTE: use reservations only that are returned from calling Pipeline.Next()
 // no validation is done here, this is just a CRUD operation
 func ReservationCreate(ctx context.Context, db *mongo.Database, r Reservation) (schema.ID, error) {
-	id := models.MustID(ctx, db, ReservationCollection)
-	r.ID = id
-
-	_, err := db.Collection(ReservationCollection).InsertOne(ctx, r)
+	// MustID would panic on the write conflicts of concurrent transactions
+	// instead of letting them retry
+	id, err := models.NewIDGenerator(db, ReservationCollection).NextID(ctx)
 	if err != nil {
 		return 0, err
 	}
 
+	r.ID = id
+	r.Version = 0
+	if _, err := db.Collection(ReservationCollection).InsertOne(ctx, r); err != nil {
+		return 0, err
+	}
+
 	return id, nil
 }
 
 // ReservationLastID get the current last ID number in the reservations collection
 func ReservationLastID(ctx context.Context, db *mongo.Database) (schema.ID, error) {
-	return models.LastID(ctx, db, ReservationCollection)
+	return models.NewIDGenerator(db, ReservationCollection).LastID(ctx)
 }
 
 // ReservationSetNextAction update the reservation next action in db
@@ -568,6 +703,7 @@ func ReservationSetNextAction(ctx context.Context, db *mongo.Database, id schema
 		"$set": bson.M{
 			"next_action": action,
 		},
+		"$inc": bson.M{"version": 1},
 	})
 
 	if err != nil {
@@ -578,19 +714,21 @@ func ReservationSetNextAction(ctx context.Context, db *mongo.Database, id schema
 }
 
 // ReservationToDeploy marks a reservation to deploy and schedule the workloads for the nodes
-// it's a short cut to SetNextAction then PushWorkloads
-func ReservationToDeploy(ctx context.Context, db *mongo.Database, reservation *Reservation) error {
-	// update reservation
-	if err := ReservationSetNextAction(ctx, db, reservation.ID, Deploy); err != nil {
-		return errors.Wrap(err, "failed to set reservation to DEPLOY state")
-	}
+// it's a short cut to SetNextAction then Push, both done in a transaction of tx
+func ReservationToDeploy(ctx context.Context, tx models.Transactor, reservations ReservationRepository, queue WorkloadQueue, reservation *Reservation) error {
+	return tx.WithTransaction(ctx, func(ctx context.Context) error {
+		// update reservation
+		if err := reservations.SetNextAction(ctx, reservation.ID, Deploy); err != nil {
+			return errors.Wrap(err, "failed to set reservation to DEPLOY state")
+		}
 
-	//queue for processing
-	if err := WorkloadPush(ctx, db, reservation.Workloads("")...); err != nil {
-		return errors.Wrap(err, "failed to schedule reservation for deploying")
-	}
+		//queue for processing
+		if err := queue.Push(ctx, reservation.Workloads("")...); err != nil {
+			return errors.Wrap(err, "failed to schedule reservation for deploying")
+		}
 
-	return nil
+		return nil
+	})
 }
 
 // SignatureMode type
@@ -603,35 +741,92 @@ const (
 	SignatureDelete SignatureMode = "signatures_delete"
 )
 
-//ReservationPushSignature push signature to reservation
-func ReservationPushSignature(ctx context.Context, db *mongo.Database, id schema.ID, mode SignatureMode, signature generated.SigningSignature) error {
+// signatures returns the signatures of the reservation for mode
+func (r *Reservation) signatures(mode SignatureMode) (*[]generated.SigningSignature, error) {
+	switch mode {
+	case SignatureProvision:
+		return &r.SignaturesProvision, nil
+	case SignatureDelete:
+		return &r.SignaturesDelete, nil
+	}
+
+	return nil, fmt.Errorf("unsupported signature mode '%s'", mode)
+}
+
+// pushSignature adds signature to signatures, replacing the one of the same user if any
+func pushSignature(signatures []generated.SigningSignature, signature generated.SigningSignature) []generated.SigningSignature {
+	kept := make([]generated.SigningSignature, 0, len(signatures)+1)
+	for _, s := range signatures {
+		if s.Tid != signature.Tid {
+			kept = append(kept, s)
+		}
+	}
+
+	return append(kept, signature)
+}
+
+// ReservationPushSignature push signature to reservation, only if the reservation
+// is still at version. ErrReservationConflict is returned otherwise
+func ReservationPushSignature(ctx context.Context, db *mongo.Database, id schema.ID, version int64, mode SignatureMode, signature generated.SigningSignature) error {
+	var filter ReservationFilter
+	filter = filter.WithID(id).WithVersion(version)
+
+	reservation, err := filter.Get(ctx, db)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrReservationConflict
+	} else if err != nil {
+		return err
+	}
+
+	signatures, err := reservation.signatures(mode)
+	if err != nil {
+		return err
+	}
+
+	// the signatures are replaced as a whole, the signature of a user can not
+	// just be added to the set since it always has a different epoch. The
+	// version in the filter makes sure no signature pushed in between is lost
+	col := db.Collection(ReservationCollection)
+	result, err := col.UpdateOne(ctx, filter, bson.M{
+		"$set": bson.M{
+			string(mode): pushSignature(*signatures, signature),
+		},
+		"$inc": bson.M{"version": 1},
+	})
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrReservationConflict
+	}
+
+	return nil
+}
 
+// ReservationFlagRetiredNode flags all the active reservations using nodeID
+// so customers know one of their nodes has been decommissioned
+func ReservationFlagRetiredNode(ctx context.Context, db *mongo.Database, nodeID string) (int64, error) {
 	var filter ReservationFilter
-	filter = filter.WithID(id)
+	filter = filter.WithNodeID(nodeID)
+	filter = append(filter,
+		bson.E{Key: "next_action", Value: bson.M{"$nin": bson.A{Delete, Deleted, Invalid}}},
+		// only reservations that are not flagged yet, so their version is not bumped for nothing
+		bson.E{Key: "retired_nodes", Value: bson.M{"$ne": nodeID}},
+	)
+
 	col := db.Collection(ReservationCollection)
-	// NOTE: this should be a transaction not a bulk write
-	// but i had so many issues with transaction, and i couldn't
-	// get it to work. so I used bulk write in place instead
-	// until we figure this issue out.
-	// Note, the reason we don't just use addToSet is the signature
-	// object always have the current 'time' which means it's a different
-	// value than the one in the document even if it has same user id.
-	_, err := col.BulkWrite(ctx, []mongo.WriteModel{
-		mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(
-			bson.M{
-				"$pull": bson.M{
-					string(mode): bson.M{"tid": signature.Tid},
-				},
-			}),
-		mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(
-			bson.M{
-				"$addToSet": bson.M{
-					string(mode): signature,
-				},
-			}),
-	}, options.BulkWrite().SetOrdered(true))
+	result, err := col.UpdateMany(ctx, filter, bson.M{
+		"$addToSet": bson.M{
+			"retired_nodes": nodeID,
+		},
+		"$inc": bson.M{"version": 1},
+	})
+	if err != nil {
+		return 0, err
+	}
 
-	return err
+	return result.ModifiedCount, nil
 }
 
 // Workload is a wrapper around generated TfgridWorkloadsReservationWorkload1 type
@@ -674,6 +869,149 @@ func WorkloadPop(ctx context.Context, db *mongo.Database, id string, nodeID stri
 	return err
 }
 
+// queuedWorkload is how a workload is stored in the queue
+type queuedWorkload struct {
+	WorkloadID string                     `bson:"workload_id" json:"workload_id"`
+	User       string                     `bson:"user" json:"user"`
+	Type       generated.WorkloadTypeEnum `bson:"type" json:"type"`
+	Content    bson.Raw                   `bson:"content" json:"content"`
+	Created    schema.Date                `bson:"created" json:"created"`
+	Duration   int64                      `bson:"duration" json:"duration"`
+	Signature  string                     `bson:"signature" json:"signature"`
+	ToDelete   bool                       `bson:"to_delete" json:"to_delete"`
+	NodeID     string                     `json:"node_id" bson:"node_id"`
+}
+
+// decodeQueued loads a workload stored in the queue
+func decodeQueued(doc bson.Raw) (Workload, error) {
+	// why we have intermediate struct you say? I will tell you
+	// Content in the workload structure is definition as of type interface{}
+	// bson if found a nil interface, it initialize it with bson.D (list of elements)
+	// so data in Content will be something like [{key: k1, value: v1}, {key: k2, value: v2}]
+	// which is not the same structure expected in the node
+	// hence we use bson.M to force it to load data in a map like {k1: v1, k2: v2}
+	var wl queuedWorkload
+	if err := bson.Unmarshal(doc, &wl); err != nil {
+		return Workload{}, err
+	}
+
+	obj := generated.ReservationWorkload{
+		WorkloadId: wl.WorkloadID,
+		User:       wl.User,
+		Type:       wl.Type,
+		Created:    wl.Created,
+		Duration:   wl.Duration,
+		Signature:  wl.Signature,
+		ToDelete:   wl.ToDelete,
+	}
+
+	switch wl.Type {
+	case generated.WorkloadTypeContainer:
+		var data generated.Container
+		if err := bson.Unmarshal(wl.Content, &data); err != nil {
+			return Workload{}, err
+		}
+		obj.Content = data
+
+	case generated.WorkloadTypeVolume:
+		var data generated.Volume
+		if err := bson.Unmarshal(wl.Content, &data); err != nil {
+			return Workload{}, err
+		}
+		obj.Content = data
+
+	case generated.WorkloadTypeZDB:
+		var data generated.ZDB
+		if err := bson.Unmarshal(wl.Content, &data); err != nil {
+			return Workload{}, err
+		}
+		obj.Content = data
+
+	case generated.WorkloadTypeNetwork:
+		var data generated.Network
+		if err := bson.Unmarshal(wl.Content, &data); err != nil {
+			return Workload{}, err
+		}
+		obj.Content = data
+
+	case generated.WorkloadTypeKubernetes:
+		var data generated.K8S
+		if err := bson.Unmarshal(wl.Content, &data); err != nil {
+			return Workload{}, err
+		}
+		obj.Content = data
+
+	case generated.WorkloadTypeDomainDelegate:
+		var data generated.GatewayDelegate
+		if err := bson.Unmarshal(wl.Content, &data); err != nil {
+			return Workload{}, err
+		}
+		obj.Content = data
+
+	case generated.WorkloadTypeSubDomain:
+		var data generated.GatewaySubdomain
+		if err := bson.Unmarshal(wl.Content, &data); err != nil {
+			return Workload{}, err
+		}
+		obj.Content = data
+
+	case generated.WorkloadTypeProxy:
+		var data generated.GatewayProxy
+		if err := bson.Unmarshal(wl.Content, &data); err != nil {
+			return Workload{}, err
+		}
+		obj.Content = data
+
+	case generated.WorkloadTypeReverseProxy:
+		var data generated.GatewayReserveProxy
+		if err := bson.Unmarshal(wl.Content, &data); err != nil {
+			return Workload{}, err
+		}
+		obj.Content = data
+	case generated.WorkloadTypeGateway4To6:
+		var data generated.Gateway4To6
+		if err := bson.Unmarshal(wl.Content, &data); err != nil {
+			return Workload{}, err
+		}
+		obj.Content = data
+	case generated.WorkloadTypePublicIP:
+		var data generated.PublicIP
+		if err := bson.Unmarshal(wl.Content, &data); err != nil {
+			return Workload{}, err
+		}
+		obj.Content = data
+	}
+
+	return Workload{
+		NodeID:              wl.NodeID,
+		ReservationWorkload: obj,
+	}, nil
+}
+
+// WorkloadQueued returns at most limit workloads from the queue of nodeID
+func WorkloadQueued(ctx context.Context, db *mongo.Database, nodeID string, limit int64) ([]Workload, error) {
+	var queue QueueFilter
+	queue = queue.WithNodeID(nodeID)
+
+	cur, err := queue.Find(ctx, db, options.Find().SetLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	workloads := make([]Workload, 0)
+	for cur.Next(ctx) {
+		wl, err := decodeQueued(cur.Current)
+		if err != nil {
+			return workloads, err
+		}
+
+		workloads = append(workloads, wl)
+	}
+
+	return workloads, cur.Err()
+}
+
 // Result is a wrapper around TfgridWorkloadsReservationResult1 type
 type Result generated.Result
 
@@ -705,9 +1043,9 @@ func ResultPush(ctx context.Context, db *mongo.Database, id schema.ID, result Re
 	var filter ReservationFilter
 	filter = filter.WithID(id)
 
-	// we don't care if we couldn't delete old result.
-	// in case it never existed, or the array is nil.
-	col.UpdateOne(ctx, filter, bson.M{
+	// pulling a result that never existed is not an error, the
+	// update just does not modify the reservation
+	_, err := col.UpdateOne(ctx, filter, bson.M{
 		"$pull": bson.M{
 			"results": bson.M{
 				"workload_id": result.WorkloadId,
@@ -715,14 +1053,21 @@ func ResultPush(ctx context.Context, db *mongo.Database, id schema.ID, result Re
 			},
 		},
 	})
+	if err != nil {
+		return errors.Wrap(err, "failed to remove previous result")
+	}
 
-	_, err := col.UpdateOne(ctx, filter, bson.D{
+	_, err = col.UpdateOne(ctx, filter, bson.D{
 		{
 			Key: "$push",
 			Value: bson.M{
 				"results": result,
 			},
 		},
+		{
+			Key:   "$inc",
+			Value: bson.M{"version": 1},
+		},
 	})
 
 	return err
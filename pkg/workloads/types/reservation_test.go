@@ -1,10 +1,15 @@
 package types
 
 import (
+	"context"
 	"encoding/json"
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	"github.com/threefoldtech/tfexplorer/models"
+	generated "github.com/threefoldtech/tfexplorer/models/generated/workloads"
+	"github.com/threefoldtech/tfexplorer/schema"
+	"go.mongodb.org/mongo-driver/bson"
 )
 
 func TestValidation(t *testing.T) {
@@ -50,3 +55,60 @@ func TestValidation(t *testing.T) {
 	err = reservation.Validate()
 	require.Error(t, err)
 }
+
+// countingTransactor counts the transactions it runs
+type countingTransactor int
+
+func (c *countingTransactor) WithTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	*c++
+	return fn(ctx)
+}
+
+func TestReservationToDeploy(t *testing.T) {
+	ctx := context.Background()
+	reservations := NewMemoryReservationRepository()
+	queue := NewMemoryWorkloadQueue()
+
+	var reservation Reservation
+	reservation.NextAction = Pay
+	reservation.DataReservation.Volumes = []generated.Volume{{WorkloadId: 1, NodeId: "node"}}
+	id, err := reservations.Create(ctx, reservation)
+	require.NoError(t, err)
+
+	reservation, err = reservations.Get(ctx, id)
+	require.NoError(t, err)
+
+	var tx countingTransactor
+	require.NoError(t, ReservationToDeploy(ctx, &tx, reservations, queue, &reservation))
+	require.Equal(t, countingTransactor(1), tx, "the state and the queue are updated together")
+
+	reservation, err = reservations.Get(ctx, id)
+	require.NoError(t, err)
+	require.Equal(t, Deploy, reservation.NextAction)
+
+	workloads, err := queue.List(ctx, "node", 10)
+	require.NoError(t, err)
+	require.Len(t, workloads, 1)
+}
+
+func TestDecodePage(t *testing.T) {
+	raw := func(doc bson.M) bson.Raw {
+		data, err := bson.Marshal(doc)
+		require.NoError(t, err)
+		return data
+	}
+
+	valid := raw(bson.M{"_id": int64(1), "next_action": int64(generated.NextActionDeploy)})
+	// an old reservation that can not be decoded is skipped
+	old := raw(bson.M{"_id": int64(2), "next_action": "deploy"})
+
+	reservations, _, err := decodePage(models.Pager{Limit: 10}, []bson.Raw{valid, old})
+	require.NoError(t, err)
+	require.Len(t, reservations, 1)
+	require.Equal(t, schema.ID(1), reservations[0].ID)
+
+	// without a valid id the document can not even be reported
+	invalid := raw(bson.M{"_id": "2", "next_action": "deploy"})
+	_, _, err = decodePage(models.Pager{Limit: 10}, []bson.Raw{valid, invalid})
+	require.Error(t, err)
+}
@@ -36,6 +36,7 @@ func Setup(ctx context.Context, db *mongo.Database) error {
 	}
 	indexes = append(indexes, mongo.IndexModel{Keys: bson.M{"next_action": 1}})
 	indexes = append(indexes, mongo.IndexModel{Keys: bson.M{"customer_tid": 1}})
+	indexes = append(indexes, mongo.IndexModel{Keys: bson.M{"data_reservation.customer_org": 1}})
 
 	if _, err := col.Indexes().CreateMany(ctx, indexes); err != nil {
 		return err
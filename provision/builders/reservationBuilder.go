@@ -77,6 +77,36 @@ func (r *ReservationBuilder) WithSigningRequestDeleteSigners(signerIDs []int64)
 	return r
 }
 
+// WithSigningRequestDeleteOrg lets the current members of the organization sign the deletion
+func (r *ReservationBuilder) WithSigningRequestDeleteOrg(orgID int64) *ReservationBuilder {
+	r.reservation.DataReservation.SigningRequestDelete.OrgId = orgID
+	return r
+}
+
+// WithSigningRequestProvisionQuorumMin sets the signing request provision quorum minimum
+func (r *ReservationBuilder) WithSigningRequestProvisionQuorumMin(quorumMin int64) *ReservationBuilder {
+	r.reservation.DataReservation.SigningRequestProvision.QuorumMin = quorumMin
+	return r
+}
+
+// WithSigningRequestProvisionOrg lets the current members of the organization sign the provisioning
+func (r *ReservationBuilder) WithSigningRequestProvisionOrg(orgID int64) *ReservationBuilder {
+	r.reservation.DataReservation.SigningRequestProvision.OrgId = orgID
+	return r
+}
+
+// WithCustomerOrg makes the reservation on behalf of an organization the customer is a member of
+func (r *ReservationBuilder) WithCustomerOrg(orgID int64) *ReservationBuilder {
+	r.reservation.DataReservation.CustomerOrg = orgID
+	return r
+}
+
+// WithCertifiedOnly only allows the reservation to be deployed on certified nodes
+func (r *ReservationBuilder) WithCertifiedOnly(certified bool) *ReservationBuilder {
+	r.reservation.DataReservation.CertifiedOnly = certified
+	return r
+}
+
 // AddVolume adds a volume builder to the reservation builder
 func (r *ReservationBuilder) AddVolume(volume VolumeBuilder) *ReservationBuilder {
 	r.reservation.DataReservation.Volumes = append(r.reservation.DataReservation.Volumes, volume.Volume)
@@ -0,0 +1,374 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// AuthNone routes are public
+	AuthNone = ""
+	// AuthSignature routes require an http signature of a user or a node
+	AuthSignature = "signature"
+	// AuthToken routes accept an API token as well as an http signature
+	AuthToken = "token"
+
+	// listPrefix marks a list of objects in the request and response of routes
+	listPrefix = "[]"
+	// freeObject is a request or response that is not described by the schema
+	freeObject = "object"
+)
+
+var (
+	// openapiKindMap maps the kinds to their openapi type and format
+	openapiKindMap = map[Kind][2]string{
+		StringKind:    {"string", ""},
+		MultilineKind: {"string", ""},
+		YamlKind:      {"string", ""},
+		HashKind:      {"string", ""},
+		MobileKind:    {"string", ""},
+		GUIDKind:      {"string", "uuid"},
+		URLKind:       {"string", "uri"},
+		EmailKind:     {"string", "email"},
+		IPAddressKind: {"string", "ip"},
+		IPRangeKind:   {"string", "cidr"},
+		IPPortKind:    {"string", ""},
+		NumericKind:   {"string", "numeric"},
+		BytesKind:     {"string", "byte"},
+		IntegerKind:   {"integer", "int64"},
+		// dates are unix timestamps
+		DateKind:     {"integer", "int64"},
+		DateTimeKind: {"integer", "int64"},
+		FloatKind:    {"number", "double"},
+		PercentKind:  {"number", "double"},
+		BoolKind:     {"boolean", ""},
+	}
+
+	pathVarRe = regexp.MustCompile(`{(\w+)(?::[^}]+)?}`)
+)
+
+// Route describes a named route of the API in the route manifest
+type Route struct {
+	// Name is the name the route is registered with
+	Name    string `yaml:"name"`
+	Method  string `yaml:"method"`
+	Path    string `yaml:"path"`
+	Summary string `yaml:"summary"`
+	// Auth is one of AuthNone, AuthSignature and AuthToken
+	Auth string `yaml:"auth"`
+	// Query are the names of the query parameters
+	Query []string `yaml:"query"`
+	// Request and Response are the urls of the schema objects of the bodies,
+	// prefixed with [] for lists, or "object" for bodies the schema does not
+	// describe
+	Request  string `yaml:"request"`
+	Response string `yaml:"response"`
+	// Status is the status of a successful response, 200 by default
+	Status int `yaml:"status"`
+}
+
+// LoadRoutes reads the route manifest at path
+func LoadRoutes(path string) ([]Route, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var routes []Route
+	if err := yaml.UnmarshalStrict(data, &routes); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse route manifest %s", path)
+	}
+
+	return routes, nil
+}
+
+// GenerateOpenAPI generates an OpenAPI 3 document of the routes, the objects
+// of schema are the components of the document
+func GenerateOpenAPI(w io.Writer, title, version string, routes []Route, schema Schema) error {
+	g := openapiGenerator{objects: make(map[string]*Object)}
+	return g.Generate(w, title, version, routes, schema)
+}
+
+// just a namespace for generation methods
+type openapiGenerator struct {
+	objects map[string]*Object
+}
+
+type object map[string]interface{}
+
+func (g *openapiGenerator) Generate(w io.Writer, title, version string, routes []Route, schema Schema) error {
+	for _, obj := range schema {
+		g.objects[obj.URL] = obj
+	}
+
+	components := object{
+		"Error": errorComponent(),
+	}
+	for _, obj := range schema {
+		component, err := g.object(obj)
+		if err != nil {
+			return err
+		}
+		components[obj.URL] = component
+	}
+
+	paths := object{}
+	names := make(map[string]struct{})
+	for _, route := range routes {
+		if _, ok := names[route.Name]; ok {
+			return fmt.Errorf("route '%s' is documented more than once", route.Name)
+		}
+		names[route.Name] = struct{}{}
+
+		operation, err := g.operation(&route)
+		if err != nil {
+			return errors.Wrapf(err, "route(%s)", route.Name)
+		}
+
+		path := pathVarRe.ReplaceAllString(route.Path, "{$1}")
+		item, ok := paths[path].(object)
+		if !ok {
+			item = object{}
+			paths[path] = item
+		}
+		item[strings.ToLower(route.Method)] = operation
+	}
+
+	document := object{
+		"openapi": "3.0.3",
+		"info": object{
+			"title":   title,
+			"version": version,
+		},
+		"servers": []object{
+			{"url": "/explorer/v2", "description": "typed errors"},
+			{"url": "/explorer", "description": "legacy errors"},
+		},
+		"paths": paths,
+		"components": object{
+			"schemas": components,
+			"securitySchemes": object{
+				AuthSignature: object{
+					"type":        "apiKey",
+					"in":          "header",
+					"name":        "Authorization",
+					"description": "http signature of the request with the key of the user or the node",
+				},
+				AuthToken: object{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "read only API token of the user",
+				},
+			},
+		},
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(document)
+}
+
+func errorComponent() object {
+	return object{
+		"type":     "object",
+		"required": []string{"code", "message"},
+		"properties": object{
+			"code":    object{"type": "string"},
+			"message": object{"type": "string"},
+			"field":   object{"type": "string"},
+			"details": object{"type": "object"},
+		},
+	}
+}
+
+func (g *openapiGenerator) operation(route *Route) (object, error) {
+	if len(route.Name) == 0 || len(route.Method) == 0 || len(route.Path) == 0 {
+		return nil, fmt.Errorf("name, method and path are required")
+	}
+
+	var params []object
+	for _, m := range pathVarRe.FindAllStringSubmatch(route.Path, -1) {
+		params = append(params, object{
+			"name":     m[1],
+			"in":       "path",
+			"required": true,
+			"schema":   object{"type": "string"},
+		})
+	}
+	for _, name := range route.Query {
+		params = append(params, object{
+			"name":   name,
+			"in":     "query",
+			"schema": object{"type": "string"},
+		})
+	}
+
+	status := route.Status
+	if status == 0 {
+		status = 200
+	}
+
+	success := object{"description": "success"}
+	if len(route.Response) != 0 {
+		body, err := g.body(route.Response)
+		if err != nil {
+			return nil, errors.Wrap(err, "response")
+		}
+		success["content"] = body
+	}
+
+	operation := object{
+		"operationId": route.Name,
+		"summary":     route.Summary,
+		"tags":        []string{strings.Split(strings.Trim(route.Path, "/"), "/")[0]},
+		"responses": object{
+			fmt.Sprint(status): success,
+			"default": object{
+				"description": "error",
+				"content": g.content(object{
+					"type":       "object",
+					"properties": object{"error": ref("Error")},
+				}),
+			},
+		},
+	}
+
+	if len(params) != 0 {
+		operation["parameters"] = params
+	}
+
+	if len(route.Request) != 0 {
+		body, err := g.body(route.Request)
+		if err != nil {
+			return nil, errors.Wrap(err, "request")
+		}
+		operation["requestBody"] = object{"required": true, "content": body}
+	}
+
+	switch route.Auth {
+	case AuthNone:
+	case AuthSignature:
+		operation["security"] = []object{{AuthSignature: []string{}}}
+	case AuthToken:
+		operation["security"] = []object{{AuthSignature: []string{}}, {AuthToken: []string{}}}
+	default:
+		return nil, fmt.Errorf("unknown auth '%s'", route.Auth)
+	}
+
+	return operation, nil
+}
+
+// body returns the content of a request or a response body
+func (g *openapiGenerator) body(typ string) (object, error) {
+	if typ == freeObject {
+		return g.content(object{"type": "object"}), nil
+	}
+
+	url := strings.TrimPrefix(typ, listPrefix)
+	if _, ok := g.objects[url]; !ok {
+		return nil, fmt.Errorf("unknown schema object '%s'", url)
+	}
+
+	if strings.HasPrefix(typ, listPrefix) {
+		return g.content(object{"type": "array", "items": ref(url)}), nil
+	}
+
+	return g.content(ref(url)), nil
+}
+
+func (g *openapiGenerator) content(schema object) object {
+	return object{"application/json": object{"schema": schema}}
+}
+
+func ref(name string) object {
+	return object{"$ref": "#/components/schemas/" + name}
+}
+
+func (g *openapiGenerator) object(obj *Object) (object, error) {
+	properties := object{}
+	if obj.IsRoot {
+		properties["id"] = object{"type": "integer", "format": "int64"}
+	}
+
+	for _, prop := range obj.Properties {
+		typ, err := g.renderType(&prop.Type)
+		if err != nil {
+			return nil, errors.Wrapf(err, "object(%s).property(%s)", obj.URL, prop.Name)
+		}
+		properties[prop.Name] = typ
+	}
+
+	return object{"type": "object", "properties": properties}, nil
+}
+
+func (g *openapiGenerator) renderType(typ *Type) (object, error) {
+	if typ == nil {
+		return object{}, nil
+	}
+
+	switch typ.Kind {
+	case UnknownKind:
+		// the type definition of the property could not be parsed, any
+		// value is accepted
+		return object{}, nil
+	case DictKind:
+		// dicts without an element are free form objects
+		if typ.Element == nil {
+			return object{"type": "object", "additionalProperties": true}, nil
+		}
+		elem, err := g.renderType(typ.Element)
+		if err != nil {
+			return nil, err
+		}
+		return object{"type": "object", "additionalProperties": elem}, nil
+	case ListKind:
+		elem, err := g.renderType(typ.Element)
+		if err != nil {
+			return nil, err
+		}
+		return object{"type": "array", "items": elem}, nil
+	case ObjectKind:
+		if _, ok := g.objects[typ.Reference]; !ok {
+			return nil, fmt.Errorf("unknown schema object '%s'", typ.Reference)
+		}
+		return ref(typ.Reference), nil
+	case EnumKind:
+		// enums are encoded as the index of their value
+		var values string
+		if err := json.Unmarshal([]byte(typ.Default), &values); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse enum values: `%s`", typ.Default)
+		}
+
+		var indexes []int
+		var names []string
+		for i, value := range strings.Split(values, ",") {
+			indexes = append(indexes, i)
+			names = append(names, strings.TrimSpace(value))
+		}
+
+		return object{
+			"type":            "integer",
+			"enum":            indexes,
+			"x-enum-varnames": names,
+			"description":     strings.Join(names, ", "),
+		}, nil
+	default:
+		m, ok := openapiKindMap[typ.Kind]
+		if !ok {
+			return nil, errors.Errorf("unsupported type in the openapi generator: %s", typ.Kind)
+		}
+
+		rendered := object{"type": m[0]}
+		if len(m[1]) != 0 {
+			rendered["format"] = m[1]
+		}
+		return rendered, nil
+	}
+}
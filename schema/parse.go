@@ -4,6 +4,8 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"unicode"
@@ -170,6 +172,35 @@ root:
 	return
 }
 
+// NewFromDir reads all the schema files (*.toml) of dir and its sub directories
+func NewFromDir(dir string) (schema Schema, err error) {
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() || filepath.Ext(path) != ".toml" {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		objects, err := New(f)
+		if err != nil {
+			return fmt.Errorf("failed to parse %s: %s", path, err)
+		}
+
+		schema = append(schema, objects...)
+		return nil
+	})
+
+	return
+}
+
 // Directive is a piece of information attached to a schema object
 type Directive struct {
 	Key   string